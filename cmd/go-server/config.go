@@ -0,0 +1,588 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/AScotM/go-server/pkg/fileserver"
+	"github.com/BurntSushi/toml"
+	"gopkg.in/yaml.v3"
+)
+
+// configFile is the on-disk configuration. It may be written as YAML, TOML
+// or JSON; the format is chosen by file extension.
+type configFile struct {
+	Listen string `json:"listen" yaml:"listen" toml:"listen"`
+	Socket struct {
+		Mode  string `json:"mode" yaml:"mode" toml:"mode"`
+		Group string `json:"group" yaml:"group" toml:"group"`
+	} `json:"socket" yaml:"socket" toml:"socket"`
+	Dir        string        `json:"dir" yaml:"dir" toml:"dir"`
+	Archive    string        `json:"archive" yaml:"archive" toml:"archive"`
+	Mounts     []configMount `json:"mounts" yaml:"mounts" toml:"mounts"`
+	Proxies    []configProxy `json:"reverse_proxy" yaml:"reverse_proxy" toml:"reverse_proxy"`
+	Rules      []configRule  `json:"rules" yaml:"rules" toml:"rules"`
+	Routes     []configRoute `json:"routes" yaml:"routes" toml:"routes"`
+	ErrorPages string        `json:"error_pages" yaml:"error_pages" toml:"error_pages"`
+	TLS        struct {
+		Cert         string `json:"cert" yaml:"cert" toml:"cert"`
+		Key          string `json:"key" yaml:"key" toml:"key"`
+		Listen       string `json:"listen" yaml:"listen" toml:"listen"`
+		RedirectHTTP *bool  `json:"redirect_http" yaml:"redirect_http" toml:"redirect_http"`
+	} `json:"tls" yaml:"tls" toml:"tls"`
+	Privileges struct {
+		User   string `json:"user" yaml:"user" toml:"user"`
+		Group  string `json:"group" yaml:"group" toml:"group"`
+		Chroot *bool  `json:"chroot" yaml:"chroot" toml:"chroot"`
+	} `json:"privileges" yaml:"privileges" toml:"privileges"`
+	Cache struct {
+		TTL string `json:"ttl" yaml:"ttl" toml:"ttl"`
+	} `json:"cache" yaml:"cache" toml:"cache"`
+	Logging struct {
+		Level            string   `json:"level" yaml:"level" toml:"level"`
+		Format           string   `json:"format" yaml:"format" toml:"format"`
+		Output           string   `json:"output" yaml:"output" toml:"output"`
+		File             string   `json:"file" yaml:"file" toml:"file"`
+		SyslogAddr       string   `json:"syslog_addr" yaml:"syslog_addr" toml:"syslog_addr"`
+		Buffer           *int     `json:"buffer" yaml:"buffer" toml:"buffer"`
+		AccessLog        string   `json:"access_log" yaml:"access_log" toml:"access_log"`
+		SecurityLog      string   `json:"security_log" yaml:"security_log" toml:"security_log"`
+		AccessLogMaxSize int64    `json:"access_log_max_size" yaml:"access_log_max_size" toml:"access_log_max_size"`
+		AccessLogRotate  string   `json:"access_log_rotate" yaml:"access_log_rotate" toml:"access_log_rotate"`
+		SampleRate       *float64 `json:"sample_rate" yaml:"sample_rate" toml:"sample_rate"`
+		Slow             string   `json:"slow" yaml:"slow" toml:"slow"`
+		StatsInterval    string   `json:"stats_interval" yaml:"stats_interval" toml:"stats_interval"`
+	} `json:"logging" yaml:"logging" toml:"logging"`
+	Tracing struct {
+		OTelEndpoint string `json:"otel_endpoint" yaml:"otel_endpoint" toml:"otel_endpoint"`
+	} `json:"tracing" yaml:"tracing" toml:"tracing"`
+	StatsD struct {
+		Addr      string   `json:"addr" yaml:"addr" toml:"addr"`
+		Prefix    string   `json:"prefix" yaml:"prefix" toml:"prefix"`
+		DogStatsD *bool    `json:"dogstatsd" yaml:"dogstatsd" toml:"dogstatsd"`
+		Tags      []string `json:"tags" yaml:"tags" toml:"tags"`
+	} `json:"statsd" yaml:"statsd" toml:"statsd"`
+	Sentry struct {
+		DSN         string `json:"dsn" yaml:"dsn" toml:"dsn"`
+		Environment string `json:"environment" yaml:"environment" toml:"environment"`
+	} `json:"sentry" yaml:"sentry" toml:"sentry"`
+	GeoIP struct {
+		CountryDB string          `json:"country_db" yaml:"country_db" toml:"country_db"`
+		ASNDB     string          `json:"asn_db" yaml:"asn_db" toml:"asn_db"`
+		Rules     []configGeoRule `json:"rules" yaml:"rules" toml:"rules"`
+	} `json:"geoip" yaml:"geoip" toml:"geoip"`
+	Admin struct {
+		Listen string `json:"listen" yaml:"listen" toml:"listen"`
+		Socket struct {
+			Mode  string `json:"mode" yaml:"mode" toml:"mode"`
+			Group string `json:"group" yaml:"group" toml:"group"`
+		} `json:"socket" yaml:"socket" toml:"socket"`
+		Token string `json:"token" yaml:"token" toml:"token"`
+	} `json:"admin" yaml:"admin" toml:"admin"`
+	Uploads struct {
+		Tus        *bool  `json:"tus" yaml:"tus" toml:"tus"`
+		TusMaxSize int64  `json:"tus_max_size" yaml:"tus_max_size" toml:"tus_max_size"`
+		Chunked    *bool  `json:"chunked" yaml:"chunked" toml:"chunked"`
+		Staging    string `json:"staging" yaml:"staging" toml:"staging"`
+	} `json:"uploads" yaml:"uploads" toml:"uploads"`
+	Drop struct {
+		TTL     string `json:"ttl" yaml:"ttl" toml:"ttl"`
+		Dir     string `json:"dir" yaml:"dir" toml:"dir"`
+		MaxSize int64  `json:"max_size" yaml:"max_size" toml:"max_size"`
+	} `json:"drop" yaml:"drop" toml:"drop"`
+	Recent struct {
+		Interval string `json:"interval" yaml:"interval" toml:"interval"`
+		Count    int    `json:"count" yaml:"count" toml:"count"`
+	} `json:"recent" yaml:"recent" toml:"recent"`
+	Search struct {
+		Index    string `json:"index" yaml:"index" toml:"index"`
+		Interval string `json:"interval" yaml:"interval" toml:"interval"`
+	} `json:"search" yaml:"search" toml:"search"`
+	Branding struct {
+		Title  string `json:"title" yaml:"title" toml:"title"`
+		Logo   string `json:"logo" yaml:"logo" toml:"logo"`
+		Footer string `json:"footer" yaml:"footer" toml:"footer"`
+	} `json:"branding" yaml:"branding" toml:"branding"`
+	ShortLinks string `json:"short_links" yaml:"short_links" toml:"short_links"`
+	MetadataDB string `json:"metadata_db" yaml:"metadata_db" toml:"metadata_db"`
+	HumanSizes *bool  `json:"human_sizes" yaml:"human_sizes" toml:"human_sizes"`
+	Lang       string `json:"lang" yaml:"lang" toml:"lang"`
+	PageSize   int    `json:"page_size" yaml:"page_size" toml:"page_size"`
+	ShowPerms  *bool  `json:"show_perms" yaml:"show_perms" toml:"show_perms"`
+	DirSizes   *bool  `json:"dir_sizes" yaml:"dir_sizes" toml:"dir_sizes"`
+	Versions   int    `json:"versions" yaml:"versions" toml:"versions"`
+	MaxBody    int64  `json:"max_body_size" yaml:"max_body_size" toml:"max_body_size"`
+	MaxEntries int    `json:"max_list_entries" yaml:"max_list_entries" toml:"max_list_entries"`
+	NoIndex    string `json:"noindex" yaml:"noindex" toml:"noindex"`
+	Trash      struct {
+		Retention string `json:"retention" yaml:"retention" toml:"retention"`
+	} `json:"trash" yaml:"trash" toml:"trash"`
+	GRPC struct {
+		Listen string `json:"listen" yaml:"listen" toml:"listen"`
+	} `json:"grpc" yaml:"grpc" toml:"grpc"`
+	S3 struct {
+		Listen      string            `json:"listen" yaml:"listen" toml:"listen"`
+		Bucket      string            `json:"bucket" yaml:"bucket" toml:"bucket"`
+		Credentials map[string]string `json:"credentials" yaml:"credentials" toml:"credentials"`
+	} `json:"s3" yaml:"s3" toml:"s3"`
+	SFTP struct {
+		Listen         string `json:"listen" yaml:"listen" toml:"listen"`
+		HostKey        string `json:"host_key" yaml:"host_key" toml:"host_key"`
+		AuthorizedKeys string `json:"authorized_keys" yaml:"authorized_keys" toml:"authorized_keys"`
+	} `json:"sftp" yaml:"sftp" toml:"sftp"`
+	Auth struct {
+		Realm    string            `json:"realm" yaml:"realm" toml:"realm"`
+		Users    map[string]string `json:"users" yaml:"users" toml:"users"`
+		Htpasswd string            `json:"htpasswd" yaml:"htpasswd" toml:"htpasswd"`
+		Kerberos struct {
+			Keytab    string `json:"keytab" yaml:"keytab" toml:"keytab"`
+			Principal string `json:"principal" yaml:"principal" toml:"principal"`
+		} `json:"kerberos" yaml:"kerberos" toml:"kerberos"`
+	} `json:"auth" yaml:"auth" toml:"auth"`
+	Proxy struct {
+		Trusted  []string `json:"trusted" yaml:"trusted" toml:"trusted"`
+		Protocol *bool    `json:"protocol" yaml:"protocol" toml:"protocol"`
+	} `json:"proxy" yaml:"proxy" toml:"proxy"`
+	Middleware []string `json:"middleware" yaml:"middleware" toml:"middleware"`
+	RateLimit  struct {
+		RPS   *float64 `json:"rps" yaml:"rps" toml:"rps"`
+		Burst *int     `json:"burst" yaml:"burst" toml:"burst"`
+	} `json:"rate_limit" yaml:"rate_limit" toml:"rate_limit"`
+	Daemon          *bool             `json:"daemon" yaml:"daemon" toml:"daemon"`
+	Pidfile         string            `json:"pidfile" yaml:"pidfile" toml:"pidfile"`
+	ShutdownTimeout string            `json:"shutdown_timeout" yaml:"shutdown_timeout" toml:"shutdown_timeout"`
+	Headers         map[string]string `json:"headers" yaml:"headers" toml:"headers"`
+	Disposition     map[string]string `json:"disposition" yaml:"disposition" toml:"disposition"`
+	ReservedPrefix  string            `json:"reserved_prefix" yaml:"reserved_prefix" toml:"reserved_prefix"`
+	MIMETypes       map[string]string `json:"mime_types" yaml:"mime_types" toml:"mime_types"`
+	MIMETypesFile   string            `json:"mime_types_file" yaml:"mime_types_file" toml:"mime_types_file"`
+	StripBOM        *bool             `json:"strip_bom" yaml:"strip_bom" toml:"strip_bom"`
+	NoRobots        *bool             `json:"no_robots" yaml:"no_robots" toml:"no_robots"`
+	GitHTTP         *bool             `json:"git_http" yaml:"git_http" toml:"git_http"`
+	Exclude         []string          `json:"exclude" yaml:"exclude" toml:"exclude"`
+	Webhooks        []configWebhook   `json:"webhooks" yaml:"webhooks" toml:"webhooks"`
+	Brokers         []configBroker    `json:"brokers" yaml:"brokers" toml:"brokers"`
+	Mirror          *configMirror     `json:"s3_mirror" yaml:"s3_mirror" toml:"s3_mirror"`
+}
+
+// configMirror is the s3_mirror section of the config file.
+type configMirror struct {
+	Endpoint  string `json:"endpoint" yaml:"endpoint" toml:"endpoint"`
+	Bucket    string `json:"bucket" yaml:"bucket" toml:"bucket"`
+	Region    string `json:"region" yaml:"region" toml:"region"`
+	AccessKey string `json:"access_key" yaml:"access_key" toml:"access_key"`
+	SecretKey string `json:"secret_key" yaml:"secret_key" toml:"secret_key"`
+	Prefix    string `json:"prefix" yaml:"prefix" toml:"prefix"`
+}
+
+func (cf *configFile) mirror() *fileserver.S3Mirror {
+	if cf.Mirror == nil {
+		return nil
+	}
+	m := fileserver.S3Mirror(*cf.Mirror)
+	return &m
+}
+
+// configBroker is a brokers entry in the config file.
+type configBroker struct {
+	URL      string `json:"url" yaml:"url" toml:"url"`
+	Subject  string `json:"subject" yaml:"subject" toml:"subject"`
+	Requests bool   `json:"requests" yaml:"requests" toml:"requests"`
+}
+
+func (cf *configFile) brokers() []fileserver.EventBroker {
+	brokers := make([]fileserver.EventBroker, len(cf.Brokers))
+	for i, b := range cf.Brokers {
+		brokers[i] = fileserver.EventBroker{URL: b.URL, Subject: b.Subject, Requests: b.Requests}
+	}
+	return brokers
+}
+
+// configWebhook is a webhooks entry in the config file.
+type configWebhook struct {
+	URL    string   `json:"url" yaml:"url" toml:"url"`
+	Secret string   `json:"secret" yaml:"secret" toml:"secret"`
+	Events []string `json:"events" yaml:"events" toml:"events"`
+}
+
+func (cf *configFile) webhooks() []fileserver.Webhook {
+	hooks := make([]fileserver.Webhook, len(cf.Webhooks))
+	for i, h := range cf.Webhooks {
+		hooks[i] = fileserver.Webhook{URL: h.URL, Secret: h.Secret, Events: h.Events}
+	}
+	return hooks
+}
+
+// configGeoRule is a geoip.rules entry in the config file.
+type configGeoRule struct {
+	Path  string   `json:"path" yaml:"path" toml:"path"`
+	Allow []string `json:"allow" yaml:"allow" toml:"allow"`
+	Deny  []string `json:"deny" yaml:"deny" toml:"deny"`
+}
+
+func (cf *configFile) geoRules() []fileserver.GeoRule {
+	rules := make([]fileserver.GeoRule, len(cf.GeoIP.Rules))
+	for i, r := range cf.GeoIP.Rules {
+		rules[i] = fileserver.GeoRule{Path: r.Path, Allow: r.Allow, Deny: r.Deny}
+	}
+	return rules
+}
+
+// configProxy is a reverse_proxy entry in the config file.
+type configProxy struct {
+	Prefix          string            `json:"prefix" yaml:"prefix" toml:"prefix"`
+	Upstream        string            `json:"upstream" yaml:"upstream" toml:"upstream"`
+	StripPrefix     bool              `json:"strip_prefix" yaml:"strip_prefix" toml:"strip_prefix"`
+	PreserveHost    bool              `json:"preserve_host" yaml:"preserve_host" toml:"preserve_host"`
+	Timeout         string            `json:"timeout" yaml:"timeout" toml:"timeout"`
+	RequestHeaders  map[string]string `json:"request_headers" yaml:"request_headers" toml:"request_headers"`
+	ResponseHeaders map[string]string `json:"response_headers" yaml:"response_headers" toml:"response_headers"`
+}
+
+// proxyRoutes converts the config file's reverse_proxy entries.
+func (cf *configFile) proxyRoutes() ([]fileserver.ProxyRoute, error) {
+	routes := make([]fileserver.ProxyRoute, len(cf.Proxies))
+	for i, p := range cf.Proxies {
+		routes[i] = fileserver.ProxyRoute{
+			Prefix:          p.Prefix,
+			Upstream:        p.Upstream,
+			StripPrefix:     p.StripPrefix,
+			PreserveHost:    p.PreserveHost,
+			RequestHeaders:  p.RequestHeaders,
+			ResponseHeaders: p.ResponseHeaders,
+		}
+		if p.Timeout != "" {
+			d, err := time.ParseDuration(p.Timeout)
+			if err != nil || d < 0 {
+				return nil, fmt.Errorf("reverse_proxy %s: invalid timeout %q", p.Prefix, p.Timeout)
+			}
+			routes[i].Timeout = d
+		}
+	}
+	return routes, nil
+}
+
+// configRule is a rules entry in the config file.
+type configRule struct {
+	Regex    string `json:"regex" yaml:"regex" toml:"regex"`
+	Glob     string `json:"glob" yaml:"glob" toml:"glob"`
+	To       string `json:"to" yaml:"to" toml:"to"`
+	Status   int    `json:"status" yaml:"status" toml:"status"`
+	IfExists bool   `json:"if_exists" yaml:"if_exists" toml:"if_exists"`
+}
+
+func (cf *configFile) rules() []fileserver.Rule {
+	rules := make([]fileserver.Rule, len(cf.Rules))
+	for i, r := range cf.Rules {
+		rules[i] = fileserver.Rule{Regex: r.Regex, Glob: r.Glob, To: r.To, Status: r.Status, IfExists: r.IfExists}
+	}
+	return rules
+}
+
+// configRoute is a routes entry in the config file.
+type configRoute struct {
+	Path        string            `json:"path" yaml:"path" toml:"path"`
+	Status      int               `json:"status" yaml:"status" toml:"status"`
+	Body        string            `json:"body" yaml:"body" toml:"body"`
+	ContentType string            `json:"content_type" yaml:"content_type" toml:"content_type"`
+	Headers     map[string]string `json:"headers" yaml:"headers" toml:"headers"`
+}
+
+func (cf *configFile) routes() []fileserver.StaticRoute {
+	routes := make([]fileserver.StaticRoute, len(cf.Routes))
+	for i, r := range cf.Routes {
+		routes[i] = fileserver.StaticRoute{Path: r.Path, Status: r.Status, Body: r.Body, ContentType: r.ContentType, Headers: r.Headers}
+	}
+	return routes
+}
+
+// effectiveExcludes returns the -exclude flags, or the config file's
+// patterns when none were given on the command line.
+func effectiveExcludes(cf *configFile) []string {
+	if explicitFlags["exclude"] {
+		return excludes
+	}
+	return cf.Exclude
+}
+
+// flagValues maps flag names to the values set in the file. Empty values
+// are omitted so they fall back to the flag default.
+func (cf *configFile) flagValues() map[string]string {
+	v := map[string]string{
+		"addr":                 cf.Listen,
+		"socket-mode":          cf.Socket.Mode,
+		"socket-group":         cf.Socket.Group,
+		"dir":                  cf.Dir,
+		"archive":              cf.Archive,
+		"htpasswd":             cf.Auth.Htpasswd,
+		"keytab":               cf.Auth.Kerberos.Keytab,
+		"keytab-principal":     cf.Auth.Kerberos.Principal,
+		"error-pages":          cf.ErrorPages,
+		"cert":                 cf.TLS.Cert,
+		"key":                  cf.TLS.Key,
+		"https-addr":           cf.TLS.Listen,
+		"user":                 cf.Privileges.User,
+		"group":                cf.Privileges.Group,
+		"cache":                cf.Cache.TTL,
+		"log-level":            cf.Logging.Level,
+		"log-format":           cf.Logging.Format,
+		"log-output":           cf.Logging.Output,
+		"log-file":             cf.Logging.File,
+		"syslog-addr":          cf.Logging.SyslogAddr,
+		"access-log":           cf.Logging.AccessLog,
+		"security-log":         cf.Logging.SecurityLog,
+		"access-log-rotate":    cf.Logging.AccessLogRotate,
+		"log-slow":             cf.Logging.Slow,
+		"stats-interval":       cf.Logging.StatsInterval,
+		"otel-endpoint":        cf.Tracing.OTelEndpoint,
+		"statsd-addr":          cf.StatsD.Addr,
+		"statsd-prefix":        cf.StatsD.Prefix,
+		"statsd-tags":          strings.Join(cf.StatsD.Tags, ","),
+		"sentry-dsn":           cf.Sentry.DSN,
+		"sentry-environment":   cf.Sentry.Environment,
+		"geoip-db":             cf.GeoIP.CountryDB,
+		"geoip-asn-db":         cf.GeoIP.ASNDB,
+		"admin-addr":           cf.Admin.Listen,
+		"admin-token":          cf.Admin.Token,
+		"grpc-addr":            cf.GRPC.Listen,
+		"s3-addr":              cf.S3.Listen,
+		"s3-bucket":            cf.S3.Bucket,
+		"sftp-addr":            cf.SFTP.Listen,
+		"sftp-host-key":        cf.SFTP.HostKey,
+		"sftp-authorized-keys": cf.SFTP.AuthorizedKeys,
+		"upload-staging":       cf.Uploads.Staging,
+		"drop-ttl":             cf.Drop.TTL,
+		"drop-dir":             cf.Drop.Dir,
+		"short-links":          cf.ShortLinks,
+		"metadata-db":          cf.MetadataDB,
+		"brand-title":          cf.Branding.Title,
+		"brand-logo":           cf.Branding.Logo,
+		"brand-footer":         cf.Branding.Footer,
+		"recent-interval":      cf.Recent.Interval,
+		"search-index":         cf.Search.Index,
+		"search-interval":      cf.Search.Interval,
+		"trash-retention":      cf.Trash.Retention,
+		"lang":                 cf.Lang,
+		"mime-types":           cf.MIMETypesFile,
+		"trusted-proxies":      strings.Join(cf.Proxy.Trusted, ","),
+		"reserved-prefix":      cf.ReservedPrefix,
+		"noindex":              cf.NoIndex,
+		"pidfile":              cf.Pidfile,
+		"shutdown-timeout":     cf.ShutdownTimeout,
+		"middleware":           strings.Join(cf.Middleware, ","),
+	}
+	if cf.RateLimit.RPS != nil {
+		v["rate-limit"] = strconv.FormatFloat(*cf.RateLimit.RPS, 'g', -1, 64)
+	}
+	if cf.RateLimit.Burst != nil {
+		v["rate-burst"] = strconv.Itoa(*cf.RateLimit.Burst)
+	}
+	if cf.Uploads.Tus != nil {
+		v["tus"] = strconv.FormatBool(*cf.Uploads.Tus)
+	}
+	if cf.Uploads.TusMaxSize != 0 {
+		v["tus-max-size"] = strconv.FormatInt(cf.Uploads.TusMaxSize, 10)
+	}
+	if cf.Uploads.Chunked != nil {
+		v["chunked-uploads"] = strconv.FormatBool(*cf.Uploads.Chunked)
+	}
+	if cf.Drop.MaxSize != 0 {
+		v["drop-max-size"] = strconv.FormatInt(cf.Drop.MaxSize, 10)
+	}
+	if cf.Recent.Count != 0 {
+		v["recent-count"] = strconv.Itoa(cf.Recent.Count)
+	}
+	if cf.PageSize != 0 {
+		v["page-size"] = strconv.Itoa(cf.PageSize)
+	}
+	if cf.Versions != 0 {
+		v["versions"] = strconv.Itoa(cf.Versions)
+	}
+	if cf.MaxBody != 0 {
+		v["max-body-size"] = strconv.FormatInt(cf.MaxBody, 10)
+	}
+	if cf.MaxEntries != 0 {
+		v["max-list-entries"] = strconv.Itoa(cf.MaxEntries)
+	}
+	if cf.StripBOM != nil {
+		v["strip-bom"] = strconv.FormatBool(*cf.StripBOM)
+	}
+	if cf.NoRobots != nil {
+		v["no-robots"] = strconv.FormatBool(*cf.NoRobots)
+	}
+	if cf.GitHTTP != nil {
+		v["git-http"] = strconv.FormatBool(*cf.GitHTTP)
+	}
+	if cf.StatsD.DogStatsD != nil {
+		v["dogstatsd"] = strconv.FormatBool(*cf.StatsD.DogStatsD)
+	}
+	if cf.DirSizes != nil {
+		v["dir-sizes"] = strconv.FormatBool(*cf.DirSizes)
+	}
+	if cf.ShowPerms != nil {
+		v["show-perms"] = strconv.FormatBool(*cf.ShowPerms)
+	}
+	if cf.HumanSizes != nil {
+		v["human-sizes"] = strconv.FormatBool(*cf.HumanSizes)
+	}
+	if cf.Daemon != nil {
+		v["daemon"] = strconv.FormatBool(*cf.Daemon)
+	}
+	if cf.Proxy.Protocol != nil {
+		v["proxy-protocol"] = strconv.FormatBool(*cf.Proxy.Protocol)
+	}
+	if cf.TLS.RedirectHTTP != nil {
+		v["redirect-http"] = strconv.FormatBool(*cf.TLS.RedirectHTTP)
+	}
+	if cf.Privileges.Chroot != nil {
+		v["chroot"] = strconv.FormatBool(*cf.Privileges.Chroot)
+	}
+	if cf.Logging.Buffer != nil {
+		v["log-buffer"] = strconv.Itoa(*cf.Logging.Buffer)
+	}
+	if cf.Logging.SampleRate != nil {
+		v["log-sample"] = strconv.FormatFloat(*cf.Logging.SampleRate, 'g', -1, 64)
+	}
+	if cf.Logging.AccessLogMaxSize != 0 {
+		v["access-log-max-size"] = strconv.FormatInt(cf.Logging.AccessLogMaxSize, 10)
+	}
+	for k, s := range v {
+		if s == "" {
+			delete(v, k)
+		}
+	}
+	return v
+}
+
+func readConfigFile(path string) (*configFile, error) {
+	cf := &configFile{}
+	if path == "" {
+		return cf, nil
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".yaml", ".yml":
+		err = yaml.Unmarshal(data, cf)
+	case ".toml":
+		err = toml.Unmarshal(data, cf)
+	case ".json":
+		err = json.Unmarshal(data, cf)
+	default:
+		return nil, fmt.Errorf("unsupported config format %q (use .yaml, .toml or .json)", filepath.Ext(path))
+	}
+	if err != nil {
+		return nil, fmt.Errorf("parse %s: %w", path, err)
+	}
+	return cf, nil
+}
+
+// explicitFlags records the flags given on the command line, which always
+// take precedence over the config file.
+var explicitFlags = make(map[string]bool)
+
+// applyConfigFile reads the config file and assigns its values to every flag
+// that was not set explicitly. It must be called once, right after
+// flag.Parse.
+func applyConfigFile(path string) (*configFile, error) {
+	flag.Visit(func(f *flag.Flag) {
+		explicitFlags[f.Name] = true
+	})
+	if explicitFlags["listen"] {
+		explicitFlags["addr"] = true
+	}
+	cf, err := readConfigFile(path)
+	if err != nil {
+		return nil, err
+	}
+	for name, value := range cf.flagValues() {
+		if explicitFlags[name] {
+			continue
+		}
+		if err := flag.Set(name, value); err != nil {
+			return nil, fmt.Errorf("config value for %s: %w", name, err)
+		}
+	}
+	return cf, nil
+}
+
+// resolve returns the effective value of a flag given its config file value:
+// command line first, then the file, then the flag default.
+func resolve(name, fileValue string) string {
+	f := flag.Lookup(name)
+	switch {
+	case explicitFlags[name]:
+		return f.Value.String()
+	case fileValue != "":
+		return fileValue
+	default:
+		return f.DefValue
+	}
+}
+
+// buildSettings derives the reloadable settings from a parsed config file.
+func buildSettings(cf *configFile) (fileserver.Settings, error) {
+	ttl, err := time.ParseDuration(resolve("cache", cf.Cache.TTL))
+	if err != nil || ttl <= 0 {
+		return fileserver.Settings{}, fmt.Errorf("invalid cache TTL %q", resolve("cache", cf.Cache.TTL))
+	}
+	s := fileserver.Settings{
+		CacheTTL:  ttl,
+		Headers:   cf.Headers,
+		AuthRealm: cf.Auth.Realm,
+		AuthUsers: cf.Auth.Users,
+	}
+	rate := resolve("log-sample", cf.flagValues()["log-sample"])
+	if s.LogSampleRate, err = strconv.ParseFloat(rate, 64); err != nil || s.LogSampleRate < 0 || s.LogSampleRate > 1 {
+		return fileserver.Settings{}, fmt.Errorf("invalid log sample rate %q (want 0..1)", rate)
+	}
+	rps := resolve("rate-limit", cf.flagValues()["rate-limit"])
+	if s.RateLimit, err = strconv.ParseFloat(rps, 64); err != nil || s.RateLimit < 0 {
+		return fileserver.Settings{}, fmt.Errorf("invalid rate limit %q", rps)
+	}
+	burst := resolve("rate-burst", cf.flagValues()["rate-burst"])
+	if s.RateBurst, err = strconv.Atoi(burst); err != nil || s.RateBurst < 0 {
+		return fileserver.Settings{}, fmt.Errorf("invalid rate burst %q", burst)
+	}
+	slow := resolve("log-slow", cf.Logging.Slow)
+	if s.LogSlow, err = time.ParseDuration(slow); err != nil {
+		return fileserver.Settings{}, fmt.Errorf("invalid slow request threshold %q", slow)
+	}
+
+	var lvl slog.Level
+	level := resolve("log-level", cf.Logging.Level)
+	if err := lvl.UnmarshalText([]byte(level)); err != nil {
+		return fileserver.Settings{}, fmt.Errorf("invalid log level %q", level)
+	}
+	logLevel.Set(lvl)
+	return s, nil
+}
+
+// reloadSettings re-reads the config file and swaps in the new settings.
+// Only reload-safe values (cache TTL, log level and sampling, headers, auth,
+// rate limits) take effect; listeners, TLS and directories require a restart. On error
+// the previous settings stay in effect.
+func reloadSettings(srv *fileserver.Server, path string) {
+	cf, err := readConfigFile(path)
+	if err == nil {
+		var s fileserver.Settings
+		if s, err = buildSettings(cf); err == nil {
+			err = srv.SetSettings(s)
+		}
+		if err == nil {
+			srv.Publish(fileserver.Event{Type: "config.reload"})
+			slog.Info("Config reloaded", "path", path, "cache_ttl", s.CacheTTL.String(), "log_level", logLevel.Level().String())
+			return
+		}
+	}
+	slog.Error("Config reload failed, keeping previous settings", "path", path, "error", err)
+}
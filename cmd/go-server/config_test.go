@@ -0,0 +1,21 @@
+package main
+
+import "testing"
+
+func TestBuildSettingsRateLimit(t *testing.T) {
+	var cf configFile
+	rps, burst := 5.0, 7
+	cf.RateLimit.RPS, cf.RateLimit.Burst = &rps, &burst
+	st, err := buildSettings(&cf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if st.RateLimit != 5 || st.RateBurst != 7 {
+		t.Errorf("got rate %v burst %d, want 5 and 7", st.RateLimit, st.RateBurst)
+	}
+
+	rps = -1
+	if _, err := buildSettings(&cf); err == nil {
+		t.Error("buildSettings accepted a negative rate limit")
+	}
+}
@@ -0,0 +1,13 @@
+//go:build !unix
+
+package main
+
+import "errors"
+
+func daemonize(logFile string) (bool, error) {
+	return false, errors.New("-daemon is only supported on Unix systems; use -service on Windows")
+}
+
+// processAlive is conservative where it cannot check: a leftover pidfile is
+// assumed stale.
+func processAlive(pid int) bool { return false }
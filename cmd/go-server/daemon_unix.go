@@ -0,0 +1,53 @@
+//go:build unix
+
+package main
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"syscall"
+)
+
+// daemonEnv marks the re-executed child so it does not fork again.
+const daemonEnv = "GO_SERVER_DAEMON"
+
+// daemonize re-executes the program in a new session, detached from the
+// terminal, with stdin on /dev/null and stdout/stderr appended to logFile
+// (or discarded). It reports true in the parent, which should exit, and
+// false in the daemon itself.
+func daemonize(logFile string) (bool, error) {
+	if os.Getenv(daemonEnv) == "1" {
+		os.Unsetenv(daemonEnv)
+		return false, nil
+	}
+	exe, err := os.Executable()
+	if err != nil {
+		return false, err
+	}
+	out, err := os.OpenFile(os.DevNull, os.O_WRONLY, 0)
+	if logFile != "" {
+		out, err = os.OpenFile(logFile, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0o640)
+	}
+	if err != nil {
+		return false, err
+	}
+	defer out.Close()
+
+	cmd := exec.Command(exe, os.Args[1:]...)
+	cmd.Env = append(os.Environ(), daemonEnv+"=1")
+	cmd.Stdout = out
+	cmd.Stderr = out
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setsid: true}
+	if err := cmd.Start(); err != nil {
+		return false, fmt.Errorf("start daemon: %w", err)
+	}
+	fmt.Printf("Started daemon, pid %d\n", cmd.Process.Pid)
+	return true, cmd.Process.Release()
+}
+
+// processAlive reports whether a process with the given ID exists.
+func processAlive(pid int) bool {
+	err := syscall.Kill(pid, 0)
+	return err == nil || err == syscall.EPERM
+}
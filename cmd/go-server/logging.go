@@ -0,0 +1,97 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"strings"
+
+	"github.com/AScotM/go-server/pkg/fileserver"
+)
+
+var logLevel = new(slog.LevelVar)
+
+// logClosers flush and close log outputs; see closeLogs.
+var logClosers []func() error
+
+// closeLogs flushes buffered log output. It runs on shutdown and before
+// fatal exits.
+func closeLogs() {
+	for i := len(logClosers) - 1; i >= 0; i-- {
+		logClosers[i]()
+	}
+	logClosers = nil
+}
+
+// setupLogger installs the default slog logger in the requested format
+// ("text" or "json") at the requested level. Output goes to stderr, the
+// local or remote syslog (syslogAddr), or journald. A positive buffer makes
+// stderr output asynchronous with that many queued records.
+func setupLogger(format, level, output, syslogAddr, file string, buffer int) error {
+	if err := logLevel.UnmarshalText([]byte(level)); err != nil {
+		return fmt.Errorf("invalid log level %q", level)
+	}
+	opts := &slog.HandlerOptions{Level: logLevel}
+	var h slog.Handler
+	switch strings.ToLower(output) {
+	case "stderr":
+		var w io.Writer = os.Stderr
+		if file != "" {
+			f, err := os.OpenFile(file, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0o640)
+			if err != nil {
+				return fmt.Errorf("open log file: %w", err)
+			}
+			logClosers = append(logClosers, f.Close)
+			w = f
+		}
+		if buffer > 0 {
+			aw := fileserver.NewAsyncWriter(w, buffer)
+			logClosers = append(logClosers, aw.Close)
+			w = aw
+		}
+		switch strings.ToLower(format) {
+		case "text":
+			h = slog.NewTextHandler(w, opts)
+		case "json":
+			h = slog.NewJSONHandler(w, opts)
+		default:
+			return fmt.Errorf("invalid log format %q", format)
+		}
+	case "syslog":
+		sink, err := newSyslogSink(syslogAddr)
+		if err != nil {
+			return err
+		}
+		if h, err = newSinkHandler(format, opts, sink); err != nil {
+			return err
+		}
+	case "eventlog":
+		sink, err := newEventLogSink()
+		if err != nil {
+			return err
+		}
+		if h, err = newSinkHandler(format, opts, sink); err != nil {
+			return err
+		}
+	case "journald":
+		sink, err := newJournaldSink()
+		if err != nil {
+			return err
+		}
+		if h, err = newSinkHandler(format, opts, sink); err != nil {
+			return err
+		}
+	default:
+		return fmt.Errorf("invalid log output %q", output)
+	}
+	slog.SetDefault(slog.New(h))
+	return nil
+}
+
+// fatal logs msg at error level and exits.
+func fatal(msg string, args ...any) {
+	slog.Error(msg, args...)
+	closeLogs()
+	os.Exit(1)
+}
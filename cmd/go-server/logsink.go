@@ -0,0 +1,206 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"log/slog"
+	"net"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// logSink delivers one formatted log record with its severity.
+type logSink interface {
+	Send(level slog.Level, msg string) error
+}
+
+// priority maps a slog level onto a syslog severity (RFC 5424 section 6.2.1).
+func priority(level slog.Level) int {
+	switch {
+	case level >= slog.LevelError:
+		return 3 // err
+	case level >= slog.LevelWarn:
+		return 4 // warning
+	case level >= slog.LevelInfo:
+		return 6 // info
+	default:
+		return 7 // debug
+	}
+}
+
+// sinkHandler formats records with a regular slog handler into a buffer and
+// hands the result to a logSink. Handlers derived through WithAttrs and
+// WithGroup share the buffer and its lock.
+type sinkHandler struct {
+	inner slog.Handler
+	mu    *sync.Mutex
+	buf   *bytes.Buffer
+	sink  logSink
+}
+
+func newSinkHandler(format string, opts *slog.HandlerOptions, sink logSink) (*sinkHandler, error) {
+	h := &sinkHandler{mu: new(sync.Mutex), buf: new(bytes.Buffer), sink: sink}
+	// The sink supplies its own timestamp and severity.
+	o := *opts
+	o.ReplaceAttr = func(groups []string, a slog.Attr) slog.Attr {
+		if len(groups) == 0 && (a.Key == slog.TimeKey || a.Key == slog.LevelKey) {
+			return slog.Attr{}
+		}
+		return a
+	}
+	switch strings.ToLower(format) {
+	case "text":
+		h.inner = slog.NewTextHandler(h.buf, &o)
+	case "json":
+		h.inner = slog.NewJSONHandler(h.buf, &o)
+	default:
+		return nil, fmt.Errorf("invalid log format %q", format)
+	}
+	return h, nil
+}
+
+func (h *sinkHandler) Enabled(ctx context.Context, level slog.Level) bool {
+	return h.inner.Enabled(ctx, level)
+}
+
+func (h *sinkHandler) Handle(ctx context.Context, r slog.Record) error {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.buf.Reset()
+	if err := h.inner.Handle(ctx, r); err != nil {
+		return err
+	}
+	return h.sink.Send(r.Level, strings.TrimRight(h.buf.String(), "\n"))
+}
+
+func (h *sinkHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	c := *h
+	c.inner = h.inner.WithAttrs(attrs)
+	return &c
+}
+
+func (h *sinkHandler) WithGroup(name string) slog.Handler {
+	c := *h
+	c.inner = h.inner.WithGroup(name)
+	return &c
+}
+
+// syslogSink writes RFC 5424 messages to a local or remote syslog daemon.
+type syslogSink struct {
+	network, addr string
+	hostname, app string
+	conn          net.Conn
+}
+
+// newSyslogSink connects to addr, which is empty for the local daemon or a
+// URL such as udp://host:514, tcp://host:601 or unix:///dev/log.
+func newSyslogSink(addr string) (*syslogSink, error) {
+	s := &syslogSink{app: filepath.Base(os.Args[0])}
+	s.hostname, _ = os.Hostname()
+	if s.hostname == "" {
+		s.hostname = "-"
+	}
+	if addr == "" {
+		s.network, s.addr = "unixgram", "/dev/log"
+	} else {
+		u, err := url.Parse(addr)
+		if err != nil {
+			return nil, fmt.Errorf("invalid syslog address %q: %w", addr, err)
+		}
+		switch u.Scheme {
+		case "udp", "tcp":
+			s.network, s.addr = u.Scheme, u.Host
+		case "unix", "unixgram":
+			s.network, s.addr = "unixgram", u.Path
+		default:
+			return nil, fmt.Errorf("unsupported syslog scheme %q", u.Scheme)
+		}
+	}
+	if err := s.connect(); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+func (s *syslogSink) connect() error {
+	conn, err := net.Dial(s.network, s.addr)
+	if err != nil {
+		return fmt.Errorf("connect to syslog %s %s: %w", s.network, s.addr, err)
+	}
+	s.conn = conn
+	return nil
+}
+
+const facilityDaemon = 3
+
+func (s *syslogSink) Send(level slog.Level, msg string) error {
+	line := fmt.Sprintf("<%d>1 %s %s %s %d - - %s",
+		facilityDaemon*8+priority(level),
+		time.Now().Format(time.RFC3339Nano),
+		s.hostname, s.app, os.Getpid(), msg)
+	if s.network == "tcp" {
+		// Octet counting framing, RFC 6587 section 3.4.1.
+		line = strconv.Itoa(len(line)) + " " + line
+	}
+	if _, err := s.conn.Write([]byte(line)); err != nil {
+		// Reconnect once, e.g. after a syslog daemon restart.
+		s.conn.Close()
+		if err := s.connect(); err != nil {
+			return err
+		}
+		_, err = s.conn.Write([]byte(line))
+		return err
+	}
+	return nil
+}
+
+// journaldSink writes entries using the systemd journal native protocol.
+type journaldSink struct {
+	conn       *net.UnixConn
+	identifier string
+}
+
+const journalSocket = "/run/systemd/journal/socket"
+
+func newJournaldSink() (*journaldSink, error) {
+	conn, err := net.DialUnix("unixgram", nil, &net.UnixAddr{Name: journalSocket, Net: "unixgram"})
+	if err != nil {
+		return nil, fmt.Errorf("connect to journald: %w", err)
+	}
+	return &journaldSink{conn: conn, identifier: filepath.Base(os.Args[0])}, nil
+}
+
+func (j *journaldSink) Send(level slog.Level, msg string) error {
+	var b bytes.Buffer
+	writeJournalField(&b, "PRIORITY", strconv.Itoa(priority(level)))
+	writeJournalField(&b, "SYSLOG_IDENTIFIER", j.identifier)
+	writeJournalField(&b, "MESSAGE", msg)
+	_, err := j.conn.Write(b.Bytes())
+	return err
+}
+
+// writeJournalField encodes one field, using the length-prefixed binary form
+// for values containing newlines.
+func writeJournalField(b *bytes.Buffer, key, value string) {
+	b.WriteString(key)
+	if !strings.Contains(value, "\n") {
+		b.WriteByte('=')
+		b.WriteString(value)
+		b.WriteByte('\n')
+		return
+	}
+	b.WriteByte('\n')
+	var n [8]byte
+	for i := range n {
+		n[i] = byte(uint64(len(value)) >> (8 * i))
+	}
+	b.Write(n[:])
+	b.WriteString(value)
+	b.WriteByte('\n')
+}
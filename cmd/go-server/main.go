@@ -0,0 +1,715 @@
+// Command go-server serves a directory over HTTP(S). It wraps the
+// pkg/fileserver library with flags, a config file, logging outputs,
+// listeners and process management.
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log/slog"
+	"net"
+	"net/http"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
+
+	"github.com/AScotM/go-server/pkg/fileserver"
+)
+
+var (
+	addr         = flag.String("addr", defaultAddr(), "HTTP network address, or unix:/path/to.sock (defaults to $HOST:$PORT when set)")
+	socketMode   = flag.String("socket-mode", "0660", "Permissions for Unix domain sockets (octal)")
+	socketGroup  = flag.String("socket-group", "", "Group owning Unix domain sockets")
+	baseDir      = flag.String("dir", ".", "Base directory to serve")
+	archive      = flag.String("archive", "", "Serve this .zip or .tar file read-only in place of -dir, without extracting it")
+	cacheTTL     = flag.Duration("cache", 10*time.Second, "Cache TTL")
+	certFile     = flag.String("cert", "", "TLS certificate file")
+	keyFile      = flag.String("key", "", "TLS key file")
+	httpsAddr    = flag.String("https-addr", "", "Serve HTTPS on this address in addition to plain HTTP on -addr")
+	redirect     = flag.Bool("redirect-http", false, "Redirect plain HTTP requests to the -https-addr listener")
+	runAsUser    = flag.String("user", "", "Drop privileges to this user after binding")
+	runAsGroup   = flag.String("group", "", "Drop privileges to this group after binding")
+	chroot       = flag.Bool("chroot", false, "Chroot to the base directory after binding (requires -user)")
+	logFormat    = flag.String("log-format", "text", "Log output format: text or json")
+	logOutput    = flag.String("log-output", "stderr", "Log destination: stderr, syslog, journald or eventlog (Windows)")
+	logFile      = flag.String("log-file", "", "Append logs to this file instead of stderr (with -daemon, also receives the daemon's stdout/stderr)")
+	syslogAddr   = flag.String("syslog-addr", "", "Syslog server URL (udp://host:514, tcp://host:601, unix:///dev/log); empty for the local daemon")
+	logBuffer    = flag.Int("log-buffer", 0, "Queue up to this many log writes and write them asynchronously (0 writes synchronously)")
+	logLvl       = flag.String("log-level", "info", "Log level: debug, info, warn or error")
+	logSample    = flag.Float64("log-sample", 1, "Fraction (0..1) of successful requests to log; errors are always logged")
+	logSlow      = flag.Duration("log-slow", 0, "Always log requests slower than this, regardless of sampling (0 disables)")
+	statsEvery   = flag.Duration("stats-interval", 0, "Log a request count and latency percentile summary at this interval (0 disables)")
+	accessPath   = flag.String("access-log", "", "Write an Apache combined format access log to this file")
+	securityPath = flag.String("security-log", "", "Write authentication failures, path traversal attempts and rate limit hits to this file, for fail2ban")
+	accessSize   = flag.Int64("access-log-max-size", 0, "Rotate the access log after this many bytes (0 disables)")
+	accessAge    = flag.Duration("access-log-rotate", 0, "Rotate the access log after this interval (0 disables)")
+	statsdAddr   = flag.String("statsd-addr", "", "Push request counts, latencies and response sizes to the StatsD agent at this host:port over UDP")
+	statsdPrefix = flag.String("statsd-prefix", "go_server.", "Prefix of the metric names sent to -statsd-addr")
+	dogStatsD    = flag.Bool("dogstatsd", false, "Send -statsd-addr metrics in the DogStatsD format, tagged with method and status class")
+	statsdTags   = flag.String("statsd-tags", "", "Comma-separated tags added to every DogStatsD metric, e.g. env:prod,service:files")
+	sentryDSN    = flag.String("sentry-dsn", "", "Report handler panics and 5xx responses, with the request and a stack trace, to this Sentry DSN")
+	sentryEnv    = flag.String("sentry-environment", "", "Environment reported to Sentry, e.g. production")
+	geoipDB      = flag.String("geoip-db", "", "MaxMind country database (.mmdb) for the country in the request log and the config file's geoip rules")
+	geoipASNDB   = flag.String("geoip-asn-db", "", "MaxMind ASN database (.mmdb) for the client network in the request log")
+	otelURL      = flag.String("otel-endpoint", "", "OTLP/HTTP endpoint URL for exporting traces (e.g. http://localhost:4318)")
+	reserved     = flag.String("reserved-prefix", "/_", "URL path below which the API, assets, uploads and other endpoints live; their former paths redirect there unless a file exists at them")
+	adminToken   = flag.String("admin-token", "", "Bearer token enabling the /admin API")
+	adminAddr    = flag.String("admin-addr", "", "Serve the /admin API on this address instead of the main listener")
+	tusUploads   = flag.Bool("tus", false, "Accept resumable tus uploads under /files/ into read-write mounts")
+	tusMax       = flag.Int64("tus-max-size", 0, "Largest tus upload in bytes (default 10 GiB)")
+	chunkedUp    = flag.Bool("chunked-uploads", false, "Enable the /api/v1/uploads chunked upload API for read-write mounts")
+	uploadStage  = flag.String("upload-staging", "", "Directory for unfinished uploads (default: a go-server-uploads directory under the system temp dir)")
+	dropTTL      = flag.Duration("drop-ttl", 0, "Enable the /drop/ zone for anonymous one-time downloads expiring after this long (0 disables)")
+	dropDir      = flag.String("drop-dir", "", "Directory for /drop/ files (default: a go-server-drop directory under the system temp dir)")
+	dropMax      = flag.Int64("drop-max-size", 0, "Largest /drop/ upload in bytes (default 100 MiB)")
+	uiLang       = flag.String("lang", "", "Language of listing pages: "+strings.Join(fileserver.Languages, ", ")+" (default: from Accept-Language)")
+	pageSize     = flag.Int("page-size", 1000, "Split HTML listings of more entries into pages (-1 disables)")
+	recentEvery  = flag.Duration("recent-interval", 0, "Enable /recent, the newest files of the tree, from an index rebuilt this often (0 disables)")
+	recentCount  = flag.Int("recent-count", 100, "Number of files shown on /recent")
+	searchIndex  = flag.String("search-index", "", "Directory of a full-text index that lets /search look into file contents (created if missing)")
+	searchEvery  = flag.Duration("search-interval", 10*time.Minute, "How often the search index rescans the tree")
+	brandTitle   = flag.String("brand-title", "", "Site title shown on listing pages")
+	brandLogo    = flag.String("brand-logo", "", "Logo on listing pages: an image URL or a local file")
+	brandFooter  = flag.String("brand-footer", "", "Footer text on listing pages")
+	trashKeep    = flag.Duration("trash-retention", 0, "Move deleted files into a .trash directory of their mount, restorable from /trash, and purge them after this long (0 deletes immediately)")
+	versions     = flag.Int("versions", 0, "Keep this many previous versions of files overwritten in read-write mounts, restorable through /api/v1/versions (0 disables)")
+	maxBody      = flag.Int64("max-body-size", 1<<20, "Largest request body in bytes accepted by /post, /api and the JSON endpoints of /api/v1/")
+	maxEntries   = flag.Int("max-list-entries", 100000, "Read at most this many entries of a directory for a listing, marking it truncated (-1 disables)")
+	noIndex      = flag.String("noindex", "forbid", "Treatment of directories holding a .noindex file: forbid or hide their listings (403 or 404), deny everything in them, or off")
+	mimeFile     = flag.String("mime-types", "", "File in mime.types format mapping extensions to the Content-Type they are served with")
+	stripBOM     = flag.Bool("strip-bom", false, "Leave the UTF-8 byte order mark out of text files served over HTTP")
+	htpasswd     = flag.String("htpasswd", "", "Apache htpasswd file (bcrypt, MD5-APR1 or SHA-1 hashes) of further users for Basic auth, re-read when it changes")
+	keytabPath   = flag.String("keytab", "", "Kerberos keytab of the HTTP service principal, enabling Negotiate (SPNEGO) single sign-on for domain-joined browsers")
+	keytabSPN    = flag.String("keytab-principal", "", "Keytab entry to use with -keytab, such as HTTP/files.corp.example.com (default: the one each ticket names)")
+	gitHTTP      = flag.Bool("git-http", false, "Serve bare Git repositories read-only over the smart HTTP protocol, so git clone works on their URLs (needs git)")
+	noRobots     = flag.Bool("no-robots", false, "Serve a deny-all robots.txt and send X-Robots-Tag: noindex with every response")
+	dirSizes     = flag.Bool("dir-sizes", false, "Show total sizes of subdirectories in listings, computed in the background")
+	showPerms    = flag.Bool("show-perms", false, "Show Unix permissions, owner and group in HTML and JSON listings")
+	humanSizes   = flag.Bool("human-sizes", true, "Show listing sizes as KiB/MiB/GiB; false shows exact byte counts")
+	shortLinks   = flag.String("short-links", "", "JSON file storing short links, enabling POST /api/v1/shorten and /s/ redirects")
+	metadataDB   = flag.String("metadata-db", "", "SQLite database keeping short links, an upload audit trail and download counts (created if missing)")
+	grpcAddr     = flag.String("grpc-addr", "", "Serve the gRPC file service on this address (TLS when -cert and -key are set)")
+	s3Addr       = flag.String("s3-addr", "", "Serve the S3-compatible API on this address (TLS when -cert and -key are set)")
+	s3Bucket     = flag.String("s3-bucket", "go-server", "Bucket name of the served tree in the S3 API")
+	sftpAddr     = flag.String("sftp-addr", "", "Serve SFTP over SSH on this address")
+	sftpHostKey  = flag.String("sftp-host-key", "", "SSH host key file for -sftp-addr, created if missing (default: a temporary key)")
+	sftpKeys     = flag.String("sftp-authorized-keys", "", "authorized_keys file of public keys allowed to log in over SFTP")
+	errorPages   = flag.String("error-pages", "", "Directory of custom error page templates (404.html, 500.html, error.html, ...)")
+	proxies      = flag.String("trusted-proxies", "", "Comma-separated CIDRs of proxies whose X-Forwarded-For/X-Real-IP/PROXY headers are trusted")
+	proxyProto   = flag.Bool("proxy-protocol", false, "Expect a HAProxy PROXY protocol (v1 or v2) header on every connection")
+	middleware   = flag.String("middleware", "", "Comma-separated request middleware, outermost first (default "+strings.Join(fileserver.DefaultMiddleware, ",")+"; also ratelimit, compress)")
+	rateLimit    = flag.Float64("rate-limit", 0, "Requests per second allowed per client by the ratelimit middleware, which -middleware must then list")
+	rateBurst    = flag.Int("rate-burst", 0, "Burst size for -rate-limit (defaults to one second's worth)")
+	drainTimeout = flag.Duration("shutdown-timeout", defaultDrainTimeout(), "How long to wait for in-flight requests on shutdown (defaults to $SHUTDOWN_TIMEOUT)")
+	daemon       = flag.Bool("daemon", false, "Detach from the terminal and run in the background (Unix)")
+	pidPath      = flag.String("pidfile", "", "Write the process ID to this file while running")
+	serviceCmd   = flag.String("service", "", "Windows service control: install, uninstall, start or stop")
+	showVersion  = flag.Bool("version", false, "Print version information and exit")
+	configPath   = flag.String("config", "", "Config file (.yaml, .toml or .json); flags override its values, reload-safe settings are re-read on SIGHUP")
+)
+
+// mounts holds the repeatable -mount flag.
+var mounts mountList
+
+// excludes holds the repeatable -exclude flag.
+var excludes stringList
+
+func init() {
+	flag.StringVar(addr, "listen", *addr, "Alias for -addr")
+	flag.Var(&mounts, "mount", "Serve a further directory under a URL prefix: /prefix=/dir[,rw][,hidden=show|hide|deny], or a remote directory /prefix=https://[user:pass@]host/path/[,webdav][,cache=/dir][,ttl=30s] (repeatable)")
+	flag.Var(&excludes, "exclude", "Hide paths matching a glob such as '*.key' or 'secrets/**' from listings and answer them with 404 (repeatable)")
+}
+
+// stringList is a repeatable string flag.
+type stringList []string
+
+func (l *stringList) String() string {
+	if l == nil {
+		return ""
+	}
+	return strings.Join(*l, " ")
+}
+
+func (l *stringList) Set(v string) error {
+	*l = append(*l, v)
+	return nil
+}
+
+// defaultAddr honours the HOST and PORT environment variables used by the
+// earlier standalone servers.
+func defaultAddr() string {
+	host, port := os.Getenv("HOST"), os.Getenv("PORT")
+	if host == "" && port == "" {
+		return ":8080"
+	}
+	if port == "" {
+		port = "8080"
+	}
+	return net.JoinHostPort(host, port)
+}
+
+// defaultDrainTimeout reads SHUTDOWN_TIMEOUT as a duration ("45s") or a
+// number of seconds.
+func defaultDrainTimeout() time.Duration {
+	const fallback = 30 * time.Second
+	v := os.Getenv("SHUTDOWN_TIMEOUT")
+	if v == "" {
+		return fallback
+	}
+	if d, err := time.ParseDuration(v); err == nil && d > 0 {
+		return d
+	}
+	if secs, err := strconv.Atoi(v); err == nil && secs > 0 {
+		return time.Duration(secs) * time.Second
+	}
+	fmt.Fprintf(os.Stderr, "Invalid SHUTDOWN_TIMEOUT %q, using %v\n", v, fallback)
+	return fallback
+}
+
+func newServer(addr string, handler http.Handler, fs *fileserver.Server) *http.Server {
+	return &http.Server{
+		Addr:         addr,
+		Handler:      handler,
+		ReadTimeout:  10 * time.Second,
+		WriteTimeout: 30 * time.Second,
+		IdleTimeout:  120 * time.Second,
+		ConnState:    fs.TrackConn,
+	}
+}
+
+// openLog opens a log file, rotated by size and age when they are
+// positive, and reopens it on the log-reopen signal for external rotation.
+func openLog(what, path string, maxSize int64, rotateEvery time.Duration) *fileserver.AccessLog {
+	l, err := fileserver.NewAccessLog(path, maxSize, rotateEvery, *logBuffer)
+	if err != nil {
+		fatal("Failed to open "+what, "path", path, "error", err)
+	}
+	logClosers = append(logClosers, l.Close)
+
+	reopen := make(chan os.Signal, 1)
+	notifyReopen(reopen)
+	go func() {
+		for range reopen {
+			if err := l.Reopen(); err != nil {
+				slog.Error("Failed to reopen "+what, "path", path, "error", err)
+			} else {
+				slog.Info("Reopened "+what, "path", path)
+			}
+		}
+	}()
+	return l
+}
+
+// statsdOptions returns the StatsD sink configured by the flags, if any.
+func statsdOptions() *fileserver.StatsD {
+	if *statsdAddr == "" {
+		return nil
+	}
+	return &fileserver.StatsD{Addr: *statsdAddr, Prefix: *statsdPrefix, DogStatsD: *dogStatsD, Tags: splitList(*statsdTags)}
+}
+
+// sentryOptions returns the Sentry reporting configured by the flags, if
+// any, tagged with the build version.
+func sentryOptions() *fileserver.Sentry {
+	if *sentryDSN == "" {
+		return nil
+	}
+	return &fileserver.Sentry{DSN: *sentryDSN, Environment: *sentryEnv, Release: version}
+}
+
+// geoipOptions returns the GeoIP lookups configured by the flags, with the
+// config file's rules, if any.
+func geoipOptions(cf *configFile) *fileserver.GeoIP {
+	rules := cf.geoRules()
+	if *geoipDB == "" && *geoipASNDB == "" && len(rules) == 0 {
+		return nil
+	}
+	return &fileserver.GeoIP{CountryDB: *geoipDB, ASNDB: *geoipASNDB, Rules: rules}
+}
+
+// splitList splits a comma-separated flag value, dropping empty items.
+func splitList(s string) []string {
+	var out []string
+	for _, item := range strings.Split(s, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			out = append(out, item)
+		}
+	}
+	return out
+}
+
+// flagConfig reports the effective flag values for GET /admin/config.
+func flagConfig() map[string]string {
+	cfg := make(map[string]string)
+	flag.VisitAll(func(f *flag.Flag) {
+		if f.Name == "admin-token" {
+			return
+		}
+		cfg[f.Name] = f.Value.String()
+	})
+	return cfg
+}
+
+func main() {
+	flag.Parse()
+	if *showVersion {
+		fmt.Println(versionString())
+		return
+	}
+	if *serviceCmd != "" {
+		if err := controlService(*serviceCmd); err != nil {
+			fmt.Fprintf(os.Stderr, "Service %s failed: %v\n", *serviceCmd, err)
+			os.Exit(1)
+		}
+		return
+	}
+
+	cf, err := applyConfigFile(*configPath)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to load config: %v\n", err)
+		os.Exit(2)
+	}
+	if *daemon {
+		parent, err := daemonize(*logFile)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+		if parent {
+			return
+		}
+	}
+
+	// Services have no console; a stop request from the service manager
+	// arrives on quit like a signal would.
+	quit := make(chan os.Signal, 1)
+	serviceDone := func() {}
+	if runningAsService() {
+		serviceDone = startService(quit)
+		if *logOutput == "stderr" {
+			*logOutput = "eventlog"
+		}
+	}
+
+	if err := setupLogger(*logFormat, *logLvl, *logOutput, *syslogAddr, *logFile, *logBuffer); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(2)
+	}
+
+	trusted, err := fileserver.ParseTrustedProxies(*proxies)
+	if err != nil {
+		fatal("Invalid -trusted-proxies", "error", err)
+	}
+
+	settings, err := buildSettings(cf)
+	if err != nil {
+		fatal("Invalid config", "path", *configPath, "error", err)
+	}
+
+	shutdownTracing, err := setupTracing(context.Background(), *otelURL)
+	if err != nil {
+		fatal("Failed to set up tracing", "error", err)
+	}
+
+	var accessLog *fileserver.AccessLog
+	if *accessPath != "" {
+		accessLog = openLog("access log", *accessPath, *accessSize, *accessAge)
+	}
+	// The security log is left to logrotate, so that fail2ban, which
+	// follows renames, sees every line.
+	var securityLog io.Writer
+	if *securityPath != "" {
+		securityLog = openLog("security log", *securityPath, 0, 0)
+	}
+
+	// After -chroot the base directory is the new root. Requests are only
+	// served once the chroot is in place.
+	root := *baseDir
+	chrootDir := ""
+	ms, err := effectiveMounts(cf)
+	if err != nil {
+		fatal("Invalid mount", "error", err)
+	}
+	if *chroot {
+		if len(ms) > 0 {
+			fatal("-chroot cannot be combined with mounts")
+		}
+		if *runAsUser == "" {
+			fatal("-chroot requires -user, as a chroot does not confine root")
+		}
+		if chrootDir, err = filepath.Abs(*baseDir); err != nil {
+			fatal("Failed to resolve base directory", "error", err)
+		}
+		root = "/"
+	}
+
+	// The archive is opened before any chroot and read in place.
+	var archiveFS fileserver.Archive
+	if *archive != "" {
+		if *chroot {
+			fatal("-chroot cannot be combined with -archive")
+		}
+		if archiveFS, err = fileserver.OpenArchive(*archive); err != nil {
+			fatal("Failed to open archive", "error", err)
+		}
+		defer archiveFS.Close()
+		root = *archive
+	}
+
+	proxies, err := cf.proxyRoutes()
+	if err != nil {
+		fatal("Invalid config", "path", *configPath, "error", err)
+	}
+
+	fs, err := fileserver.New(fileserver.Options{
+		Settings:        settings,
+		Root:            root,
+		FS:              archiveFS,
+		Mounts:          ms,
+		Proxies:         proxies,
+		Rules:           cf.rules(),
+		Routes:          cf.routes(),
+		ReservedPrefix:  *reserved,
+		Tus:             *tusUploads,
+		TusMaxSize:      *tusMax,
+		UploadStaging:   *uploadStage,
+		ChunkedUploads:  *chunkedUp,
+		DropTTL:         *dropTTL,
+		DropDir:         *dropDir,
+		DropMaxSize:     *dropMax,
+		ShortLinks:      *shortLinks,
+		MetadataDB:      *metadataDB,
+		ExactSizes:      !*humanSizes,
+		Language:        *uiLang,
+		PageSize:        *pageSize,
+		ShowPerms:       *showPerms,
+		DirSizes:        *dirSizes,
+		TrashRetention:  *trashKeep,
+		Versions:        *versions,
+		MaxBodySize:     *maxBody,
+		MaxListEntries:  *maxEntries,
+		NoIndex:         fileserver.NoIndexPolicy(*noIndex),
+		RecentInterval:  *recentEvery,
+		RecentCount:     *recentCount,
+		SearchIndex:     *searchIndex,
+		SearchInterval:  *searchEvery,
+		Disposition:     cf.Disposition,
+		MIMETypes:       cf.MIMETypes,
+		MIMETypesFile:   *mimeFile,
+		StripBOM:        *stripBOM,
+		NoRobots:        *noRobots,
+		GitHTTP:         *gitHTTP,
+		Htpasswd:        *htpasswd,
+		Keytab:          *keytabPath,
+		KeytabPrincipal: *keytabSPN,
+		Exclude:         effectiveExcludes(cf),
+		Webhooks:        cf.webhooks(),
+		Brokers:         cf.brokers(),
+		Mirror:          cf.mirror(),
+		StatsD:          statsdOptions(),
+		Sentry:          sentryOptions(),
+		GeoIP:           geoipOptions(cf),
+		Branding:        fileserver.Branding{Title: *brandTitle, Logo: *brandLogo, Footer: *brandFooter},
+		ErrorPages:      *errorPages,
+		TrustedProxies:  trusted,
+		AccessLog:       accessLog,
+		SecurityLog:     securityLog,
+		StatsInterval:   *statsEvery,
+		LogLevel:        logLevel,
+		AdminConfig:     flagConfig,
+		Middleware:      splitList(*middleware),
+	})
+	if err != nil {
+		fatal("Failed to set up file server", "error", err)
+	}
+	fs.HandleEndpoint("GET /version", http.HandlerFunc(versionHandler))
+
+	reload := make(chan os.Signal, 1)
+	notifyReload(reload)
+	go func() {
+		for range reload {
+			reloadSettings(fs, *configPath)
+		}
+	}()
+
+	var adminSrv *http.Server
+	if *adminToken != "" {
+		admin := fs.AdminHandler(*adminToken)
+		if *adminAddr != "" {
+			adminSrv = &http.Server{
+				Addr:         *adminAddr,
+				Handler:      fs.WithLogging(admin),
+				ReadTimeout:  10 * time.Second,
+				WriteTimeout: 10 * time.Second,
+			}
+		} else {
+			fs.HandleEndpoint("/admin/", admin)
+		}
+	} else if *adminAddr != "" {
+		fatal("-admin-addr requires -admin-token")
+	}
+
+	useTLS := *certFile != "" && *keyFile != ""
+	if *httpsAddr != "" && !useTLS {
+		fatal("-https-addr requires -cert and -key")
+	}
+	if *redirect && *httpsAddr == "" {
+		fatal("-redirect-http requires -https-addr")
+	}
+
+	// With -https-addr, -addr stays plain HTTP and HTTPS gets its own
+	// listener; otherwise -addr serves HTTPS whenever a key pair is given.
+	var httpHandler http.Handler = fs
+	if *redirect {
+		httpHandler = fs.WithLogging(redirectToHTTPS(*httpsAddr))
+	}
+	srv := newServer(*addr, httpHandler, fs)
+	var tlsSrv *http.Server
+	var tlsConfig *tls.Config
+	if useTLS {
+		// Load the key pair up front; the files may be unreadable once
+		// privileges are dropped or the process is chrooted.
+		cert, err := tls.LoadX509KeyPair(*certFile, *keyFile)
+		if err != nil {
+			fatal("Failed to load TLS key pair", "error", err)
+		}
+		tlsConfig = &tls.Config{Certificates: []tls.Certificate{cert}}
+		if *httpsAddr != "" {
+			tlsSrv = newServer(*httpsAddr, fs, fs)
+			tlsSrv.TLSConfig = tlsConfig
+		} else {
+			srv.TLSConfig = tlsConfig
+		}
+	}
+
+	ln, err := listen(*addr)
+	if err != nil {
+		fatal("Listen failed", "addr", *addr, "error", err)
+	}
+	if *proxyProto {
+		ln = fs.ProxyListener(ln)
+	}
+
+	var tlsLn net.Listener
+	if tlsSrv != nil {
+		if tlsLn, err = listen(*httpsAddr); err != nil {
+			fatal("Listen failed", "addr", *httpsAddr, "error", err)
+		}
+		if *proxyProto {
+			tlsLn = fs.ProxyListener(tlsLn)
+		}
+	}
+
+	var adminLn net.Listener
+	if adminSrv != nil {
+		if adminLn, err = listen(*adminAddr); err != nil {
+			fatal("Admin listen failed", "addr", *adminAddr, "error", err)
+		}
+	}
+
+	var grpcSrv *grpc.Server
+	var grpcLn net.Listener
+	if *grpcAddr != "" {
+		var opts []grpc.ServerOption
+		if tlsConfig != nil {
+			opts = append(opts, grpc.Creds(credentials.NewTLS(tlsConfig)))
+		}
+		grpcSrv = fs.GRPCServer(opts...)
+		if grpcLn, err = listen(*grpcAddr); err != nil {
+			fatal("gRPC listen failed", "addr", *grpcAddr, "error", err)
+		}
+	}
+
+	var s3Srv *http.Server
+	var s3Ln net.Listener
+	if *s3Addr != "" {
+		if len(cf.S3.Credentials) == 0 {
+			slog.Warn("The S3 API has no credentials configured and accepts unsigned requests")
+		}
+		// Objects may be large, so only the headers have a deadline.
+		s3Srv = &http.Server{
+			Handler:           fs.WithLogging(fs.S3Handler(fileserver.S3Options{Bucket: *s3Bucket, Credentials: cf.S3.Credentials})),
+			ReadHeaderTimeout: 10 * time.Second,
+			IdleTimeout:       120 * time.Second,
+			TLSConfig:         tlsConfig,
+		}
+		if s3Ln, err = listen(*s3Addr); err != nil {
+			fatal("S3 listen failed", "addr", *s3Addr, "error", err)
+		}
+	}
+
+	var sftpLn net.Listener
+	var sftpOpts fileserver.SFTPOptions
+	if *sftpAddr != "" {
+		if sftpOpts, err = sftpOptions(*sftpHostKey, *sftpKeys); err != nil {
+			fatal("Failed to load SFTP keys", "error", err)
+		}
+		if sftpLn, err = listen(*sftpAddr); err != nil {
+			fatal("SFTP listen failed", "addr", *sftpAddr, "error", err)
+		}
+	}
+
+	// Write the pidfile before privileges are dropped; removing it on exit
+	// is best effort.
+	var pidfile string
+	if *pidPath != "" {
+		if pidfile, err = writePidfile(*pidPath); err != nil {
+			fatal("Failed to write pidfile", "error", err)
+		}
+	}
+
+	// The notification socket, like the listeners, is opened before the
+	// chroot hides it.
+	notifier, err := openSdNotify()
+	if err != nil {
+		slog.Warn("Failed to open the systemd notification socket", "error", err)
+	}
+
+	if err := dropPrivileges(*runAsUser, *runAsGroup, chrootDir); err != nil {
+		fatal("Failed to drop privileges", "error", err)
+	}
+	if chrootDir != "" {
+		slog.Info("Chrooted", "root", chrootDir)
+	}
+
+	go func() {
+		var err error
+		if srv.TLSConfig != nil {
+			slog.Info("Starting HTTPS", "addr", ln.Addr().String(), "dir", *baseDir)
+			err = srv.ServeTLS(ln, "", "")
+		} else {
+			slog.Info("Starting HTTP", "addr", ln.Addr().String(), "dir", *baseDir, "version", version)
+			err = srv.Serve(ln)
+		}
+		if err != nil && err != http.ErrServerClosed {
+			fatal("Serve failed", "error", err)
+		}
+	}()
+
+	if tlsSrv != nil {
+		go func() {
+			slog.Info("Starting HTTPS", "addr", tlsLn.Addr().String())
+			if err := tlsSrv.ServeTLS(tlsLn, "", ""); err != nil && err != http.ErrServerClosed {
+				fatal("Serve failed", "error", err)
+			}
+		}()
+	}
+
+	if adminSrv != nil {
+		go func() {
+			slog.Info("Starting admin API", "addr", adminLn.Addr().String())
+			if err := adminSrv.Serve(adminLn); err != nil && err != http.ErrServerClosed {
+				fatal("Admin serve failed", "error", err)
+			}
+		}()
+	}
+
+	if grpcSrv != nil {
+		go func() {
+			slog.Info("Starting gRPC", "addr", grpcLn.Addr().String(), "tls", tlsConfig != nil)
+			if err := grpcSrv.Serve(grpcLn); err != nil {
+				fatal("gRPC serve failed", "error", err)
+			}
+		}()
+	}
+
+	if s3Srv != nil {
+		go func() {
+			var err error
+			slog.Info("Starting S3 API", "addr", s3Ln.Addr().String(), "bucket", *s3Bucket, "tls", tlsConfig != nil)
+			if tlsConfig != nil {
+				err = s3Srv.ServeTLS(s3Ln, "", "")
+			} else {
+				err = s3Srv.Serve(s3Ln)
+			}
+			if err != nil && err != http.ErrServerClosed {
+				fatal("S3 serve failed", "error", err)
+			}
+		}()
+	}
+
+	if sftpLn != nil {
+		go func() {
+			slog.Info("Starting SFTP", "addr", sftpLn.Addr().String(), "authorized_keys", len(sftpOpts.AuthorizedKeys))
+			if err := fs.ServeSFTP(sftpLn, sftpOpts); err != nil && !errors.Is(err, net.ErrClosed) {
+				fatal("SFTP serve failed", "error", err)
+			}
+		}()
+	}
+
+	stop := make(chan struct{})
+	if err := notifier.notify("READY=1"); err != nil {
+		slog.Warn("Failed to notify systemd", "error", err)
+	}
+	go notifier.watchdog(fs.Healthy, stop)
+
+	// Graceful shutdown
+	signal.Notify(quit, os.Interrupt, syscall.SIGTERM)
+	<-quit
+	notifier.notify("STOPPING=1")
+	close(stop)
+
+	slog.Info("Shutting down, draining connections", "timeout", drainTimeout.String())
+	ctx, cancel := context.WithTimeout(context.Background(), *drainTimeout)
+	defer cancel()
+	if adminSrv != nil {
+		adminSrv.Shutdown(ctx)
+	}
+	if s3Srv != nil {
+		s3Srv.Shutdown(ctx)
+	}
+	if sftpLn != nil {
+		sftpLn.Close()
+	}
+	if grpcSrv != nil {
+		stopped := make(chan struct{})
+		go func() {
+			grpcSrv.GracefulStop()
+			close(stopped)
+		}()
+		select {
+		case <-stopped:
+		case <-ctx.Done():
+			grpcSrv.Stop()
+		}
+	}
+	timedOut := false
+	for _, s := range []*http.Server{tlsSrv, srv} {
+		if s == nil {
+			continue
+		}
+		if err := s.Shutdown(ctx); err != nil {
+			// Both servers hand their requests to fs, so this lists the
+			// remaining ones of either.
+			if !timedOut {
+				aborted := fs.InFlight()
+				slog.Warn("Drain timeout exceeded, aborting remaining requests", "count", len(aborted), "requests", aborted)
+				timedOut = true
+			}
+			s.Close()
+		}
+	}
+	fs.Close()
+	if err := shutdownTracing(ctx); err != nil {
+		slog.Error("Failed to flush traces", "error", err)
+	}
+	if pidfile != "" {
+		if err := os.Remove(pidfile); err != nil {
+			slog.Warn("Failed to remove pidfile", "path", pidfile, "error", err)
+		}
+	}
+	slog.Info("Server gracefully stopped")
+	closeLogs()
+	serviceDone()
+}
@@ -0,0 +1,140 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/AScotM/go-server/pkg/fileserver"
+)
+
+// mountList is the repeatable -mount flag. Each value is
+// /prefix=/dir[,rw][,hidden=show|hide|deny], or for a remote directory
+// /prefix=https://[user:pass@]host/path/[,webdav][,cache=/dir][,ttl=30s].
+type mountList []fileserver.Mount
+
+func (l *mountList) String() string {
+	if l == nil {
+		return ""
+	}
+	specs := make([]string, len(*l))
+	for i, m := range *l {
+		dir := m.Dir
+		if r, ok := m.FS.(fmt.Stringer); ok {
+			dir = r.String()
+		}
+		specs[i] = m.Prefix + "=" + dir
+		if m.ReadWrite {
+			specs[i] += ",rw"
+		}
+		if m.Hidden != "" {
+			specs[i] += ",hidden=" + string(m.Hidden)
+		}
+	}
+	return strings.Join(specs, " ")
+}
+
+func (l *mountList) Set(spec string) error {
+	m, err := parseMount(spec)
+	if err != nil {
+		return err
+	}
+	*l = append(*l, m)
+	return nil
+}
+
+func parseMount(spec string) (fileserver.Mount, error) {
+	prefix, rest, ok := strings.Cut(spec, "=")
+	if !ok || prefix == "" || rest == "" {
+		return fileserver.Mount{}, fmt.Errorf("invalid mount %q (want /prefix=/dir[,rw][,hidden=show|hide|deny])", spec)
+	}
+	opts := strings.Split(rest, ",")
+	m := fileserver.Mount{Prefix: prefix, Dir: opts[0]}
+	var remote *fileserver.Remote
+	if remoteURL(opts[0]) {
+		remote = &fileserver.Remote{URL: opts[0]}
+	}
+	for _, opt := range opts[1:] {
+		switch {
+		case opt == "rw":
+			m.ReadWrite = true
+		case opt == "ro":
+			m.ReadWrite = false
+		case strings.HasPrefix(opt, "hidden="):
+			m.Hidden = fileserver.HiddenPolicy(strings.TrimPrefix(opt, "hidden="))
+		case remote != nil && opt == "webdav":
+			remote.WebDAV = true
+		case remote != nil && strings.HasPrefix(opt, "cache="):
+			remote.CacheDir = strings.TrimPrefix(opt, "cache=")
+		case remote != nil && strings.HasPrefix(opt, "ttl="):
+			d, err := time.ParseDuration(strings.TrimPrefix(opt, "ttl="))
+			if err != nil {
+				return fileserver.Mount{}, fmt.Errorf("mount %s: invalid ttl: %w", prefix, err)
+			}
+			remote.CacheTTL = d
+		default:
+			return fileserver.Mount{}, fmt.Errorf("mount %s: unknown option %q", prefix, opt)
+		}
+	}
+	if remote != nil {
+		fsys, err := fileserver.OpenRemote(*remote)
+		if err != nil {
+			return fileserver.Mount{}, fmt.Errorf("mount %s: %w", prefix, err)
+		}
+		m.Dir, m.FS = "", fsys
+	}
+	return m, nil
+}
+
+// remoteURL reports whether a mount's directory is a remote one.
+func remoteURL(dir string) bool {
+	return strings.HasPrefix(dir, "http://") || strings.HasPrefix(dir, "https://")
+}
+
+// configMount is a mounts entry in the config file. A mount has either a
+// dir or the url of a remote directory.
+type configMount struct {
+	Prefix    string `json:"prefix" yaml:"prefix" toml:"prefix"`
+	Dir       string `json:"dir" yaml:"dir" toml:"dir"`
+	ReadWrite bool   `json:"read_write" yaml:"read_write" toml:"read_write"`
+	Hidden    string `json:"hidden" yaml:"hidden" toml:"hidden"`
+
+	URL      string `json:"url" yaml:"url" toml:"url"`
+	WebDAV   bool   `json:"webdav" yaml:"webdav" toml:"webdav"`
+	Username string `json:"username" yaml:"username" toml:"username"`
+	Password string `json:"password" yaml:"password" toml:"password"`
+	CacheTTL string `json:"cache_ttl" yaml:"cache_ttl" toml:"cache_ttl"`
+	CacheDir string `json:"cache_dir" yaml:"cache_dir" toml:"cache_dir"`
+}
+
+// effectiveMounts returns the -mount flags, or the config file's mounts
+// when none were given on the command line.
+func effectiveMounts(cf *configFile) ([]fileserver.Mount, error) {
+	if explicitFlags["mount"] {
+		return mounts, nil
+	}
+	out := make([]fileserver.Mount, len(cf.Mounts))
+	for i, m := range cf.Mounts {
+		out[i] = fileserver.Mount{Prefix: m.Prefix, Dir: m.Dir, ReadWrite: m.ReadWrite, Hidden: fileserver.HiddenPolicy(m.Hidden)}
+		if m.URL == "" {
+			continue
+		}
+		if m.Dir != "" {
+			return nil, fmt.Errorf("mount %s: dir and url are exclusive", m.Prefix)
+		}
+		r := fileserver.Remote{URL: m.URL, WebDAV: m.WebDAV, Username: m.Username, Password: m.Password, CacheDir: m.CacheDir}
+		if m.CacheTTL != "" {
+			d, err := time.ParseDuration(m.CacheTTL)
+			if err != nil {
+				return nil, fmt.Errorf("mount %s: invalid cache_ttl %q", m.Prefix, m.CacheTTL)
+			}
+			r.CacheTTL = d
+		}
+		fsys, err := fileserver.OpenRemote(r)
+		if err != nil {
+			return nil, fmt.Errorf("mount %s: %w", m.Prefix, err)
+		}
+		out[i].FS = fsys
+	}
+	return out, nil
+}
@@ -0,0 +1,30 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// writePidfile records the process ID in path. It refuses to overwrite a
+// pidfile whose process is still running.
+func writePidfile(path string) (string, error) {
+	abs, err := filepath.Abs(path)
+	if err != nil {
+		return "", err
+	}
+	if data, err := os.ReadFile(abs); err == nil {
+		if pid, err := strconv.Atoi(strings.TrimSpace(string(data))); err == nil && pid != os.Getpid() && processAlive(pid) {
+			return "", fmt.Errorf("pidfile %s names running process %d", abs, pid)
+		}
+	} else if !errors.Is(err, os.ErrNotExist) {
+		return "", err
+	}
+	if err := os.WriteFile(abs, []byte(strconv.Itoa(os.Getpid())+"\n"), 0o644); err != nil {
+		return "", err
+	}
+	return abs, nil
+}
@@ -0,0 +1,7 @@
+package main
+
+// Compiled-in plugins register themselves with fileserver.RegisterPlugin
+// from an init function. To build them into go-server, add a blank import
+// of the plugin package here, e.g.
+//
+//	import _ "example.com/go-server-billing"
@@ -0,0 +1,12 @@
+//go:build !unix
+
+package main
+
+import "errors"
+
+func dropPrivileges(userName, groupName, root string) error {
+	if userName == "" && groupName == "" && root == "" {
+		return nil
+	}
+	return errors.New("-user, -group and -chroot are only supported on Unix systems")
+}
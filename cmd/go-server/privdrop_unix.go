@@ -0,0 +1,82 @@
+//go:build unix
+
+package main
+
+import (
+	"errors"
+	"fmt"
+	"mime"
+	"os"
+	"os/user"
+	"strconv"
+	"syscall"
+	"time"
+)
+
+// dropPrivileges optionally chroots into root and then switches to the given
+// user and group. It must run after the listening socket has been bound and
+// before any request is served. A chroot needs a user: root can escape one
+// trivially.
+func dropPrivileges(userName, groupName, root string) error {
+	if userName == "" && groupName == "" && root == "" {
+		return nil
+	}
+	if root != "" && userName == "" {
+		return errors.New("-chroot requires -user, as a chroot does not confine root")
+	}
+
+	uid, gid := -1, -1
+	if userName != "" {
+		u, err := user.Lookup(userName)
+		if err != nil {
+			return fmt.Errorf("lookup user %q: %w", userName, err)
+		}
+		if uid, err = strconv.Atoi(u.Uid); err != nil {
+			return fmt.Errorf("invalid uid %q: %w", u.Uid, err)
+		}
+		if gid, err = strconv.Atoi(u.Gid); err != nil {
+			return fmt.Errorf("invalid gid %q: %w", u.Gid, err)
+		}
+	}
+	if groupName != "" {
+		g, err := user.LookupGroup(groupName)
+		if err != nil {
+			return fmt.Errorf("lookup group %q: %w", groupName, err)
+		}
+		if gid, err = strconv.Atoi(g.Gid); err != nil {
+			return fmt.Errorf("invalid gid %q: %w", g.Gid, err)
+		}
+	}
+
+	if root != "" {
+		// The mime table and local time zone are loaded lazily from /etc,
+		// which is no longer reachable once we are inside the chroot.
+		mime.TypeByExtension(".html")
+		time.Now().Local()
+
+		if err := syscall.Chroot(root); err != nil {
+			return fmt.Errorf("chroot %s: %w", root, err)
+		}
+		if err := syscall.Chdir("/"); err != nil {
+			return fmt.Errorf("chdir after chroot: %w", err)
+		}
+	}
+
+	if gid != -1 {
+		if err := syscall.Setgroups([]int{gid}); err != nil {
+			return fmt.Errorf("setgroups: %w", err)
+		}
+		if err := syscall.Setgid(gid); err != nil {
+			return fmt.Errorf("setgid %d: %w", gid, err)
+		}
+	}
+	if uid != -1 {
+		if err := syscall.Setuid(uid); err != nil {
+			return fmt.Errorf("setuid %d: %w", uid, err)
+		}
+	}
+	if root != "" && os.Geteuid() == 0 {
+		return fmt.Errorf("-user %s runs as root, which a chroot does not confine", userName)
+	}
+	return nil
+}
@@ -0,0 +1,21 @@
+//go:build unix
+
+package main
+
+import (
+	"os"
+	"testing"
+)
+
+func TestDropPrivilegesChrootNeedsUser(t *testing.T) {
+	root := t.TempDir()
+	for _, group := range []string{"", "nogroup"} {
+		if err := dropPrivileges("", group, root); err == nil {
+			t.Fatalf("chroot without a user (group %q) was accepted", group)
+		}
+	}
+	// The refusal comes before the chroot.
+	if _, err := os.Stat(root); err != nil {
+		t.Fatalf("the temporary directory is gone: %v", err)
+	}
+}
@@ -0,0 +1,92 @@
+package main
+
+import (
+	"errors"
+	"log/slog"
+	"net"
+	"os"
+	"strconv"
+	"time"
+)
+
+// sdNotifier sends state strings to systemd's notification socket. It is
+// opened before privileges are dropped, as a path-based socket such as
+// /run/systemd/notify is out of reach after a chroot. A nil *sdNotifier,
+// as when the process was not started by systemd with Type=notify,
+// ignores every state.
+type sdNotifier struct {
+	conn *net.UnixConn
+}
+
+func openSdNotify() (*sdNotifier, error) {
+	name := os.Getenv("NOTIFY_SOCKET")
+	if name == "" {
+		return nil, nil
+	}
+	if name[0] == '@' {
+		name = "\x00" + name[1:] // abstract namespace socket
+	}
+	conn, err := net.DialUnix("unixgram", nil, &net.UnixAddr{Name: name, Net: "unixgram"})
+	if err != nil {
+		return nil, err
+	}
+	return &sdNotifier{conn: conn}, nil
+}
+
+func (n *sdNotifier) notify(state string) error {
+	if n == nil {
+		return nil
+	}
+	_, err := n.conn.Write([]byte(state))
+	return err
+}
+
+// sdWatchdogInterval returns how often to send watchdog keepalives, or zero
+// if the systemd watchdog is not enabled for this process.
+func sdWatchdogInterval() time.Duration {
+	usec, err := strconv.ParseInt(os.Getenv("WATCHDOG_USEC"), 10, 64)
+	if err != nil || usec <= 0 {
+		return 0
+	}
+	if pid := os.Getenv("WATCHDOG_PID"); pid != "" && pid != strconv.Itoa(os.Getpid()) {
+		return 0
+	}
+	// Ping at half the timeout, as recommended by sd_watchdog_enabled(3).
+	return time.Duration(usec) * time.Microsecond / 2
+}
+
+// sdWatchdog sends WATCHDOG=1 while healthy reports no error within half
+// an interval. If the server hangs, such as on a dead network filesystem,
+// keepalives stop and systemd restarts the unit. The check runs in
+// process rather than through the listener, which may expect a PROXY
+// header or sit on a path the chroot hides.
+func (n *sdNotifier) watchdog(healthy func() error, stop <-chan struct{}) {
+	interval := sdWatchdogInterval()
+	if n == nil || interval == 0 {
+		return
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			done := make(chan error, 1)
+			go func() { done <- healthy() }()
+			var err error
+			select {
+			case err = <-done:
+			case <-time.After(interval / 2):
+				err = errors.New("timed out")
+			}
+			if err != nil {
+				slog.Warn("Watchdog health check failed, skipping keepalive", "error", err)
+				continue
+			}
+			if err := n.notify("WATCHDOG=1"); err != nil {
+				slog.Warn("Failed to send watchdog keepalive", "error", err)
+			}
+		case <-stop:
+			return
+		}
+	}
+}
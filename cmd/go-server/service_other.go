@@ -0,0 +1,21 @@
+//go:build !windows
+
+package main
+
+import (
+	"errors"
+	"os"
+)
+
+func runningAsService() bool { return false }
+
+// startService is never called outside Windows.
+func startService(quit chan<- os.Signal) func() { return func() {} }
+
+func controlService(cmd string) error {
+	return errors.New("-service is only supported on Windows")
+}
+
+func newEventLogSink() (logSink, error) {
+	return nil, errors.New("eventlog output is only supported on Windows")
+}
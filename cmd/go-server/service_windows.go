@@ -0,0 +1,191 @@
+//go:build windows
+
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"time"
+
+	"golang.org/x/sys/windows/svc"
+	"golang.org/x/sys/windows/svc/eventlog"
+	"golang.org/x/sys/windows/svc/mgr"
+)
+
+// pathFlags are made absolute when installing the service, whose working
+// directory is %SystemRoot%\System32.
+var pathFlags = map[string]bool{
+	"dir": true, "config": true, "cert": true, "key": true,
+	"access-log": true, "error-pages": true,
+}
+
+// runningAsService reports whether the process was started by the service
+// control manager.
+func runningAsService() bool {
+	ok, err := svc.IsWindowsService()
+	return err == nil && ok
+}
+
+// serviceHandler relays stop requests from the service control manager to
+// main's shutdown path.
+type serviceHandler struct {
+	quit    chan<- os.Signal
+	stopped chan struct{}
+}
+
+func (h *serviceHandler) Execute(args []string, req <-chan svc.ChangeRequest, status chan<- svc.Status) (bool, uint32) {
+	status <- svc.Status{State: svc.StartPending}
+	status <- svc.Status{State: svc.Running, Accepts: svc.AcceptStop | svc.AcceptShutdown}
+	for {
+		select {
+		case c := <-req:
+			switch c.Cmd {
+			case svc.Interrogate:
+				status <- c.CurrentStatus
+			case svc.Stop, svc.Shutdown:
+				status <- svc.Status{State: svc.StopPending, WaitHint: 15000}
+				h.quit <- os.Interrupt
+				<-h.stopped
+				return false, 0
+			}
+		case <-h.stopped:
+			return false, 0
+		}
+	}
+}
+
+// startService hands the process over to the service control manager.
+// Stop requests are delivered to quit as os.Interrupt; the returned function
+// must be called once the server has shut down.
+func startService(quit chan<- os.Signal) func() {
+	h := &serviceHandler{quit: quit, stopped: make(chan struct{})}
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		if err := svc.Run(serviceName, h); err != nil {
+			slog.Error("Service dispatcher failed", "error", err)
+		}
+	}()
+	return func() {
+		close(h.stopped)
+		<-done
+	}
+}
+
+// controlService implements -service install|uninstall|start|stop.
+func controlService(cmd string) error {
+	m, err := mgr.Connect()
+	if err != nil {
+		return fmt.Errorf("connect to service manager: %w", err)
+	}
+	defer m.Disconnect()
+
+	if cmd == "install" {
+		return installService(m)
+	}
+	s, err := m.OpenService(serviceName)
+	if err != nil {
+		return fmt.Errorf("open service %s: %w", serviceName, err)
+	}
+	defer s.Close()
+	switch cmd {
+	case "uninstall":
+		if err := s.Delete(); err != nil {
+			return err
+		}
+		return eventlog.Remove(serviceName)
+	case "start":
+		return s.Start()
+	case "stop":
+		st, err := s.Control(svc.Stop)
+		if err != nil {
+			return err
+		}
+		for deadline := time.Now().Add(30 * time.Second); st.State != svc.Stopped; {
+			if time.Now().After(deadline) {
+				return fmt.Errorf("service %s did not stop in time", serviceName)
+			}
+			time.Sleep(300 * time.Millisecond)
+			if st, err = s.Query(); err != nil {
+				return err
+			}
+		}
+		return nil
+	default:
+		return fmt.Errorf("unknown -service command %q (want install, uninstall, start or stop)", cmd)
+	}
+}
+
+// installService registers the current executable as an automatic-start
+// service. The flags given alongside -service install become the service's
+// arguments.
+func installService(m *mgr.Mgr) error {
+	exe, err := os.Executable()
+	if err != nil {
+		return err
+	}
+	var args []string
+	flag.Visit(func(f *flag.Flag) {
+		if f.Name == "service" {
+			return
+		}
+		v := f.Value.String()
+		if pathFlags[f.Name] {
+			if abs, err := filepath.Abs(v); err == nil {
+				v = abs
+			}
+		}
+		args = append(args, "-"+f.Name+"="+v)
+	})
+	if flag.Lookup("dir").Value.String() == flag.Lookup("dir").DefValue {
+		abs, err := filepath.Abs(*baseDir)
+		if err != nil {
+			return err
+		}
+		args = append(args, "-dir="+abs)
+	}
+
+	s, err := m.CreateService(serviceName, exe, mgr.Config{
+		DisplayName: "Go file server",
+		Description: "Serves files over HTTP(S).",
+		StartType:   mgr.StartAutomatic,
+	}, args...)
+	if err != nil {
+		return fmt.Errorf("create service %s: %w", serviceName, err)
+	}
+	defer s.Close()
+	if err := eventlog.InstallAsEventCreate(serviceName, eventlog.Error|eventlog.Warning|eventlog.Info); err != nil {
+		s.Delete()
+		return fmt.Errorf("register event log source: %w", err)
+	}
+	return nil
+}
+
+// eventLogSink writes to the Windows application event log under the
+// service's source name.
+type eventLogSink struct {
+	log *eventlog.Log
+}
+
+func newEventLogSink() (logSink, error) {
+	l, err := eventlog.Open(serviceName)
+	if err != nil {
+		return nil, fmt.Errorf("open event log: %w", err)
+	}
+	logClosers = append(logClosers, l.Close)
+	return &eventLogSink{log: l}, nil
+}
+
+func (e *eventLogSink) Send(level slog.Level, msg string) error {
+	switch {
+	case level >= slog.LevelError:
+		return e.log.Error(1, msg)
+	case level >= slog.LevelWarn:
+		return e.log.Warning(1, msg)
+	default:
+		return e.log.Info(1, msg)
+	}
+}
@@ -0,0 +1,86 @@
+package main
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+
+	"golang.org/x/crypto/ssh"
+
+	"github.com/AScotM/go-server/pkg/fileserver"
+)
+
+// sftpOptions loads the SFTP host key and authorized keys named by the
+// flags.
+func sftpOptions(hostKeyPath, authorizedPath string) (fileserver.SFTPOptions, error) {
+	var opts fileserver.SFTPOptions
+	var err error
+	if opts.HostKey, err = loadHostKey(hostKeyPath); err != nil {
+		return opts, fmt.Errorf("SFTP host key: %w", err)
+	}
+	if authorizedPath != "" {
+		if opts.AuthorizedKeys, err = loadAuthorizedKeys(authorizedPath); err != nil {
+			return opts, fmt.Errorf("SFTP authorized keys: %w", err)
+		}
+	}
+	return opts, nil
+}
+
+// loadHostKey reads a private key in OpenSSH or PEM format. A missing file
+// is created with a new ed25519 key; with no path at all the key only
+// lasts until exit, so clients see a new host key on every start.
+func loadHostKey(path string) (ssh.Signer, error) {
+	if path != "" {
+		data, err := os.ReadFile(path)
+		if err == nil {
+			return ssh.ParsePrivateKey(data)
+		}
+		if !errors.Is(err, os.ErrNotExist) {
+			return nil, err
+		}
+	}
+	_, key, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+	if path == "" {
+		slog.Warn("No -sftp-host-key given, using a temporary host key")
+	} else {
+		block, err := ssh.MarshalPrivateKey(key, "go-server host key")
+		if err != nil {
+			return nil, err
+		}
+		if err := os.WriteFile(path, pem.EncodeToMemory(block), 0o600); err != nil {
+			return nil, err
+		}
+		slog.Info("Generated SFTP host key", "path", path)
+	}
+	return ssh.NewSignerFromKey(key)
+}
+
+// loadAuthorizedKeys reads an OpenSSH authorized_keys file.
+func loadAuthorizedKeys(path string) ([]ssh.PublicKey, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var keys []ssh.PublicKey
+	for len(data) > 0 {
+		key, _, _, rest, err := ssh.ParseAuthorizedKey(data)
+		if err != nil {
+			// ParseAuthorizedKey skips lines it cannot parse, so this is
+			// only reached when no keys remain.
+			if len(keys) == 0 {
+				return nil, err
+			}
+			break
+		}
+		keys = append(keys, key)
+		data = rest
+	}
+	return keys, nil
+}
@@ -0,0 +1,11 @@
+//go:build !unix
+
+package main
+
+import "os"
+
+// notifyReopen is a no-op on platforms without SIGUSR1.
+func notifyReopen(c chan<- os.Signal) {}
+
+// notifyReload is a no-op on platforms without SIGHUP.
+func notifyReload(c chan<- os.Signal) {}
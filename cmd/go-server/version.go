@@ -0,0 +1,56 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"runtime"
+	"runtime/debug"
+)
+
+// Build information, set at link time:
+//
+//	go build -ldflags "-X main.version=1.2.3 -X main.commit=$(git rev-parse --short HEAD) -X main.buildDate=$(date -u +%Y-%m-%dT%H:%M:%SZ)"
+var (
+	version   = "dev"
+	commit    = ""
+	buildDate = ""
+)
+
+// versionInfo fills in commit and build date from the Go build info when
+// they were not set via ldflags.
+func versionInfo() map[string]string {
+	info := map[string]string{
+		"version":    version,
+		"commit":     commit,
+		"build_date": buildDate,
+		"go_version": runtime.Version(),
+	}
+	if bi, ok := debug.ReadBuildInfo(); ok {
+		for _, s := range bi.Settings {
+			switch {
+			case s.Key == "vcs.revision" && info["commit"] == "":
+				info["commit"] = s.Value
+			case s.Key == "vcs.time" && info["build_date"] == "":
+				info["build_date"] = s.Value
+			}
+		}
+	}
+	for k, v := range info {
+		if v == "" {
+			info[k] = "unknown"
+		}
+	}
+	return info
+}
+
+func versionString() string {
+	v := versionInfo()
+	return fmt.Sprintf("go-server %s (commit %s, built %s, %s)",
+		v["version"], v["commit"], v["build_date"], v["go_version"])
+}
+
+func versionHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(versionInfo())
+}
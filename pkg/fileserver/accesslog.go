@@ -0,0 +1,163 @@
+package fileserver
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"log/slog"
+	"net/http"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// AccessLog writes requests in Apache combined log format to a file,
+// rotating it by size and/or age. Reopen supports external rotation tools
+// such as logrotate that move the file away and signal the process.
+type AccessLog struct {
+	mu          sync.Mutex
+	path        string
+	maxSize     int64
+	rotateEvery time.Duration
+	f           *os.File
+	out         io.Writer // al itself, or an AsyncWriter wrapping it
+	async       *AsyncWriter
+	size        int64
+	openedAt    time.Time
+}
+
+// NewAccessLog opens path for appending. A positive maxSize or rotateEvery
+// enables rotation; a positive buffer queues that many lines and writes
+// them asynchronously.
+func NewAccessLog(path string, maxSize int64, rotateEvery time.Duration, buffer int) (*AccessLog, error) {
+	al := &AccessLog{path: path, maxSize: maxSize, rotateEvery: rotateEvery}
+	al.out = al
+	if err := al.open(); err != nil {
+		return nil, err
+	}
+	if buffer > 0 {
+		al.async = NewAsyncWriter(al, buffer)
+		al.out = al.async
+	}
+	return al, nil
+}
+
+func (al *AccessLog) open() error {
+	f, err := os.OpenFile(al.path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0o644)
+	if err != nil {
+		return err
+	}
+	info, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return err
+	}
+	al.f = f
+	al.size = info.Size()
+	al.openedAt = time.Now()
+	return nil
+}
+
+// Reopen closes and reopens the log file at the same path.
+func (al *AccessLog) Reopen() error {
+	al.mu.Lock()
+	defer al.mu.Unlock()
+	if al.f != nil {
+		al.f.Close()
+	}
+	return al.open()
+}
+
+// rotate renames the current file with a timestamp suffix and opens a new one.
+// Rotations within the same second, such as SIGUSR1 right after the size
+// trigger, add a sequence number rather than replacing the earlier file.
+// Callers must hold al.mu.
+func (al *AccessLog) rotate() error {
+	al.f.Close()
+	stamp := al.path + "." + time.Now().Format("20060102-150405")
+	rotated := stamp
+	for i := 1; ; i++ {
+		if _, err := os.Lstat(rotated); errors.Is(err, fs.ErrNotExist) {
+			break
+		}
+		rotated = stamp + "." + strconv.Itoa(i)
+	}
+	if err := os.Rename(al.path, rotated); err != nil {
+		slog.Error("Failed to rotate access log", "path", al.path, "error", err)
+	}
+	return al.open()
+}
+
+func (al *AccessLog) needsRotation() bool {
+	if al.maxSize > 0 && al.size >= al.maxSize {
+		return true
+	}
+	return al.rotateEvery > 0 && time.Since(al.openedAt) >= al.rotateEvery
+}
+
+// Log writes a single combined-format line for the request.
+func (al *AccessLog) Log(r *http.Request, status int, bytes int64, start time.Time) {
+	user := "-"
+	if u, _, ok := r.BasicAuth(); ok && u != "" {
+		user = u
+	}
+	size := "-"
+	if bytes > 0 {
+		size = strconv.FormatInt(bytes, 10)
+	}
+	line := fmt.Sprintf("%s - %s [%s] %q %d %s %q %q\n",
+		RemoteIP(r),
+		user,
+		start.Format("02/Jan/2006:15:04:05 -0700"),
+		r.Method+" "+r.RequestURI+" "+r.Proto,
+		status,
+		size,
+		orDash(r.Referer()),
+		orDash(r.UserAgent()))
+	al.out.Write([]byte(line))
+}
+
+// Write appends p to the current file, rotating it first if due.
+func (al *AccessLog) Write(p []byte) (int, error) {
+	al.mu.Lock()
+	defer al.mu.Unlock()
+	if al.f == nil {
+		return 0, os.ErrClosed
+	}
+	if al.needsRotation() {
+		if err := al.rotate(); err != nil {
+			slog.Error("Failed to reopen access log", "path", al.path, "error", err)
+			return 0, err
+		}
+	}
+	n, err := al.f.Write(p)
+	al.size += int64(n)
+	if err != nil {
+		slog.Error("Failed to write access log", "path", al.path, "error", err)
+	}
+	return n, err
+}
+
+// Close flushes queued lines and closes the underlying file.
+func (al *AccessLog) Close() error {
+	if al.async != nil {
+		al.async.Close()
+	}
+	al.mu.Lock()
+	defer al.mu.Unlock()
+	if al.f == nil {
+		return nil
+	}
+	err := al.f.Close()
+	al.f = nil
+	return err
+}
+
+func orDash(s string) string {
+	if s == "" {
+		return "-"
+	}
+	return s
+}
@@ -0,0 +1,45 @@
+package fileserver
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestAccessLogRotateTwiceInASecond(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "access.log")
+	// Every write after the first finds the file full and rotates it.
+	al, err := NewAccessLog(path, 1, 0, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, line := range []string{"one\n", "two\n", "three\n"} {
+		if _, err := al.Write([]byte(line)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	al.Close()
+
+	files, err := filepath.Glob(path + "*")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(files) != 3 {
+		t.Fatalf("got files %v, want the log and two archives", files)
+	}
+	var all []string
+	for _, f := range files {
+		data, err := os.ReadFile(f)
+		if err != nil {
+			t.Fatal(err)
+		}
+		all = append(all, strings.TrimSpace(string(data)))
+	}
+	got := strings.Join(all, " ")
+	for _, line := range []string{"one", "two", "three"} {
+		if !strings.Contains(got, line) {
+			t.Errorf("line %q was lost; files hold %q", line, got)
+		}
+	}
+}
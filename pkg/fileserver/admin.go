@@ -0,0 +1,182 @@
+package fileserver
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"net"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// connTracker records the state of each open connection via
+// http.Server.ConnState.
+type connTracker struct {
+	mu       sync.Mutex
+	states   map[net.Conn]http.ConnState
+	accepted int64
+}
+
+func (ct *connTracker) track(c net.Conn, state http.ConnState) {
+	ct.mu.Lock()
+	defer ct.mu.Unlock()
+	if ct.states == nil {
+		ct.states = make(map[net.Conn]http.ConnState)
+	}
+	switch state {
+	case http.StateNew:
+		ct.accepted++
+		ct.states[c] = state
+	case http.StateHijacked, http.StateClosed:
+		delete(ct.states, c)
+	default:
+		ct.states[c] = state
+	}
+}
+
+// counts returns the number of open connections in each state.
+func (ct *connTracker) counts() map[string]int64 {
+	ct.mu.Lock()
+	defer ct.mu.Unlock()
+	out := map[string]int64{"open": int64(len(ct.states)), "accepted": ct.accepted}
+	for _, st := range ct.states {
+		out[st.String()]++
+	}
+	return out
+}
+
+// requestTracker records the requests being served, as method and path.
+type requestTracker struct {
+	mu       sync.Mutex
+	requests map[*http.Request]string
+}
+
+func (rt *requestTracker) start(r *http.Request) {
+	rt.mu.Lock()
+	defer rt.mu.Unlock()
+	if rt.requests == nil {
+		rt.requests = make(map[*http.Request]string)
+	}
+	rt.requests[r] = r.Method + " " + r.URL.Path
+}
+
+func (rt *requestTracker) done(r *http.Request) {
+	rt.mu.Lock()
+	defer rt.mu.Unlock()
+	delete(rt.requests, r)
+}
+
+// list returns the requests being served, sorted.
+func (rt *requestTracker) list() []string {
+	rt.mu.Lock()
+	defer rt.mu.Unlock()
+	out := make([]string, 0, len(rt.requests))
+	for _, req := range rt.requests {
+		out = append(out, req)
+	}
+	sort.Strings(out)
+	return out
+}
+
+// AdminHandler returns the /admin API, guarded by a bearer token. Mount it
+// at /admin/ with Handle, or serve it on a separate listener.
+func (s *Server) AdminHandler(token string) http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /admin/config", s.adminConfig)
+	mux.HandleFunc("POST /admin/cache/flush", s.adminCacheFlush)
+	if s.logLevel != nil {
+		mux.HandleFunc("GET /admin/log-level", s.adminLogLevel)
+		mux.HandleFunc("PUT /admin/log-level", s.adminLogLevel)
+	}
+	mux.HandleFunc("GET /admin/connections", s.adminConnections)
+	if s.meta != nil {
+		mux.HandleFunc("GET /admin/uploads", s.adminUploads)
+		mux.HandleFunc("GET /admin/downloads", s.adminDownloads)
+	}
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		got, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+		if !ok || subtle.ConstantTimeCompare([]byte(got), []byte(token)) != 1 {
+			w.Header().Set("WWW-Authenticate", `Bearer realm="admin"`)
+			httpError(w, r, "Unauthorized", http.StatusUnauthorized)
+			s.log.Warn("Rejected admin request", "remote_ip", RemoteIP(r), "path", r.URL.Path)
+			if ok {
+				s.securityRequestEvent(securityAuthFailure, r, "admin", "")
+			}
+			return
+		}
+		mux.ServeHTTP(w, r)
+	})
+}
+
+// writeJSON sends v with an exact Content-Length, so that HEAD responses,
+// whose body net/http drops, still report it.
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	body, err := json.Marshal(v)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	body = append(body, '\n')
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Content-Length", strconv.Itoa(len(body)))
+	w.WriteHeader(status)
+	w.Write(body)
+}
+
+func (s *Server) adminConfig(w http.ResponseWriter, r *http.Request) {
+	cfg := make(map[string]string)
+	if s.configReport != nil {
+		for k, v := range s.configReport() {
+			cfg[k] = v
+		}
+	}
+	if s.logLevel != nil {
+		cfg["log-level"] = s.logLevel.Level().String()
+	}
+	cfg["dir"] = s.root
+	var mounts []string
+	for _, m := range s.mounts {
+		if m.Prefix != "/" {
+			mounts = append(mounts, m.Prefix+"="+m.Dir)
+		}
+	}
+	cfg["mounts"] = strings.Join(mounts, " ")
+	cfg["plugins"] = strings.Join(s.Plugins(), ",")
+	cfg["cache"] = s.settings.Load().CacheTTL.String()
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"config": cfg,
+		"uptime": time.Since(s.started).Round(time.Second).String(),
+	})
+}
+
+func (s *Server) adminCacheFlush(w http.ResponseWriter, r *http.Request) {
+	n := s.FlushCache()
+	s.log.Info("Cache flushed via admin API", "entries", n)
+	writeJSON(w, http.StatusOK, map[string]int{"flushed": n})
+}
+
+func (s *Server) adminLogLevel(w http.ResponseWriter, r *http.Request) {
+	if r.Method == http.MethodPut {
+		var req struct {
+			Level string `json:"level"`
+		}
+		if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, 1024)).Decode(&req); err != nil {
+			httpError(w, r, "Invalid JSON", http.StatusBadRequest)
+			return
+		}
+		if err := s.logLevel.UnmarshalText([]byte(req.Level)); err != nil {
+			httpError(w, r, "Invalid log level", http.StatusBadRequest)
+			return
+		}
+		s.log.Info("Log level changed via admin API", "level", s.logLevel.Level().String())
+	}
+	writeJSON(w, http.StatusOK, map[string]string{"level": s.logLevel.Level().String()})
+}
+
+func (s *Server) adminConnections(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, s.conns.counts())
+}
@@ -0,0 +1,288 @@
+package fileserver
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"runtime"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+const (
+	apiV1Prefix    = "/api/v1/"
+	echoMaxMessage = 4096
+)
+
+// apiError is the error envelope of every /api/v1/ endpoint.
+type apiError struct {
+	Error     string            `json:"error"`
+	Code      int               `json:"code"`
+	RequestID string            `json:"request_id,omitempty"`
+	Fields    map[string]string `json:"fields,omitempty"` // field -> problem
+}
+
+func writeAPIError(w http.ResponseWriter, status int, msg string, fields map[string]string) {
+	writeJSON(w, status, apiError{
+		Error:     msg,
+		Code:      status,
+		RequestID: w.Header().Get("X-Request-ID"),
+		Fields:    fields,
+	})
+}
+
+// apiV1Routes maps endpoint names below /api/v1/ to handlers by method.
+// A "*" segment in a name matches any single path segment.
+func (s *Server) apiV1Routes() map[string]map[string]http.HandlerFunc {
+	routes := map[string]map[string]http.HandlerFunc{
+		"echo":    {http.MethodPost: s.apiEcho},
+		"info":    {http.MethodGet: s.apiInfo},
+		"time":    {http.MethodGet: s.apiTime},
+		"stats":   {http.MethodGet: s.apiStats},
+		"stat":    {http.MethodGet: s.apiStat},
+		"batch":   {http.MethodPost: s.apiBatch},
+		"qr":      {http.MethodGet: s.apiQR},
+		"archive": {http.MethodPost: s.apiArchive},
+		"thumb":   {http.MethodGet: s.apiThumb},
+		"file":    {http.MethodPut: s.apiFilePut},
+		"sync":    {http.MethodPost: s.apiSync},
+	}
+	if s.chunkedUploads {
+		routes["uploads"] = map[string]http.HandlerFunc{http.MethodPost: s.apiUploadCreate}
+		routes["uploads/*"] = map[string]http.HandlerFunc{http.MethodDelete: s.apiUploadAbort}
+		routes["uploads/*/chunks/*"] = map[string]http.HandlerFunc{http.MethodPut: s.apiUploadChunk}
+		routes["uploads/*/complete"] = map[string]http.HandlerFunc{http.MethodPost: s.apiUploadComplete}
+	}
+	if s.trashRetention > 0 {
+		routes["trash"] = map[string]http.HandlerFunc{http.MethodGet: s.apiTrashList, http.MethodDelete: s.apiTrashEmpty}
+		routes["trash/*"] = map[string]http.HandlerFunc{http.MethodDelete: s.apiTrashPurge}
+		routes["trash/*/restore"] = map[string]http.HandlerFunc{http.MethodPost: s.apiTrashRestore}
+	}
+	if s.versions > 0 {
+		routes["versions"] = map[string]http.HandlerFunc{http.MethodGet: s.apiVersions, http.MethodPost: s.apiVersionRestore}
+	}
+	if s.dirSizes != nil {
+		routes["dirsize"] = map[string]http.HandlerFunc{http.MethodGet: s.apiDirSize}
+	}
+	if s.shortLinks != nil {
+		routes["shorten"] = map[string]http.HandlerFunc{http.MethodPost: s.apiShorten}
+	}
+	return routes
+}
+
+// matchEndpoint reports whether name matches a route name with wildcards.
+func matchEndpoint(pattern, name string) bool {
+	ps, ns := strings.Split(pattern, "/"), strings.Split(name, "/")
+	if len(ps) != len(ns) {
+		return false
+	}
+	for i := range ps {
+		if ns[i] == "" || (ps[i] != "*" && ps[i] != ns[i]) {
+			return false
+		}
+	}
+	return true
+}
+
+// endpointSegment returns the i-th segment of the path below /api/v1/.
+func endpointSegment(r *http.Request, i int) string {
+	segs := strings.Split(strings.TrimPrefix(r.URL.Path, apiV1Prefix), "/")
+	if i < len(segs) {
+		return segs[i]
+	}
+	return ""
+}
+
+// apiV1Handler routes /api/v1/ requests, answering unknown endpoints with
+// 404 and unsupported methods with 405 in the error envelope.
+func (s *Server) apiV1Handler() http.HandlerFunc {
+	routes := s.apiV1Routes()
+	return func(w http.ResponseWriter, r *http.Request) {
+		name := strings.TrimPrefix(r.URL.Path, apiV1Prefix)
+		methods, ok := routes[name]
+		if !ok {
+			for pattern, m := range routes {
+				if strings.Contains(pattern, "*") && matchEndpoint(pattern, name) {
+					methods, ok = m, true
+					break
+				}
+			}
+		}
+		if !ok {
+			writeAPIError(w, http.StatusNotFound, "no such endpoint", nil)
+			return
+		}
+		var allow []string
+		for m := range methods {
+			allow = append(allow, m)
+			if m == http.MethodGet {
+				allow = append(allow, http.MethodHead)
+			}
+		}
+		if !allowAPIMethods(w, r, allow...) {
+			return
+		}
+		if h, ok := methods[r.Method]; ok {
+			h(w, r)
+		} else {
+			methods[http.MethodGet](w, r) // HEAD
+		}
+	}
+}
+
+// validator is implemented by request bodies that check their own fields.
+type validator interface {
+	validate() map[string]string
+}
+
+// limitBody caps the request body at the server's MaxBodySize.
+func (s *Server) limitBody(w http.ResponseWriter, r *http.Request) {
+	r.Body = http.MaxBytesReader(w, r.Body, s.maxBody)
+}
+
+// bodyTooLarge writes a 413 and reports true if err comes from reading a
+// body past its limit.
+func bodyTooLarge(w http.ResponseWriter, err error) bool {
+	var tooLarge *http.MaxBytesError
+	if !errors.As(err, &tooLarge) {
+		return false
+	}
+	writeAPIError(w, http.StatusRequestEntityTooLarge, fmt.Sprintf("body exceeds %d bytes", tooLarge.Limit), nil)
+	return true
+}
+
+// decodeAPIRequest strictly decodes a JSON body into v and validates it,
+// writing the error response and returning false on failure.
+func (s *Server) decodeAPIRequest(w http.ResponseWriter, r *http.Request, v validator) bool {
+	return decodeAPIBody(w, r, v, s.maxBody)
+}
+
+// decodeAPIBody is decodeAPIRequest with a body limit of its own, for
+// requests that are larger by nature.
+func decodeAPIBody(w http.ResponseWriter, r *http.Request, v validator, limit int64) bool {
+	if ct, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type")); ct != "application/json" {
+		writeAPIError(w, http.StatusUnsupportedMediaType, "Content-Type must be application/json", nil)
+		return false
+	}
+	r.Body = http.MaxBytesReader(w, r.Body, limit)
+	dec := json.NewDecoder(r.Body)
+	dec.DisallowUnknownFields()
+	err := dec.Decode(v)
+	if err == nil && dec.Decode(&struct{}{}) != io.EOF {
+		err = errors.New("unexpected data after the JSON value")
+	}
+	switch {
+	case bodyTooLarge(w, err):
+		return false
+	case err != nil:
+		writeAPIError(w, http.StatusBadRequest, "invalid JSON: "+err.Error(), nil)
+		return false
+	}
+	if fields := v.validate(); len(fields) > 0 {
+		writeAPIError(w, http.StatusUnprocessableEntity, "validation failed", fields)
+		return false
+	}
+	return true
+}
+
+type echoRequest struct {
+	Message string `json:"message"`
+}
+
+func (e *echoRequest) validate() map[string]string {
+	switch {
+	case e.Message == "":
+		return map[string]string{"message": "required"}
+	case utf8.RuneCountInString(e.Message) > echoMaxMessage:
+		return map[string]string{"message": fmt.Sprintf("longer than %d characters", echoMaxMessage)}
+	}
+	return nil
+}
+
+type echoResponse struct {
+	Message string    `json:"message"`
+	Time    time.Time `json:"time"`
+}
+
+func (s *Server) apiEcho(w http.ResponseWriter, r *http.Request) {
+	var req echoRequest
+	if !s.decodeAPIRequest(w, r, &req) {
+		return
+	}
+	writeJSON(w, http.StatusOK, echoResponse{Message: req.Message, Time: time.Now()})
+}
+
+type infoMount struct {
+	Prefix    string `json:"prefix"`
+	ReadWrite bool   `json:"read_write"`
+}
+
+type infoResponse struct {
+	GoVersion string      `json:"go_version"`
+	Started   time.Time   `json:"started"`
+	Uptime    string      `json:"uptime"`
+	Mounts    []infoMount `json:"mounts"`
+}
+
+func (s *Server) apiInfo(w http.ResponseWriter, r *http.Request) {
+	resp := infoResponse{
+		GoVersion: runtime.Version(),
+		Started:   s.started,
+		Uptime:    time.Since(s.started).Round(time.Second).String(),
+	}
+	for _, m := range s.mounts {
+		resp.Mounts = append(resp.Mounts, infoMount{Prefix: m.Prefix, ReadWrite: m.ReadWrite})
+	}
+	writeJSON(w, http.StatusOK, resp)
+}
+
+type timeResponse struct {
+	Time     time.Time `json:"time"`
+	Unix     int64     `json:"unix"`
+	UnixNano int64     `json:"unix_nano"`
+	Zone     string    `json:"zone"`
+}
+
+func (s *Server) apiTime(w http.ResponseWriter, r *http.Request) {
+	now := time.Now()
+	zone, _ := now.Zone()
+	writeJSON(w, http.StatusOK, timeResponse{Time: now, Unix: now.Unix(), UnixNano: now.UnixNano(), Zone: zone})
+}
+
+type statsResponse struct {
+	Uptime       string           `json:"uptime"`
+	Requests     uint64           `json:"requests"`
+	ClientErrors uint64           `json:"client_errors"`
+	ServerErrors uint64           `json:"server_errors"`
+	P50          string           `json:"p50"`
+	P95          string           `json:"p95"`
+	P99          string           `json:"p99"`
+	Max          string           `json:"max"`
+	Connections  map[string]int64 `json:"connections"`
+	CacheEntries int              `json:"cache_entries"`
+}
+
+// apiStats reports request counts and latencies since the last periodic
+// summary (or since start when summaries are off).
+func (s *Server) apiStats(w http.ResponseWriter, r *http.Request) {
+	snap := s.stats.snapshot()
+	s.cacheMu.Lock()
+	cached := len(s.cache)
+	s.cacheMu.Unlock()
+	writeJSON(w, http.StatusOK, statsResponse{
+		Uptime:       time.Since(s.started).Round(time.Second).String(),
+		Requests:     snap.count,
+		ClientErrors: snap.clientErrors,
+		ServerErrors: snap.serverErrors,
+		P50:          snap.percentile(0.50).String(),
+		P95:          snap.percentile(0.95).String(),
+		P99:          snap.percentile(0.99).String(),
+		Max:          snap.max.String(),
+		Connections:  s.conns.counts(),
+		CacheEntries: cached,
+	})
+}
@@ -0,0 +1,185 @@
+package fileserver
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
+)
+
+// Archive is a read-only file tree read from an archive file; see
+// OpenArchive.
+type Archive interface {
+	fs.FS
+	io.Closer
+}
+
+// OpenArchive opens a .zip or uncompressed .tar file for serving in place,
+// for use as Options.FS or Mount.FS. Files are read from the archive on
+// demand; a tar file is indexed once when opened.
+func OpenArchive(name string) (Archive, error) {
+	switch strings.ToLower(filepath.Ext(name)) {
+	case ".zip":
+		return zip.OpenReader(name)
+	case ".tar":
+		return openTar(name)
+	}
+	return nil, fmt.Errorf("%s: unsupported archive format (want .zip or .tar)", name)
+}
+
+// tarFS serves the regular files and directories of a tar file. Other
+// entries, such as links, are skipped.
+type tarFS struct {
+	f       *os.File
+	entries map[string]*tarEntry // by cleaned name; "." is the root
+}
+
+type tarEntry struct {
+	name     string
+	mode     fs.FileMode
+	size     int64
+	modTime  time.Time
+	offset   int64       // of the file data in the archive
+	children []*tarEntry // of a directory, sorted by name
+}
+
+func openTar(name string) (*tarFS, error) {
+	f, err := os.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	t := &tarFS{f: f, entries: make(map[string]*tarEntry)}
+	t.entries["."] = &tarEntry{name: ".", mode: fs.ModeDir | 0o555}
+	tr := tar.NewReader(f)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			f.Close()
+			return nil, fmt.Errorf("%s: %w", name, err)
+		}
+		p := path.Clean(strings.TrimPrefix(hdr.Name, "/"))
+		if !fs.ValidPath(p) || p == "." {
+			continue
+		}
+		switch hdr.Typeflag {
+		case tar.TypeDir:
+			e := t.dir(p)
+			e.mode, e.modTime = fs.ModeDir|fs.FileMode(hdr.Mode).Perm(), hdr.ModTime
+		case tar.TypeReg:
+			// tar.Reader leaves f at the start of the entry's data.
+			offset, err := f.Seek(0, io.SeekCurrent)
+			if err != nil {
+				f.Close()
+				return nil, err
+			}
+			e := &tarEntry{name: path.Base(p), mode: fs.FileMode(hdr.Mode).Perm(), size: hdr.Size, modTime: hdr.ModTime, offset: offset}
+			if old, dup := t.entries[p]; dup && old.mode.IsDir() {
+				continue
+			}
+			t.add(p, e)
+		}
+	}
+	for _, e := range t.entries {
+		slices.SortFunc(e.children, func(a, b *tarEntry) int { return strings.Compare(a.name, b.name) })
+	}
+	return t, nil
+}
+
+// dir returns the directory entry for p, creating it and any missing
+// parents.
+func (t *tarFS) dir(p string) *tarEntry {
+	if e, ok := t.entries[p]; ok && e.mode.IsDir() {
+		return e
+	}
+	e := &tarEntry{name: path.Base(p), mode: fs.ModeDir | 0o555}
+	t.add(p, e)
+	return e
+}
+
+// add records an entry, replacing an earlier file of the same name as tar
+// extraction would.
+func (t *tarFS) add(p string, e *tarEntry) {
+	parent := t.dir(path.Dir(p))
+	if old, ok := t.entries[p]; ok {
+		parent.children = slices.DeleteFunc(parent.children, func(c *tarEntry) bool { return c == old })
+	}
+	t.entries[p] = e
+	parent.children = append(parent.children, e)
+}
+
+func (t *tarFS) Open(name string) (fs.File, error) {
+	if !fs.ValidPath(name) {
+		return nil, &fs.PathError{Op: "open", Path: name, Err: fs.ErrInvalid}
+	}
+	e, ok := t.entries[name]
+	if !ok {
+		return nil, &fs.PathError{Op: "open", Path: name, Err: fs.ErrNotExist}
+	}
+	if e.mode.IsDir() {
+		return &tarDir{e: e}, nil
+	}
+	return &tarFile{SectionReader: io.NewSectionReader(t.f, e.offset, e.size), e: e}, nil
+}
+
+func (t *tarFS) Close() error {
+	return t.f.Close()
+}
+
+func (e *tarEntry) Name() string               { return e.name }
+func (e *tarEntry) Size() int64                { return e.size }
+func (e *tarEntry) Mode() fs.FileMode          { return e.mode }
+func (e *tarEntry) ModTime() time.Time         { return e.modTime }
+func (e *tarEntry) IsDir() bool                { return e.mode.IsDir() }
+func (e *tarEntry) Sys() any                   { return nil }
+func (e *tarEntry) Type() fs.FileMode          { return e.mode.Type() }
+func (e *tarEntry) Info() (fs.FileInfo, error) { return e, nil }
+
+// tarFile reads a file's section of the archive, so it can seek.
+type tarFile struct {
+	*io.SectionReader
+	e *tarEntry
+}
+
+func (f *tarFile) Stat() (fs.FileInfo, error) { return f.e, nil }
+func (f *tarFile) Close() error               { return nil }
+
+type tarDir struct {
+	e   *tarEntry
+	pos int
+}
+
+var errIsDir = errors.New("is a directory")
+
+func (d *tarDir) Stat() (fs.FileInfo, error) { return d.e, nil }
+func (d *tarDir) Close() error               { return nil }
+
+func (d *tarDir) Read([]byte) (int, error) {
+	return 0, &fs.PathError{Op: "read", Path: d.e.name, Err: errIsDir}
+}
+
+func (d *tarDir) ReadDir(n int) ([]fs.DirEntry, error) {
+	rest := d.e.children[d.pos:]
+	if n > 0 && len(rest) == 0 {
+		return nil, io.EOF
+	}
+	if n > 0 && n < len(rest) {
+		rest = rest[:n]
+	}
+	d.pos += len(rest)
+	out := make([]fs.DirEntry, len(rest))
+	for i, e := range rest {
+		out[i] = e
+	}
+	return out, nil
+}
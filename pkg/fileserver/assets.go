@@ -0,0 +1,39 @@
+package fileserver
+
+import (
+	"embed"
+	"io/fs"
+	"net/http"
+	"strings"
+)
+
+// assetsPrefix is where the embedded UI assets are served, below the
+// reserved prefix.
+const assetsPrefix = "/assets/"
+
+//go:embed assets
+var embeddedAssets embed.FS
+
+// assetHandler serves the listing and error page assets. They only change
+// with the binary, so clients may cache them for a day.
+func (s *Server) assetHandler() http.Handler {
+	sub, err := fs.Sub(embeddedAssets, "assets")
+	if err != nil {
+		panic(err)
+	}
+	files := http.StripPrefix(assetsPrefix, http.FileServerFS(sub))
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !s.allowMethods(w, r, http.MethodGet, http.MethodHead) {
+			return
+		}
+		if s.serveBrandLogo(w, r) {
+			return
+		}
+		if strings.HasSuffix(r.URL.Path, "/") {
+			s.renderError(w, r, http.StatusNotFound)
+			return
+		}
+		w.Header().Set("Cache-Control", "public, max-age=86400")
+		files.ServeHTTP(w, r)
+	})
+}
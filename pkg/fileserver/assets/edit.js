@@ -0,0 +1,43 @@
+// Save the editor's text with PUT file below the API URL in data-api, sending the ETag the file
+// was loaded or last saved at so that a concurrent change is reported
+// instead of overwritten.
+document.addEventListener("DOMContentLoaded", function () {
+  var form = document.getElementById("editor");
+  if (!form) return;
+  var text = form.querySelector("textarea"), status = form.querySelector(".status");
+  var button = form.querySelector("button"), data = document.body.dataset;
+  function report(message, failed) {
+    status.textContent = message;
+    status.classList.toggle("failed", failed);
+  }
+  form.addEventListener("submit", function (e) {
+    e.preventDefault();
+    button.disabled = true;
+    fetch(data.api + "file?path=" + encodeURIComponent(form.getAttribute("data-path")), {
+      method: "PUT",
+      headers: { "If-Match": form.getAttribute("data-etag"), "Content-Type": "text/plain; charset=utf-8" },
+      body: text.value
+    }).then(function (res) {
+      return res.json().catch(function () { return {}; }).then(function (body) {
+        if (res.ok) {
+          form.setAttribute("data-etag", body.etag);
+          report(data.saved || "Saved", false);
+        } else if (res.status === 412) {
+          report(data.conflict || "The file was changed since you opened it.", true);
+        } else {
+          report(body.error || res.status + " " + res.statusText, true);
+        }
+      });
+    }, function (err) {
+      report(err.message, true);
+    }).then(function () { button.disabled = false; });
+  });
+  // Ctrl+S / Cmd+S saves, as in desktop editors.
+  document.addEventListener("keydown", function (e) {
+    if ((e.ctrlKey || e.metaKey) && e.key === "s") {
+      e.preventDefault();
+      form.requestSubmit();
+    }
+  });
+  text.addEventListener("input", function () { if (!status.classList.contains("failed")) status.textContent = ""; });
+});
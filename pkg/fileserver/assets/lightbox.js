@@ -0,0 +1,72 @@
+// Open image links in an overlay with previous/next navigation instead of
+// leaving the listing. Modified clicks still open the raw file.
+document.addEventListener("DOMContentLoaded", function () {
+  var links = Array.prototype.slice.call(document.querySelectorAll("table.listing a.image, ul.gallery a.image"));
+  if (links.length === 0) return;
+
+  var box = document.createElement("div"), img = document.createElement("img"), caption = document.createElement("p");
+  var prev = document.createElement("button"), next = document.createElement("button"), close = document.createElement("button");
+  box.className = "lightbox";
+  box.hidden = true;
+  box.setAttribute("role", "dialog");
+  box.setAttribute("aria-modal", "true");
+  prev.className = "prev";
+  prev.textContent = "‹";
+  prev.setAttribute("aria-label", document.body.dataset.previous || "Previous image");
+  next.className = "next";
+  next.textContent = "›";
+  next.setAttribute("aria-label", document.body.dataset.next || "Next image");
+  close.className = "close";
+  close.textContent = "×";
+  close.setAttribute("aria-label", document.body.dataset.close || "Close");
+  [img, caption, prev, next, close].forEach(function (el) { box.appendChild(el); });
+  document.body.appendChild(box);
+
+  var current = -1, opener = null;
+
+  function show(i) {
+    // Skip rows hidden by the filter box.
+    var visible = links.filter(function (a) { return !a.closest("tr, li").hidden; });
+    if (visible.length === 0) return;
+    var pos = visible.indexOf(links[current]);
+    if (i !== 0 && pos >= 0) {
+      current = links.indexOf(visible[(pos + i + visible.length) % visible.length]);
+    }
+    img.src = links[current].href;
+    img.alt = links[current].textContent;
+    caption.textContent = links[current].textContent + " (" + (visible.indexOf(links[current]) + 1) + "/" + visible.length + ")";
+    prev.hidden = next.hidden = visible.length < 2;
+  }
+
+  function hide() {
+    box.hidden = true;
+    img.removeAttribute("src");
+    if (opener) opener.focus();
+  }
+
+  links.forEach(function (a, i) {
+    a.addEventListener("click", function (e) {
+      if (e.button !== 0 || e.ctrlKey || e.metaKey || e.shiftKey || e.altKey) return;
+      e.preventDefault();
+      current = i;
+      opener = a;
+      box.hidden = false;
+      show(0);
+      close.focus();
+    });
+  });
+  prev.addEventListener("click", function () { show(-1); });
+  next.addEventListener("click", function () { show(1); });
+  close.addEventListener("click", hide);
+  box.addEventListener("click", function (e) {
+    if (e.target === box) hide();
+  });
+  document.addEventListener("keydown", function (e) {
+    if (box.hidden) return;
+    if (e.key === "Escape") hide();
+    else if (e.key === "ArrowLeft") show(-1);
+    else if (e.key === "ArrowRight") show(1);
+    else return;
+    e.preventDefault();
+  });
+});
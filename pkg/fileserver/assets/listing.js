@@ -0,0 +1,227 @@
+// Show modification times in the viewer's local time zone, formatted for
+// the page's language.
+document.addEventListener("DOMContentLoaded", function () {
+  document.querySelectorAll("time[datetime]").forEach(function (el) {
+    var d = new Date(el.getAttribute("datetime"));
+    if (!isNaN(d)) {
+      el.title = el.getAttribute("datetime");
+      el.textContent = d.toLocaleString(document.documentElement.lang || undefined);
+    }
+  });
+});
+
+// Filter the listing as the user types, without a server round trip. The
+// box is added here so pages without scripts do not show a dead control.
+document.addEventListener("DOMContentLoaded", function () {
+  var table = document.querySelector("table.listing");
+  if (!table) return;
+  var rows = Array.prototype.filter.call(table.tBodies[0].rows, function (row) {
+    return row.querySelector("td.name a").textContent !== "..";
+  });
+  var box = document.createElement("input");
+  box.type = "search";
+  box.className = "filter";
+  box.placeholder = (document.body.dataset.filter || "Filter %d entries").replace("%d", rows.length);
+  box.setAttribute("aria-label", box.placeholder);
+  table.parentNode.insertBefore(box, table);
+  box.addEventListener("input", function () {
+    var q = box.value.toLowerCase();
+    rows.forEach(function (row) {
+      row.hidden = q !== "" && row.querySelector("td.name a").textContent.toLowerCase().indexOf(q) < 0;
+    });
+  });
+  // "/" focuses the box, as on many code hosting sites.
+  document.addEventListener("keydown", function (e) {
+    if (e.key === "/" && document.activeElement !== box) {
+      e.preventDefault();
+      box.focus();
+    }
+  });
+});
+
+// Show the QR code of the page in a popover rather than navigating to it.
+document.addEventListener("DOMContentLoaded", function () {
+  var link = document.querySelector("a.qr");
+  if (!link) return;
+  var pop = null;
+  link.addEventListener("click", function (e) {
+    if (e.button !== 0 || e.ctrlKey || e.metaKey || e.shiftKey) return;
+    e.preventDefault();
+    if (pop) {
+      pop.remove();
+      pop = null;
+      return;
+    }
+    pop = document.createElement("div");
+    pop.className = "qr-popover";
+    var img = document.createElement("img");
+    img.src = link.href;
+    img.alt = document.body.dataset.qrTitle || "QR code for this page";
+    pop.appendChild(img);
+    link.parentNode.appendChild(pop);
+  });
+});
+
+// Add a button to each entry that copies its absolute URL. The origin
+// comes from the server, which knows the scheme a TLS-terminating proxy
+// was reached with.
+document.addEventListener("DOMContentLoaded", function () {
+  var table = document.querySelector("table.listing");
+  if (!table) return;
+  var data = document.body.dataset, origin = data.origin || location.origin;
+  function copy(text) {
+    // The Clipboard API needs a secure context; plain HTTP falls back to
+    // a selected text field.
+    if (navigator.clipboard && window.isSecureContext) return navigator.clipboard.writeText(text);
+    var field = document.createElement("textarea");
+    field.value = text;
+    field.style.position = "fixed";
+    field.style.opacity = "0";
+    document.body.appendChild(field);
+    field.select();
+    var ok = document.execCommand("copy");
+    field.remove();
+    return ok ? Promise.resolve() : Promise.reject(new Error("copy failed"));
+  }
+  Array.prototype.forEach.call(table.tBodies[0].rows, function (row) {
+    var a = row.querySelector("td.name a");
+    if (!a || a.textContent === "..") return;
+    var button = document.createElement("button");
+    button.type = "button";
+    button.className = "copy";
+    button.textContent = "⧉";
+    button.title = data.copyLink || "Copy link";
+    button.setAttribute("aria-label", button.title + ": " + a.textContent);
+    button.addEventListener("click", function () {
+      copy(origin + a.pathname).then(function () {
+        button.title = data.copied || "Copied";
+        button.classList.add("copied");
+        setTimeout(function () {
+          button.title = data.copyLink || "Copy link";
+          button.classList.remove("copied");
+        }, 1500);
+      });
+    });
+    a.parentNode.appendChild(button);
+  });
+});
+
+// Keep the download-as-zip button disabled until something is ticked, and
+// add a checkbox that ticks every entry the filter leaves visible.
+document.addEventListener("DOMContentLoaded", function () {
+  var form = document.getElementById("selection"), slot = document.querySelector("th.select .select-all");
+  if (!form || !slot) return;
+  var button = form.querySelector("button"), all = document.createElement("input");
+  var boxes = document.querySelectorAll("input[type=checkbox][form=selection]");
+  all.type = "checkbox";
+  all.setAttribute("aria-label", slot.title);
+  slot.appendChild(all);
+  function update() {
+    var n = 0;
+    boxes.forEach(function (box) { if (box.checked) n++; });
+    button.disabled = n === 0;
+    all.checked = n > 0 && n === boxes.length;
+  }
+  boxes.forEach(function (box) { box.addEventListener("change", update); });
+  all.addEventListener("change", function () {
+    boxes.forEach(function (box) {
+      if (!box.closest("tr").hidden) box.checked = all.checked;
+    });
+    update();
+  });
+  update();
+});
+
+// Fill in directory sizes that the server is still computing, polling
+// until each is known.
+document.addEventListener("DOMContentLoaded", function () {
+  var cells = Array.prototype.slice.call(document.querySelectorAll("td.size[data-dirsize]"));
+  if (cells.length === 0) return;
+  var data = document.body.dataset, delay = 500;
+  function poll() {
+    cells = cells.filter(function (cell) { return cell.classList.contains("pending"); });
+    if (cells.length === 0) return;
+    Promise.all(cells.map(function (cell) {
+      return fetch(data.api + "dirsize?path=" + encodeURIComponent(cell.getAttribute("data-dirsize")))
+        .then(function (res) { return res.ok ? res.json() : { pending: false, failed: true }; })
+        .then(function (d) {
+          if (d.pending) return;
+          cell.classList.remove("pending");
+          cell.textContent = "";
+          if (d.failed) return;
+          var bytes = (data.bytes || "%d bytes").replace("%d", d.size);
+          if ("exactSizes" in data) {
+            cell.textContent = bytes;
+          } else {
+            cell.textContent = d.human.replace(".", data.decimal || ".");
+            cell.title = bytes;
+          }
+        }, function () { /* retried on the next round */ });
+    })).then(function () {
+      delay = Math.min(delay * 2, 5000);
+      setTimeout(poll, delay);
+    });
+  }
+  setTimeout(poll, delay);
+});
+
+// Move between entries with the arrow keys, Home and End once one has
+// focus, as the skip link or Tab gives it; elsewhere the keys scroll as
+// usual. In the gallery, left and right move too.
+document.addEventListener("DOMContentLoaded", function () {
+  var container = document.querySelector("table.listing tbody, ul.gallery");
+  if (!container) return;
+  function links() {
+    var selector = container.tagName === "UL" ? "li > a" : "td.name a";
+    return Array.prototype.filter.call(container.querySelectorAll(selector), function (a) {
+      return !a.closest("tr, li").hidden;
+    });
+  }
+  var grid = container.tagName === "UL";
+  document.addEventListener("keydown", function (e) {
+    if (e.altKey || e.ctrlKey || e.metaKey || e.shiftKey) return;
+    var list = links(), i = list.indexOf(document.activeElement), next;
+    if (i < 0) return;
+    switch (e.key) {
+      case "ArrowDown":
+        next = grid ? nextInColumn(list, i, 1) : Math.min(i + 1, list.length - 1);
+        break;
+      case "ArrowUp":
+        next = grid ? nextInColumn(list, i, -1) : Math.max(i - 1, 0);
+        break;
+      case "ArrowRight":
+        if (!grid) return;
+        next = Math.min(i + 1, list.length - 1);
+        break;
+      case "ArrowLeft":
+        if (!grid) return;
+        next = Math.max(i - 1, 0);
+        break;
+      case "Home":
+        next = 0;
+        break;
+      case "End":
+        next = list.length - 1;
+        break;
+      default:
+        return;
+    }
+    e.preventDefault();
+    list[next].focus();
+  });
+  // nextInColumn finds the entry above or below in a wrapped grid, which
+  // is the nearest one in the next row by horizontal position.
+  function nextInColumn(list, i, dir) {
+    var from = list[i].getBoundingClientRect(), best = i, bestDist = Infinity;
+    list.forEach(function (a, j) {
+      var r = a.getBoundingClientRect();
+      if (dir > 0 ? r.top <= from.top + 1 : r.top >= from.top - 1) return;
+      var dist = Math.abs(r.top - from.top) * 1000 + Math.abs(r.left - from.left);
+      if (dist < bestDist) {
+        best = j;
+        bestDist = dist;
+      }
+    });
+    return best;
+  }
+});
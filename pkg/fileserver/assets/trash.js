@@ -0,0 +1,27 @@
+// Restore or purge trash items through the trash API below data-api, removing their rows
+// on success.
+document.addEventListener("DOMContentLoaded", function () {
+  var api = document.body.dataset.api;
+  document.querySelectorAll("table.trash tr[data-id] button").forEach(function (button) {
+    button.addEventListener("click", function () {
+      var row = button.closest("tr"), id = encodeURIComponent(row.getAttribute("data-id"));
+      var restore = button.classList.contains("restore");
+      row.querySelectorAll("button").forEach(function (b) { b.disabled = true; });
+      fetch(api + "trash/" + id + (restore ? "/restore" : ""), { method: restore ? "POST" : "DELETE" }).then(function (res) {
+        if (res.ok) {
+          row.remove();
+          return;
+        }
+        return res.json().catch(function () { return {}; }).then(function (body) {
+          throw new Error(body.error || res.status + " " + res.statusText);
+        });
+      }).catch(function (err) {
+        row.querySelectorAll("button").forEach(function (b) { b.disabled = false; });
+        var cell = row.lastElementChild, note = cell.querySelector(".failed") || document.createElement("span");
+        note.className = "failed";
+        note.textContent = " " + err.message;
+        cell.appendChild(note);
+      });
+    });
+  });
+});
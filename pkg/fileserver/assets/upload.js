@@ -0,0 +1,165 @@
+// Drag-and-drop uploads for listings of read-write directories, through
+// the chunked upload API: create, send chunks, then complete with the
+// file's SHA-256, which is computed here while the chunks are read.
+(function () {
+  "use strict";
+
+  var CHUNK = 8 << 20;
+
+  // Incremental SHA-256, as crypto.subtle only digests whole buffers and
+  // is missing on plain-HTTP pages.
+  var K = new Uint32Array([
+    0x428a2f98, 0x71374491, 0xb5c0fbcf, 0xe9b5dba5, 0x3956c25b, 0x59f111f1, 0x923f82a4, 0xab1c5ed5,
+    0xd807aa98, 0x12835b01, 0x243185be, 0x550c7dc3, 0x72be5d74, 0x80deb1fe, 0x9bdc06a7, 0xc19bf174,
+    0xe49b69c1, 0xefbe4786, 0x0fc19dc6, 0x240ca1cc, 0x2de92c6f, 0x4a7484aa, 0x5cb0a9dc, 0x76f988da,
+    0x983e5152, 0xa831c66d, 0xb00327c8, 0xbf597fc7, 0xc6e00bf3, 0xd5a79147, 0x06ca6351, 0x14292967,
+    0x27b70a85, 0x2e1b2138, 0x4d2c6dfc, 0x53380d13, 0x650a7354, 0x766a0abb, 0x81c2c92e, 0x92722c85,
+    0xa2bfe8a1, 0xa81a664b, 0xc24b8b70, 0xc76c51a3, 0xd192e819, 0xd6990624, 0xf40e3585, 0x106aa070,
+    0x19a4c116, 0x1e376c08, 0x2748774c, 0x34b0bcb5, 0x391c0cb3, 0x4ed8aa4a, 0x5b9cca4f, 0x682e6ff3,
+    0x748f82ee, 0x78a5636f, 0x84c87814, 0x8cc70208, 0x90befffa, 0xa4506ceb, 0xbef9a3f7, 0xc67178f2
+  ]);
+
+  function SHA256() {
+    this.h = new Uint32Array([0x6a09e667, 0xbb67ae85, 0x3c6ef372, 0xa54ff53a, 0x510e527f, 0x9b05688c, 0x1f83d9ab, 0x5be0cd19]);
+    this.buf = new Uint8Array(64);
+    this.n = 0; // bytes in buf
+    this.len = 0; // total bytes
+    this.w = new Uint32Array(64);
+  }
+
+  SHA256.prototype.block = function (p, off) {
+    var w = this.w, h = this.h, i, t1, t2;
+    for (i = 0; i < 16; i++) {
+      w[i] = (p[off + 4 * i] << 24) | (p[off + 4 * i + 1] << 16) | (p[off + 4 * i + 2] << 8) | p[off + 4 * i + 3];
+    }
+    for (i = 16; i < 64; i++) {
+      var a = w[i - 15], b = w[i - 2];
+      var s0 = ((a >>> 7) | (a << 25)) ^ ((a >>> 18) | (a << 14)) ^ (a >>> 3);
+      var s1 = ((b >>> 17) | (b << 15)) ^ ((b >>> 19) | (b << 13)) ^ (b >>> 10);
+      w[i] = (w[i - 16] + s0 + w[i - 7] + s1) | 0;
+    }
+    var A = h[0], B = h[1], C = h[2], D = h[3], E = h[4], F = h[5], G = h[6], H = h[7];
+    for (i = 0; i < 64; i++) {
+      t1 = (H + (((E >>> 6) | (E << 26)) ^ ((E >>> 11) | (E << 21)) ^ ((E >>> 25) | (E << 7))) +
+        ((E & F) ^ (~E & G)) + K[i] + w[i]) | 0;
+      t2 = ((((A >>> 2) | (A << 30)) ^ ((A >>> 13) | (A << 19)) ^ ((A >>> 22) | (A << 10))) +
+        ((A & B) ^ (A & C) ^ (B & C))) | 0;
+      H = G; G = F; F = E; E = (D + t1) | 0; D = C; C = B; B = A; A = (t1 + t2) | 0;
+    }
+    h[0] += A; h[1] += B; h[2] += C; h[3] += D; h[4] += E; h[5] += F; h[6] += G; h[7] += H;
+  };
+
+  SHA256.prototype.update = function (p) {
+    var i = 0;
+    this.len += p.length;
+    if (this.n > 0) {
+      while (this.n < 64 && i < p.length) this.buf[this.n++] = p[i++];
+      if (this.n < 64) return;
+      this.block(this.buf, 0);
+      this.n = 0;
+    }
+    for (; i + 64 <= p.length; i += 64) this.block(p, i);
+    while (i < p.length) this.buf[this.n++] = p[i++];
+  };
+
+  SHA256.prototype.hex = function () {
+    var bits = this.len * 8, pad = new Uint8Array((this.n < 56 ? 56 : 120) - this.n + 8);
+    pad[0] = 0x80;
+    for (var i = 0; i < 8; i++) pad[pad.length - 1 - i] = Math.floor(bits / Math.pow(2, 8 * i)) & 0xff;
+    this.update(pad);
+    var out = "";
+    for (i = 0; i < 8; i++) out += ("00000000" + this.h[i].toString(16)).slice(-8);
+    return out;
+  };
+
+  function request(method, url, body, onProgress) {
+    return new Promise(function (resolve, reject) {
+      var xhr = new XMLHttpRequest();
+      xhr.open(method, url);
+      if (typeof body === "string") xhr.setRequestHeader("Content-Type", "application/json");
+      if (onProgress) xhr.upload.onprogress = function (e) { onProgress(e.loaded); };
+      xhr.onload = function () {
+        var data = {};
+        try { data = JSON.parse(xhr.responseText); } catch (e) { /* not JSON */ }
+        if (xhr.status >= 200 && xhr.status < 300) resolve(data);
+        else reject(new Error(data.error || xhr.status + " " + xhr.statusText));
+      };
+      xhr.onerror = function () { reject(new Error("network error")); };
+      xhr.send(body);
+    });
+  }
+
+  function upload(zone, file, row) {
+    var bar = row.querySelector("progress"), status = row.querySelector(".status");
+    var api = zone.getAttribute("data-api"), hash = new SHA256(), sent = 0;
+    bar.max = file.size || 1;
+    return request("POST", api, JSON.stringify({ path: zone.getAttribute("data-path") + file.name, size: file.size }))
+      .then(function (up) {
+        var size = Math.min(CHUNK, up.max_chunk_size || CHUNK);
+        var count = Math.max(1, Math.ceil(file.size / size)), n = 0;
+        function next() {
+          if (n === count) {
+            return request("POST", api + "/" + up.id + "/complete", JSON.stringify({ chunks: count, sha256: hash.hex() }));
+          }
+          var chunk = file.slice(n * size, (n + 1) * size);
+          return chunk.arrayBuffer().then(function (data) {
+            hash.update(new Uint8Array(data));
+            return request("PUT", api + "/" + up.id + "/chunks/" + n, data, function (loaded) { bar.value = sent + loaded; });
+          }).then(function () {
+            sent += chunk.size;
+            n++;
+            return next();
+          });
+        }
+        return next().catch(function (err) {
+          request("DELETE", api + "/" + up.id, null);
+          throw err;
+        });
+      })
+      .then(function () {
+        bar.value = bar.max;
+        status.textContent = document.body.dataset.uploadDone || "done";
+      }, function (err) {
+        row.classList.add("failed");
+        status.textContent = err.message;
+      });
+  }
+
+  function start(zone, files) {
+    var list = document.getElementById("upload-progress"), rows = [];
+    Array.prototype.forEach.call(files, function (file) {
+      var row = document.createElement("li"), name = document.createElement("span");
+      name.textContent = file.name;
+      row.appendChild(name);
+      row.appendChild(document.createElement("progress"));
+      var status = document.createElement("span");
+      status.className = "status";
+      row.appendChild(status);
+      list.appendChild(row);
+      rows.push([file, row]);
+    });
+    // Upload one file at a time, then show the new listing.
+    rows.reduce(function (p, fr) {
+      return p.then(function () { return upload(zone, fr[0], fr[1]); });
+    }, Promise.resolve()).then(function () {
+      if (!list.querySelector(".failed")) location.reload();
+    });
+  }
+
+  document.addEventListener("DOMContentLoaded", function () {
+    var zone = document.getElementById("upload");
+    if (!zone) return;
+    var input = zone.querySelector("input[type=file]");
+    input.addEventListener("change", function () { start(zone, input.files); });
+    zone.addEventListener("dragover", function (e) {
+      e.preventDefault();
+      zone.classList.add("over");
+    });
+    zone.addEventListener("dragleave", function () { zone.classList.remove("over"); });
+    zone.addEventListener("drop", function (e) {
+      e.preventDefault();
+      zone.classList.remove("over");
+      start(zone, e.dataTransfer.files);
+    });
+  });
+})();
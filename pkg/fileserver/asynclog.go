@@ -0,0 +1,63 @@
+package fileserver
+
+import (
+	"bufio"
+	"io"
+	"sync"
+)
+
+// AsyncWriter moves log writes off the request path. Writes are copied onto
+// a buffered channel and a single goroutine batches them into the
+// underlying writer. When the channel is full, Write blocks rather than
+// dropping lines.
+type AsyncWriter struct {
+	w      io.Writer
+	ch     chan []byte
+	done   chan struct{}
+	mu     sync.RWMutex
+	closed bool
+}
+
+// NewAsyncWriter starts the writer goroutine with a queue of size writes.
+func NewAsyncWriter(w io.Writer, size int) *AsyncWriter {
+	aw := &AsyncWriter{w: w, ch: make(chan []byte, size), done: make(chan struct{})}
+	go aw.run()
+	return aw
+}
+
+func (aw *AsyncWriter) run() {
+	defer close(aw.done)
+	bw := bufio.NewWriterSize(aw.w, 64*1024)
+	for b := range aw.ch {
+		bw.Write(b)
+		// Flush whenever the queue drains so output stays timely while
+		// bursts are still coalesced into fewer writes.
+		if len(aw.ch) == 0 {
+			bw.Flush()
+		}
+	}
+	bw.Flush()
+}
+
+func (aw *AsyncWriter) Write(p []byte) (int, error) {
+	aw.mu.RLock()
+	defer aw.mu.RUnlock()
+	if aw.closed {
+		return aw.w.Write(p)
+	}
+	aw.ch <- append([]byte(nil), p...)
+	return len(p), nil
+}
+
+// Close flushes all queued writes and waits for them to complete. Later
+// writes go straight to the underlying writer.
+func (aw *AsyncWriter) Close() error {
+	aw.mu.Lock()
+	if !aw.closed {
+		aw.closed = true
+		close(aw.ch)
+	}
+	aw.mu.Unlock()
+	<-aw.done
+	return nil
+}
@@ -0,0 +1,83 @@
+package fileserver
+
+import (
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"net/http"
+	"strings"
+)
+
+// basicAuth requires HTTP Basic credentials matching the configured users,
+// or with a keytab a Kerberos ticket through Negotiate. It is a no-op when
+// neither is configured. The admin API below the reserved prefix has its
+// own token and is exempt.
+func (srv *Server) basicAuth(next http.Handler) http.Handler {
+	var negotiate http.Handler
+	if srv.keytab != nil {
+		negotiate = srv.negotiate(next)
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		s := srv.settings.Load()
+		if !srv.authRequired(s) || strings.HasPrefix(r.URL.Path, srv.reserved+"/admin/") {
+			next.ServeHTTP(w, r)
+			return
+		}
+		if srv.negotiating(r) {
+			lrw := &loggingResponseWriter{ResponseWriter: w, status: http.StatusOK}
+			negotiate.ServeHTTP(lrw, r)
+			if lrw.status == http.StatusUnauthorized {
+				srv.securityRequestEvent(securityAuthFailure, r, "http", "")
+			}
+			return
+		}
+		user, pass, ok := r.BasicAuth()
+		if !ok || !srv.checkUser(s, user, pass) {
+			srv.challenge(w, s)
+			srv.renderError(w, r, http.StatusUnauthorized)
+			if ok {
+				srv.log.Warn("Authentication failed", "user", user, "remote_ip", RemoteIP(r))
+				srv.securityRequestEvent(securityAuthFailure, r, "http", user)
+			}
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// authRequired reports whether any users are configured, in the settings
+// or in the htpasswd file, or a keytab for Negotiate.
+func (srv *Server) authRequired(st *Settings) bool {
+	return len(st.AuthUsers) > 0 || srv.htpasswd != nil || srv.keytab != nil
+}
+
+// challenge asks for credentials in a 401 response: Negotiate first when
+// there is a keytab, which browsers prefer, and Basic when there are users
+// with passwords.
+func (srv *Server) challenge(w http.ResponseWriter, st *Settings) {
+	if srv.keytab != nil {
+		w.Header().Add("WWW-Authenticate", "Negotiate")
+	}
+	if len(st.AuthUsers) > 0 || srv.htpasswd != nil {
+		w.Header().Add("WWW-Authenticate", `Basic realm="`+st.AuthRealm+`", charset="UTF-8"`)
+	}
+}
+
+// checkUser reports whether pass is the password of a configured user.
+// Settings.AuthUsers take precedence over the htpasswd file.
+func (srv *Server) checkUser(st *Settings, user, pass string) bool {
+	if digest, ok := st.AuthUsers[user]; ok {
+		return checkPassword(digest, pass)
+	}
+	return srv.htpasswd != nil && srv.htpasswd.check(user, pass)
+}
+
+// checkPassword compares pass against a hex-encoded SHA-256 digest.
+func checkPassword(digest, pass string) bool {
+	want, err := hex.DecodeString(digest)
+	if err != nil || len(want) != sha256.Size {
+		return false
+	}
+	got := sha256.Sum256([]byte(pass))
+	return subtle.ConstantTimeCompare(got[:], want) == 1
+}
@@ -0,0 +1,347 @@
+package fileserver
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"syscall"
+)
+
+const batchMaxOps = 1000
+
+// batchOp is one operation of a POST /api/v1/batch request.
+type batchOp struct {
+	Op        string `json:"op"`                  // delete, move or copy
+	Path      string `json:"path,omitempty"`      // delete
+	From      string `json:"from,omitempty"`      // move, copy
+	To        string `json:"to,omitempty"`        // move, copy
+	Recursive bool   `json:"recursive,omitempty"` // delete non-empty directories
+	Overwrite bool   `json:"overwrite,omitempty"` // replace an existing target file
+}
+
+type batchRequest struct {
+	Operations []batchOp `json:"operations"`
+}
+
+func (b *batchRequest) validate() map[string]string {
+	fields := make(map[string]string)
+	switch n := len(b.Operations); {
+	case n == 0:
+		fields["operations"] = "required"
+	case n > batchMaxOps:
+		fields["operations"] = fmt.Sprintf("more than %d operations", batchMaxOps)
+	}
+	for i, op := range b.Operations {
+		key := fmt.Sprintf("operations[%d]", i)
+		switch op.Op {
+		case "delete":
+			if op.Path == "" {
+				fields[key+".path"] = "required"
+			}
+		case "move", "copy":
+			if op.From == "" {
+				fields[key+".from"] = "required"
+			}
+			if op.To == "" {
+				fields[key+".to"] = "required"
+			}
+		default:
+			fields[key+".op"] = "must be delete, move or copy"
+		}
+	}
+	return fields
+}
+
+type batchResult struct {
+	Index int    `json:"index"`
+	Op    string `json:"op"`
+	OK    bool   `json:"ok"`
+	Error string `json:"error,omitempty"`
+	Code  int    `json:"code,omitempty"`
+}
+
+type batchResponse struct {
+	Succeeded int           `json:"succeeded"`
+	Failed    int           `json:"failed"`
+	Results   []batchResult `json:"results"`
+}
+
+// opError is a batch operation failure with its HTTP status.
+type opError struct {
+	code int
+	msg  string
+}
+
+func (e *opError) Error() string { return e.msg }
+
+// apiBatch runs each operation in order, reporting success or failure per
+// operation. The response is 200 when all succeeded and 207 otherwise.
+func (s *Server) apiBatch(w http.ResponseWriter, r *http.Request) {
+	var req batchRequest
+	if !s.decodeAPIRequest(w, r, &req) {
+		return
+	}
+	resp := batchResponse{Results: make([]batchResult, len(req.Operations))}
+	for i, op := range req.Operations {
+		res := batchResult{Index: i, Op: op.Op, OK: true}
+		if err := r.Context().Err(); err != nil {
+			return
+		}
+		var err error
+		switch op.Op {
+		case "delete":
+			err = s.batchDelete(r, op)
+		case "move":
+			err = s.batchMove(op)
+		case "copy":
+			err = s.batchCopy(op)
+		}
+		if err != nil {
+			res.OK = false
+			res.Code = statusForError(err)
+			var oe *opError
+			if errors.As(err, &oe) {
+				res.Code, res.Error = oe.code, oe.msg
+			} else {
+				res.Error = http.StatusText(res.Code)
+			}
+			resp.Failed++
+		} else {
+			resp.Succeeded++
+		}
+		resp.Results[i] = res
+	}
+	s.log.Info("Batch processed", "succeeded", resp.Succeeded, "failed", resp.Failed)
+	status := http.StatusOK
+	if resp.Failed > 0 {
+		status = http.StatusMultiStatus
+	}
+	writeJSON(w, status, resp)
+}
+
+// writable resolves a path that an operation will modify.
+func (s *Server) writable(p string) (string, string, error) {
+	relPath, m, fsPath, ok := s.lookup(p)
+	switch {
+	case !ok:
+		return "", "", &opError{http.StatusNotFound, p + " not found"}
+	case !m.ReadWrite:
+		return "", "", &opError{http.StatusForbidden, m.Prefix + " is read-only"}
+	case relPath == m.Prefix:
+		return "", "", &opError{http.StatusForbidden, "cannot modify mount point " + m.Prefix}
+	}
+	return relPath, fsPath, nil
+}
+
+func (s *Server) batchDelete(r *http.Request, op batchOp) error {
+	relPath, fsPath, err := s.writable(op.Path)
+	if err != nil {
+		return err
+	}
+	switch {
+	case s.trashRetention > 0:
+		err = s.moveToTrash(relPath, fsPath, op.Recursive)
+	case op.Recursive:
+		if _, err := os.Lstat(fsPath); err != nil {
+			return err
+		}
+		err = os.RemoveAll(fsPath)
+	default:
+		err = os.Remove(fsPath)
+		if info, serr := os.Lstat(fsPath); err != nil && serr == nil && info.IsDir() {
+			return &opError{http.StatusConflict, relPath + " is a non-empty directory"}
+		}
+	}
+	if err != nil {
+		return err
+	}
+	s.invalidate(fsPath)
+	s.deleted(r, fsPath)
+	return nil
+}
+
+// transfer resolves the source and target of a move or copy. The source
+// of a copy may be in any mount, so it is also returned as a name in that
+// mount's fs.FS.
+func (s *Server) transfer(op batchOp, modifySource bool) (src, dst string, srcFS fs.FS, srcName string, info fs.FileInfo, err error) {
+	if modifySource {
+		// Only directory mounts are writable.
+		if _, src, err = s.writable(op.From); err == nil {
+			srcFS, srcName = os.DirFS(filepath.Dir(src)), filepath.Base(src)
+		}
+	} else {
+		_, m, fsPath, ok := s.lookup(op.From)
+		if ok {
+			src, srcFS, srcName = fsPath, m.fsys, m.name(fsPath)
+		} else {
+			err = &opError{http.StatusNotFound, op.From + " not found"}
+		}
+	}
+	if err != nil {
+		return "", "", nil, "", nil, err
+	}
+	toRel, dst, err := s.writable(op.To)
+	if err != nil {
+		return "", "", nil, "", nil, err
+	}
+	if modifySource {
+		info, err = os.Lstat(src)
+	} else {
+		info, err = fs.Stat(srcFS, srcName)
+	}
+	if err != nil {
+		return "", "", nil, "", nil, err
+	}
+	if info.IsDir() && within(src, dst) {
+		return "", "", nil, "", nil, &opError{http.StatusBadRequest, "cannot " + op.Op + " a directory into itself"}
+	}
+	if existing, err := os.Lstat(dst); err == nil {
+		if !op.Overwrite || existing.IsDir() || info.IsDir() {
+			return "", "", nil, "", nil, &opError{http.StatusConflict, toRel + " already exists"}
+		}
+	}
+	return src, dst, srcFS, srcName, info, nil
+}
+
+func (s *Server) batchMove(op batchOp) error {
+	src, dst, srcFS, srcName, info, err := s.transfer(op, true)
+	if err != nil {
+		return err
+	}
+	if err := s.keepVersion(dst); err != nil {
+		return err
+	}
+	if err := os.Rename(src, dst); err != nil {
+		// Mounts may live on different filesystems.
+		if !errors.Is(err, syscall.EXDEV) {
+			return err
+		}
+		if err := copyPath(srcFS, srcName, dst, info, nil); err != nil {
+			return err
+		}
+		if err := os.RemoveAll(src); err != nil {
+			return err
+		}
+	}
+	s.invalidate(src)
+	s.invalidate(dst)
+	s.Publish(Event{Type: "move", From: s.urlPath(src), Path: s.urlPath(dst)})
+	return nil
+}
+
+func (s *Server) batchCopy(op batchOp) error {
+	src, dst, srcFS, srcName, info, err := s.transfer(op, false)
+	if err != nil {
+		return err
+	}
+	if err := s.keepVersion(dst); err != nil {
+		return err
+	}
+	// A copy is readable where the source was not, so it leaves out what
+	// a listing or archive of the source directory would.
+	m, _, _ := s.resolve(path.Clean("/" + op.From))
+	skip := func(name string, d fs.DirEntry) bool { return s.unlisted(m, name, d) }
+	if err := copyPath(srcFS, srcName, dst, info, skip); err != nil {
+		return err
+	}
+	s.invalidate(dst)
+	s.Publish(Event{Type: "copy", From: s.urlPath(src), Path: s.urlPath(dst)})
+	return nil
+}
+
+// unlisted reports whether the entry name of m's fs.FS is left out of
+// listings, archives and copies of a directory above it.
+func (s *Server) unlisted(m *Mount, name string, d fs.DirEntry) bool {
+	urlPath := path.Join(m.Prefix, name)
+	if (m.Hidden != HiddenShow && strings.HasPrefix(d.Name(), ".")) || m.internal(urlPath) || s.excluded(urlPath) {
+		return true
+	}
+	return d.IsDir() && s.noIndexed(m, name)
+}
+
+// copyPath copies the file, or directory tree, name in fsys to dst. Below
+// a directory, entries for which skip, when set, reports true are left
+// out.
+func copyPath(fsys fs.FS, name, dst string, info fs.FileInfo, skip func(name string, d fs.DirEntry) bool) error {
+	if info.IsDir() {
+		return copyTree(fsys, name, dst, skip)
+	}
+	if !info.Mode().IsRegular() {
+		return &opError{http.StatusBadRequest, "only regular files and directories can be copied"}
+	}
+	in, err := fsys.Open(name)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	tmp, err := os.CreateTemp(filepath.Dir(dst), ".copy-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	defer tmp.Close()
+	if _, err := io.Copy(tmp, in); err != nil {
+		return err
+	}
+	if err := tmp.Chmod(info.Mode().Perm()); err != nil {
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), dst)
+}
+
+// copyTree copies the directory name in fsys to dst, which must not exist,
+// the way os.CopyFS does.
+func copyTree(fsys fs.FS, name, dst string, skip func(name string, d fs.DirEntry) bool) error {
+	return fs.WalkDir(fsys, name, func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		target := dst
+		if p != name {
+			if skip != nil && skip(p, d) {
+				if d.IsDir() {
+					return fs.SkipDir
+				}
+				return nil
+			}
+			rel := p
+			if name != "." {
+				rel = strings.TrimPrefix(p, name+"/")
+			}
+			target = filepath.Join(dst, filepath.FromSlash(rel))
+		}
+		if d.IsDir() {
+			return os.Mkdir(target, 0o777)
+		}
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+		if !info.Mode().IsRegular() {
+			return &opError{http.StatusBadRequest, "only regular files and directories can be copied"}
+		}
+		in, err := fsys.Open(p)
+		if err != nil {
+			return err
+		}
+		defer in.Close()
+		out, err := os.OpenFile(target, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0o666|info.Mode().Perm())
+		if err != nil {
+			return err
+		}
+		if _, err := io.Copy(out, in); err != nil {
+			out.Close()
+			return err
+		}
+		return out.Close()
+	})
+}
@@ -0,0 +1,96 @@
+package fileserver
+
+import (
+	"errors"
+	"io/fs"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// copyFixture serves a hidden=deny mount at /priv, populated from files,
+// and a writable one at /rw.
+func copyFixture(t *testing.T, opts Options, files map[string]string) (*Server, string) {
+	t.Helper()
+	priv, rw := t.TempDir(), t.TempDir()
+	for name, data := range files {
+		p := filepath.Join(priv, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(p), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(p, []byte(data), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	opts.Root = t.TempDir()
+	opts.Mounts = []Mount{
+		{Prefix: "/priv", Dir: priv, Hidden: HiddenDeny},
+		{Prefix: "/rw", Dir: rw, ReadWrite: true},
+	}
+	s, err := New(opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { s.Close() })
+	return s, rw
+}
+
+func TestBatchCopyLeavesOutUnlisted(t *testing.T) {
+	s, rw := copyFixture(t, Options{}, map[string]string{
+		"a.txt":          "a",
+		".env":           "secret",
+		"sub/b.txt":      "b",
+		"sub/.git/HEAD":  "ref",
+		"quiet/.noindex": "",
+		"quiet/c.txt":    "c",
+	})
+	if err := s.batchCopy(batchOp{Op: "copy", From: "/priv", To: "/rw/c"}); err != nil {
+		t.Fatal(err)
+	}
+	for name, want := range map[string]bool{
+		"a.txt":          true,
+		"sub/b.txt":      true,
+		".env":           false,
+		"sub/.git":       false,
+		"quiet/c.txt":    false,
+		"quiet/.noindex": false,
+	} {
+		_, err := os.Stat(filepath.Join(rw, "c", filepath.FromSlash(name)))
+		if got := err == nil; got != want {
+			t.Errorf("%s copied: %v, want %v", name, got, want)
+		} else if !want && !errors.Is(err, fs.ErrNotExist) {
+			t.Errorf("%s: %v", name, err)
+		}
+	}
+}
+
+func TestBatchCopyLeavesOutExcluded(t *testing.T) {
+	s, _ := copyFixture(t, Options{Exclude: []string{"priv/secrets/**"}}, map[string]string{
+		"a.txt":      "a",
+		"secrets/id": "KEY",
+	})
+	get := func(p string) int {
+		w := httptest.NewRecorder()
+		s.ServeHTTP(w, httptest.NewRequest(http.MethodGet, p, nil))
+		return w.Code
+	}
+	if code := get("/priv/secrets/id"); code != http.StatusNotFound {
+		t.Fatalf("GET /priv/secrets/id: got %d, want 404", code)
+	}
+	if err := s.batchCopy(batchOp{Op: "copy", From: "/priv", To: "/rw/c"}); err != nil {
+		t.Fatal(err)
+	}
+	if code := get("/rw/c/a.txt"); code != http.StatusOK {
+		t.Errorf("GET /rw/c/a.txt: got %d, want 200", code)
+	}
+	for _, p := range []string{"/rw/c/secrets/id", "/rw/c/secrets/"} {
+		if code := get(p); code != http.StatusNotFound {
+			t.Errorf("GET %s: got %d, want 404", p, code)
+		}
+	}
+	if err := s.batchCopy(batchOp{Op: "copy", From: "/priv/secrets/id", To: "/rw/id"}); err == nil {
+		t.Error("copying an excluded file succeeded")
+	}
+}
@@ -0,0 +1,63 @@
+package fileserver
+
+import (
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// Branding customizes HTML listings for a deployment.
+type Branding struct {
+	// Title names the site above each listing and in the window title.
+	Title string
+	// Logo is an image URL, or a local file served among the UI assets.
+	// URLs on other hosts must be allowed by the Content-Security-Policy.
+	Logo string
+	// Footer is plain text shown below each listing.
+	Footer string
+}
+
+// brandLogoPath is where a local logo file is served, keeping its
+// extension for the Content-Type.
+const brandLogoPath = assetsPrefix + "brand/logo"
+
+// compileBranding checks a local logo file and returns the branding as the
+// listings use it, with Logo a URL below the reserved prefix, and the file
+// to serve at that URL.
+func compileBranding(b Branding, reserved string) (Branding, string, error) {
+	// Absolute paths that are not files are URL paths on this server.
+	if b.Logo == "" || strings.Contains(b.Logo, "://") || (strings.HasPrefix(b.Logo, "/") && !fileExists(b.Logo)) {
+		return b, "", nil
+	}
+	file, err := filepath.Abs(b.Logo)
+	if err != nil {
+		return b, "", err
+	}
+	info, err := os.Stat(file)
+	if err != nil {
+		return b, "", fmt.Errorf("logo: %w", err)
+	}
+	if info.IsDir() {
+		return b, "", fmt.Errorf("logo %s is a directory", file)
+	}
+	b.Logo = reserved + brandLogoPath + strings.ToLower(filepath.Ext(file))
+	return b, file, nil
+}
+
+func fileExists(name string) bool {
+	_, err := os.Stat(name)
+	return err == nil
+}
+
+// serveBrandLogo serves the local logo file for requests to its URL,
+// reporting whether it did.
+func (s *Server) serveBrandLogo(w http.ResponseWriter, r *http.Request) bool {
+	if s.brandLogo == "" || s.endpoint(r.URL.Path) != s.branding.Logo {
+		return false
+	}
+	w.Header().Set("Cache-Control", "no-cache")
+	http.ServeFile(w, r, s.brandLogo)
+	return true
+}
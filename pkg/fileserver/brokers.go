@@ -0,0 +1,218 @@
+package fileserver
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"sync/atomic"
+	"time"
+
+	mqtt "github.com/eclipse/paho.mqtt.golang"
+	"github.com/nats-io/nats.go"
+)
+
+// EventBroker publishes events to a NATS or MQTT message broker.
+type EventBroker struct {
+	// URL is nats://host:4222 (tls:// for TLS) for NATS, or
+	// mqtt://host:1883 (mqtts:// for TLS) for MQTT, with user:password@
+	// to authenticate.
+	URL string
+	// Subject is the NATS subject or MQTT topic events are published
+	// below, as Subject.type for NATS and Subject/type for MQTT, such as
+	// go-server.upload. It defaults to "go-server".
+	Subject string
+	// Requests also publishes a "request" event for every request served.
+	Requests bool
+}
+
+// RequestEvent is published to brokers with Requests set after each
+// request.
+type RequestEvent struct {
+	Type      string    `json:"type"` // always "request"
+	Method    string    `json:"method"`
+	Path      string    `json:"path"`
+	Status    int       `json:"status"`
+	Bytes     int64     `json:"bytes"`
+	Duration  float64   `json:"duration_ms"`
+	RemoteIP  string    `json:"remote_ip"`
+	RequestID string    `json:"request_id"`
+	Time      time.Time `json:"time"`
+}
+
+const (
+	defaultBrokerSubject = "go-server"
+	// brokerQueue is how many messages wait for one broker; later ones
+	// are dropped.
+	brokerQueue   = 4096
+	brokerTimeout = 10 * time.Second
+)
+
+// brokerConn is a connection to a NATS server or an MQTT broker.
+type brokerConn interface {
+	publish(typ string, payload []byte) error
+	close()
+}
+
+type brokerMessage struct {
+	typ     string
+	payload []byte
+}
+
+// brokerSink feeds one broker from a queue, so that a slow or unreachable
+// broker never holds up requests.
+type brokerSink struct {
+	url      string
+	requests bool
+	conn     brokerConn
+	queue    chan brokerMessage
+	dropped  atomic.Int64
+}
+
+// startBrokers connects to the brokers, retrying in the background while
+// they are unreachable, and publishes events to them until the server is
+// closed.
+func (s *Server) startBrokers(brokers []EventBroker) error {
+	for _, b := range brokers {
+		subject := b.Subject
+		if subject == "" {
+			subject = defaultBrokerSubject
+		}
+		conn, err := dialBroker(b.URL, subject)
+		if err != nil {
+			return err
+		}
+		sink := &brokerSink{url: b.URL, requests: b.Requests, conn: conn, queue: make(chan brokerMessage, brokerQueue)}
+		s.brokers = append(s.brokers, sink)
+
+		events, unsubscribe := s.Subscribe()
+		go func() {
+			for {
+				select {
+				case ev := <-events:
+					payload, err := json.Marshal(ev)
+					if err == nil {
+						sink.enqueue(ev.Type, payload)
+					}
+				case <-s.stop:
+					unsubscribe()
+					return
+				}
+			}
+		}()
+		go func() {
+			defer sink.conn.close()
+			for {
+				select {
+				case msg := <-sink.queue:
+					if err := sink.conn.publish(msg.typ, msg.payload); err != nil {
+						s.log.Warn("Event publishing failed", "broker", redactURL(sink.url), "type", msg.typ, "error", err)
+					}
+					if n := sink.dropped.Swap(0); n > 0 {
+						s.log.Warn("Event broker queue was full, events dropped", "broker", redactURL(sink.url), "dropped", n)
+					}
+				case <-s.stop:
+					return
+				}
+			}
+		}()
+	}
+	return nil
+}
+
+func (b *brokerSink) enqueue(typ string, payload []byte) {
+	select {
+	case b.queue <- brokerMessage{typ: typ, payload: payload}:
+	default:
+		b.dropped.Add(1)
+	}
+}
+
+// requestEvent publishes a served request to the brokers that want them.
+func (s *Server) requestEvent(r *http.Request, status int, bytes int64, duration time.Duration, reqID string) {
+	var payload []byte
+	for _, b := range s.brokers {
+		if !b.requests {
+			continue
+		}
+		if payload == nil {
+			var err error
+			payload, err = json.Marshal(RequestEvent{
+				Type:      "request",
+				Method:    r.Method,
+				Path:      r.URL.Path,
+				Status:    status,
+				Bytes:     bytes,
+				Duration:  float64(duration.Microseconds()) / 1000,
+				RemoteIP:  RemoteIP(r),
+				RequestID: reqID,
+				Time:      time.Now(),
+			})
+			if err != nil {
+				return
+			}
+		}
+		b.enqueue("request", payload)
+	}
+}
+
+// dialBroker opens a connection by the scheme of rawURL.
+func dialBroker(rawURL, subject string) (brokerConn, error) {
+	u, err := url.Parse(rawURL)
+	if err != nil || u.Host == "" {
+		return nil, fmt.Errorf("event broker %q: invalid URL", rawURL)
+	}
+	switch u.Scheme {
+	case "nats", "tls":
+		nc, err := nats.Connect(rawURL, nats.Name("go-server"), nats.MaxReconnects(-1),
+			nats.RetryOnFailedConnect(true), nats.Timeout(brokerTimeout))
+		if err != nil {
+			return nil, fmt.Errorf("event broker %s: %w", redactURL(rawURL), err)
+		}
+		return &natsConn{nc: nc, subject: subject}, nil
+	case "mqtt", "mqtts":
+		opts := mqtt.NewClientOptions().AddBroker(rawURL).
+			SetClientID("go-server-" + newRequestID()).
+			SetAutoReconnect(true).
+			SetConnectRetry(true).
+			SetConnectTimeout(brokerTimeout)
+		c := mqtt.NewClient(opts)
+		c.Connect() // retried in the background until it succeeds
+		return &mqttConn{c: c, topic: subject}, nil
+	}
+	return nil, fmt.Errorf("event broker %q: unsupported scheme (want nats, tls, mqtt or mqtts)", rawURL)
+}
+
+type natsConn struct {
+	nc      *nats.Conn
+	subject string
+}
+
+func (n *natsConn) publish(typ string, payload []byte) error {
+	return n.nc.Publish(n.subject+"."+typ, payload)
+}
+
+func (n *natsConn) close() { n.nc.Drain() }
+
+type mqttConn struct {
+	c     mqtt.Client
+	topic string
+}
+
+func (m *mqttConn) publish(typ string, payload []byte) error {
+	t := m.c.Publish(m.topic+"/"+typ, 1, false, payload)
+	if !t.WaitTimeout(brokerTimeout) {
+		return fmt.Errorf("publish timed out")
+	}
+	return t.Error()
+}
+
+func (m *mqttConn) close() { m.c.Disconnect(250) }
+
+// redactURL drops the password from a URL for logging.
+func redactURL(rawURL string) string {
+	if u, err := url.Parse(rawURL); err == nil {
+		return u.Redacted()
+	}
+	return rawURL
+}
@@ -0,0 +1,44 @@
+package fileserver
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+)
+
+// contextReader reads until its context is done and then fails with the
+// context's error, so a copy to a client that has gone away stops at the
+// next read instead of running on until a write fails.
+type contextReader struct {
+	ctx context.Context
+	io.Reader
+}
+
+func (c contextReader) Read(p []byte) (int, error) {
+	if err := c.ctx.Err(); err != nil {
+		return 0, err
+	}
+	return c.Reader.Read(p)
+}
+
+// contextSeeker is a contextReader for http.ServeContent, which seeks.
+type contextSeeker struct {
+	contextReader
+	io.Seeker
+}
+
+func newContextSeeker(ctx context.Context, rs io.ReadSeeker) contextSeeker {
+	return contextSeeker{contextReader{ctx, rs}, rs}
+}
+
+// canceled reports whether err is the request's context ending, which
+// happens when the client disconnects, and logs it as a cancellation
+// rather than a failure. Nothing can be written back by then.
+func (s *Server) canceled(r *http.Request, err error) bool {
+	if err == nil || !errors.Is(err, r.Context().Err()) {
+		return false
+	}
+	s.log.Info("Request canceled", "method", r.Method, "path", r.URL.Path, "remote_ip", RemoteIP(r), "cause", context.Cause(r.Context()))
+	return true
+}
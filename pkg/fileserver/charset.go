@@ -0,0 +1,73 @@
+package fileserver
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"mime"
+	"net/http"
+	"strings"
+	"unicode/utf8"
+)
+
+// charsetSniffLen is how much of a text file is read to tell its encoding.
+const charsetSniffLen = 8 << 10
+
+var utf8BOM = []byte{0xEF, 0xBB, 0xBF}
+
+// detectCharset names the encoding of text starting with head: UTF-16 by
+// its byte order mark, UTF-8 if head is valid UTF-8, and Latin-1 otherwise,
+// which browsers read as Windows-1252 and which cannot be wrong about a
+// byte. A rune cut off at the end of head does not count against UTF-8
+// unless head is the whole file.
+func detectCharset(head []byte, whole bool) string {
+	switch {
+	case bytes.HasPrefix(head, []byte{0xFE, 0xFF}):
+		return "utf-16be"
+	case bytes.HasPrefix(head, []byte{0xFF, 0xFE}):
+		return "utf-16le"
+	}
+	if !whole {
+		// Drop the start of a final rune cut off by the sniff length.
+		for i := len(head) - 1; i >= 0 && i >= len(head)-utf8.UTFMax+1; i-- {
+			if utf8.RuneStart(head[i]) {
+				if !utf8.FullRune(head[i:]) {
+					head = head[:i]
+				}
+				break
+			}
+		}
+	}
+	if utf8.Valid(head) {
+		return "utf-8"
+	}
+	return "iso-8859-1"
+}
+
+// textContent settles the Content-Type of a served file from its media
+// type, sniffed when typ is empty, and for text the charset detected in its
+// first bytes. Only a charset configured in Options.MIMETypes or
+// MIMETypesFile is kept as given; the operating system's registry adds
+// UTF-8 to every text type whatever the file holds. With StripBOM, a UTF-8
+// byte order mark is left out of the returned body.
+func (s *Server) textContent(name, typ string, f content, size int64) (string, io.ReadSeeker, error) {
+	head := make([]byte, min(size, charsetSniffLen))
+	n, err := f.ReadAt(head, 0)
+	if err != nil && !errors.Is(err, io.EOF) {
+		return "", nil, err
+	}
+	head = head[:n]
+	if typ == "" {
+		typ = http.DetectContentType(head)
+	}
+	mediaType, params, err := mime.ParseMediaType(typ)
+	if err != nil || !strings.HasPrefix(mediaType, "text/") || (params["charset"] != "" && s.configuredType(name)) {
+		return typ, f, nil
+	}
+	params["charset"] = detectCharset(head, int64(n) == size)
+	var body io.ReadSeeker = f
+	if s.stripBOM && bytes.HasPrefix(head, utf8BOM) {
+		body = io.NewSectionReader(f, int64(len(utf8BOM)), size-int64(len(utf8BOM)))
+	}
+	return mime.FormatMediaType(mediaType, params), body, nil
+}
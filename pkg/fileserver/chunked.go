@@ -0,0 +1,274 @@
+package fileserver
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// The chunked upload API is a simpler alternative to tus:
+//
+//	POST   /api/v1/uploads                    {"path": "/dir/file", "size": 123}
+//	PUT    /api/v1/uploads/{id}/chunks/{n}    raw chunk bytes, n from 0
+//	POST   /api/v1/uploads/{id}/complete      {"chunks": N, "sha256": "..."}
+//	DELETE /api/v1/uploads/{id}
+//
+// Chunks may arrive in any order and be re-sent; completion concatenates
+// chunks 0..N-1, verifies the digest and moves the file into place.
+const (
+	chunkMaxSize  = 64 << 20
+	chunkMaxCount = 10000
+)
+
+type chunkedInfo struct {
+	Dest    string    `json:"dest"`
+	Size    int64     `json:"size,omitempty"` // expected total, if declared
+	Created time.Time `json:"created"`
+}
+
+type uploadCreateRequest struct {
+	Path string `json:"path"`
+	Size int64  `json:"size"`
+}
+
+func (u *uploadCreateRequest) validate() map[string]string {
+	fields := make(map[string]string)
+	if u.Path == "" || u.Path[len(u.Path)-1] == '/' {
+		fields["path"] = "must name a file"
+	}
+	if u.Size < 0 {
+		fields["size"] = "must not be negative"
+	}
+	return fields
+}
+
+type uploadCreateResponse struct {
+	ID           string `json:"id"`
+	Path         string `json:"path"`
+	MaxChunkSize int64  `json:"max_chunk_size"`
+}
+
+func (s *Server) apiUploadCreate(w http.ResponseWriter, r *http.Request) {
+	var req uploadCreateRequest
+	if !s.decodeAPIRequest(w, r, &req) {
+		return
+	}
+	dest := path.Clean("/" + req.Path)
+	if _, err := s.checkUploadTarget(dest); err != nil {
+		writeOpError(w, err)
+		return
+	}
+	id := newUploadID()
+	if err := os.Mkdir(s.staging.path("chunked", id, ".d"), 0o700); err != nil {
+		writeOpError(w, err)
+		return
+	}
+	if err := s.staging.writeInfo("chunked", id, chunkedInfo{Dest: dest, Size: req.Size, Created: time.Now()}); err != nil {
+		s.staging.remove("chunked", id)
+		writeOpError(w, err)
+		return
+	}
+	s.log.Debug("Chunked upload created", "id", id, "dest", dest)
+	w.Header().Set("Location", s.endpoint(apiV1Prefix+"uploads/"+id))
+	writeJSON(w, http.StatusCreated, uploadCreateResponse{ID: id, Path: dest, MaxChunkSize: chunkMaxSize})
+}
+
+// chunkedUpload loads the upload named in the request path.
+func (s *Server) chunkedUpload(w http.ResponseWriter, r *http.Request) (string, chunkedInfo, bool) {
+	var info chunkedInfo
+	id := endpointSegment(r, 1)
+	if !validUploadID(id) {
+		writeAPIError(w, http.StatusNotFound, "no such upload", nil)
+		return "", info, false
+	}
+	if err := s.staging.readInfo("chunked", id, &info); err != nil {
+		if errors.Is(err, fs.ErrNotExist) {
+			writeAPIError(w, http.StatusNotFound, "no such upload", nil)
+		} else {
+			writeOpError(w, err)
+		}
+		return "", info, false
+	}
+	return id, info, true
+}
+
+func chunkName(n int) string {
+	return fmt.Sprintf("%06d", n)
+}
+
+func (s *Server) apiUploadChunk(w http.ResponseWriter, r *http.Request) {
+	id, _, ok := s.chunkedUpload(w, r)
+	if !ok {
+		return
+	}
+	n, err := strconv.Atoi(endpointSegment(r, 3))
+	if err != nil || n < 0 || n >= chunkMaxCount {
+		writeAPIError(w, http.StatusBadRequest, "invalid chunk number", map[string]string{"chunk": fmt.Sprintf("must be 0..%d", chunkMaxCount-1)})
+		return
+	}
+	// Write to a temporary name so an interrupted chunk is never taken
+	// for a complete one.
+	dir := s.staging.path("chunked", id, ".d")
+	tmp, err := os.CreateTemp(dir, ".part-*")
+	if err != nil {
+		writeOpError(w, err)
+		return
+	}
+	defer os.Remove(tmp.Name())
+	defer tmp.Close()
+	size, err := io.Copy(tmp, http.MaxBytesReader(w, r.Body, chunkMaxSize))
+	var tooLarge *http.MaxBytesError
+	if errors.As(err, &tooLarge) {
+		writeAPIError(w, http.StatusRequestEntityTooLarge, fmt.Sprintf("chunk exceeds %d bytes", chunkMaxSize), nil)
+		return
+	}
+	if err == nil {
+		err = tmp.Close()
+	}
+	if err == nil {
+		err = os.Rename(tmp.Name(), filepath.Join(dir, chunkName(n)))
+	}
+	if err != nil {
+		writeOpError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]int64{"chunk": int64(n), "size": size})
+}
+
+type uploadCompleteRequest struct {
+	Chunks int    `json:"chunks"`
+	SHA256 string `json:"sha256"`
+}
+
+func (u *uploadCompleteRequest) validate() map[string]string {
+	fields := make(map[string]string)
+	if u.Chunks < 1 || u.Chunks > chunkMaxCount {
+		fields["chunks"] = fmt.Sprintf("must be 1..%d", chunkMaxCount)
+	}
+	if b, err := hex.DecodeString(u.SHA256); err != nil || len(b) != sha256.Size {
+		fields["sha256"] = "must be a hex SHA-256 digest"
+	}
+	return fields
+}
+
+type uploadCompleteResponse struct {
+	Path   string `json:"path"`
+	Size   int64  `json:"size"`
+	SHA256 string `json:"sha256"`
+	Mirror string `json:"mirror,omitempty"` // "mirrored" or "failed" with a Mirror
+}
+
+func (s *Server) apiUploadComplete(w http.ResponseWriter, r *http.Request) {
+	id, info, ok := s.chunkedUpload(w, r)
+	if !ok {
+		return
+	}
+	var req uploadCompleteRequest
+	if !s.decodeAPIRequest(w, r, &req) {
+		return
+	}
+	if !s.staging.acquire(id) {
+		writeAPIError(w, http.StatusConflict, "upload is already being completed", nil)
+		return
+	}
+	defer s.staging.release(id)
+
+	dir := s.staging.path("chunked", id, ".d")
+	missing := make(map[string]string)
+	for i := 0; i < req.Chunks; i++ {
+		if _, err := os.Stat(filepath.Join(dir, chunkName(i))); err != nil {
+			missing[fmt.Sprintf("chunks[%d]", i)] = "missing"
+		}
+	}
+	if len(missing) > 0 {
+		writeAPIError(w, http.StatusConflict, "chunks are missing", missing)
+		return
+	}
+
+	assembled := s.staging.path("chunked", id, ".bin")
+	size, digest, err := assembleChunks(dir, req.Chunks, assembled)
+	if err != nil {
+		os.Remove(assembled)
+		writeOpError(w, err)
+		return
+	}
+	switch {
+	case info.Size > 0 && size != info.Size:
+		os.Remove(assembled)
+		writeAPIError(w, http.StatusUnprocessableEntity, fmt.Sprintf("assembled %d bytes, expected %d", size, info.Size), nil)
+		return
+	case digest != strings.ToLower(req.SHA256):
+		os.Remove(assembled)
+		writeAPIError(w, http.StatusUnprocessableEntity, "checksum mismatch", map[string]string{"sha256": "assembled file has " + digest})
+		return
+	}
+	mirror, err := s.commitUpload(r, assembled, info.Dest, size)
+	if err != nil {
+		os.Remove(assembled)
+		writeOpError(w, err)
+		return
+	}
+	s.staging.remove("chunked", id)
+	writeJSON(w, http.StatusOK, uploadCompleteResponse{Path: info.Dest, Size: size, SHA256: digest, Mirror: mirror})
+}
+
+// assembleChunks concatenates chunks 0..n-1 of dir into dst, returning
+// the size and hex SHA-256 of the result.
+func assembleChunks(dir string, n int, dst string) (int64, string, error) {
+	out, err := os.OpenFile(dst, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0o600)
+	if err != nil {
+		return 0, "", err
+	}
+	defer out.Close()
+	h := sha256.New()
+	var size int64
+	for i := 0; i < n; i++ {
+		part, err := os.Open(filepath.Join(dir, chunkName(i)))
+		if err != nil {
+			return 0, "", err
+		}
+		written, err := io.Copy(io.MultiWriter(out, h), part)
+		part.Close()
+		if err != nil {
+			return 0, "", err
+		}
+		size += written
+	}
+	return size, hex.EncodeToString(h.Sum(nil)), out.Close()
+}
+
+func (s *Server) apiUploadAbort(w http.ResponseWriter, r *http.Request) {
+	id, _, ok := s.chunkedUpload(w, r)
+	if !ok {
+		return
+	}
+	if !s.staging.acquire(id) {
+		writeAPIError(w, http.StatusConflict, "upload is being completed", nil)
+		return
+	}
+	defer s.staging.release(id)
+	s.staging.remove("chunked", id)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// writeOpError reports an opError, or a filesystem error, in the API
+// error envelope.
+func writeOpError(w http.ResponseWriter, err error) {
+	var oe *opError
+	if errors.As(err, &oe) {
+		writeAPIError(w, oe.code, oe.msg, nil)
+		return
+	}
+	status := statusForError(err)
+	writeAPIError(w, status, http.StatusText(status), nil)
+}
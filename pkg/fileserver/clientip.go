@@ -0,0 +1,140 @@
+package fileserver
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/http"
+	"net/netip"
+	"strings"
+)
+
+// ParseTrustedProxies parses a comma-separated list of CIDRs or bare IPs.
+func ParseTrustedProxies(list string) ([]netip.Prefix, error) {
+	var out []netip.Prefix
+	for _, s := range strings.Split(list, ",") {
+		s = strings.TrimSpace(s)
+		if s == "" {
+			continue
+		}
+		if !strings.Contains(s, "/") {
+			addr, err := netip.ParseAddr(s)
+			if err != nil {
+				return nil, fmt.Errorf("invalid trusted proxy %q: %w", s, err)
+			}
+			out = append(out, netip.PrefixFrom(addr, addr.BitLen()))
+			continue
+		}
+		p, err := netip.ParsePrefix(s)
+		if err != nil {
+			return nil, fmt.Errorf("invalid trusted proxy %q: %w", s, err)
+		}
+		out = append(out, p.Masked())
+	}
+	return out, nil
+}
+
+// isTrustedProxy reports whether ip lies in one of the trusted networks.
+func (s *Server) isTrustedProxy(ip string) bool {
+	addr, err := netip.ParseAddr(ip)
+	if err != nil {
+		return false
+	}
+	addr = addr.Unmap()
+	for _, p := range s.trusted {
+		if p.Contains(addr) {
+			return true
+		}
+	}
+	return false
+}
+
+type clientIPKey struct{}
+
+// realIP resolves the client address for requests arriving through a
+// trusted proxy. X-Forwarded-For is walked from the right, skipping trusted
+// hops, so a client cannot spoof its address by prepending entries.
+func (s *Server) realIP(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		peer := peerIP(r)
+		if len(s.trusted) == 0 || !s.isTrustedProxy(peer) {
+			next.ServeHTTP(w, r)
+			return
+		}
+		client := peer
+		if xff := r.Header.Values("X-Forwarded-For"); len(xff) > 0 {
+			hops := strings.Split(strings.Join(xff, ","), ",")
+			for i := len(hops) - 1; i >= 0; i-- {
+				hop := strings.TrimSpace(hops[i])
+				if _, err := netip.ParseAddr(hop); err != nil {
+					break
+				}
+				client = hop
+				if !s.isTrustedProxy(hop) {
+					break
+				}
+			}
+		} else if xri := strings.TrimSpace(r.Header.Get("X-Real-IP")); xri != "" {
+			if _, err := netip.ParseAddr(xri); err == nil {
+				client = xri
+			}
+		}
+		next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), clientIPKey{}, client)))
+	})
+}
+
+// origin returns the scheme and host the client used, for building
+// absolute URLs. Behind a trusted proxy that terminates TLS, the scheme
+// comes from X-Forwarded-Proto, walked from the right in step with
+// X-Forwarded-For like realIP does: each proxy appends the scheme it was
+// reached with, so the entry to use is the one the proxy nearest the
+// client appended, and anything left of it may come from the client.
+func (s *Server) origin(r *http.Request) string {
+	scheme := "http"
+	if r.TLS != nil {
+		scheme = "https"
+	}
+	if len(s.trusted) > 0 && s.isTrustedProxy(peerIP(r)) {
+		protos := headerList(r, "X-Forwarded-Proto")
+		hops := headerList(r, "X-Forwarded-For")
+		i, j := len(protos)-1, len(hops)-1
+		for i > 0 && j >= 0 && s.isTrustedProxy(hops[j]) {
+			i, j = i-1, j-1
+		}
+		if i >= 0 {
+			if proto := strings.ToLower(protos[i]); proto == "http" || proto == "https" {
+				scheme = proto
+			}
+		}
+	}
+	return scheme + "://" + r.Host
+}
+
+// headerList returns the comma-separated entries of every name header of r.
+func headerList(r *http.Request, name string) []string {
+	var out []string
+	for _, v := range r.Header.Values(name) {
+		for _, e := range strings.Split(v, ",") {
+			out = append(out, strings.TrimSpace(e))
+		}
+	}
+	return out
+}
+
+// peerIP returns the address of the directly connected peer.
+func peerIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
+
+// RemoteIP returns the client address, as resolved from forwarding headers
+// when the request came through a trusted proxy.
+func RemoteIP(r *http.Request) string {
+	if ip, ok := r.Context().Value(clientIPKey{}).(string); ok {
+		return ip
+	}
+	return peerIP(r)
+}
@@ -0,0 +1,41 @@
+package fileserver
+
+import (
+	"net/http/httptest"
+	"net/netip"
+	"testing"
+)
+
+func TestOriginForwardedProto(t *testing.T) {
+	s := &Server{trusted: []netip.Prefix{netip.MustParsePrefix("10.0.0.0/8")}}
+	tests := []struct {
+		name       string
+		peer       string
+		proto, xff string
+		want       string
+	}{
+		{"no header", "10.0.0.1", "", "", "http"},
+		{"proxy terminates TLS", "10.0.0.1", "https", "203.0.113.7", "https"},
+		{"spoofed leading https", "10.0.0.1", "https, http", "203.0.113.7", "http"},
+		{"spoofed leading http", "10.0.0.1", "http, https", "203.0.113.7", "https"},
+		{"spoofed with a forged hop", "10.0.0.1", "https, http", "10.9.9.9, 203.0.113.7", "http"},
+		{"chain of trusted proxies", "10.0.0.1", "https, http", "203.0.113.7, 10.0.0.2", "https"},
+		{"untrusted peer", "198.51.100.1", "https", "", "http"},
+		{"unknown scheme", "10.0.0.1", "ftp", "203.0.113.7", "http"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest("GET", "http://example.com/", nil)
+			r.RemoteAddr = tt.peer + ":1234"
+			if tt.proto != "" {
+				r.Header.Set("X-Forwarded-Proto", tt.proto)
+			}
+			if tt.xff != "" {
+				r.Header.Set("X-Forwarded-For", tt.xff)
+			}
+			if got, want := s.origin(r), tt.want+"://example.com"; got != want {
+				t.Errorf("got %q, want %q", got, want)
+			}
+		})
+	}
+}
@@ -0,0 +1,63 @@
+package fileserver
+
+import (
+	"errors"
+	"io/fs"
+	"path/filepath"
+	"strings"
+)
+
+// within reports whether target is base or lies below it, comparing the
+// paths as written. It relies on filepath.Rel, which compares Windows
+// paths case-insensitively, handles drive letters and both separators, and
+// does not mistake a sibling such as /srv/data2 for part of /srv/data or a
+// name beginning with ".." for a step up.
+func within(base, target string) bool {
+	rel, err := filepath.Rel(base, target)
+	if err != nil || filepath.IsAbs(rel) {
+		return false
+	}
+	return rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator))
+}
+
+// contained reports whether target stays within base on disk: both are
+// resolved with filepath.EvalSymlinks, which follows symbolic links and
+// expands Windows 8.3 short names, before comparing them with within.
+// Parts of target that do not exist yet, such as the name of an upload,
+// are compared as written. base must already be resolved; see realDir.
+func contained(base, target string) bool {
+	real, err := evalExisting(target)
+	return err == nil && within(base, real)
+}
+
+// realDir resolves a directory with filepath.EvalSymlinks for contained,
+// falling back to dir itself when it cannot be resolved, such as when it
+// does not exist yet.
+func realDir(dir string) string {
+	if real, err := filepath.EvalSymlinks(dir); err == nil {
+		return real
+	}
+	return dir
+}
+
+// evalExisting resolves the symbolic links in the longest existing prefix
+// of name and appends the rest unchanged.
+func evalExisting(name string) (string, error) {
+	name = filepath.Clean(name)
+	var rest []string
+	for {
+		real, err := filepath.EvalSymlinks(name)
+		if err == nil {
+			return filepath.Join(append([]string{real}, rest...)...), nil
+		}
+		if !errors.Is(err, fs.ErrNotExist) {
+			return "", err
+		}
+		parent := filepath.Dir(name)
+		if parent == name {
+			return "", err
+		}
+		rest = append([]string{filepath.Base(name)}, rest...)
+		name = parent
+	}
+}
@@ -0,0 +1,92 @@
+package fileserver
+
+import (
+	"os"
+	"path/filepath"
+	"runtime"
+	"testing"
+)
+
+func TestWithin(t *testing.T) {
+	tests := []struct {
+		base, target string
+		want         bool
+	}{
+		{"/srv/data", "/srv/data", true},
+		{"/srv/data", "/srv/data/a.txt", true},
+		{"/srv/data", "/srv/data/dir/../a.txt", true},
+		{"/srv/data", "/srv/data/..foo", true},
+		{"/srv/data", "/srv/data/a/..b/c", true},
+		{"/srv/data", "/srv", false},
+		{"/srv/data", "/srv/data2", false},
+		{"/srv/data", "/srv/data2/a.txt", false},
+		{"/srv/data", "/srv/data/../etc/passwd", false},
+		{"/srv/data", "/etc/passwd", false},
+		{"/srv/data/", "/srv/data/a", true},
+		{"/", "/anything", true},
+	}
+	if runtime.GOOS == "windows" {
+		tests = []struct {
+			base, target string
+			want         bool
+		}{
+			{`C:\srv\data`, `C:\srv\data`, true},
+			{`C:\srv\data`, `C:\srv\data\a.txt`, true},
+			{`C:\srv\data`, `c:\SRV\Data\a.txt`, true},
+			{`C:\srv\data`, `C:/srv/data/a.txt`, true},
+			{`C:\srv\data`, `C:\srv\data\..foo`, true},
+			{`C:\srv\data`, `C:\srv\data\..\secret`, false},
+			{`C:\srv\data`, `C:\srv\data\a\..\..\secret`, false},
+			{`C:\srv\data`, `C:\srv\data2`, false},
+			{`C:\srv\data`, `D:\srv\data\a.txt`, false},
+			{`C:\srv\data`, `\\server\share\srv\data`, false},
+			{`\\server\share\data`, `\\server\share\data\a.txt`, true},
+			{`\\server\share\data`, `\\server\share\other`, false},
+		}
+	}
+	for _, tt := range tests {
+		if got := within(tt.base, tt.target); got != tt.want {
+			t.Errorf("within(%q, %q) = %v, want %v", tt.base, tt.target, got, tt.want)
+		}
+	}
+}
+
+func TestContained(t *testing.T) {
+	dir := t.TempDir()
+	base, outside := filepath.Join(dir, "base"), filepath.Join(dir, "outside")
+	for _, d := range []string{filepath.Join(base, "sub"), outside} {
+		if err := os.MkdirAll(d, 0o755); err != nil {
+			t.Fatal(err)
+		}
+	}
+	links := map[string]string{
+		filepath.Join(base, "out"): outside,
+		filepath.Join(base, "in"):  filepath.Join(base, "sub"),
+	}
+	for link, target := range links {
+		if err := os.Symlink(target, link); err != nil {
+			t.Skipf("cannot create symbolic links: %v", err)
+		}
+	}
+	real := realDir(base)
+
+	tests := []struct {
+		target string
+		want   bool
+	}{
+		{base, true},
+		{filepath.Join(base, "sub"), true},
+		{filepath.Join(base, "sub", "new.txt"), true},
+		{filepath.Join(base, "missing", "new.txt"), true},
+		{filepath.Join(base, "in"), true},
+		{filepath.Join(base, "in", "new.txt"), true},
+		{filepath.Join(base, "out"), false},
+		{filepath.Join(base, "out", "new.txt"), false},
+		{outside, false},
+	}
+	for _, tt := range tests {
+		if got := contained(real, tt.target); got != tt.want {
+			t.Errorf("contained(%q, %q) = %v, want %v", real, tt.target, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,163 @@
+package fileserver
+
+import (
+	"io/fs"
+	"net/http"
+	"path"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// dirSizeTTL bounds how long a size is trusted, as changes made
+	// outside the server are not seen.
+	dirSizeTTL     = 10 * time.Minute
+	dirSizeWorkers = 2
+)
+
+type dirSize struct {
+	size  int64
+	files int
+	at    time.Time
+}
+
+// dirSizer computes the total size of directories in the background for
+// listings. Results are cached by URL path and dropped, with those of
+// every parent, when the server writes or deletes below them.
+type dirSizer struct {
+	mu      sync.Mutex
+	sizes   map[string]dirSize
+	pending map[string]bool
+	queue   chan string
+}
+
+func newDirSizer() *dirSizer {
+	return &dirSizer{
+		sizes:   make(map[string]dirSize),
+		pending: make(map[string]bool),
+		queue:   make(chan string, 1024),
+	}
+}
+
+// get returns the size of the directory at urlPath if it is known, and
+// otherwise queues its computation.
+func (ds *dirSizer) get(urlPath string) (dirSize, bool) {
+	urlPath = path.Clean(urlPath)
+	ds.mu.Lock()
+	defer ds.mu.Unlock()
+	if d, ok := ds.sizes[urlPath]; ok && time.Since(d.at) < dirSizeTTL {
+		return d, true
+	}
+	if !ds.pending[urlPath] {
+		select {
+		case ds.queue <- urlPath:
+			ds.pending[urlPath] = true
+		default: // busy; asked again on the next poll
+		}
+	}
+	return dirSize{}, false
+}
+
+// invalidate forgets the sizes of urlPath and all its parents.
+func (ds *dirSizer) invalidate(urlPath string) {
+	ds.mu.Lock()
+	defer ds.mu.Unlock()
+	for p := path.Clean(urlPath); ; p = path.Dir(p) {
+		delete(ds.sizes, p)
+		if p == "/" || p == "." {
+			return
+		}
+	}
+}
+
+// sizeDirs computes queued directory sizes until the server is closed.
+func (s *Server) sizeDirs() {
+	for {
+		select {
+		case urlPath := <-s.dirSizes.queue:
+			d := s.measureDir(urlPath)
+			s.dirSizes.mu.Lock()
+			delete(s.dirSizes.pending, urlPath)
+			s.dirSizes.sizes[urlPath] = d
+			s.dirSizes.mu.Unlock()
+		case <-s.stop:
+			return
+		}
+	}
+}
+
+// measureDir adds up the regular files below a directory of one mount,
+// leaving out what listings hide. Unreadable parts count as empty.
+func (s *Server) measureDir(urlPath string) dirSize {
+	d := dirSize{at: time.Now()}
+	m, fsPath, ok := s.resolve(urlPath)
+	if !ok {
+		return d
+	}
+	root := m.name(fsPath)
+	fs.WalkDir(m.fsys, root, func(name string, e fs.DirEntry, err error) error {
+		select {
+		case <-s.stop:
+			return fs.SkipAll
+		default:
+		}
+		if err != nil {
+			return nil
+		}
+		if name != root && ((m.Hidden != HiddenShow && strings.HasPrefix(e.Name(), ".")) || m.internal(path.Join(m.Prefix, name)) || s.excluded(path.Join(m.Prefix, name))) {
+			if e.IsDir() {
+				return fs.SkipDir
+			}
+			return nil
+		}
+		if e.Type().IsRegular() {
+			if info, err := e.Info(); err == nil {
+				d.size += info.Size()
+				d.files++
+			}
+		}
+		return nil
+	})
+	return d
+}
+
+type dirSizeResponse struct {
+	Path    string `json:"path"`
+	Pending bool   `json:"pending"`
+	Size    int64  `json:"size"`
+	Files   int    `json:"files"`
+	Human   string `json:"human,omitempty"`
+}
+
+// apiDirSize answers GET /api/v1/dirsize?path=/dir/ with the total size
+// of a directory, or with pending set while it is being computed; the
+// listing page polls it for directories shown with a placeholder.
+func (s *Server) apiDirSize(w http.ResponseWriter, r *http.Request) {
+	p := r.URL.Query().Get("path")
+	if p == "" {
+		writeAPIError(w, http.StatusBadRequest, "missing path", map[string]string{"path": "required"})
+		return
+	}
+	relPath, m, fsPath, ok := s.lookup(p)
+	if !ok {
+		writeAPIError(w, http.StatusNotFound, http.StatusText(http.StatusNotFound), nil)
+		return
+	}
+	info, err := m.stat(fsPath)
+	if err != nil {
+		status := statusForError(err)
+		writeAPIError(w, status, http.StatusText(status), nil)
+		return
+	}
+	if !info.IsDir() {
+		writeAPIError(w, http.StatusUnprocessableEntity, "not a directory", nil)
+		return
+	}
+	resp := dirSizeResponse{Path: relPath, Pending: true}
+	if d, ok := s.dirSizes.get(relPath); ok {
+		resp = dirSizeResponse{Path: relPath, Size: d.size, Files: d.files, Human: humanSize(d.size)}
+	}
+	w.Header().Set("Cache-Control", "no-store")
+	writeJSON(w, http.StatusOK, resp)
+}
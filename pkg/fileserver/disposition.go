@@ -0,0 +1,90 @@
+package fileserver
+
+import (
+	"fmt"
+	"mime"
+	"net/http"
+	"path"
+	"strings"
+)
+
+// DefaultDisposition is the Content-Disposition policy used unless
+// Options.Disposition is given: PDFs and images open in the browser,
+// installers and disk images download. Other files get no header, leaving
+// the choice to the browser.
+var DefaultDisposition = map[string]string{
+	".pdf": "inline", ".png": "inline", ".jpg": "inline", ".jpeg": "inline",
+	".gif": "inline", ".webp": "inline", ".avif": "inline", ".svg": "inline",
+	".exe": "attachment", ".msi": "attachment", ".dmg": "attachment", ".pkg": "attachment",
+	".deb": "attachment", ".rpm": "attachment", ".apk": "attachment",
+	".iso": "attachment", ".img": "attachment", ".bin": "attachment",
+}
+
+// compileDisposition validates a policy mapping extensions, or "*" for
+// all others, to "inline" or "attachment".
+func compileDisposition(policy map[string]string) (map[string]string, error) {
+	if policy == nil {
+		policy = DefaultDisposition
+	}
+	out := make(map[string]string, len(policy))
+	for ext, disp := range policy {
+		if ext != "*" && !strings.HasPrefix(ext, ".") {
+			return nil, fmt.Errorf("disposition: extension %q must start with a dot or be *", ext)
+		}
+		if disp != "inline" && disp != "attachment" {
+			return nil, fmt.Errorf("disposition for %s: %q is not inline or attachment", ext, disp)
+		}
+		out[strings.ToLower(ext)] = disp
+	}
+	return out, nil
+}
+
+// setDisposition sets Content-Disposition for a served file from the
+// policy, or to attachment when the request has ?download=1.
+func (s *Server) setDisposition(w http.ResponseWriter, r *http.Request, name string) {
+	disp := s.disposition[strings.ToLower(path.Ext(name))]
+	if disp == "" {
+		disp = s.disposition["*"]
+	}
+	if v := r.URL.Query().Get("download"); v == "1" || v == "true" {
+		disp = "attachment"
+	}
+	if disp == "" {
+		return
+	}
+	w.Header().Set("Content-Disposition", contentDisposition(disp, name))
+}
+
+// contentDisposition formats a Content-Disposition naming a file. Names
+// that are not plain ASCII get an approximation in filename for old
+// clients and the exact name in an RFC 5987 filename*, which browsers
+// prefer.
+func contentDisposition(disp, name string) string {
+	var ascii strings.Builder
+	for _, c := range name {
+		if c < 0x20 || c >= 0x7f || c == '%' {
+			c = '_'
+		}
+		ascii.WriteRune(c)
+	}
+	v := mime.FormatMediaType(disp, map[string]string{"filename": ascii.String()})
+	if ascii.String() != name {
+		v += "; filename*=UTF-8''" + rfc5987Escape(name)
+	}
+	return v
+}
+
+// rfc5987Escape percent-encodes every byte of s that is not an attr-char
+// of RFC 5987.
+func rfc5987Escape(s string) string {
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		if 'a' <= c && c <= 'z' || 'A' <= c && c <= 'Z' || '0' <= c && c <= '9' || strings.IndexByte("!#$&+-.^_`|~", c) >= 0 {
+			b.WriteByte(c)
+		} else {
+			fmt.Fprintf(&b, "%%%02X", c)
+		}
+	}
+	return b.String()
+}
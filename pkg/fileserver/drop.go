@@ -0,0 +1,263 @@
+package fileserver
+
+import (
+	"errors"
+	"fmt"
+	"html"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+// The drop zone under /drop/ holds ad-hoc uploads outside the served tree.
+// Each one gets an unguessable URL that works once and only until the
+// drop TTL passes.
+const (
+	dropPrefix         = "/drop/"
+	defaultDropMaxSize = 100 << 20
+)
+
+type dropZone struct {
+	dir     string
+	ttl     time.Duration
+	maxSize int64
+
+	mu    sync.Mutex
+	items map[string]dropItem // by token
+}
+
+type dropItem struct {
+	name    string
+	size    int64
+	expires time.Time
+}
+
+// newDropZone prepares dir, by default under os.TempDir. Files left by an
+// earlier run are unreachable, as the index is kept in memory, and are
+// removed.
+func newDropZone(dir string, ttl time.Duration, maxSize int64) (*dropZone, error) {
+	if dir == "" {
+		dir = filepath.Join(os.TempDir(), "go-server-drop")
+	}
+	if maxSize <= 0 {
+		maxSize = defaultDropMaxSize
+	}
+	if err := os.MkdirAll(dir, 0o700); err != nil {
+		return nil, fmt.Errorf("create drop directory: %w", err)
+	}
+	leftovers, _ := filepath.Glob(filepath.Join(dir, "drop-*"))
+	for _, f := range leftovers {
+		os.Remove(f)
+	}
+	return &dropZone{dir: dir, ttl: ttl, maxSize: maxSize, items: make(map[string]dropItem)}, nil
+}
+
+func (dz *dropZone) path(token string) string {
+	return filepath.Join(dz.dir, "drop-"+token)
+}
+
+// claim removes an item from the index so only one request can fetch it.
+func (dz *dropZone) claim(token string) (dropItem, bool) {
+	dz.mu.Lock()
+	defer dz.mu.Unlock()
+	item, ok := dz.items[token]
+	if !ok {
+		return item, false
+	}
+	delete(dz.items, token)
+	if time.Now().After(item.expires) {
+		os.Remove(dz.path(token))
+		return item, false
+	}
+	return item, true
+}
+
+// cleanDrop periodically deletes expired drops.
+func (s *Server) cleanDrop() {
+	ticker := time.NewTicker(min(s.drop.ttl, time.Minute))
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			now := time.Now()
+			s.drop.mu.Lock()
+			for token, item := range s.drop.items {
+				if now.After(item.expires) {
+					delete(s.drop.items, token)
+					os.Remove(s.drop.path(token))
+					s.log.Debug("Drop expired", "name", item.name)
+				}
+			}
+			s.drop.mu.Unlock()
+		case <-s.stop:
+			return
+		}
+	}
+}
+
+func (s *Server) dropHandler(w http.ResponseWriter, r *http.Request) {
+	rest := strings.TrimPrefix(r.URL.Path, dropPrefix)
+	token, _, download := strings.Cut(rest, "/")
+	switch {
+	case rest == "":
+		if !s.allowMethods(w, r, http.MethodGet, http.MethodHead, http.MethodPost) {
+			return
+		}
+		if r.Method == http.MethodPost {
+			s.dropPost(w, r)
+		} else {
+			s.dropPage(w, http.StatusOK, "")
+		}
+	case !download:
+		// curl -T file https://host/_/drop/
+		if s.allowMethods(w, r, http.MethodPut) {
+			s.dropStore(w, r, rest, r.Body)
+		}
+	case !validUploadID(token):
+		s.renderError(w, r, http.StatusNotFound)
+	// Not HEAD: the link works once, and a HEAD would use it up.
+	case s.allowMethods(w, r, http.MethodGet):
+		s.dropGet(w, r, token)
+	}
+}
+
+// dropPost accepts the page's form: a "file" field, or pasted "text".
+func (s *Server) dropPost(w http.ResponseWriter, r *http.Request) {
+	r.Body = http.MaxBytesReader(w, r.Body, s.drop.maxSize+64<<10)
+	mr, err := r.MultipartReader()
+	if err != nil {
+		httpError(w, r, "Expected a multipart/form-data upload", http.StatusBadRequest)
+		return
+	}
+	for {
+		part, err := mr.NextPart()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			s.dropError(w, r, err)
+			return
+		}
+		switch {
+		case part.FormName() == "file" && part.FileName() != "":
+			s.dropStore(w, r, part.FileName(), part)
+			return
+		case part.FormName() == "text":
+			// Skip an empty paste box submitted alongside a file.
+			var first [1]byte
+			if n, _ := io.ReadFull(part, first[:]); n == 1 {
+				s.dropStore(w, r, "paste.txt", io.MultiReader(strings.NewReader(string(first[:])), part))
+				return
+			}
+		}
+	}
+	httpError(w, r, "Nothing to drop: send a file or text", http.StatusBadRequest)
+}
+
+// dropStore saves body as a new drop and answers with its URL.
+func (s *Server) dropStore(w http.ResponseWriter, r *http.Request, name string, body io.Reader) {
+	name = filepath.Base(strings.ReplaceAll(name, `\`, "/"))
+	if name == "." || name == "/" || name == ".." || len(name) > 255 {
+		httpError(w, r, "Invalid file name", http.StatusBadRequest)
+		return
+	}
+	token := newUploadID()
+	tmp := s.drop.path(token) + ".part"
+	f, err := os.OpenFile(tmp, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0o600)
+	if err != nil {
+		s.dropError(w, r, err)
+		return
+	}
+	defer os.Remove(tmp)
+	size, err := io.Copy(f, io.LimitReader(body, s.drop.maxSize+1))
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err == nil && size > s.drop.maxSize {
+		err = &http.MaxBytesError{Limit: s.drop.maxSize}
+	}
+	if err == nil {
+		err = os.Rename(tmp, s.drop.path(token))
+	}
+	if err != nil {
+		s.dropError(w, r, err)
+		return
+	}
+	item := dropItem{name: name, size: size, expires: time.Now().Add(s.drop.ttl)}
+	s.drop.mu.Lock()
+	s.drop.items[token] = item
+	s.drop.mu.Unlock()
+	s.log.Info("File dropped", "name", name, "size", size, "remote_ip", RemoteIP(r))
+
+	link := s.endpoint(dropPrefix + token + "/" + name)
+	url := s.origin(r) + link
+	if strings.Contains(r.Header.Get("Accept"), "text/html") {
+		s.dropPage(w, http.StatusCreated, url)
+		return
+	}
+	w.Header().Set("Location", link)
+	writeJSON(w, http.StatusCreated, map[string]interface{}{
+		"url":     url,
+		"name":    name,
+		"size":    size,
+		"expires": item.expires.UTC(),
+	})
+}
+
+func (s *Server) dropError(w http.ResponseWriter, r *http.Request, err error) {
+	var tooLarge *http.MaxBytesError
+	if errors.As(err, &tooLarge) {
+		httpError(w, r, fmt.Sprintf("Drops are limited to %d bytes", s.drop.maxSize), http.StatusRequestEntityTooLarge)
+		return
+	}
+	s.log.Error("Drop failed", "error", err)
+	httpError(w, r, "Drop failed", http.StatusInternalServerError)
+}
+
+// dropGet sends a drop once and deletes it.
+func (s *Server) dropGet(w http.ResponseWriter, r *http.Request, token string) {
+	item, ok := s.drop.claim(token)
+	if !ok {
+		s.renderError(w, r, http.StatusNotFound)
+		return
+	}
+	p := s.drop.path(token)
+	defer os.Remove(p)
+	f, err := os.Open(p)
+	if err != nil {
+		s.renderError(w, r, statusForError(err))
+		return
+	}
+	defer f.Close()
+	// The file is gone after this response, so there is nothing to
+	// resume or revalidate.
+	r.Header.Del("Range")
+	r.Header.Del("If-Modified-Since")
+	r.Header.Del("If-None-Match")
+	w.Header().Set("Cache-Control", "no-store")
+	w.Header().Set("Content-Disposition", contentDisposition("attachment", item.name))
+	http.ServeContent(w, r, item.name, time.Time{}, f)
+	s.log.Info("Drop downloaded", "name", item.name, "size", item.size, "remote_ip", RemoteIP(r))
+}
+
+// dropPage renders the upload form, with the link to a new drop if any.
+func (s *Server) dropPage(w http.ResponseWriter, status int, link string) {
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.WriteHeader(status)
+	fmt.Fprintf(w, `<!DOCTYPE html>
+<html><head><meta charset="utf-8"><meta name="viewport" content="width=device-width, initial-scale=1"><title>Drop</title>
+<link rel="stylesheet" href="%[1]sstyle.css"><link rel="icon" href="%[1]sfavicon.svg"></head><body>
+<h1>Drop a file</h1>`, s.endpoint(assetsPrefix))
+	if link != "" {
+		fmt.Fprintf(w, `<p>One-time link, valid for %s: <a href="%[2]s">%[2]s</a></p>`, s.drop.ttl, html.EscapeString(link))
+	}
+	fmt.Fprintf(w, `<form method="post" action="%s" enctype="multipart/form-data">
+<p><input type="file" name="file"></p>
+<p><textarea name="text" rows="10" cols="80" placeholder="or paste text"></textarea></p>
+<p><button type="submit">Drop</button> Up to %d bytes; the link works once and expires after %s.</p>
+</form></body></html>`, s.endpoint(dropPrefix), s.drop.maxSize, s.drop.ttl)
+}
@@ -0,0 +1,242 @@
+package fileserver
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"html/template"
+	"io"
+	"io/fs"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+// editMaxSize is the largest file the editor opens and PUT /api/v1/file
+// accepts.
+const editMaxSize = 1 << 20
+
+// fileETag derives a strong entity tag from a file's size and
+// modification time, which change with every write through the server.
+func fileETag(info fs.FileInfo) string {
+	sum := sha256.Sum256(fmt.Appendf(nil, "%d/%d", info.Size(), info.ModTime().UnixNano()))
+	return `"` + hex.EncodeToString(sum[:16]) + `"`
+}
+
+// authenticated reports whether the request carries valid credentials.
+// Without configured users nobody is authenticated, so editing is off.
+func (s *Server) authenticated(r *http.Request) bool {
+	if _, ok := kerberosUser(r); ok {
+		return true
+	}
+	st := s.settings.Load()
+	user, pass, ok := r.BasicAuth()
+	return ok && s.authRequired(st) && s.checkUser(st, user, pass)
+}
+
+// editable checks that a file may be changed in the editor by this
+// request, returning its URL path and filesystem path.
+func (s *Server) editable(r *http.Request, p string) (string, string, error) {
+	if !s.authenticated(r) {
+		return "", "", &opError{http.StatusForbidden, "editing requires authentication"}
+	}
+	return s.writable(p)
+}
+
+type editPage struct {
+	Lang, Title, Path, Parent, ETag, Content string
+	Save, Saved, Conflict, Back              string
+	Assets, API                              string // URLs ending in "/"
+}
+
+var editTemplate = template.Must(template.New("edit").Parse(`<!DOCTYPE html>
+<html lang="{{.Lang}}"><head><meta charset="utf-8"><meta name="viewport" content="width=device-width, initial-scale=1">
+<title>{{.Title}}</title><link rel="stylesheet" href="{{.Assets}}style.css"><script src="{{.Assets}}edit.js" defer></script></head>
+<body data-api="{{.API}}" data-saved="{{.Saved}}" data-conflict="{{.Conflict}}">
+<header class="title"><h1>{{.Title}}</h1><a class="view" href="{{.Parent}}">{{.Back}}</a></header>
+<form id="editor" class="editor" data-path="{{.Path}}" data-etag="{{.ETag}}">
+{{/* The newline after the tag is dropped by HTML parsers, so one that
+starts the file survives. */}}<textarea name="content" spellcheck="false" autofocus>
+{{.Content}}</textarea>
+<p><button type="submit">{{.Save}}</button> <span class="status" role="status"></span></p>
+</form>
+</body></html>
+`))
+
+// editHandler shows a text file in an editor (?edit=1), which saves it
+// through PUT /api/v1/file with the ETag it was opened at.
+func (s *Server) editHandler(w http.ResponseWriter, r *http.Request, relPath string) {
+	if !s.authenticated(r) && s.authRequired(s.settings.Load()) {
+		// Ask for credentials even when the auth middleware is not in
+		// the chain.
+		s.challenge(w, s.settings.Load())
+		s.renderError(w, r, http.StatusUnauthorized)
+		return
+	}
+	_, fsPath, err := s.editable(r, relPath)
+	if err != nil {
+		var oe *opError
+		if errors.As(err, &oe) {
+			s.renderError(w, r, oe.code)
+		} else {
+			s.renderError(w, r, statusForError(err))
+		}
+		return
+	}
+	info, err := os.Stat(fsPath)
+	if err != nil {
+		s.renderError(w, r, statusForError(err))
+		return
+	}
+	if !info.Mode().IsRegular() || info.Size() > editMaxSize {
+		s.renderError(w, r, http.StatusUnprocessableEntity)
+		return
+	}
+	content, err := os.ReadFile(fsPath)
+	if err != nil {
+		s.renderError(w, r, statusForError(err))
+		return
+	}
+	if !utf8.Valid(content) || bytes.IndexByte(content, 0) >= 0 {
+		s.renderError(w, r, http.StatusUnsupportedMediaType)
+		return
+	}
+	lang := s.language(w, r)
+	page := editPage{
+		Lang:     lang,
+		Assets:   s.endpoint(assetsPrefix),
+		API:      s.endpoint(apiV1Prefix),
+		Title:    msg(lang, "editing", relPath),
+		Path:     relPath,
+		Parent:   path.Dir(relPath),
+		ETag:     fileETag(info),
+		Content:  string(content),
+		Save:     msg(lang, "save"),
+		Saved:    msg(lang, "saved"),
+		Conflict: msg(lang, "edit_conflict"),
+		Back:     msg(lang, "back"),
+	}
+	if page.Parent != "/" {
+		page.Parent += "/"
+	}
+	page.Parent = escapePath(page.Parent)
+	var buf bytes.Buffer
+	if err := editTemplate.Execute(&buf, page); err != nil {
+		s.renderError(w, r, http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Header().Set("Cache-Control", "no-store")
+	w.Header().Set("Content-Length", strconv.Itoa(buf.Len()))
+	if r.Method != http.MethodHead {
+		buf.WriteTo(w)
+	}
+}
+
+type fileWriteResponse struct {
+	Path     string    `json:"path"`
+	Size     int64     `json:"size"`
+	ETag     string    `json:"etag"`
+	Modified time.Time `json:"modified"`
+	Mirror   string    `json:"mirror,omitempty"` // "mirrored" or "failed" with a Mirror
+}
+
+// apiFilePut answers PUT /api/v1/file?path=/dir/file by replacing an
+// existing file of up to editMaxSize bytes, for authenticated users in
+// read-write mounts. If-Match must carry the file's current ETag, so a
+// save never silently overwrites someone else's change: a stale one gets
+// 412 Precondition Failed.
+func (s *Server) apiFilePut(w http.ResponseWriter, r *http.Request) {
+	p := r.URL.Query().Get("path")
+	if p == "" {
+		writeAPIError(w, http.StatusBadRequest, "missing path", map[string]string{"path": "required"})
+		return
+	}
+	relPath, fsPath, err := s.editable(r, p)
+	if err != nil {
+		writeOpError(w, err)
+		return
+	}
+	match := r.Header.Get("If-Match")
+	if match == "" {
+		writeAPIError(w, http.StatusPreconditionRequired, "If-Match with the file's ETag is required", nil)
+		return
+	}
+	body, err := io.ReadAll(http.MaxBytesReader(w, r.Body, editMaxSize))
+	if err != nil {
+		var tooLarge *http.MaxBytesError
+		if errors.As(err, &tooLarge) {
+			writeAPIError(w, http.StatusRequestEntityTooLarge, fmt.Sprintf("body exceeds %d bytes", tooLarge.Limit), nil)
+			return
+		}
+		writeAPIError(w, http.StatusBadRequest, "could not read the body", nil)
+		return
+	}
+
+	// The check and the rename happen under one lock, so of two saves
+	// from the same version only the first succeeds.
+	s.editMu.Lock()
+	defer s.editMu.Unlock()
+	info, err := os.Stat(fsPath)
+	if err != nil {
+		writeOpError(w, err)
+		return
+	}
+	if !info.Mode().IsRegular() {
+		writeAPIError(w, http.StatusUnprocessableEntity, relPath+" is not a regular file", nil)
+		return
+	}
+	if etag := fileETag(info); match != "*" && !strings.Contains(match, etag) {
+		w.Header().Set("ETag", etag)
+		writeAPIError(w, http.StatusPreconditionFailed, relPath+" was changed since it was read", nil)
+		return
+	}
+	if err := s.keepVersion(fsPath); err != nil {
+		s.log.Error("Keeping version failed", "path", relPath, "error", err)
+		writeOpError(w, err)
+		return
+	}
+	if err := replaceFile(fsPath, body, info.Mode().Perm()); err != nil {
+		s.log.Error("Saving edited file failed", "path", relPath, "error", err)
+		writeOpError(w, err)
+		return
+	}
+	if info, err = os.Stat(fsPath); err != nil {
+		writeOpError(w, err)
+		return
+	}
+	s.invalidate(fsPath)
+	s.log.Info("File edited", "path", relPath, "size", info.Size(), "remote_ip", RemoteIP(r))
+	s.uploaded(r, fsPath, info.Size())
+	mirror := s.mirrorUpload(r.Context(), fsPath)
+	w.Header().Set("ETag", fileETag(info))
+	writeJSON(w, http.StatusOK, fileWriteResponse{Path: relPath, Size: info.Size(), ETag: fileETag(info), Modified: info.ModTime(), Mirror: mirror})
+}
+
+// replaceFile writes data next to name and renames it into place, so
+// readers see either the old or the new content.
+func replaceFile(name string, data []byte, perm fs.FileMode) error {
+	f, err := os.CreateTemp(filepath.Dir(name), ".edit-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(f.Name())
+	if _, err := f.Write(data); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	if err := os.Chmod(f.Name(), perm); err != nil {
+		return err
+	}
+	return os.Rename(f.Name(), name)
+}
@@ -0,0 +1,166 @@
+package fileserver
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"html/template"
+	"io/fs"
+	"mime"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// errorPage is the data available to error page templates.
+type errorPage struct {
+	Status     int
+	StatusText string
+	Path       string
+	Parent     string
+	Assets     string // URL of the UI assets, ending in "/"
+	RequestID  string
+}
+
+var defaultErrorTemplate = template.Must(template.New("error").Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<meta name="viewport" content="width=device-width, initial-scale=1">
+<title>{{.Status}} {{.StatusText}}</title>
+<link rel="stylesheet" href="{{.Assets}}style.css">
+</head>
+<body>
+<h1>{{.Status}} {{.StatusText}}</h1>
+<p>The requested path <code>{{.Path}}</code> could not be served.</p>
+<p><a href="{{.Parent}}">Back to {{.Parent}}</a></p>
+{{if .RequestID}}<small>Request ID: {{.RequestID}}</small>{{end}}
+</body>
+</html>
+`))
+
+// loadErrorPages parses <status>.html files (e.g. 404.html) and an optional
+// error.html fallback from dir.
+func (s *Server) loadErrorPages(dir string) error {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return err
+	}
+	for _, e := range entries {
+		name := e.Name()
+		if e.IsDir() || filepath.Ext(name) != ".html" {
+			continue
+		}
+		base := strings.TrimSuffix(name, ".html")
+		code := 0
+		if base != "error" {
+			if code, err = strconv.Atoi(base); err != nil || code < 400 || code > 599 {
+				continue
+			}
+		}
+		t, err := template.ParseFiles(filepath.Join(dir, name))
+		if err != nil {
+			return fmt.Errorf("parse %s: %w", name, err)
+		}
+		s.errorTemplates[code] = t
+	}
+	return nil
+}
+
+// renderError writes an HTML error page for status, using a custom template
+// when one is configured and the built-in page otherwise. Clients that
+// prefer JSON get the API error envelope instead.
+func (s *Server) renderError(w http.ResponseWriter, r *http.Request, status int) {
+	s.errored(r, status)
+	w.Header().Add("Vary", "Accept")
+	if prefersJSON(r) {
+		w.Header().Set("Cache-Control", "no-store")
+		writeAPIError(w, status, http.StatusText(status), nil)
+		return
+	}
+	parent := path.Dir(strings.TrimSuffix(r.URL.Path, "/"))
+	if !strings.HasSuffix(parent, "/") {
+		parent += "/"
+	}
+	data := errorPage{
+		Status:     status,
+		StatusText: http.StatusText(status),
+		Path:       r.URL.Path,
+		Parent:     parent,
+		Assets:     s.endpoint(assetsPrefix),
+		RequestID:  w.Header().Get("X-Request-ID"),
+	}
+
+	t, ok := s.errorTemplates[status]
+	if !ok {
+		if t, ok = s.errorTemplates[0]; !ok {
+			t = defaultErrorTemplate
+		}
+	}
+	var buf bytes.Buffer
+	if err := t.Execute(&buf, data); err != nil {
+		s.log.Error("Failed to render error page", "status", status, "error", err)
+		http.Error(w, http.StatusText(status), status)
+		return
+	}
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(status)
+	w.Write(buf.Bytes())
+}
+
+// httpError is http.Error for responses also given to API clients: those
+// that prefer JSON get msg in the API error envelope instead of plain text.
+func httpError(w http.ResponseWriter, r *http.Request, msg string, status int) {
+	w.Header().Add("Vary", "Accept")
+	if prefersJSON(r) {
+		writeAPIError(w, status, msg, nil)
+		return
+	}
+	http.Error(w, msg, status)
+}
+
+// prefersJSON reports whether the Accept header of r ranks JSON, either
+// application/json or a +json type, above HTML. Wildcards count for HTML
+// only, so browsers and clients sending */* keep getting pages.
+func prefersJSON(r *http.Request) bool {
+	accept := r.Header.Get("Accept")
+	if accept == "" {
+		return false
+	}
+	var jsonQ, htmlQ float64
+	for _, part := range strings.Split(accept, ",") {
+		mediaType, params, err := mime.ParseMediaType(strings.TrimSpace(part))
+		if err != nil {
+			continue
+		}
+		q := 1.0
+		if v, ok := params["q"]; ok {
+			if q, err = strconv.ParseFloat(v, 64); err != nil {
+				continue
+			}
+		}
+		switch {
+		case mediaType == "application/json" || strings.HasSuffix(mediaType, "+json"):
+			jsonQ = max(jsonQ, q)
+		case mediaType == "text/html" || mediaType == "text/*" || mediaType == "*/*":
+			htmlQ = max(htmlQ, q)
+		}
+	}
+	return jsonQ > 0 && jsonQ > htmlQ
+}
+
+// statusForError maps a filesystem error to an HTTP status code.
+func statusForError(err error) int {
+	switch {
+	case errors.Is(err, fs.ErrNotExist):
+		return http.StatusNotFound
+	case errors.Is(err, fs.ErrPermission):
+		return http.StatusForbidden
+	default:
+		return http.StatusInternalServerError
+	}
+}
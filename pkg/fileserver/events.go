@@ -0,0 +1,152 @@
+package fileserver
+
+import (
+	"net/http"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// Event is a server event streamed to /ws clients as a JSON message.
+type Event struct {
+	Type string    `json:"type"` // "upload", "delete", "move", "copy", "config.reload", ...
+	Path string    `json:"path,omitempty"`
+	From string    `json:"from,omitempty"` // source of a move or copy
+	Size int64     `json:"size,omitempty"`
+	Time time.Time `json:"time"`
+}
+
+const (
+	eventBuffer    = 64
+	wsWriteTimeout = 10 * time.Second
+	wsPingInterval = 30 * time.Second
+)
+
+type eventHub struct {
+	mu   sync.Mutex
+	subs map[chan Event]struct{}
+}
+
+// Publish sends ev to every subscriber. Subscribers that are not keeping up
+// miss the event rather than holding up the publisher.
+func (s *Server) Publish(ev Event) {
+	if ev.Time.IsZero() {
+		ev.Time = time.Now()
+	}
+	s.events.mu.Lock()
+	defer s.events.mu.Unlock()
+	for ch := range s.events.subs {
+		select {
+		case ch <- ev:
+		default:
+		}
+	}
+}
+
+// Subscribe returns a channel receiving published events and a function
+// that unsubscribes and closes it.
+func (s *Server) Subscribe() (<-chan Event, func()) {
+	ch := make(chan Event, eventBuffer)
+	s.events.mu.Lock()
+	if s.events.subs == nil {
+		s.events.subs = make(map[chan Event]struct{})
+	}
+	s.events.subs[ch] = struct{}{}
+	s.events.mu.Unlock()
+	var once sync.Once
+	return ch, func() {
+		once.Do(func() {
+			s.events.mu.Lock()
+			delete(s.events.subs, ch)
+			s.events.mu.Unlock()
+			close(ch)
+		})
+	}
+}
+
+// urlPath maps a filesystem path back to the URL path serving it.
+func (s *Server) urlPath(fsPath string) string {
+	for _, m := range s.mounts {
+		if !within(m.Dir, fsPath) {
+			continue
+		}
+		rel, _ := filepath.Rel(m.Dir, fsPath)
+		if rel == "." {
+			return m.Prefix
+		}
+		return strings.TrimSuffix(m.Prefix, "/") + "/" + filepath.ToSlash(rel)
+	}
+	return ""
+}
+
+func (s *Server) deleted(r *http.Request, path string) {
+	if s.recent != nil {
+		s.recent.remove(s.urlPath(path))
+	}
+	if s.dirSizes != nil {
+		s.dirSizes.invalidate(s.urlPath(path))
+	}
+	s.Publish(Event{Type: "delete", Path: s.urlPath(path)})
+}
+
+var upgrader = websocket.Upgrader{}
+
+// eventsHandler upgrades GET /ws to a WebSocket streaming events.
+func (s *Server) eventsHandler(w http.ResponseWriter, r *http.Request) {
+	if !s.allowMethods(w, r, http.MethodGet) {
+		return
+	}
+	conn, err := upgrader.Upgrade(w, r, nil)
+	if err != nil {
+		return // Upgrade has already replied
+	}
+	defer conn.Close()
+	// The http.Server read and write timeouts do not apply to a
+	// long-lived stream; liveness is checked with pings instead.
+	conn.NetConn().SetDeadline(time.Time{})
+
+	events, unsubscribe := s.Subscribe()
+	defer unsubscribe()
+	s.log.Debug("WebSocket client connected", "remote", r.RemoteAddr)
+
+	// Read (and discard) client messages so control frames are handled
+	// and a closed connection is noticed.
+	done := make(chan struct{})
+	conn.SetReadDeadline(time.Now().Add(2 * wsPingInterval))
+	conn.SetPongHandler(func(string) error {
+		return conn.SetReadDeadline(time.Now().Add(2 * wsPingInterval))
+	})
+	go func() {
+		defer close(done)
+		for {
+			if _, _, err := conn.NextReader(); err != nil {
+				return
+			}
+		}
+	}()
+
+	ping := time.NewTicker(wsPingInterval)
+	defer ping.Stop()
+	for {
+		select {
+		case ev := <-events:
+			conn.SetWriteDeadline(time.Now().Add(wsWriteTimeout))
+			if err := conn.WriteJSON(ev); err != nil {
+				return
+			}
+		case <-ping.C:
+			if err := conn.WriteControl(websocket.PingMessage, nil, time.Now().Add(wsWriteTimeout)); err != nil {
+				return
+			}
+		case <-done:
+			return
+		case <-s.stop:
+			conn.WriteControl(websocket.CloseMessage,
+				websocket.FormatCloseMessage(websocket.CloseGoingAway, ""), time.Now().Add(wsWriteTimeout))
+			return
+		}
+	}
+}
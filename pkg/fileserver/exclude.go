@@ -0,0 +1,72 @@
+package fileserver
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// compileExcludes turns Options.Exclude globs into regexes matched against
+// URL paths without their leading slash; see compilePathGlob.
+func compileExcludes(globs []string) ([]*regexp.Regexp, error) {
+	out := make([]*regexp.Regexp, 0, len(globs))
+	for _, g := range globs {
+		re, err := compilePathGlob(g)
+		if err != nil {
+			return nil, fmt.Errorf("exclude pattern %q: %w", g, err)
+		}
+		out = append(out, re)
+	}
+	return out, nil
+}
+
+// compilePathGlob turns a glob into a regex matched against URL paths
+// without their leading slash. A glob without a slash matches the last
+// element at any depth, so "*.key" covers "a/b/c.key"; one with a slash
+// matches from the root, and a trailing "/**" also covers the directory
+// itself.
+func compilePathGlob(g string) (*regexp.Regexp, error) {
+	g = strings.TrimPrefix(g, "/")
+	if g == "" {
+		return nil, errors.New("empty pattern")
+	}
+	var expr string
+	switch {
+	case !strings.Contains(g, "/"):
+		expr = "(^|/)" + strings.TrimPrefix(globToRegex(g), "^")
+	case strings.HasSuffix(g, "/**"):
+		expr = strings.TrimSuffix(globToRegex(strings.TrimSuffix(g, "/**")), "$") + "(/.*)?$"
+	default:
+		expr = globToRegex(g)
+	}
+	return regexp.Compile(expr)
+}
+
+// pathGlobMatches reports whether urlPath, or a directory it is in,
+// matches re from compilePathGlob.
+func pathGlobMatches(re *regexp.Regexp, urlPath string) bool {
+	p := strings.Trim(urlPath, "/")
+	for p != "" {
+		if re.MatchString(p) {
+			return true
+		}
+		i := strings.LastIndex(p, "/")
+		if i < 0 {
+			break
+		}
+		p = p[:i]
+	}
+	return false
+}
+
+// excluded reports whether urlPath, or a directory it is in, matches an
+// exclude pattern.
+func (s *Server) excluded(urlPath string) bool {
+	for _, re := range s.excludes {
+		if pathGlobMatches(re, urlPath) {
+			return true
+		}
+	}
+	return false
+}
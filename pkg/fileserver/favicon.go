@@ -0,0 +1,23 @@
+package fileserver
+
+import (
+	"bytes"
+	"net/http"
+	"time"
+)
+
+// faviconPath is where browsers ask for an icon on every first visit.
+const faviconPath = "/favicon.ico"
+
+// serveFavicon answers a request for /favicon.ico, when no such file is
+// under the root, with the embedded one rather than a logged 404.
+func (s *Server) serveFavicon(w http.ResponseWriter, r *http.Request) {
+	data, err := embeddedAssets.ReadFile("assets/favicon.ico")
+	if err != nil {
+		s.renderError(w, r, http.StatusNotFound)
+		return
+	}
+	w.Header().Set("Content-Type", "image/x-icon")
+	w.Header().Set("Cache-Control", "public, max-age=86400")
+	http.ServeContent(w, r, "favicon.ico", time.Time{}, bytes.NewReader(data))
+}
@@ -0,0 +1,5 @@
+// Package filepb holds the protobuf messages and gRPC stubs for the file
+// service served by fileserver.Server.GRPCServer.
+package filepb
+
+//go:generate protoc -I ../.. --go_out=../.. --go_opt=paths=source_relative --go-grpc_out=../.. --go-grpc_opt=paths=source_relative fileserver/filepb/file.proto
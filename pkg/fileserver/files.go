@@ -0,0 +1,465 @@
+package fileserver
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"io/fs"
+	"net/http"
+	"os"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+)
+
+type cacheEntry struct {
+	info       os.FileInfo
+	modTime    time.Time
+	lastAccess time.Time
+}
+
+func (s *Server) getFromCache(path string) (os.FileInfo, bool) {
+	s.cacheMu.Lock()
+	defer s.cacheMu.Unlock()
+	if entry, ok := s.cache[path]; ok {
+		if time.Since(entry.lastAccess) < s.settings.Load().CacheTTL {
+			entry.lastAccess = time.Now()
+			s.cache[path] = entry
+			return entry.info, true
+		}
+		delete(s.cache, path)
+	}
+	return nil, false
+}
+
+func (s *Server) putInCache(path string, info os.FileInfo) {
+	s.cacheMu.Lock()
+	defer s.cacheMu.Unlock()
+	s.cache[path] = cacheEntry{info: info, modTime: info.ModTime(), lastAccess: time.Now()}
+}
+
+// invalidate drops the cached stat result for a path that has changed.
+func (s *Server) invalidate(path string) {
+	s.cacheMu.Lock()
+	defer s.cacheMu.Unlock()
+	delete(s.cache, path)
+}
+
+// FlushCache drops every cached stat result and reports how many there were.
+func (s *Server) FlushCache() int {
+	s.cacheMu.Lock()
+	defer s.cacheMu.Unlock()
+	n := len(s.cache)
+	clear(s.cache)
+	return n
+}
+
+func (s *Server) cleanCache() {
+	ttl := s.settings.Load().CacheTTL
+	ticker := time.NewTicker(ttl)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			// Pick up TTL changes from SetSettings.
+			if t := s.settings.Load().CacheTTL; t != ttl {
+				ttl = t
+				ticker.Reset(ttl)
+			}
+			s.cacheMu.Lock()
+			for path, entry := range s.cache {
+				if time.Since(entry.lastAccess) > ttl {
+					delete(s.cache, path)
+				}
+			}
+			s.cacheMu.Unlock()
+		case <-s.stop:
+			return
+		}
+	}
+}
+
+func (s *Server) fileHandler(w http.ResponseWriter, r *http.Request) {
+	if s.legacyRedirect(w, r, path.Clean("/"+r.URL.Path)) {
+		return
+	}
+	if s.gitHTTP && s.serveGit(w, r) {
+		return
+	}
+	if !s.allowMethods(w, r, http.MethodGet, http.MethodHead) {
+		return
+	}
+	relPath := path.Clean("/" + r.URL.Path)
+	m, fsPath, ok := s.resolve(relPath)
+	if !ok || (m.Hidden == HiddenDeny && m.hiddenPath(relPath)) || s.noIndexDenied(m, fsPath) {
+		s.renderError(w, r, http.StatusNotFound)
+		return
+	}
+
+	// Browsers ask for the favicon on every first visit; it is left out
+	// of the metadata cache so those requests do not crowd it.
+	favicon := relPath == faviconPath
+	var info fs.FileInfo
+	var hit bool
+	if !favicon {
+		_, span := tracer.Start(r.Context(), "cache.lookup")
+		info, hit = s.getFromCache(fsPath)
+		span.SetAttributes(attribute.Bool("cache.hit", hit))
+		span.End()
+	}
+	if !hit {
+		_, span := tracer.Start(r.Context(), "fs.stat")
+		var err error
+		info, err = m.stat(fsPath)
+		if err != nil {
+			span.RecordError(err)
+			span.End()
+			if favicon && errors.Is(err, fs.ErrNotExist) {
+				s.serveFavicon(w, r)
+				return
+			}
+			s.renderError(w, r, statusForError(err))
+			return
+		}
+		span.End()
+		if !favicon {
+			s.putInCache(fsPath, info)
+		}
+	}
+
+	// Relative links resolve against a directory only when its URL ends
+	// in a slash, so directories are served with one and files without.
+	// The target is built from the cleaned path, so "//host" cannot turn
+	// into a protocol-relative redirect.
+	slash := strings.HasSuffix(r.URL.Path, "/")
+	switch {
+	case info.IsDir() && !slash:
+		target := escapePath(relPath) + "/"
+		if r.URL.RawQuery != "" {
+			target += "?" + r.URL.RawQuery
+		}
+		http.Redirect(w, r, target, http.StatusMovedPermanently)
+		return
+	case !info.IsDir() && slash:
+		s.renderError(w, r, http.StatusNotFound)
+		return
+	}
+	if info.IsDir() {
+		s.dirList(w, r, m, fsPath, relPath)
+		return
+	}
+	if v := r.URL.Query().Get("edit"); v == "1" || v == "true" {
+		s.editHandler(w, r, relPath)
+		return
+	}
+
+	ctx, span := tracer.Start(r.Context(), "fs.serve_file",
+		trace.WithAttributes(attribute.Int64("file.size", info.Size())))
+	defer span.End()
+	f, _, err := m.openContent(fsPath)
+	if err != nil {
+		span.RecordError(err)
+		s.renderError(w, r, statusForError(err))
+		return
+	}
+	defer f.Close()
+	typ, body, err := s.textContent(info.Name(), s.mimeType(info.Name()), f, info.Size())
+	if err != nil {
+		span.RecordError(err)
+		s.renderError(w, r, statusForError(err))
+		return
+	}
+	s.setDisposition(w, r, info.Name())
+	w.Header().Set("Content-Type", typ)
+	http.ServeContent(w, r.WithContext(ctx), info.Name(), info.ModTime(), newContextSeeker(r.Context(), body))
+	if s.canceled(r, r.Context().Err()) {
+		return
+	}
+	s.fileServed(r, fsPath, info)
+}
+
+// listEntry is one row of a directory listing.
+type listEntry struct {
+	name    string
+	isDir   bool
+	size    int64
+	modTime time.Time
+	mode    fs.FileMode
+	// sizePending marks a directory whose total size is still being
+	// computed.
+	sizePending bool
+	owner       string // owner and group are only set with ShowPerms
+	group       string
+}
+
+// readListing reads the entries shown for a directory: its files, minus
+// excluded ones and hidden ones unless the mount shows them, plus any mounts nested directly
+// below it. It stops with ctx's error once ctx is done, and after
+// MaxListEntries entries, reporting that the listing is truncated. A
+// directory with a .noindex file gets errNoIndex.
+func (s *Server) readListing(ctx context.Context, m *Mount, fsPath, relPath string) ([]listEntry, bool, error) {
+	if s.noIndexed(m, m.name(fsPath)) {
+		return nil, false, errNoIndex
+	}
+	files, truncated, err := m.readDir(ctx, fsPath, s.maxEntries)
+	if err != nil {
+		return nil, false, err
+	}
+	entries := make([]listEntry, 0, len(files))
+	seen := make(map[string]bool)
+	for i, f := range files {
+		if i%readDirBatch == 0 {
+			if err := ctx.Err(); err != nil {
+				return nil, false, err
+			}
+		}
+		name := f.Name()
+		if (m.Hidden != HiddenShow && strings.HasPrefix(name, ".")) || m.internal(path.Join(relPath, name)) || s.excluded(path.Join(relPath, name)) {
+			continue
+		}
+		info, err := f.Info()
+		if err != nil {
+			continue
+		}
+		seen[name] = true
+		e := listEntry{name: name, isDir: f.IsDir(), size: info.Size(), modTime: info.ModTime(), mode: info.Mode()}
+		if s.showPerms {
+			e.owner, e.group = fileOwner(info)
+		}
+		entries = append(entries, e)
+	}
+	for _, name := range s.childMounts(relPath) {
+		if seen[name] || s.excluded(path.Join(relPath, name)) {
+			continue
+		}
+		e := listEntry{name: name, isDir: true}
+		if cm, dir, ok := s.resolve(path.Join(relPath, name)); ok {
+			if info, err := cm.stat(dir); err == nil {
+				e.modTime, e.mode = info.ModTime(), info.Mode()
+				if s.showPerms {
+					e.owner, e.group = fileOwner(info)
+				}
+			}
+		}
+		entries = append(entries, e)
+	}
+	sort.Slice(entries, func(i, j int) bool {
+		if entries[i].isDir != entries[j].isDir {
+			return entries[i].isDir
+		}
+		return entries[i].name < entries[j].name
+	})
+	return entries, truncated, nil
+}
+
+// dirList renders a directory in the format named by ?format=, HTML by
+// default.
+func (s *Server) dirList(w http.ResponseWriter, r *http.Request, m *Mount, fsPath, relPath string) {
+	l, format, ok := parseListingQuery(w, r, "name", false)
+	if !ok {
+		return
+	}
+	_, span := tracer.Start(r.Context(), "fs.readdir")
+	files, truncated, err := s.readListing(r.Context(), m, fsPath, relPath)
+	span.SetAttributes(attribute.Int("fs.entries", len(files)))
+	span.End()
+	if s.canceled(r, err) {
+		return
+	}
+	if errors.Is(err, errNoIndex) {
+		s.renderError(w, r, s.noIndexStatus())
+		return
+	}
+	if err != nil {
+		s.renderError(w, r, http.StatusForbidden)
+		return
+	}
+
+	dir := relPath
+	if dir != "/" {
+		dir += "/"
+	}
+	l.Path, l.Entries, l.Upload = dir, files, s.chunkedUploads && m.ReadWrite
+	if truncated {
+		l.Truncated = s.maxEntries
+	}
+	if info, err := m.stat(fsPath); err == nil {
+		l.Modified = info.ModTime()
+	}
+	s.prepareListing(w, r, l, format)
+	if format == "html" {
+		l.Header = annotation(m, fsPath, files, "HEADER.html")
+		l.Footer = annotation(m, fsPath, files, "FOOTER.html")
+	}
+	if format == "html" && l.Page == 1 {
+		l.Readme = s.readme(m, fsPath, files)
+	}
+	s.writeListing(w, r, l, format)
+}
+
+// parseListingQuery reads the format, sort, order and view parameters of
+// a listing request, answering 400 for unknown values. sort and desc are
+// the order without ?sort=.
+func parseListingQuery(w http.ResponseWriter, r *http.Request, sort string, desc bool) (l *listing, format string, ok bool) {
+	q := r.URL.Query()
+	format = q.Get("format")
+	if format == "" {
+		format = "html"
+	}
+	if _, ok := listingFormats[format]; !ok {
+		httpError(w, r, "Unknown listing format "+strconv.Quote(format), http.StatusBadRequest)
+		return nil, "", false
+	}
+	l = &listing{Sort: q.Get("sort"), Desc: q.Get("order") == "desc"}
+	if l.Sort == "" {
+		l.Sort, l.Desc = sort, desc
+	}
+	if _, ok := listingSorts[l.Sort]; !ok {
+		httpError(w, r, "Unknown sort field "+strconv.Quote(l.Sort), http.StatusBadRequest)
+		return nil, "", false
+	}
+	if order := q.Get("order"); order != "" && order != "asc" && order != "desc" {
+		httpError(w, r, "Unknown sort order "+strconv.Quote(order), http.StatusBadRequest)
+		return nil, "", false
+	}
+	switch view := q.Get("view"); view {
+	case "", "list":
+	case "gallery":
+		l.Gallery = true
+	default:
+		httpError(w, r, "Unknown view "+strconv.Quote(view), http.StatusBadRequest)
+		return nil, "", false
+	}
+	return l, format, true
+}
+
+// prepareListing fills in the server-wide settings of a listing, sorts it
+// and, for HTML, picks the page and language.
+func (s *Server) prepareListing(w http.ResponseWriter, r *http.Request, l *listing, format string) {
+	l.ExactSizes, l.ShortLinks, l.ShowPerms = s.exactSizes, s.shortLinks != nil, s.showPerms
+	l.Origin, l.HasRecent, l.Brand = s.origin(r), s.recent != nil, s.branding
+	l.HasTrash, l.Reserved = s.trashRetention > 0, s.reserved
+	l.sort()
+	if format == "html" {
+		page, _ := strconv.Atoi(r.URL.Query().Get("page"))
+		l.paginate(page, s.pageSize)
+		l.Lang = s.language(w, r)
+		if s.dirSizes != nil {
+			l.DirSizes = true
+			for i, e := range l.Entries {
+				if e.isDir {
+					d, ok := s.dirSizes.get(l.href(e))
+					l.Entries[i].size, l.Entries[i].sizePending = d.size, !ok
+				}
+			}
+		}
+	}
+}
+
+// listingBufferMax is the most entries writeListing renders to a buffer;
+// larger listings are streamed.
+const listingBufferMax = 10000
+
+// writeListing renders a listing to a buffer to send an exact
+// Content-Length, which HEAD requests get without the body, and to answer
+// If-None-Match and If-Modified-Since with 304 when nothing changed.
+func (s *Server) writeListing(w http.ResponseWriter, r *http.Request, l *listing, format string) {
+	renderer := listingFormats[format]
+	if len(l.Entries) > listingBufferMax {
+		// Huge listings go out as they render rather than being held in
+		// memory first, without an ETag or Content-Length.
+		w.Header().Set("Content-Type", renderer.contentType)
+		w.Header().Set("Cache-Control", "no-cache")
+		if r.Method != http.MethodHead {
+			renderer.render(w, r, l)
+		}
+		return
+	}
+	var buf bytes.Buffer
+	renderer.render(&buf, r, l)
+	// The ETag hashes the output, so it covers the language, format and
+	// page as well as the entries. Last-Modified also counts the
+	// directory, whose time changes as entries are added or removed.
+	modified := l.Modified
+	for _, e := range l.Entries {
+		if e.modTime.After(modified) {
+			modified = e.modTime
+		}
+	}
+	sum := sha256.Sum256(buf.Bytes())
+	w.Header().Set("Content-Type", renderer.contentType)
+	w.Header().Set("ETag", `W/"`+hex.EncodeToString(sum[:16])+`"`)
+	w.Header().Set("Cache-Control", "no-cache")
+	http.ServeContent(w, r, "", modified, bytes.NewReader(buf.Bytes()))
+}
+
+// deprecated marks a legacy endpoint superseded by an /api/v1/ one.
+func deprecated(w http.ResponseWriter, successor string) {
+	w.Header().Set("Deprecation", "true")
+	w.Header().Set("Link", "<"+successor+`>; rel="successor-version"`)
+}
+
+// anyObject is the body of /api: any JSON object, so there are no unknown
+// fields to reject.
+type anyObject map[string]interface{}
+
+func (anyObject) validate() map[string]string { return nil }
+
+// postRequest is the body of /post.
+type postRequest struct {
+	Data string `json:"data"`
+}
+
+func (p *postRequest) validate() map[string]string {
+	if p.Data == "" {
+		return map[string]string{"data": "required"}
+	}
+	return nil
+}
+
+// apiHandler echoes an arbitrary JSON object back with a timestamp. It is
+// kept for existing clients; new ones should use /api/v1/echo.
+func (s *Server) apiHandler(w http.ResponseWriter, r *http.Request) {
+	deprecated(w, s.endpoint(apiV1Prefix+"echo"))
+	if !allowAPIMethods(w, r, http.MethodPost) {
+		return
+	}
+	var payload anyObject
+	if !s.decodeAPIRequest(w, r, &payload) {
+		return
+	}
+	resp := map[string]interface{}{
+		"received": payload,
+		"time":     time.Now(),
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(resp)
+}
+
+// postHandler accepts {"data": "..."} and answers
+// {"status": "success", "received": {...}}, as the original servers did,
+// but to POST only. Retries with an Idempotency-Key replay the first
+// answer; see idempotent.
+// Like apiHandler it is superseded by /api/v1/echo.
+func (s *Server) postHandler(w http.ResponseWriter, r *http.Request) {
+	deprecated(w, s.endpoint(apiV1Prefix+"echo"))
+	if !allowAPIMethods(w, r, http.MethodPost) {
+		return
+	}
+	var requestData postRequest
+	if !s.decodeAPIRequest(w, r, &requestData) {
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"status":   "success",
+		"received": requestData,
+	})
+}
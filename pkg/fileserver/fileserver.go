@@ -0,0 +1,765 @@
+// Package fileserver serves a directory tree over HTTP: files, HTML
+// directory listings, custom error pages, Basic auth, request and access
+// logging, tracing, an optional admin API and a gRPC file service. The
+// go-server command is a thin wrapper around it; other programs can embed
+// a Server as an http.Handler.
+package fileserver
+
+import (
+	"fmt"
+	"html/template"
+	"io"
+	"io/fs"
+	"log/slog"
+	"net"
+	"net/http"
+	"net/netip"
+	"path"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/getsentry/sentry-go"
+	"github.com/jcmturner/gokrb5/v8/keytab"
+)
+
+// Settings are the values that may change while a Server is running; see
+// Server.SetSettings. A Settings value is never mutated once installed.
+type Settings struct {
+	CacheTTL  time.Duration
+	Headers   map[string]string // response headers; nil means DefaultHeaders
+	AuthRealm string
+	AuthUsers map[string]string // username -> hex SHA-256 of the password
+
+	LogSampleRate float64       // fraction (0..1) of successful requests to log
+	LogSlow       time.Duration // always log requests at least this slow
+
+	// RateLimit is the sustained requests per second allowed per client
+	// by the "ratelimit" middleware, with bursts of up to RateBurst (one
+	// second's worth by default). A zero RateLimit lets every request
+	// through; a positive one is refused without that middleware.
+	RateLimit float64
+	RateBurst int
+}
+
+// DefaultHeaders are set on every response unless Settings.Headers is given.
+var DefaultHeaders = map[string]string{
+	"X-Content-Type-Options":  "nosniff",
+	"X-Frame-Options":         "DENY",
+	"Content-Security-Policy": "default-src 'self'",
+}
+
+const (
+	defaultCacheTTL  = 10 * time.Second
+	defaultAuthRealm = "go-server"
+	defaultPageSize  = 1000
+	defaultMaxBody   = 1 << 20
+	defaultMaxList   = 100000
+	defaultReserved  = "/_"
+)
+
+// Options configure a Server.
+type Options struct {
+	Settings
+
+	// Root is the directory to serve. It defaults to the working directory.
+	Root string
+	// FS, when set, is served at "/" instead of the directory Root, which
+	// then only names it; see Mount.FS, OpenArchive and OpenRemote.
+	FS fs.FS
+	// Mounts expose further directories under URL prefixes. A mount at "/"
+	// replaces Root.
+	Mounts []Mount
+	// Proxies forward URL prefixes to upstream HTTP servers.
+	Proxies []ProxyRoute
+	// Rules rewrite or redirect request paths before routing.
+	Rules []Rule
+	// Routes answer fixed responses without files in the served tree.
+	Routes []StaticRoute
+	// ReservedPrefix is the URL path below which every endpoint that is
+	// not a file lives: the API, the UI assets, uploads, /drop/, short
+	// links and so on, so that they cannot shadow files or be shadowed by
+	// them. It defaults to "/_". The endpoints' former paths redirect
+	// there unless a file exists at them.
+	ReservedPrefix string
+
+	// Tus enables resumable tus uploads under /files/ into read-write
+	// mounts. Partial uploads are kept in UploadStaging, by default a
+	// directory under os.TempDir. TusMaxSize bounds the Upload-Length of
+	// tus uploads, advertised as Tus-Max-Size; it defaults to 10 GiB.
+	Tus           bool
+	TusMaxSize    int64
+	UploadStaging string
+	// ChunkedUploads enables the /api/v1/uploads chunked upload API,
+	// which shares the staging directory with Tus.
+	ChunkedUploads bool
+	// DropTTL enables the /drop/ zone: anonymous uploads kept in DropDir,
+	// by default a directory under os.TempDir, behind a link that works
+	// once and expires after DropTTL. DropMaxSize defaults to 100 MiB.
+	DropTTL     time.Duration
+	DropDir     string
+	DropMaxSize int64
+	// ShortLinks names a JSON file of short links, enabling
+	// POST /api/v1/shorten and the /s/ redirects. It is created when
+	// the first link is made. With a MetadataDB, the links are kept there
+	// instead, and those of this file copied into it at startup.
+	ShortLinks string
+	// MetadataDB names an SQLite database, created if missing, that keeps
+	// short links (enabling them without ShortLinks), an audit trail of
+	// uploads and per-file download counts across restarts. The admin API
+	// lists the latter two at /admin/uploads and /admin/downloads.
+	MetadataDB string
+	// Disposition maps file extensions such as ".pdf", or "*" for all
+	// others, to the Content-Disposition "inline" or "attachment". It
+	// defaults to DefaultDisposition. ?download=1 always forces attachment.
+	Disposition map[string]string
+	// MIMETypes maps file extensions such as ".wasm" to the Content-Type
+	// they are served with, over those read from MIMETypesFile, a file in
+	// the mime.types format, and over DefaultMIMETypes. Other extensions
+	// get the type registered with the operating system.
+	MIMETypes     map[string]string
+	MIMETypesFile string
+	// StripBOM leaves the UTF-8 byte order mark out of text files served
+	// over HTTP; their charset is declared in the Content-Type either way.
+	StripBOM bool
+	// Language fixes the language of HTML listings, one of Languages.
+	// By default it is negotiated with each request's Accept-Language.
+	Language string
+	// PageSize splits HTML listings of more entries into pages, with an
+	// index of initials when sorted by name. It defaults to 1000; a
+	// negative value disables paging.
+	PageSize int
+	// RecentInterval enables /recent, the newest RecentCount files (100
+	// by default) of all mounts, from an index rebuilt this often.
+	RecentInterval time.Duration
+	RecentCount    int
+	// SearchIndex is a directory for a full-text index of the tree, which
+	// lets /search look into the contents of text files as well as match
+	// names. The index is created if missing and rescanned every
+	// SearchInterval (10 minutes by default) besides following changes
+	// made through the server.
+	SearchIndex    string
+	SearchInterval time.Duration
+	// TrashRetention makes deletions move files into a .trash directory
+	// of their mount, from where /trash and /api/v1/trash restore or purge
+	// them, and purges them automatically after this long.
+	TrashRetention time.Duration
+	// Versions keeps this many previous versions of files overwritten
+	// through the server in a .versions directory of their mount, listed
+	// and restored through /api/v1/versions.
+	Versions int
+	// MaxBodySize bounds the request bodies of /post, /api and the JSON
+	// endpoints of /api/v1/; larger bodies get 413. It defaults to 1 MiB.
+	// Uploads through tus (TusMaxSize), chunks and /drop/ (DropMaxSize)
+	// have limits of their own.
+	MaxBodySize int64
+	// MaxListEntries caps how many entries of a directory are read for a
+	// listing, including those of the gRPC, S3 and SFTP servers; listings
+	// of larger directories say they are truncated. It defaults to
+	// 100000; a negative value disables the cap.
+	MaxListEntries int
+	// Exclude hides paths matching any of these globs from listings,
+	// archives and copies of the directories they are in and answers them
+	// with 404 as if they did not exist. A pattern without a
+	// slash, such as "*.key", matches names at any depth; one with a
+	// slash, such as "secrets/**", matches from the root. * and ? match
+	// within a path element and ** across elements.
+	Exclude []string
+	// Webhooks receive upload, delete and move events, or those they
+	// name, as JSON POSTs.
+	Webhooks []Webhook
+	// Mirror copies uploads into an S3-compatible bucket. Upload API
+	// responses report whether that succeeded; uploads through the gRPC,
+	// S3 and SFTP servers are mirrored in the background.
+	Mirror *S3Mirror
+	// Brokers receive every event, and with Requests set one per request
+	// served, on a NATS subject or MQTT topic.
+	Brokers []EventBroker
+	// StatsD, when set, pushes request counts, latencies and response
+	// sizes to a StatsD or DogStatsD agent.
+	StatsD *StatsD
+	// Sentry, when set, reports handler panics and 5xx responses with
+	// their request and stack trace.
+	Sentry *Sentry
+	// GeoIP adds the country and ASN of clients to the request log and
+	// refuses paths to clients by country; see GeoRule.
+	GeoIP *GeoIP
+	// GitHTTP serves bare Git repositories in directory mounts over the
+	// smart HTTP protocol, read-only, so that git clone works on their
+	// URLs. It needs git installed.
+	GitHTTP bool
+	// Htpasswd is an Apache htpasswd file whose users may log in besides
+	// Settings.AuthUsers, with bcrypt, MD5-APR1 or SHA-1 hashes. Changes
+	// to it take effect within seconds.
+	Htpasswd string
+	// Keytab is a Kerberos keytab holding the key of the server's HTTP
+	// service principal, such as HTTP/files.corp.example.com@CORP.EXAMPLE.COM.
+	// It enables Negotiate (SPNEGO) authentication, with which browsers on
+	// domain-joined machines log in as the Windows user without a prompt;
+	// Basic auth keeps working for users with passwords.
+	Keytab string
+	// KeytabPrincipal picks the keytab entry to use, such as
+	// "HTTP/files.corp.example.com". By default it is the one the ticket
+	// names.
+	KeytabPrincipal string
+	// NoRobots serves a robots.txt disallowing everything and marks every
+	// response X-Robots-Tag: noindex, for instances not meant to turn up
+	// in search engines.
+	NoRobots bool
+	// NoIndex sets how directories holding a .noindex file are treated:
+	// by default their listings, in every protocol, are refused while the
+	// files in them are still served when addressed by name.
+	NoIndex NoIndexPolicy
+	// DirSizes shows the total size of subdirectories in HTML listings,
+	// computed in the background and cached.
+	DirSizes bool
+	// ShowPerms adds Unix mode bits, owner and group to HTML and JSON
+	// listings.
+	ShowPerms bool
+	// ExactSizes shows byte counts in HTML listings instead of sizes
+	// such as "1.5 MiB".
+	ExactSizes bool
+	// Branding adds a site title, logo and footer to HTML listings.
+	Branding Branding
+	// ErrorPages is a directory of <status>.html and error.html templates
+	// replacing the built-in error page.
+	ErrorPages string
+	// TrustedProxies are the networks whose X-Forwarded-For, X-Real-IP
+	// and PROXY protocol headers are believed; see ParseTrustedProxies.
+	TrustedProxies []netip.Prefix
+	// AccessLog, when set, receives a combined-format line per request.
+	AccessLog *AccessLog
+	// SecurityLog, when set, receives authentication failures, path
+	// traversal attempts and rate limit hits, for fail2ban and similar
+	// tools, one per line:
+	//
+	//	<time> <event> client=<ip> service=<service> [user="<user>"] [path="<path>"]
+	//
+	// time is RFC 3339 in UTC with milliseconds. event is auth_failure,
+	// path_traversal or rate_limited, and service http, admin, grpc, sftp
+	// or s3. user and path, the URL path as sent, are Go quoted strings
+	// and left out when unknown. Fields may be added at the end of lines;
+	// those above keep their names, order and meaning.
+	SecurityLog io.Writer
+	// StatsInterval enables a periodic request latency summary log line.
+	StatsInterval time.Duration
+	// LogLevel, when set, can be read and changed through the admin API.
+	LogLevel *slog.LevelVar
+	// AdminConfig reports extra configuration from GET /admin/config.
+	AdminConfig func() map[string]string
+	// Logger defaults to slog.Default().
+	Logger *slog.Logger
+
+	// Middleware names the request middleware, outermost first; see
+	// DefaultMiddleware and RegisterMiddleware.
+	Middleware []string
+
+	// Plugins are added after those registered with RegisterPlugin.
+	Plugins []Plugin
+}
+
+// Server is an http.Handler serving Options.Root. Create it with New and
+// release its background goroutines with Close.
+type Server struct {
+	root            string
+	log             *slog.Logger
+	settings        atomic.Pointer[Settings]
+	errorTemplates  map[int]*template.Template // key 0 is error.html
+	trusted         []netip.Prefix
+	accessLog       *AccessLog
+	securityLog     io.Writer
+	logLevel        *slog.LevelVar
+	configReport    func() map[string]string
+	plugins         []Plugin
+	events          eventHub
+	staging         *staging // nil unless an upload API is enabled
+	tusMaxSize      int64
+	chunkedUploads  bool
+	drop            *dropZone      // nil unless DropTTL is set
+	shortLinks      shortLinkStore // nil unless ShortLinks or MetadataDB is set
+	meta            *metaStore     // nil unless MetadataDB is set
+	exactSizes      bool
+	lang            string
+	pageSize        int
+	showPerms       bool
+	thumbs          *thumbCache
+	recent          *recentIndex // nil unless RecentInterval is set
+	search          *searchIndex // nil unless SearchIndex is set
+	branding        Branding
+	brandLogo       string    // local logo file, if any
+	dirSizes        *dirSizer // nil unless DirSizes is set
+	trashRetention  time.Duration
+	versions        int
+	maxBody         int64
+	maxEntries      int
+	noIndex         NoIndexPolicy
+	noRobots        bool
+	gitHTTP         bool
+	excludes        []*regexp.Regexp
+	htpasswd        *htpasswdFile  // nil unless Htpasswd is set
+	keytab          *keytab.Keytab // nil unless Keytab is set
+	keytabPrincipal string
+	idempotency     *idempotencyCache
+	syncHashes      *syncHashCache
+	brokers         []*brokerSink
+	statsd          *statsdSink       // nil unless StatsD is set
+	sentry          *sentry.Hub       // nil unless Sentry is set
+	geo             *geoIP            // nil unless GeoIP is set
+	rateLimited     bool              // the ratelimit middleware is in the chain
+	mirror          *s3Mirror         // nil unless Mirror is set
+	disposition     map[string]string // by lower-case extension
+	mimeTypes       map[string]string // by lower-case extension
+	stripBOM        bool
+	mounts          []*Mount // longest prefix first
+	rules           []rule
+
+	editMu sync.Mutex // serializes editor saves
+
+	cacheMu sync.Mutex
+	cache   map[string]cacheEntry
+
+	stats    *latencyStats
+	conns    connTracker
+	requests requestTracker
+	started  time.Time
+
+	mux       *http.ServeMux
+	endpoints *http.ServeMux // below reserved, with it stripped
+	reserved  string
+	handler   http.Handler
+	stop      chan struct{}
+	closed    sync.Once
+}
+
+// New validates opts and returns a Server ready to handle requests.
+func New(opts Options) (*Server, error) {
+	s := &Server{
+		root:            opts.Root,
+		log:             opts.Logger,
+		errorTemplates:  make(map[int]*template.Template),
+		trusted:         opts.TrustedProxies,
+		accessLog:       opts.AccessLog,
+		securityLog:     opts.SecurityLog,
+		logLevel:        opts.LogLevel,
+		configReport:    opts.AdminConfig,
+		cache:           make(map[string]cacheEntry),
+		stats:           newLatencyStats(),
+		started:         time.Now(),
+		mux:             http.NewServeMux(),
+		endpoints:       http.NewServeMux(),
+		reserved:        path.Clean("/" + opts.ReservedPrefix),
+		plugins:         append(registeredPlugins(), opts.Plugins...),
+		exactSizes:      opts.ExactSizes,
+		lang:            opts.Language,
+		pageSize:        opts.PageSize,
+		showPerms:       opts.ShowPerms,
+		trashRetention:  opts.TrashRetention,
+		versions:        opts.Versions,
+		maxBody:         opts.MaxBodySize,
+		maxEntries:      opts.MaxListEntries,
+		noIndex:         opts.NoIndex,
+		noRobots:        opts.NoRobots,
+		gitHTTP:         opts.GitHTTP,
+		keytabPrincipal: opts.KeytabPrincipal,
+		stripBOM:        opts.StripBOM,
+		thumbs:          newThumbCache(),
+		idempotency:     newIdempotencyCache(),
+		syncHashes:      newSyncHashCache(),
+		stop:            make(chan struct{}),
+	}
+	if s.pageSize == 0 {
+		s.pageSize = defaultPageSize
+	}
+	if opts.ReservedPrefix == "" {
+		s.reserved = defaultReserved
+	}
+	if s.reserved == "/" {
+		return nil, fmt.Errorf("reserved prefix %q would hide every file", opts.ReservedPrefix)
+	}
+	if s.maxBody <= 0 {
+		s.maxBody = defaultMaxBody
+	}
+	if s.maxEntries == 0 {
+		s.maxEntries = defaultMaxList
+	}
+	if s.root == "" && opts.FS == nil {
+		s.root = "."
+	}
+	var err error
+	if s.root != "" {
+		if s.root, err = filepath.Abs(s.root); err != nil {
+			return nil, fmt.Errorf("resolve root: %w", err)
+		}
+	}
+	if s.mounts, err = newMounts(s.root, opts.FS, opts.Mounts); err != nil {
+		return nil, err
+	}
+	s.root = s.mounts[len(s.mounts)-1].Dir // the "/" mount sorts last
+	for _, m := range s.mounts {
+		if s.isReserved(m.Prefix) {
+			return nil, fmt.Errorf("mount prefix %q is reserved", m.Prefix)
+		}
+		m.trash = s.trashRetention > 0 && m.ReadWrite
+		m.versions = s.versions > 0 && m.ReadWrite
+	}
+	if s.rules, err = compileRules(opts.Rules); err != nil {
+		return nil, err
+	}
+	switch s.noIndex {
+	case "":
+		s.noIndex = NoIndexForbid
+	case NoIndexForbid, NoIndexHide, NoIndexDeny, NoIndexOff:
+	default:
+		return nil, fmt.Errorf("invalid noindex policy %q (want forbid, hide, deny or off)", s.noIndex)
+	}
+	if s.disposition, err = compileDisposition(opts.Disposition); err != nil {
+		return nil, err
+	}
+	if opts.Mirror != nil {
+		if s.mirror, err = newS3Mirror(*opts.Mirror); err != nil {
+			return nil, err
+		}
+	}
+	if s.excludes, err = compileExcludes(opts.Exclude); err != nil {
+		return nil, err
+	}
+	if opts.GeoIP != nil {
+		if s.geo, err = newGeoIP(opts.GeoIP); err != nil {
+			return nil, err
+		}
+	}
+	if s.mimeTypes, err = compileMIMETypes(opts.MIMETypes, opts.MIMETypesFile); err != nil {
+		return nil, err
+	}
+	if s.lang != "" && !validLanguage(s.lang) {
+		return nil, fmt.Errorf("unsupported language %q (want one of %s)", s.lang, strings.Join(Languages, ", "))
+	}
+	if s.log == nil {
+		s.log = slog.Default()
+	}
+	names := opts.Middleware
+	if len(names) == 0 {
+		names = DefaultMiddleware
+	}
+	s.rateLimited = slices.Contains(names, "ratelimit")
+	if err := s.SetSettings(opts.Settings); err != nil {
+		return nil, err
+	}
+	if s.branding, s.brandLogo, err = compileBranding(opts.Branding, s.reserved); err != nil {
+		return nil, err
+	}
+	if opts.ErrorPages != "" {
+		if err := s.loadErrorPages(opts.ErrorPages); err != nil {
+			return nil, fmt.Errorf("load error pages from %s: %w", opts.ErrorPages, err)
+		}
+	}
+
+	if opts.Tus || opts.ChunkedUploads {
+		if s.staging, err = newStaging(opts.UploadStaging); err != nil {
+			return nil, err
+		}
+		s.chunkedUploads = opts.ChunkedUploads
+		s.tusMaxSize = opts.TusMaxSize
+		if s.tusMaxSize <= 0 {
+			s.tusMaxSize = defaultTusMaxSize
+		}
+	}
+	if opts.DropTTL > 0 {
+		if s.drop, err = newDropZone(opts.DropDir, opts.DropTTL, opts.DropMaxSize); err != nil {
+			return nil, err
+		}
+	}
+
+	if opts.MetadataDB != "" {
+		if s.meta, err = openMetaStore(opts.MetadataDB); err != nil {
+			return nil, err
+		}
+		s.shortLinks = metaShortLinks{s.meta.db}
+	}
+	if opts.ShortLinks != "" {
+		sl, err := loadShortLinks(opts.ShortLinks)
+		if err != nil {
+			return nil, err
+		}
+		if s.meta == nil {
+			s.shortLinks = sl
+		} else if n, err := s.meta.importShortLinks(sl); err != nil {
+			return nil, fmt.Errorf("importing short links: %w", err)
+		} else if n > 0 {
+			s.log.Info("Imported short links into the metadata store", "file", opts.ShortLinks, "links", n)
+		}
+	}
+
+	if opts.Htpasswd != "" {
+		var skipped []string
+		if s.htpasswd, skipped, err = loadHtpasswd(opts.Htpasswd); err != nil {
+			return nil, fmt.Errorf("load htpasswd file: %w", err)
+		}
+		s.logUnsupportedHashes(skipped)
+	}
+	if opts.Keytab != "" {
+		if s.keytab, err = loadKeytab(opts.Keytab); err != nil {
+			return nil, err
+		}
+	}
+
+	if opts.DirSizes {
+		s.dirSizes = newDirSizer()
+	}
+
+	s.mux.Handle(s.reserved+"/", http.StripPrefix(s.reserved, s.endpoints))
+	s.mux.HandleFunc("/", s.fileHandler)
+	s.endpoints.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
+		s.renderError(w, r, http.StatusNotFound)
+	})
+	s.endpoints.Handle(assetsPrefix, s.assetHandler())
+	s.endpoints.HandleFunc("/api", s.apiHandler)
+	s.endpoints.HandleFunc(apiV1Prefix, s.apiV1Handler())
+	s.endpoints.HandleFunc("/post", s.idempotent(s.postHandler))
+	s.endpoints.HandleFunc("/ws", s.eventsHandler)
+	s.endpoints.HandleFunc("/graphql", s.graphqlHandler(s.graphqlSchema()))
+	if opts.Tus {
+		s.endpoints.HandleFunc(tusPrefix, s.tusHandler)
+	}
+	if s.drop != nil {
+		s.endpoints.HandleFunc(dropPrefix, s.dropHandler)
+	}
+	if s.shortLinks != nil {
+		s.endpoints.HandleFunc(shortPrefix, s.shortHandler)
+	}
+	if s.trashRetention > 0 {
+		s.endpoints.HandleFunc(trashPath, s.trashHandler)
+	}
+	if opts.RecentInterval > 0 {
+		s.recent = newRecentIndex(opts.RecentInterval, opts.RecentCount)
+		s.endpoints.HandleFunc(recentPath, s.recentHandler)
+	}
+	s.endpoints.HandleFunc(searchPath, s.searchHandler)
+	if s.noRobots {
+		// Static routes are method-specific, so one for /robots.txt
+		// still takes precedence.
+		s.mux.HandleFunc(robotsPath, s.robotsHandler)
+	}
+	patterns := make(map[string]bool)
+	for _, pr := range opts.Proxies {
+		pattern, h, err := s.proxyHandler(pr)
+		if err == nil && patterns[pattern] {
+			err = fmt.Errorf("proxy prefix %q conflicts with another route", pattern)
+		}
+		if err != nil {
+			s.Close()
+			return nil, err
+		}
+		patterns[pattern] = true
+		s.mux.Handle(pattern, h)
+	}
+	for _, sr := range opts.Routes {
+		pattern, h, err := s.staticHandler(sr)
+		if err == nil && patterns[pattern] {
+			err = fmt.Errorf("static route %q conflicts with another route", sr.Path)
+		}
+		if err != nil {
+			s.Close()
+			return nil, err
+		}
+		patterns[pattern] = true
+		s.mux.Handle(pattern, h)
+	}
+	if s.geo != nil && len(s.geo.rules) > 0 && !slices.Contains(names, "geoip") {
+		s.Close()
+		return nil, fmt.Errorf("geo rules need the geoip middleware")
+	}
+	if s.handler, err = s.buildChain(names, s.rewrite(s.mux)); err != nil {
+		s.Close()
+		return nil, err
+	}
+
+	if err := s.startWebhooks(opts.Webhooks); err != nil {
+		s.Close()
+		return nil, err
+	}
+	if err := s.startBrokers(opts.Brokers); err != nil {
+		s.Close()
+		return nil, err
+	}
+	if err := s.startStatsD(opts.StatsD); err != nil {
+		s.Close()
+		return nil, err
+	}
+	if err := s.startSentry(opts.Sentry); err != nil {
+		s.Close()
+		return nil, err
+	}
+	if opts.SearchIndex != "" {
+		if s.search, err = openSearchIndex(opts.SearchIndex, opts.SearchInterval); err != nil {
+			s.Close()
+			return nil, err
+		}
+	}
+	go s.cleanCache()
+	if s.htpasswd != nil {
+		go s.watchHtpasswd()
+	}
+	if s.geo != nil {
+		go s.watchGeoIP()
+	}
+	if s.staging != nil {
+		go s.cleanStaging()
+	}
+	if s.drop != nil {
+		go s.cleanDrop()
+	}
+	if s.recent != nil {
+		go s.indexRecent()
+	}
+	if s.search != nil {
+		go s.runSearchIndex()
+	}
+	if s.meta != nil {
+		go s.flushMeta()
+	}
+	if s.trashRetention > 0 {
+		go s.cleanTrash()
+	}
+	if s.dirSizes != nil {
+		for range dirSizeWorkers {
+			go s.sizeDirs()
+		}
+	}
+	if opts.StatsInterval > 0 {
+		go s.logStats(opts.StatsInterval)
+	}
+	return s, nil
+}
+
+// SetSettings atomically replaces the reloadable settings. Zero values fall
+// back to the defaults.
+func (s *Server) SetSettings(st Settings) error {
+	if st.CacheTTL == 0 {
+		st.CacheTTL = defaultCacheTTL
+	}
+	if st.CacheTTL < 0 {
+		return fmt.Errorf("invalid cache TTL %v", st.CacheTTL)
+	}
+	if st.LogSampleRate < 0 || st.LogSampleRate > 1 {
+		return fmt.Errorf("invalid log sample rate %v (want 0..1)", st.LogSampleRate)
+	}
+	if st.RateLimit < 0 || st.RateBurst < 0 {
+		return fmt.Errorf("invalid rate limit %v with burst %d", st.RateLimit, st.RateBurst)
+	}
+	if st.RateLimit > 0 && !s.rateLimited {
+		return fmt.Errorf("a rate limit needs the ratelimit middleware")
+	}
+	if st.Headers == nil {
+		st.Headers = DefaultHeaders
+	}
+	if st.AuthRealm == "" {
+		st.AuthRealm = defaultAuthRealm
+	}
+	s.settings.Store(&st)
+	return nil
+}
+
+// Settings returns the settings currently in effect.
+func (s *Server) Settings() Settings {
+	return *s.settings.Load()
+}
+
+// Root returns the absolute path of the directory served at "/", or its
+// label when that is an fs.FS.
+func (s *Server) Root() string {
+	return s.root
+}
+
+// Handle registers an additional handler on the server's mux, behind the
+// same middleware as the built-in routes.
+func (s *Server) Handle(pattern string, h http.Handler) {
+	s.mux.Handle(pattern, h)
+}
+
+// HandleEndpoint registers an additional handler below the reserved
+// prefix, like the built-in endpoints: the pattern "/admin/" serves
+// /_/admin/ by default, and the handler sees the path without the prefix.
+func (s *Server) HandleEndpoint(pattern string, h http.Handler) {
+	s.endpoints.Handle(pattern, h)
+}
+
+// ReservedPrefix returns the URL path below which the endpoints live.
+func (s *Server) ReservedPrefix() string {
+	return s.reserved
+}
+
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.requests.start(r)
+	defer s.requests.done(r)
+	s.handler.ServeHTTP(w, r)
+}
+
+// InFlight lists the requests being served, as method and path, such as
+// those a shutdown is about to cut off.
+func (s *Server) InFlight() []string {
+	return s.requests.list()
+}
+
+// WithLogging wraps a handler served outside the Server, such as a
+// separate admin listener, in its request logger.
+func (s *Server) WithLogging(h http.Handler) http.Handler {
+	return s.logger(h)
+}
+
+// TrackConn is an http.Server ConnState hook feeding the admin API's
+// connection counts.
+func (s *Server) TrackConn(c net.Conn, state http.ConnState) {
+	s.conns.track(c, state)
+}
+
+// Close stops the cache cleaner and stats logger. The Server keeps serving
+// requests, but call it once the HTTP servers have shut down: it ends
+// WebSocket connections and the background work that requests still
+// draining rely on.
+func (s *Server) Close() error {
+	s.closed.Do(func() {
+		close(s.stop)
+		if s.sentry != nil {
+			s.sentry.Flush(sentryFlush)
+		}
+		if s.meta != nil {
+			if err := s.meta.flush(); err != nil {
+				s.log.Error("Failed to save download counts", "error", err)
+			}
+		}
+	})
+	return nil
+}
+
+// Healthy reports an error when the directory of a mount cannot be read,
+// such as when its disk failed or its network filesystem went away; it
+// blocks for as long as the filesystem does. Mounts of an FS are not
+// checked.
+func (s *Server) Healthy() error {
+	for _, m := range s.mounts {
+		if m.FS != nil {
+			continue
+		}
+		if _, err := m.stat(m.Dir); err != nil {
+			return fmt.Errorf("mount %s: %w", m.Prefix, err)
+		}
+	}
+	return nil
+}
+
+func (s *Server) secureHeaders(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		for k, v := range s.settings.Load().Headers {
+			w.Header().Set(k, v)
+		}
+		if s.noRobots {
+			w.Header().Set("X-Robots-Tag", "noindex")
+		}
+		next.ServeHTTP(w, r)
+	})
+}
@@ -0,0 +1,271 @@
+package fileserver
+
+import (
+	"fmt"
+	"log/slog"
+	"net/http"
+	"net/netip"
+	"os"
+	"path"
+	"regexp"
+	"slices"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/oschwald/maxminddb-golang/v2"
+)
+
+// GeoIP looks up the country and network (ASN) of clients in MaxMind
+// databases, for the request log and for Rules.
+type GeoIP struct {
+	// CountryDB is a MaxMind country database, such as
+	// GeoLite2-Country.mmdb; City databases work too.
+	CountryDB string
+	// ASNDB is a MaxMind ASN database, such as GeoLite2-ASN.mmdb.
+	ASNDB string
+	// Rules restrict paths to or from countries, and need CountryDB.
+	Rules []GeoRule
+}
+
+// GeoRule limits the countries a path may be fetched from. Requests it
+// refuses are answered with 451 Unavailable For Legal Reasons. As the
+// endpoints below the reserved prefix (the API, archive downloads,
+// thumbnails and so on) reach files by parameters rather than their URL,
+// a client that any rule refuses is refused all of them too, except the
+// static assets pages need.
+//
+// Only HTTP requests are checked: the SFTP server and a separate S3
+// listener are not.
+type GeoRule struct {
+	// Path is a glob like those of Options.Exclude: "*.iso" matches at any
+	// depth, "exports/**" the directory and everything in it.
+	Path string
+	// Allow lists the ISO 3166-1 country codes the path may be fetched
+	// from; Deny those it may not. Exactly one of the two is set.
+	// Addresses the database has no country for, private ones among them,
+	// have the code ZZ.
+	Allow []string
+	Deny  []string
+}
+
+const (
+	// geoPoll is how often the databases are checked for updates, such as
+	// geoipupdate installs every week.
+	geoPoll = time.Minute
+	// geoUnknown is the country of addresses not in the database.
+	geoUnknown = "ZZ"
+)
+
+type geoIP struct {
+	country, asn *geoDB // nil when not configured
+	rules        []geoRule
+}
+
+type geoRule struct {
+	re        *regexp.Regexp
+	allow     bool // countries lists those allowed rather than denied
+	countries map[string]bool
+}
+
+// geoDB is a database read into memory, which lets a reload swap it while
+// lookups are still using the previous one.
+type geoDB struct {
+	path    string
+	reader  atomic.Pointer[maxminddb.Reader]
+	modTime time.Time // of the file read; only touched while loading
+	size    int64
+}
+
+// countryRecord holds the fields read from country and City databases.
+type countryRecord struct {
+	Country struct {
+		ISOCode string `maxminddb:"iso_code"`
+	} `maxminddb:"country"`
+	// RegisteredCountry is used when the address has no country, as for
+	// some anycast networks.
+	RegisteredCountry struct {
+		ISOCode string `maxminddb:"iso_code"`
+	} `maxminddb:"registered_country"`
+}
+
+type asnRecord struct {
+	Number       uint   `maxminddb:"autonomous_system_number"`
+	Organization string `maxminddb:"autonomous_system_organization"`
+}
+
+func newGeoIP(opts *GeoIP) (*geoIP, error) {
+	g := &geoIP{}
+	if opts.CountryDB != "" {
+		g.country = &geoDB{path: opts.CountryDB}
+		if _, err := g.country.load(); err != nil {
+			return nil, err
+		}
+	}
+	if opts.ASNDB != "" {
+		g.asn = &geoDB{path: opts.ASNDB}
+		if _, err := g.asn.load(); err != nil {
+			return nil, err
+		}
+	}
+	if len(opts.Rules) > 0 && g.country == nil {
+		return nil, fmt.Errorf("geo rules need a country database")
+	}
+	for _, r := range opts.Rules {
+		re, err := compilePathGlob(r.Path)
+		if err != nil {
+			return nil, fmt.Errorf("geo rule %q: %w", r.Path, err)
+		}
+		if (len(r.Allow) > 0) == (len(r.Deny) > 0) {
+			return nil, fmt.Errorf("geo rule %q: want either allow or deny countries", r.Path)
+		}
+		rule := geoRule{re: re, allow: len(r.Allow) > 0, countries: make(map[string]bool)}
+		for _, c := range slices.Concat(r.Allow, r.Deny) {
+			code := strings.ToUpper(strings.TrimSpace(c))
+			if len(code) != 2 || code[0] < 'A' || code[0] > 'Z' || code[1] < 'A' || code[1] > 'Z' {
+				return nil, fmt.Errorf("geo rule %q: %q is not a two-letter country code", r.Path, c)
+			}
+			rule.countries[code] = true
+		}
+		g.rules = append(g.rules, rule)
+	}
+	return g, nil
+}
+
+// load reads the database if its size or modification time changed,
+// reporting whether it did. The previous database stays in use when it
+// fails.
+func (d *geoDB) load() (bool, error) {
+	info, err := os.Stat(d.path)
+	if err != nil {
+		return false, fmt.Errorf("geoip database: %w", err)
+	}
+	if d.reader.Load() != nil && info.ModTime().Equal(d.modTime) && info.Size() == d.size {
+		return false, nil
+	}
+	data, err := os.ReadFile(d.path)
+	if err != nil {
+		return false, fmt.Errorf("geoip database: %w", err)
+	}
+	reader, err := maxminddb.OpenBytes(data)
+	if err != nil {
+		return false, fmt.Errorf("geoip database %s: %w", d.path, err)
+	}
+	d.reader.Store(reader)
+	d.modTime, d.size = info.ModTime(), info.Size()
+	return true, nil
+}
+
+// countryOf returns the ISO code of the country of ip, or geoUnknown.
+func (g *geoIP) countryOf(ip string) string {
+	addr, err := netip.ParseAddr(ip)
+	if g.country == nil || err != nil {
+		return geoUnknown
+	}
+	var rec countryRecord
+	if err := g.country.reader.Load().Lookup(addr.Unmap()).Decode(&rec); err != nil {
+		return geoUnknown
+	}
+	switch {
+	case rec.Country.ISOCode != "":
+		return rec.Country.ISOCode
+	case rec.RegisteredCountry.ISOCode != "":
+		return rec.RegisteredCountry.ISOCode
+	}
+	return geoUnknown
+}
+
+// asnOf returns the autonomous system number and organization of ip, or
+// zero when unknown.
+func (g *geoIP) asnOf(ip string) (uint, string) {
+	addr, err := netip.ParseAddr(ip)
+	if g.asn == nil || err != nil {
+		return 0, ""
+	}
+	var rec asnRecord
+	if err := g.asn.reader.Load().Lookup(addr.Unmap()).Decode(&rec); err != nil {
+		return 0, ""
+	}
+	return rec.Number, rec.Organization
+}
+
+// geoAttrs returns the request log attributes for ip.
+func (s *Server) geoAttrs(ip string) []slog.Attr {
+	var attrs []slog.Attr
+	if s.geo.country != nil {
+		attrs = append(attrs, slog.String("country", s.geo.countryOf(ip)))
+	}
+	if n, org := s.geo.asnOf(ip); n != 0 {
+		attrs = append(attrs, slog.Uint64("asn", uint64(n)), slog.String("as_org", org))
+	}
+	return attrs
+}
+
+// allowed reports whether urlPath may be fetched from country: the first
+// rule whose glob matches it or a directory it is in decides.
+func (g *geoIP) allowed(urlPath, country string) bool {
+	for _, r := range g.rules {
+		if pathGlobMatches(r.re, urlPath) {
+			return r.countries[country] == r.allow
+		}
+	}
+	return true
+}
+
+// refusesAny reports whether some rule refuses country.
+func (g *geoIP) refusesAny(country string) bool {
+	for _, r := range g.rules {
+		if r.countries[country] != r.allow {
+			return true
+		}
+	}
+	return false
+}
+
+// geoRules answers requests the GeoIP rules refuse with 451.
+func (s *Server) geoRules(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if s.geo == nil || len(s.geo.rules) == 0 {
+			next.ServeHTTP(w, r)
+			return
+		}
+		country := s.geo.countryOf(RemoteIP(r))
+		urlPath := path.Clean("/" + r.URL.Path)
+		var ok bool
+		if s.isReserved(urlPath) && !strings.HasPrefix(urlPath, s.endpoint(assetsPrefix)) {
+			ok = !s.geo.refusesAny(country)
+		} else {
+			ok = s.geo.allowed(urlPath, country)
+		}
+		if !ok {
+			s.renderError(w, r, http.StatusUnavailableForLegalReasons)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// watchGeoIP reloads the databases when they change until the server is
+// closed.
+func (s *Server) watchGeoIP() {
+	t := time.NewTicker(geoPoll)
+	defer t.Stop()
+	for {
+		select {
+		case <-t.C:
+			for _, d := range []*geoDB{s.geo.country, s.geo.asn} {
+				if d == nil {
+					continue
+				}
+				changed, err := d.load()
+				if err != nil {
+					s.log.Warn("Failed to reload GeoIP database, keeping the previous one", "path", d.path, "error", err)
+				} else if changed {
+					s.log.Info("Reloaded GeoIP database", "path", d.path, "built", time.Unix(int64(d.reader.Load().Metadata.BuildEpoch), 0).UTC())
+				}
+			}
+		case <-s.stop:
+			return
+		}
+	}
+}
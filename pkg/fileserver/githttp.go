@@ -0,0 +1,134 @@
+package fileserver
+
+import (
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"os/exec"
+	"path"
+	"path/filepath"
+	"regexp"
+	"strings"
+)
+
+const (
+	gitInfoRefs   = "/info/refs"
+	gitUploadPack = "/git-upload-pack"
+)
+
+// gitProtocol is the Git-Protocol header values passed on to git, such as
+// "version=2".
+var gitProtocol = regexp.MustCompile(`^[a-z0-9=:.-]+$`)
+
+// serveGit answers the smart HTTP protocol for bare repositories under
+// directory mounts by running git upload-pack, so that they can be cloned
+// and fetched. Pushing is refused. It reports whether r was such a
+// request.
+func (s *Server) serveGit(w http.ResponseWriter, r *http.Request) bool {
+	p := r.URL.Path
+	var repo string
+	switch {
+	case strings.HasSuffix(p, gitInfoRefs) && r.URL.Query().Has("service"):
+		repo = strings.TrimSuffix(p, gitInfoRefs)
+	case strings.HasSuffix(p, gitUploadPack):
+		repo = strings.TrimSuffix(p, gitUploadPack)
+	case strings.HasSuffix(p, "/git-receive-pack"):
+		repo = strings.TrimSuffix(p, "/git-receive-pack")
+	default:
+		return false
+	}
+	relPath := path.Clean("/" + repo)
+	m, fsPath, ok := s.resolve(relPath)
+	if !ok || m.FS != nil || (m.Hidden == HiddenDeny && m.hiddenPath(relPath)) || s.noIndexDenied(m, fsPath) || !bareRepo(fsPath) {
+		return false
+	}
+
+	service := r.URL.Query().Get("service")
+	switch {
+	case strings.HasSuffix(p, gitInfoRefs) && service != "git-upload-pack":
+		writeAPIError(w, http.StatusForbidden, "only git-upload-pack is served; repositories are read-only", nil)
+	case strings.HasSuffix(p, gitInfoRefs):
+		if s.allowMethods(w, r, http.MethodGet, http.MethodHead) {
+			s.gitAdvertise(w, r, fsPath)
+		}
+	case strings.HasSuffix(p, gitUploadPack):
+		if s.allowMethods(w, r, http.MethodPost) {
+			s.gitUploadPack(w, r, fsPath)
+		}
+	default:
+		writeAPIError(w, http.StatusForbidden, "repositories are read-only", nil)
+	}
+	return true
+}
+
+// bareRepo reports whether dir looks like a bare Git repository.
+func bareRepo(dir string) bool {
+	for _, name := range []string{"HEAD", "objects", "refs"} {
+		if _, err := os.Stat(filepath.Join(dir, name)); err != nil {
+			return false
+		}
+	}
+	return true
+}
+
+// gitCommand runs git upload-pack in stateless RPC mode for dir.
+func gitCommand(r *http.Request, dir string, args ...string) *exec.Cmd {
+	// The served repositories often belong to another user than the
+	// server, which git refuses unless told they are safe.
+	args = append([]string{"-c", "safe.directory=*", "upload-pack", "--stateless-rpc"}, args...)
+	cmd := exec.CommandContext(r.Context(), "git", append(args, dir)...)
+	cmd.Env = append(os.Environ(), "GIT_HTTP_EXPORT_ALL=1")
+	if v := r.Header.Get("Git-Protocol"); gitProtocol.MatchString(v) {
+		cmd.Env = append(cmd.Env, "GIT_PROTOCOL="+v)
+	}
+	return cmd
+}
+
+// gitAdvertise answers GET info/refs?service=git-upload-pack.
+func (s *Server) gitAdvertise(w http.ResponseWriter, r *http.Request, dir string) {
+	var stderr bytes.Buffer
+	cmd := gitCommand(r, dir, "--advertise-refs")
+	cmd.Stderr = &stderr
+	refs, err := cmd.Output()
+	if err != nil {
+		s.log.Error("git upload-pack failed", "repo", r.URL.Path, "error", err, "stderr", strings.TrimSpace(stderr.String()))
+		s.renderError(w, r, http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/x-git-upload-pack-advertisement")
+	w.Header().Set("Cache-Control", "no-cache")
+	// Protocol v2 clients get the capability advertisement as is; v0 and
+	// v1 expect it after a service announcement.
+	if !strings.Contains(r.Header.Get("Git-Protocol"), "version=2") {
+		fmt.Fprintf(w, "%04x# service=git-upload-pack\n0000", len("# service=git-upload-pack\n")+4)
+	}
+	w.Write(refs)
+}
+
+// gitUploadPack answers POST git-upload-pack, streaming the pack.
+func (s *Server) gitUploadPack(w http.ResponseWriter, r *http.Request, dir string) {
+	body := io.Reader(r.Body)
+	if r.Header.Get("Content-Encoding") == "gzip" {
+		zr, err := gzip.NewReader(r.Body)
+		if err != nil {
+			writeAPIError(w, http.StatusBadRequest, "invalid gzip body", nil)
+			return
+		}
+		defer zr.Close()
+		body = zr
+	}
+	var stderr bytes.Buffer
+	cmd := gitCommand(r, dir)
+	cmd.Stdin = body
+	cmd.Stdout = w
+	cmd.Stderr = &stderr
+	w.Header().Set("Content-Type", "application/x-git-upload-pack-result")
+	w.Header().Set("Cache-Control", "no-cache")
+	if err := cmd.Run(); err != nil && !s.canceled(r, r.Context().Err()) {
+		// The status line may be gone; git reports errors in-band.
+		s.log.Error("git upload-pack failed", "repo", r.URL.Path, "error", err, "stderr", strings.TrimSpace(stderr.String()))
+	}
+}
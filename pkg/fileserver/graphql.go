@@ -0,0 +1,248 @@
+package fileserver
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"path"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/graph-gophers/graphql-go"
+	gqlotel "github.com/graph-gophers/graphql-go/trace/otel"
+)
+
+const (
+	graphqlMaxDepth    = 10 // query nesting, including children within children
+	graphqlSearchDepth = 8
+	graphqlMaxResults  = 1000
+)
+
+const graphqlSchema = `
+schema {
+	query: Query
+}
+
+scalar Time
+
+enum FileType {
+	FILE
+	DIR
+}
+
+input FileFilter {
+	ext: [String!]
+	minSize: Float
+	maxSize: Float
+	modifiedAfter: Time
+	modifiedBefore: Time
+	type: FileType
+}
+
+type Query {
+	# file describes a single path, or null if it does not exist.
+	file(path: String!): File
+	# search walks the tree below path up to depth levels deep.
+	search(path: String = "/", depth: Int = 3, filter: FileFilter, limit: Int = 100): [File!]!
+}
+
+type File {
+	name: String!
+	path: String!
+	ext: String!
+	size: Float!
+	isDir: Boolean!
+	modTime: Time!
+	children(filter: FileFilter): [File!]!
+}
+`
+
+func (s *Server) graphqlSchema() *graphql.Schema {
+	return graphql.MustParseSchema(graphqlSchema, &gqlQuery{s: s},
+		graphql.MaxDepth(graphqlMaxDepth),
+		graphql.Tracer(gqlotel.DefaultTracer()),
+	)
+}
+
+// graphqlHandler answers POST /graphql with {"query", "operationName",
+// "variables"} bodies.
+func (s *Server) graphqlHandler(schema *graphql.Schema) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if !allowAPIMethods(w, r, http.MethodPost) {
+			return
+		}
+		var params struct {
+			Query         string         `json:"query"`
+			OperationName string         `json:"operationName"`
+			Variables     map[string]any `json:"variables"`
+		}
+		s.limitBody(w, r)
+		err := json.NewDecoder(r.Body).Decode(&params)
+		if bodyTooLarge(w, err) {
+			return
+		}
+		if err != nil || params.Query == "" {
+			httpError(w, r, "Invalid GraphQL request", http.StatusBadRequest)
+			return
+		}
+		writeJSON(w, http.StatusOK, schema.Exec(r.Context(), params.Query, params.OperationName, params.Variables))
+	}
+}
+
+type gqlQuery struct {
+	s *Server
+}
+
+type gqlFilter struct {
+	Ext            *[]string
+	MinSize        *float64
+	MaxSize        *float64
+	ModifiedAfter  *graphql.Time
+	ModifiedBefore *graphql.Time
+	Type           *string
+}
+
+func (f *gqlFilter) match(e listEntry) bool {
+	if f == nil {
+		return true
+	}
+	if f.Ext != nil && !slices.ContainsFunc(*f.Ext, func(ext string) bool {
+		return strings.EqualFold(strings.TrimPrefix(ext, "."), strings.TrimPrefix(path.Ext(e.name), "."))
+	}) {
+		return false
+	}
+	switch {
+	case f.MinSize != nil && float64(e.size) < *f.MinSize,
+		f.MaxSize != nil && float64(e.size) > *f.MaxSize,
+		f.ModifiedAfter != nil && !e.modTime.After(f.ModifiedAfter.Time),
+		f.ModifiedBefore != nil && !e.modTime.Before(f.ModifiedBefore.Time),
+		f.Type != nil && (*f.Type == "DIR") != e.isDir:
+		return false
+	}
+	return true
+}
+
+// lookup resolves a URL path the way fileHandler does.
+func (s *Server) lookup(p string) (string, *Mount, string, bool) {
+	relPath := path.Clean("/" + p)
+	m, fsPath, ok := s.resolve(relPath)
+	if !ok || (m.Hidden == HiddenDeny && m.hiddenPath(relPath)) || s.noIndexDenied(m, fsPath) {
+		return "", nil, "", false
+	}
+	return relPath, m, fsPath, true
+}
+
+func (q *gqlQuery) File(args struct{ Path string }) (*gqlFile, error) {
+	relPath, m, fsPath, ok := q.s.lookup(args.Path)
+	if !ok {
+		return nil, nil
+	}
+	info, err := m.stat(fsPath)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &gqlFile{s: q.s, path: relPath, listEntry: listEntry{
+		name: path.Base(relPath), isDir: info.IsDir(), size: info.Size(), modTime: info.ModTime(),
+	}}, nil
+}
+
+func (q *gqlQuery) Search(ctx context.Context, args struct {
+	Path   string
+	Depth  int32
+	Filter *gqlFilter
+	Limit  int32
+}) ([]*gqlFile, error) {
+	if args.Depth < 1 || args.Depth > graphqlSearchDepth {
+		return nil, fmt.Errorf("depth must be between 1 and %d", graphqlSearchDepth)
+	}
+	if args.Limit < 1 || args.Limit > graphqlMaxResults {
+		return nil, fmt.Errorf("limit must be between 1 and %d", graphqlMaxResults)
+	}
+	root, _, _, ok := q.s.lookup(args.Path)
+	if !ok {
+		return nil, fmt.Errorf("%s not found", args.Path)
+	}
+	var out []*gqlFile
+	dirs := []string{root}
+	for depth := int32(0); depth < args.Depth && len(dirs) > 0; depth++ {
+		var next []string
+		for _, dir := range dirs {
+			if err := ctx.Err(); err != nil {
+				return nil, err
+			}
+			children, err := q.s.children(ctx, dir)
+			if err != nil {
+				continue // unreadable directories are skipped
+			}
+			for _, c := range children {
+				if args.Filter.match(c.listEntry) {
+					out = append(out, c)
+					if len(out) == int(args.Limit) {
+						return out, nil
+					}
+				}
+				if c.isDir {
+					next = append(next, c.path)
+				}
+			}
+		}
+		dirs = next
+	}
+	return out, nil
+}
+
+// children lists the directory at a URL path.
+func (s *Server) children(ctx context.Context, urlPath string) ([]*gqlFile, error) {
+	relPath, m, fsPath, ok := s.lookup(urlPath)
+	if !ok {
+		return nil, os.ErrNotExist
+	}
+	entries, _, err := s.readListing(ctx, m, fsPath, relPath)
+	if err != nil {
+		return nil, err
+	}
+	out := make([]*gqlFile, len(entries))
+	for i, e := range entries {
+		out[i] = &gqlFile{s: s, path: path.Join(relPath, e.name), listEntry: e}
+	}
+	return out, nil
+}
+
+type gqlFile struct {
+	listEntry
+	s    *Server
+	path string
+}
+
+func (f *gqlFile) Name() string  { return f.name }
+func (f *gqlFile) Path() string  { return f.path }
+func (f *gqlFile) Ext() string   { return path.Ext(f.name) }
+func (f *gqlFile) Size() float64 { return float64(f.size) }
+func (f *gqlFile) IsDir() bool   { return f.isDir }
+func (f *gqlFile) ModTime() graphql.Time {
+	return graphql.Time{Time: f.modTime.UTC().Truncate(time.Second)}
+}
+
+func (f *gqlFile) Children(ctx context.Context, args struct{ Filter *gqlFilter }) ([]*gqlFile, error) {
+	if !f.isDir {
+		return []*gqlFile{}, nil
+	}
+	all, err := f.s.children(ctx, f.path)
+	if err != nil {
+		return nil, err
+	}
+	out := all[:0]
+	for _, c := range all {
+		if args.Filter.match(c.listEntry) {
+			out = append(out, c)
+		}
+	}
+	return out, nil
+}
@@ -0,0 +1,256 @@
+package fileserver
+
+import (
+	"context"
+	"errors"
+	"io"
+	"io/fs"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/timestamppb"
+
+	"github.com/AScotM/go-server/pkg/fileserver/filepb"
+)
+
+const grpcChunkSize = 32 * 1024
+
+// GRPCServer returns a gRPC server offering filepb.FileService over the
+// same mounts, hidden-file policies, Basic auth users and plugin hooks as
+// HTTP. Clients authenticate with an "authorization: Basic ..." metadata
+// entry.
+func (s *Server) GRPCServer(opts ...grpc.ServerOption) *grpc.Server {
+	opts = append(opts,
+		grpc.ChainUnaryInterceptor(func(ctx context.Context, req any, _ *grpc.UnaryServerInfo, h grpc.UnaryHandler) (any, error) {
+			if err := s.grpcAuthorize(ctx); err != nil {
+				return nil, err
+			}
+			return h(ctx, req)
+		}),
+		grpc.ChainStreamInterceptor(func(srv any, ss grpc.ServerStream, _ *grpc.StreamServerInfo, h grpc.StreamHandler) error {
+			if err := s.grpcAuthorize(ss.Context()); err != nil {
+				return err
+			}
+			return h(srv, ss)
+		}),
+	)
+	gs := grpc.NewServer(opts...)
+	filepb.RegisterFileServiceServer(gs, &fileService{s: s})
+	return gs
+}
+
+// grpcAuthorize checks Basic credentials in the call metadata against the
+// configured users, as basicAuth does for HTTP.
+func (s *Server) grpcAuthorize(ctx context.Context) error {
+	st := s.settings.Load()
+	if !s.authRequired(st) {
+		return nil
+	}
+	md, _ := metadata.FromIncomingContext(ctx)
+	for _, v := range md.Get("authorization") {
+		r := http.Request{Header: http.Header{"Authorization": {v}}}
+		user, pass, ok := r.BasicAuth()
+		if ok && s.checkUser(st, user, pass) {
+			return nil
+		}
+		if p, found := peer.FromContext(ctx); found && ok {
+			s.securityEvent(securityAuthFailure, p.Addr.String(), "grpc", user, "")
+		}
+	}
+	return status.Error(codes.Unauthenticated, "invalid or missing credentials")
+}
+
+type fileService struct {
+	filepb.UnimplementedFileServiceServer
+	s *Server
+}
+
+func (fsvc *fileService) lookup(p string) (string, *Mount, string, error) {
+	relPath, m, fsPath, ok := fsvc.s.lookup(p)
+	if !ok {
+		return "", nil, "", status.Errorf(codes.NotFound, "%s not found", path.Clean("/"+p))
+	}
+	return relPath, m, fsPath, nil
+}
+
+// hookRequest builds the request handed to plugin hooks for a gRPC call.
+func hookRequest(ctx context.Context, method, urlPath string) *http.Request {
+	r := &http.Request{
+		Method:     method,
+		URL:        &url.URL{Path: urlPath},
+		Proto:      "gRPC",
+		Header:     make(http.Header),
+		RequestURI: urlPath,
+	}
+	if p, ok := peer.FromContext(ctx); ok {
+		r.RemoteAddr = p.Addr.String()
+	}
+	return r.WithContext(ctx)
+}
+
+func fsError(err error) error {
+	switch {
+	case errors.Is(err, fs.ErrNotExist):
+		return status.Error(codes.NotFound, "not found")
+	case errors.Is(err, fs.ErrPermission):
+		return status.Error(codes.PermissionDenied, "permission denied")
+	default:
+		return status.Error(codes.Internal, err.Error())
+	}
+}
+
+func fileInfo(urlPath string, info fs.FileInfo) *filepb.FileInfo {
+	return &filepb.FileInfo{
+		Name:    info.Name(),
+		Path:    urlPath,
+		Size:    info.Size(),
+		IsDir:   info.IsDir(),
+		ModTime: timestamppb.New(info.ModTime()),
+	}
+}
+
+func (fsvc *fileService) List(ctx context.Context, req *filepb.ListRequest) (*filepb.ListResponse, error) {
+	relPath, m, fsPath, err := fsvc.lookup(req.Path)
+	if err != nil {
+		return nil, err
+	}
+	entries, _, err := fsvc.s.readListing(ctx, m, fsPath, relPath)
+	if err != nil {
+		return nil, fsError(err)
+	}
+	resp := &filepb.ListResponse{Entries: make([]*filepb.FileInfo, len(entries))}
+	for i, e := range entries {
+		resp.Entries[i] = &filepb.FileInfo{
+			Name:    e.name,
+			Path:    path.Join(relPath, e.name),
+			Size:    e.size,
+			IsDir:   e.isDir,
+			ModTime: timestamppb.New(e.modTime),
+		}
+	}
+	return resp, nil
+}
+
+func (fsvc *fileService) Stat(ctx context.Context, req *filepb.StatRequest) (*filepb.FileInfo, error) {
+	relPath, m, fsPath, err := fsvc.lookup(req.Path)
+	if err != nil {
+		return nil, err
+	}
+	info, err := m.stat(fsPath)
+	if err != nil {
+		return nil, fsError(err)
+	}
+	return fileInfo(relPath, info), nil
+}
+
+func (fsvc *fileService) Download(req *filepb.DownloadRequest, stream grpc.ServerStreamingServer[filepb.Chunk]) error {
+	relPath, m, fsPath, err := fsvc.lookup(req.Path)
+	if err != nil {
+		return err
+	}
+	f, info, err := m.openContent(fsPath)
+	if err != nil {
+		return fsError(err)
+	}
+	defer f.Close()
+	if info.IsDir() {
+		return status.Errorf(codes.InvalidArgument, "%s is a directory", relPath)
+	}
+	if req.Offset < 0 || req.Offset > info.Size() {
+		return status.Errorf(codes.OutOfRange, "offset %d outside file of %d bytes", req.Offset, info.Size())
+	}
+	if _, err := f.Seek(req.Offset, io.SeekStart); err != nil {
+		return fsError(err)
+	}
+	buf := make([]byte, grpcChunkSize)
+	for {
+		n, err := f.Read(buf)
+		if n > 0 {
+			if err := stream.Send(&filepb.Chunk{Data: buf[:n]}); err != nil {
+				return err
+			}
+		}
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return fsError(err)
+		}
+	}
+	fsvc.s.fileServed(hookRequest(stream.Context(), http.MethodGet, relPath), fsPath, info)
+	return nil
+}
+
+func (fsvc *fileService) Upload(stream grpc.ClientStreamingServer[filepb.UploadRequest, filepb.FileInfo]) error {
+	first, err := stream.Recv()
+	if err != nil {
+		return err
+	}
+	relPath, m, fsPath, err := fsvc.lookup(first.Path)
+	if err != nil {
+		return err
+	}
+	if !m.ReadWrite {
+		return status.Errorf(codes.PermissionDenied, "%s is read-only", m.Prefix)
+	}
+	if relPath == m.Prefix {
+		return status.Errorf(codes.InvalidArgument, "%s is a directory", relPath)
+	}
+
+	// Write to a temporary file beside the target so a failed upload
+	// never leaves a partial file in place.
+	tmp, err := os.CreateTemp(filepath.Dir(fsPath), ".upload-*")
+	if err != nil {
+		return fsError(err)
+	}
+	defer os.Remove(tmp.Name())
+	defer tmp.Close()
+	var size int64
+	msg := first
+	for {
+		n, err := tmp.Write(msg.Data)
+		size += int64(n)
+		if err != nil {
+			return fsError(err)
+		}
+		msg, err = stream.Recv()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return err
+		}
+	}
+	if err := tmp.Chmod(0o644); err != nil {
+		return fsError(err)
+	}
+	if err := tmp.Close(); err != nil {
+		return fsError(err)
+	}
+	if info, err := os.Stat(fsPath); err == nil && info.IsDir() {
+		return status.Errorf(codes.InvalidArgument, "%s is a directory", relPath)
+	}
+	if err := fsvc.s.keepVersion(fsPath); err != nil {
+		return fsError(err)
+	}
+	if err := os.Rename(tmp.Name(), fsPath); err != nil {
+		return fsError(err)
+	}
+	fsvc.s.invalidate(fsPath)
+	info, err := os.Stat(fsPath)
+	if err != nil {
+		return fsError(err)
+	}
+	fsvc.s.log.Info("File uploaded", "path", relPath, "size", size, "via", "grpc")
+	fsvc.s.uploaded(hookRequest(stream.Context(), http.MethodPut, relPath), fsPath, size)
+	fsvc.s.mirrorLater(fsPath)
+	return stream.SendAndClose(fileInfo(relPath, info))
+}
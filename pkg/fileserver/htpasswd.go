@@ -0,0 +1,243 @@
+package fileserver
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/md5"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+const (
+	// htpasswdPoll is how often the htpasswd file is checked for changes.
+	htpasswdPoll = 2 * time.Second
+	// htpasswdVerified bounds the remembered successful logins, which
+	// spare a bcrypt comparison on every request of a session.
+	htpasswdVerified = 1024
+)
+
+// htpasswdFile holds the users of an Apache htpasswd file, re-read when
+// the file changes. Passwords hashed with bcrypt ($2y$, as htpasswd -B
+// writes), MD5-APR1 ($apr1$, its default) and SHA-1 ({SHA}) are accepted.
+type htpasswdFile struct {
+	path string
+
+	mu       sync.RWMutex
+	users    map[string]string // user -> hash
+	modTime  time.Time
+	size     int64
+	verified map[[sha256.Size]byte]bool // of user, password and hash
+}
+
+func loadHtpasswd(path string) (*htpasswdFile, []string, error) {
+	h := &htpasswdFile{path: path}
+	_, skipped, err := h.reload()
+	if err != nil {
+		return nil, nil, err
+	}
+	return h, skipped, nil
+}
+
+// reload re-reads the file if its size or modification time changed,
+// reporting whether it did and the users whose hashes are unsupported.
+// The users read before stay in place when it fails.
+func (h *htpasswdFile) reload() (bool, []string, error) {
+	info, err := os.Stat(h.path)
+	if err != nil {
+		return false, nil, err
+	}
+	h.mu.RLock()
+	same := h.users != nil && info.ModTime().Equal(h.modTime) && info.Size() == h.size
+	h.mu.RUnlock()
+	if same {
+		return false, nil, nil
+	}
+	data, err := os.ReadFile(h.path)
+	if err != nil {
+		return false, nil, err
+	}
+	users := make(map[string]string)
+	var skipped []string
+	sc := bufio.NewScanner(bytes.NewReader(data))
+	for n := 1; sc.Scan(); n++ {
+		line := strings.TrimSpace(sc.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		user, hash, ok := strings.Cut(line, ":")
+		if !ok || user == "" {
+			return false, nil, fmt.Errorf("%s:%d: want user:hash", h.path, n)
+		}
+		if !htpasswdSupported(hash) {
+			skipped = append(skipped, user)
+			continue
+		}
+		users[user] = hash
+	}
+	if err := sc.Err(); err != nil {
+		return false, nil, fmt.Errorf("%s: %w", h.path, err)
+	}
+	h.mu.Lock()
+	h.users, h.modTime, h.size = users, info.ModTime(), info.Size()
+	h.verified = make(map[[sha256.Size]byte]bool)
+	h.mu.Unlock()
+	return true, skipped, nil
+}
+
+func htpasswdSupported(hash string) bool {
+	for _, prefix := range []string{"$2a$", "$2b$", "$2y$", "$apr1$", "{SHA}"} {
+		if strings.HasPrefix(hash, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+// check reports whether pass is the password of user.
+func (h *htpasswdFile) check(user, pass string) bool {
+	h.mu.RLock()
+	hash, ok := h.users[user]
+	h.mu.RUnlock()
+	if !ok {
+		return false
+	}
+	key := sha256.Sum256([]byte(user + "\x00" + pass + "\x00" + hash))
+	h.mu.RLock()
+	known := h.verified[key]
+	h.mu.RUnlock()
+	if known {
+		return true
+	}
+	if !htpasswdMatch(hash, pass) {
+		return false
+	}
+	h.mu.Lock()
+	if len(h.verified) >= htpasswdVerified {
+		clear(h.verified)
+	}
+	h.verified[key] = true
+	h.mu.Unlock()
+	return true
+}
+
+func htpasswdMatch(hash, pass string) bool {
+	switch {
+	case strings.HasPrefix(hash, "$apr1$"):
+		salt, _, _ := strings.Cut(strings.TrimPrefix(hash, "$apr1$"), "$")
+		return subtle.ConstantTimeCompare([]byte(apr1(pass, salt)), []byte(hash)) == 1
+	case strings.HasPrefix(hash, "{SHA}"):
+		sum := sha1.Sum([]byte(pass))
+		return subtle.ConstantTimeCompare([]byte(base64.StdEncoding.EncodeToString(sum[:])), []byte(hash[len("{SHA}"):])) == 1
+	default:
+		return bcrypt.CompareHashAndPassword([]byte(hash), []byte(pass)) == nil
+	}
+}
+
+// apr1 hashes pass with Apache's variant of the MD5-based crypt, returning
+// the whole "$apr1$salt$hash" string.
+func apr1(pass, salt string) string {
+	const magic = "$apr1$"
+	if len(salt) > 8 {
+		salt = salt[:8]
+	}
+	p, s := []byte(pass), []byte(salt)
+
+	alt := md5.New()
+	alt.Write(p)
+	alt.Write(s)
+	alt.Write(p)
+	altSum := alt.Sum(nil)
+
+	h := md5.New()
+	h.Write(p)
+	h.Write([]byte(magic))
+	h.Write(s)
+	for i := len(p); i > 0; i -= 16 {
+		h.Write(altSum[:min(i, 16)])
+	}
+	for i := len(p); i > 0; i >>= 1 {
+		if i&1 != 0 {
+			h.Write([]byte{0})
+		} else {
+			h.Write(p[:1])
+		}
+	}
+	sum := h.Sum(nil)
+
+	for i := range 1000 {
+		r := md5.New()
+		if i&1 != 0 {
+			r.Write(p)
+		} else {
+			r.Write(sum)
+		}
+		if i%3 != 0 {
+			r.Write(s)
+		}
+		if i%7 != 0 {
+			r.Write(p)
+		}
+		if i&1 != 0 {
+			r.Write(sum)
+		} else {
+			r.Write(p)
+		}
+		sum = r.Sum(nil)
+	}
+
+	const itoa64 = "./0123456789ABCDEFGHIJKLMNOPQRSTUVWXYZabcdefghijklmnopqrstuvwxyz"
+	var out strings.Builder
+	out.WriteString(magic + salt + "$")
+	encode := func(v uint32, n int) {
+		for ; n > 0; n-- {
+			out.WriteByte(itoa64[v&0x3f])
+			v >>= 6
+		}
+	}
+	for _, g := range [][3]int{{0, 6, 12}, {1, 7, 13}, {2, 8, 14}, {3, 9, 15}, {4, 10, 5}} {
+		encode(uint32(sum[g[0]])<<16|uint32(sum[g[1]])<<8|uint32(sum[g[2]]), 4)
+	}
+	encode(uint32(sum[11]), 2)
+	return out.String()
+}
+
+// watchHtpasswd re-reads the htpasswd file when it changes until the
+// server is closed.
+func (s *Server) watchHtpasswd() {
+	t := time.NewTicker(htpasswdPoll)
+	defer t.Stop()
+	for {
+		select {
+		case <-t.C:
+			changed, skipped, err := s.htpasswd.reload()
+			if err != nil {
+				s.log.Warn("Failed to reload htpasswd file, keeping its previous users", "path", s.htpasswd.path, "error", err)
+				continue
+			}
+			if changed {
+				s.htpasswd.mu.RLock()
+				n := len(s.htpasswd.users)
+				s.htpasswd.mu.RUnlock()
+				s.log.Info("Reloaded htpasswd file", "path", s.htpasswd.path, "users", n)
+				s.logUnsupportedHashes(skipped)
+			}
+		case <-s.stop:
+			return
+		}
+	}
+}
+
+func (s *Server) logUnsupportedHashes(users []string) {
+	if len(users) > 0 {
+		s.log.Warn("Ignoring htpasswd users with unsupported password hashes (want bcrypt, MD5-APR1 or SHA-1)", "path", s.htpasswd.path, "users", users)
+	}
+}
@@ -0,0 +1,220 @@
+package fileserver
+
+import (
+	"fmt"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// Languages lists the languages of the listing UI, the first being the
+// default.
+var Languages = []string{"en", "de", "zh"}
+
+// messages is the listing UI catalog: message key to text by language.
+// Texts with verbs are fmt formats. Every key must exist in English; other
+// languages fall back to it.
+var messages = map[string]map[string]string{
+	"en": {
+		"index_of":       "Index of %s",
+		"parent":         "Parent directory",
+		"name":           "Name",
+		"size":           "Size",
+		"modified":       "Modified",
+		"bytes":          "%d bytes",
+		"qr":             "QR",
+		"qr_title":       "QR code for this page",
+		"upload":         "Drop files here or %s to upload.",
+		"choose":         "choose files",
+		"upload_done":    "done",
+		"filter":         "Filter %d entries",
+		"previous":       "Previous image",
+		"next":           "Next image",
+		"close":          "Close",
+		"decimal":        ".",
+		"pages":          "Pages",
+		"page_of":        "Page %d of %d",
+		"previous_page":  "« Previous",
+		"next_page":      "Next »",
+		"permissions":    "Permissions",
+		"owner":          "Owner",
+		"copy_link":      "Copy link",
+		"copied":         "Copied",
+		"select":         "Select %s",
+		"select_all":     "Select all",
+		"download_zip":   "Download selected as zip",
+		"gallery":        "Gallery",
+		"list_view":      "List",
+		"recent":         "Recently modified",
+		"editing":        "Editing %s",
+		"save":           "Save",
+		"saved":          "Saved",
+		"edit_conflict":  "The file was changed since you opened it. Copy your text, reload and merge.",
+		"back":           "Back",
+		"trash":          "Trash",
+		"trash_empty":    "The trash is empty.",
+		"restore":        "Restore",
+		"purge":          "Delete permanently",
+		"original_path":  "Original location",
+		"deleted":        "Deleted",
+		"expires":        "Purged after",
+		"search":         "Search",
+		"search_in":      "Search in %s",
+		"search_content": "Search file contents",
+		"matches":        "%d matches",
+		"skip":           "Skip to the listing",
+		"initials":       "Initials",
+		"truncated":      "This directory has more entries than can be listed; only the first %d read are shown.",
+	},
+	"de": {
+		"index_of":       "Inhalt von %s",
+		"parent":         "Übergeordnetes Verzeichnis",
+		"name":           "Name",
+		"size":           "Größe",
+		"modified":       "Geändert",
+		"bytes":          "%d Bytes",
+		"qr":             "QR",
+		"qr_title":       "QR-Code dieser Seite",
+		"upload":         "Dateien hierher ziehen oder %s zum Hochladen.",
+		"choose":         "Dateien auswählen",
+		"upload_done":    "fertig",
+		"filter":         "%d Einträge filtern",
+		"previous":       "Vorheriges Bild",
+		"next":           "Nächstes Bild",
+		"close":          "Schließen",
+		"decimal":        ",",
+		"pages":          "Seiten",
+		"page_of":        "Seite %d von %d",
+		"previous_page":  "« Zurück",
+		"next_page":      "Weiter »",
+		"permissions":    "Rechte",
+		"owner":          "Besitzer",
+		"copy_link":      "Link kopieren",
+		"copied":         "Kopiert",
+		"select":         "%s auswählen",
+		"select_all":     "Alle auswählen",
+		"download_zip":   "Auswahl als ZIP herunterladen",
+		"gallery":        "Galerie",
+		"list_view":      "Liste",
+		"recent":         "Kürzlich geändert",
+		"editing":        "%s bearbeiten",
+		"save":           "Speichern",
+		"saved":          "Gespeichert",
+		"edit_conflict":  "Die Datei wurde seit dem Öffnen geändert. Text kopieren, neu laden und zusammenführen.",
+		"back":           "Zurück",
+		"trash":          "Papierkorb",
+		"trash_empty":    "Der Papierkorb ist leer.",
+		"restore":        "Wiederherstellen",
+		"purge":          "Endgültig löschen",
+		"original_path":  "Ursprünglicher Ort",
+		"deleted":        "Gelöscht",
+		"expires":        "Entfernt nach",
+		"search":         "Suchen",
+		"search_in":      "Suche in %s",
+		"search_content": "Dateiinhalte durchsuchen",
+		"matches":        "%d Treffer",
+		"skip":           "Zur Dateiliste springen",
+		"initials":       "Anfangsbuchstaben",
+		"truncated":      "Dieses Verzeichnis hat zu viele Einträge; nur die ersten %d gelesenen werden angezeigt.",
+	},
+	"zh": {
+		"index_of":       "%s 的索引",
+		"parent":         "上级目录",
+		"name":           "名称",
+		"size":           "大小",
+		"modified":       "修改时间",
+		"bytes":          "%d 字节",
+		"qr":             "二维码",
+		"qr_title":       "本页二维码",
+		"upload":         "将文件拖到此处或%s以上传。",
+		"choose":         "选择文件",
+		"upload_done":    "完成",
+		"filter":         "筛选 %d 个条目",
+		"previous":       "上一张",
+		"next":           "下一张",
+		"close":          "关闭",
+		"decimal":        ".",
+		"pages":          "分页",
+		"page_of":        "第 %d 页，共 %d 页",
+		"previous_page":  "« 上一页",
+		"next_page":      "下一页 »",
+		"permissions":    "权限",
+		"owner":          "所有者",
+		"copy_link":      "复制链接",
+		"copied":         "已复制",
+		"select":         "选择 %s",
+		"select_all":     "全选",
+		"download_zip":   "将所选项下载为 zip",
+		"gallery":        "图库",
+		"list_view":      "列表",
+		"recent":         "最近修改",
+		"editing":        "编辑 %s",
+		"save":           "保存",
+		"saved":          "已保存",
+		"edit_conflict":  "文件在打开后已被修改。请复制您的文本，重新加载后合并。",
+		"back":           "返回",
+		"trash":          "回收站",
+		"trash_empty":    "回收站是空的。",
+		"restore":        "还原",
+		"purge":          "永久删除",
+		"original_path":  "原位置",
+		"deleted":        "删除时间",
+		"expires":        "清除时间",
+		"search":         "搜索",
+		"search_in":      "在 %s 中搜索",
+		"search_content": "搜索文件内容",
+		"matches":        "%d 个匹配项",
+		"skip":           "跳到文件列表",
+		"initials":       "首字母",
+		"truncated":      "此目录条目过多，仅显示最先读取的 %d 个。",
+	},
+}
+
+// msg returns the message for key in lang, formatted with args if any.
+func msg(lang, key string, args ...any) string {
+	text, ok := messages[lang][key]
+	if !ok {
+		text = messages["en"][key]
+	}
+	if len(args) > 0 {
+		return fmt.Sprintf(text, args...)
+	}
+	return text
+}
+
+func validLanguage(lang string) bool {
+	return slices.Contains(Languages, lang)
+}
+
+// language returns the UI language for a request: the configured one, or
+// else the best match for its Accept-Language header.
+func (s *Server) language(w http.ResponseWriter, r *http.Request) string {
+	if s.lang != "" {
+		return s.lang
+	}
+	w.Header().Add("Vary", "Accept-Language")
+	return matchLanguage(r.Header.Get("Accept-Language"))
+}
+
+// matchLanguage picks the supported language with the highest quality in
+// an Accept-Language header, comparing primary subtags only, so "de-AT"
+// selects German.
+func matchLanguage(header string) string {
+	best, bestQ := Languages[0], 0.0
+	for _, part := range strings.Split(header, ",") {
+		tag, params, _ := strings.Cut(strings.TrimSpace(part), ";")
+		q := 1.0
+		if v, ok := strings.CutPrefix(strings.TrimSpace(params), "q="); ok {
+			var err error
+			if q, err = strconv.ParseFloat(v, 64); err != nil {
+				continue
+			}
+		}
+		primary, _, _ := strings.Cut(strings.ToLower(tag), "-")
+		if validLanguage(primary) && q > bestQ {
+			best, bestQ = primary, q
+		}
+	}
+	return best
+}
@@ -0,0 +1,145 @@
+package fileserver
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"io"
+	"net/http"
+	"sync"
+	"time"
+)
+
+const (
+	// idempotencyTTL is how long a response is replayed for retries
+	// carrying the same Idempotency-Key.
+	idempotencyTTL = 10 * time.Minute
+	// idempotencyMaxKey bounds the length of an Idempotency-Key.
+	idempotencyMaxKey = 255
+)
+
+// idempotencyCache holds the responses to requests with an
+// Idempotency-Key, by client, path and key.
+type idempotencyCache struct {
+	mu      sync.Mutex
+	entries map[string]*idempotentEntry
+}
+
+type idempotentEntry struct {
+	body    [sha256.Size]byte // digest of the request body
+	done    bool              // false while the first request is running
+	status  int
+	header  http.Header
+	resp    []byte
+	expires time.Time
+}
+
+func newIdempotencyCache() *idempotencyCache {
+	return &idempotencyCache{entries: make(map[string]*idempotentEntry)}
+}
+
+// idempotent lets clients retry POST requests to h safely: a request with
+// an Idempotency-Key header that was already answered gets the same
+// response again without h running twice. Reusing a key with another body
+// gets 422, and while the first request is still running 409. Responses
+// with a 5xx status are not kept, so those may be retried for real.
+func (s *Server) idempotent(h http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		key := r.Header.Get("Idempotency-Key")
+		if key == "" || r.Method != http.MethodPost {
+			h(w, r)
+			return
+		}
+		if len(key) > idempotencyMaxKey {
+			writeAPIError(w, http.StatusBadRequest, "Idempotency-Key too long", nil)
+			return
+		}
+		body, err := io.ReadAll(io.LimitReader(r.Body, s.maxBody+1))
+		if err != nil || int64(len(body)) > s.maxBody {
+			// Let h answer the oversized or broken body as it would.
+			r.Body = io.NopCloser(io.MultiReader(bytes.NewReader(body), r.Body))
+			h(w, r)
+			return
+		}
+		r.Body = io.NopCloser(bytes.NewReader(body))
+
+		client := RemoteIP(r)
+		if user, _, ok := r.BasicAuth(); ok {
+			client = "user:" + user
+		}
+		id := client + " " + r.URL.Path + " " + key
+		digest := sha256.Sum256(body)
+
+		c := s.idempotency
+		c.mu.Lock()
+		now := time.Now()
+		for k, e := range c.entries {
+			if e.done && now.After(e.expires) {
+				delete(c.entries, k)
+			}
+		}
+		e, found := c.entries[id]
+		switch {
+		case found && e.body != digest:
+			c.mu.Unlock()
+			writeAPIError(w, http.StatusUnprocessableEntity, "Idempotency-Key was used with a different body", nil)
+			return
+		case found && !e.done:
+			c.mu.Unlock()
+			writeAPIError(w, http.StatusConflict, "a request with this Idempotency-Key is in progress", nil)
+			return
+		case found:
+			c.mu.Unlock()
+			for k, v := range e.header {
+				w.Header()[k] = v
+			}
+			w.Header().Set("Idempotent-Replayed", "true")
+			w.WriteHeader(e.status)
+			w.Write(e.resp)
+			return
+		}
+		e = &idempotentEntry{body: digest}
+		c.entries[id] = e
+		c.mu.Unlock()
+
+		rec := &idempotentRecorder{ResponseWriter: w, status: http.StatusOK}
+		defer func() {
+			p := recover()
+			c.mu.Lock()
+			if p != nil || rec.status >= 500 {
+				delete(c.entries, id)
+			} else {
+				header := w.Header().Clone()
+				header.Del("X-Request-Id")
+				e.done, e.status, e.header, e.resp = true, rec.status, header, rec.buf.Bytes()
+				e.expires = time.Now().Add(idempotencyTTL)
+			}
+			c.mu.Unlock()
+			if p != nil {
+				panic(p)
+			}
+		}()
+		h(rec, r)
+	}
+}
+
+// idempotentRecorder keeps a copy of the response it passes on.
+type idempotentRecorder struct {
+	http.ResponseWriter
+	status int
+	buf    bytes.Buffer
+}
+
+func (rec *idempotentRecorder) WriteHeader(code int) {
+	rec.status = code
+	rec.ResponseWriter.WriteHeader(code)
+}
+
+func (rec *idempotentRecorder) Write(b []byte) (int, error) {
+	rec.buf.Write(b)
+	return rec.ResponseWriter.Write(b)
+}
+
+// Unwrap lets http.ResponseController reach the underlying writer.
+func (rec *idempotentRecorder) Unwrap() http.ResponseWriter {
+	return rec.ResponseWriter
+}
@@ -0,0 +1,531 @@
+package fileserver
+
+import (
+	"cmp"
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"html"
+	"html/template"
+	"io"
+	"mime"
+	"net/http"
+	"net/url"
+	"path"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+	"unicode/utf8"
+)
+
+// listing is a directory listing as every output format sees it.
+type listing struct {
+	Path    string // URL path of the directory, starting and ending with "/"
+	Entries []listEntry
+	// Modified is the directory's modification time, if it has one.
+	Modified time.Time
+	Upload   bool   // offer uploads into the directory
+	Sort     string // a key of listingSorts
+	Desc     bool
+	// ExactSizes shows sizes in bytes rather than KiB, MiB and so on.
+	ExactSizes bool
+	// Readme is the rendered README shown below an HTML listing.
+	Readme template.HTML
+	// Header and Footer are the directory's sanitized HEADER.html and
+	// FOOTER.html, shown above and below an HTML listing.
+	Header, Footer template.HTML
+	// ShortLinks makes the QR code point at a short link.
+	ShortLinks bool
+	// Lang is the language of the HTML listing; see Languages.
+	Lang string
+	// Page of Pages is shown when an HTML listing is split into pages;
+	// Letters then index the page where each initial letter starts.
+	Page, Pages int
+	Letters     []pageLetter
+	// ShowPerms adds mode bits, owner and group columns.
+	ShowPerms bool
+	// Origin is the scheme and host for absolute URLs: text listings
+	// and the copy-link buttons.
+	Origin string
+	// Gallery shows an HTML listing as a grid of thumbnails (?view=gallery).
+	Gallery bool
+	// Recent marks the /recent page, whose entries are paths across the
+	// tree; HasRecent links to it from directory listings.
+	Recent, HasRecent bool
+	// HasTrash links to the /trash page.
+	HasTrash bool
+	// Brand is the deployment's title, logo and footer.
+	Brand Branding
+	// DirSizes shows total sizes of directories, with placeholders the
+	// page fills in from /api/v1/dirsize while they are computed.
+	DirSizes bool
+	// Reserved is the server's reserved prefix, below which its endpoints
+	// and assets live.
+	Reserved string
+	// Truncated is the entry cap when the directory held more entries,
+	// which are left out of the listing; it is zero otherwise.
+	Truncated int
+}
+
+// endpoint returns the URL of a server endpoint, given by its path below
+// the reserved prefix.
+func (l *listing) endpoint(p string) string {
+	return l.Reserved + p
+}
+
+type pageLetter struct {
+	Letter string
+	Page   int
+}
+
+// paginate keeps only the entries of page, counted from 1, when there
+// are more than size. An index of initials is only useful, and so only
+// built, when the listing is sorted by name.
+func (l *listing) paginate(page, size int) {
+	l.Page, l.Pages = 1, 1
+	if size <= 0 || len(l.Entries) <= size {
+		return
+	}
+	l.Pages = (len(l.Entries) + size - 1) / size
+	l.Page = min(max(page, 1), l.Pages)
+	if l.Sort == "name" {
+		seen := make(map[string]bool)
+		for i, e := range l.Entries {
+			letter := initial(e.name)
+			if !seen[letter] {
+				seen[letter] = true
+				l.Letters = append(l.Letters, pageLetter{letter, i/size + 1})
+			}
+		}
+		slices.SortFunc(l.Letters, func(a, b pageLetter) int { return strings.Compare(a.Letter, b.Letter) })
+	}
+	l.Entries = l.Entries[(l.Page-1)*size : min(l.Page*size, len(l.Entries))]
+}
+
+// initial returns the upper-cased first letter of name, or "#" when it
+// does not start with a letter.
+func initial(name string) string {
+	r, _ := utf8.DecodeRuneInString(name)
+	if !unicode.IsLetter(r) {
+		return "#"
+	}
+	return string(unicode.ToUpper(r))
+}
+
+// pageLink returns the query of page n in the current sort order.
+func (l *listing) pageLink(n int) string {
+	q := url.Values{"page": {strconv.Itoa(n)}}
+	if l.Sort != "name" {
+		q.Set("sort", l.Sort)
+	}
+	if l.Desc {
+		q.Set("order", "desc")
+	}
+	if l.Gallery {
+		q.Set("view", "gallery")
+	}
+	return "?" + q.Encode()
+}
+
+// renderPages writes the page navigation of a paginated HTML listing.
+func renderPages(w io.Writer, l *listing) {
+	if l.Pages < 2 {
+		return
+	}
+	fmt.Fprintf(w, `<nav class="pages" aria-label="%s">`, html.EscapeString(l.t("pages")))
+	if l.Page > 1 {
+		fmt.Fprintf(w, `<a href="%s" rel="prev">%s</a>`, template.HTMLEscapeString(l.pageLink(l.Page-1)), html.EscapeString(l.t("previous_page")))
+	}
+	fmt.Fprintf(w, `<span>%s</span>`, html.EscapeString(l.t("page_of", l.Page, l.Pages)))
+	if l.Page < l.Pages {
+		fmt.Fprintf(w, `<a href="%s" rel="next">%s</a>`, template.HTMLEscapeString(l.pageLink(l.Page+1)), html.EscapeString(l.t("next_page")))
+	}
+	fmt.Fprint(w, `</nav>`)
+}
+
+// t returns a UI message in the listing's language.
+func (l *listing) t(key string, args ...any) string {
+	return msg(l.Lang, key, args...)
+}
+
+// listingSorts are the fields selectable with ?sort=, ordered with
+// ?order=asc or desc. Directories always come first and ties go by name.
+var listingSorts = map[string]func(a, b listEntry) int{
+	"name":     func(a, b listEntry) int { return 0 },
+	"size":     func(a, b listEntry) int { return cmp.Compare(a.size, b.size) },
+	"modified": func(a, b listEntry) int { return a.modTime.Compare(b.modTime) },
+}
+
+func (l *listing) sort() {
+	by := listingSorts[l.Sort]
+	slices.SortStableFunc(l.Entries, func(a, b listEntry) int {
+		if a.isDir != b.isDir {
+			if a.isDir {
+				return -1
+			}
+			return 1
+		}
+		c := by(a, b)
+		if c == 0 {
+			c = strings.Compare(a.name, b.name)
+		}
+		if l.Desc {
+			c = -c
+		}
+		return c
+	})
+}
+
+// qrQuery selects the QR code of the listing's URL, or of a short link to
+// it when those are enabled.
+func (l *listing) qrQuery() string {
+	q := url.Values{"path": {l.Path}}
+	if l.ShortLinks {
+		q.Set("short", "1")
+	}
+	return q.Encode()
+}
+
+// sortLink returns the query selecting field, reversing the order when
+// the listing is already sorted by it, and an arrow marking the current
+// order.
+func (l *listing) sortLink(field string) (href, arrow string) {
+	href = "?sort=" + field
+	if field == l.Sort && !l.Desc {
+		href, arrow = href+"&order=desc", " <span aria-hidden=\"true\">\u25b2</span>"
+	} else if field == l.Sort {
+		arrow = " <span aria-hidden=\"true\">\u25bc</span>"
+	}
+	if l.Gallery {
+		href += "&view=gallery"
+	}
+	return href, arrow
+}
+
+// viewLink returns the query switching between the table and the gallery,
+// keeping the sort order.
+func (l *listing) viewLink() string {
+	q := url.Values{}
+	if l.Sort != "name" {
+		q.Set("sort", l.Sort)
+	}
+	if l.Desc {
+		q.Set("order", "desc")
+	}
+	if !l.Gallery {
+		q.Set("view", "gallery")
+	}
+	return "?" + q.Encode()
+}
+
+// href returns the URL path of an entry, with a trailing slash for
+// directories.
+func (l *listing) href(e listEntry) string {
+	p := path.Join(l.Path, e.name)
+	if e.isDir {
+		p += "/"
+	}
+	return p
+}
+
+// escapePath percent-encodes a URL path for a link, as HTML escaping
+// alone leaves names with "#", "?", "%" or spaces broken.
+func escapePath(p string) string {
+	return (&url.URL{Path: p}).EscapedPath()
+}
+
+// listingRenderer writes a listing in one output format.
+type listingRenderer struct {
+	contentType string
+	render      func(w io.Writer, r *http.Request, l *listing)
+}
+
+// listingFormats are the renderers selectable with ?format=; "html" is the
+// default.
+var listingFormats = map[string]listingRenderer{
+	"html": {"text/html; charset=utf-8", renderListingHTML},
+	"json": {"application/json", renderListingJSON},
+	"txt":  {"text/plain; charset=utf-8", renderListingText},
+	"xml":  {"application/xml; charset=utf-8", renderListingXML},
+}
+
+func renderListingHTML(w io.Writer, r *http.Request, l *listing) {
+	title := html.EscapeString(l.t("index_of", l.Path))
+	if l.Recent {
+		title = html.EscapeString(l.t("recent"))
+	}
+	windowTitle := title
+	if l.Brand.Title != "" {
+		windowTitle += " \u2013 " + html.EscapeString(l.Brand.Title)
+	}
+	fmt.Fprintf(w, `<!DOCTYPE html>
+<html lang="%s"><head><meta charset="utf-8"><meta name="viewport" content="width=device-width, initial-scale=1"><title>%s</title>
+<link rel="stylesheet" href="%[3]sstyle.css"><link rel="icon" href="%[3]sfavicon.svg">
+<script src="%[3]slisting.js" defer></script><script src="%[3]slightbox.js" defer></script>`, l.Lang, windowTitle, l.endpoint(assetsPrefix))
+	if l.Upload {
+		fmt.Fprintf(w, `<script src="%supload.js" defer></script>`, l.endpoint(assetsPrefix))
+	}
+	// Scripts take their messages from the body's data attributes.
+	fmt.Fprint(w, `</head><body`)
+	for _, key := range []string{"filter", "qr_title", "upload_done", "previous", "next", "close", "copy_link", "copied", "bytes", "decimal"} {
+		fmt.Fprintf(w, ` data-%s="%s"`, strings.ReplaceAll(key, "_", "-"), html.EscapeString(l.t(key)))
+	}
+	fmt.Fprintf(w, ` data-origin="%s" data-api="%s"`, html.EscapeString(l.Origin), html.EscapeString(l.endpoint(apiV1Prefix)))
+	if l.ExactSizes {
+		fmt.Fprint(w, ` data-exact-sizes`)
+	}
+	view := "gallery"
+	if l.Gallery {
+		view = "list_view"
+	}
+	fmt.Fprintf(w, `><a class="skip" href="#main">%s</a>`, html.EscapeString(l.t("skip")))
+	if l.Brand.Title != "" || l.Brand.Logo != "" {
+		fmt.Fprint(w, `<a class="brand" href="/">`)
+		if l.Brand.Logo != "" {
+			fmt.Fprintf(w, `<img src="%s" alt="">`, template.HTMLEscapeString(l.Brand.Logo))
+		}
+		fmt.Fprintf(w, `%s</a>`, html.EscapeString(l.Brand.Title))
+	}
+	fmt.Fprintf(w, `<header class="title"><h1 id="title">%s</h1>`, title)
+	if !l.Recent {
+		fmt.Fprintf(w, `<a class="qr" href="%sqr?%s" title="%s">%s</a>`,
+			l.endpoint(apiV1Prefix), template.HTMLEscapeString(l.qrQuery()), html.EscapeString(l.t("qr_title")), html.EscapeString(l.t("qr")))
+	}
+	fmt.Fprintf(w, `<a class="view" href="%s">%s</a>`, template.HTMLEscapeString(l.viewLink()), html.EscapeString(l.t(view)))
+	if !l.Recent {
+		fmt.Fprintf(w, `<a class="view" href="%s?path=%s">%s</a>`, l.endpoint(searchPath), url.QueryEscape(l.Path), html.EscapeString(l.t("search")))
+	}
+	if l.HasRecent && !l.Recent {
+		fmt.Fprintf(w, `<a class="view" href="%s">%s</a>`, l.endpoint(recentPath), html.EscapeString(l.t("recent")))
+	}
+	if l.HasTrash {
+		fmt.Fprintf(w, `<a class="view" href="%s">%s</a>`, l.endpoint(trashPath), html.EscapeString(l.t("trash")))
+	}
+	fmt.Fprint(w, `</header>`)
+	if l.Header != "" {
+		fmt.Fprintf(w, `<section class="annotation header">%s</section>`, l.Header)
+	}
+	if l.Upload {
+		choose := `<label><input type="file" multiple>` + html.EscapeString(l.t("choose")) + `</label>`
+		fmt.Fprintf(w, `<div id="upload" class="upload" data-path="%s" data-api="%suploads">%s</div><ul id="upload-progress" class="upload-progress"></ul>`,
+			html.EscapeString(l.Path), l.endpoint(apiV1Prefix), fmt.Sprintf(html.EscapeString(l.t("upload")), choose))
+	}
+	fmt.Fprint(w, `<main id="main" tabindex="-1">`)
+	if l.Truncated > 0 {
+		fmt.Fprintf(w, `<p class="truncated" role="status">%s</p>`, html.EscapeString(l.t("truncated", l.Truncated)))
+	}
+	renderPages(w, l)
+	if len(l.Letters) > 0 {
+		fmt.Fprintf(w, `<nav class="letters" aria-label="%s">`, html.EscapeString(l.t("initials")))
+		for _, pl := range l.Letters {
+			fmt.Fprintf(w, `<a href="%s">%s</a>`, template.HTMLEscapeString(l.pageLink(pl.Page)), html.EscapeString(pl.Letter))
+		}
+		fmt.Fprint(w, `</nav>`)
+	}
+	if l.Gallery {
+		renderGallery(w, l)
+	} else {
+		renderTable(w, l)
+	}
+	renderPages(w, l)
+	fmt.Fprint(w, `</main>`)
+	if l.Footer != "" {
+		fmt.Fprintf(w, `<section class="annotation footer">%s</section>`, l.Footer)
+	}
+	if l.Readme != "" {
+		fmt.Fprintf(w, `<article class="readme">%s</article>`, l.Readme)
+	}
+	if l.Brand.Footer != "" {
+		fmt.Fprintf(w, `<footer class="brand">%s</footer>`, html.EscapeString(l.Brand.Footer))
+	}
+	fmt.Fprint(w, "</body></html>")
+}
+
+// renderTable writes the entries of an HTML listing as a table with
+// sortable columns.
+func renderTable(w io.Writer, l *listing) {
+	// Checkboxes belong to the selection form by their form attribute, so
+	// it need not wrap the table.
+	fmt.Fprintf(w, `<form id="selection" class="selection" method="post" action="%sarchive"><input type="hidden" name="name" value="%s"><button type="submit">%s</button></form>`,
+		l.endpoint(apiV1Prefix), html.EscapeString(path.Base(l.Path)), html.EscapeString(l.t("download_zip")))
+	fmt.Fprintf(w, `<table class="listing" aria-labelledby="title"><thead><tr><th class="select" scope="col"><span class="select-all" title="%s"></span></th>`, html.EscapeString(l.t("select_all")))
+	for _, field := range []string{"name", "size", "modified"} {
+		href, arrow := l.sortLink(field)
+		sorted := ""
+		if field == l.Sort && l.Desc {
+			sorted = ` aria-sort="descending"`
+		} else if field == l.Sort {
+			sorted = ` aria-sort="ascending"`
+		}
+		fmt.Fprintf(w, `<th class="%s" scope="col"%s><a href="%s">%s</a>%s</th>`, field, sorted, template.HTMLEscapeString(href), html.EscapeString(l.t(field)), arrow)
+	}
+	perms := ""
+	if l.ShowPerms {
+		fmt.Fprintf(w, `<th class="perms" scope="col">%s</th><th class="owner" scope="col">%s</th>`, html.EscapeString(l.t("permissions")), html.EscapeString(l.t("owner")))
+		perms = `<td></td><td></td>`
+	}
+	fmt.Fprint(w, `</tr></thead><tbody>`)
+
+	if l.Path != "/" {
+		parent := path.Dir(path.Clean(l.Path))
+		fmt.Fprintf(w, `<tr><td class="select"></td><td class="name"><img src="%sicons/up.svg" alt=""><a href="%s" title="%[3]s" aria-label="%[3]s">..</a></td><td></td><td></td>%[4]s</tr>`,
+			l.endpoint(assetsPrefix), template.HTMLEscapeString(escapePath(parent)), html.EscapeString(l.t("parent")), perms)
+	}
+
+	for _, f := range l.Entries {
+		icon := "file.svg"
+		if f.isDir {
+			icon = "dir.svg"
+		}
+		bytes := html.EscapeString(l.t("bytes", f.size))
+		size := fmt.Sprintf(`<td class="size" title="%s">%s</td>`, bytes, strings.Replace(humanSize(f.size), ".", l.t("decimal"), 1))
+		if l.ExactSizes {
+			size = `<td class="size">` + bytes + `</td>`
+		}
+		if f.sizePending {
+			size = fmt.Sprintf(`<td class="size pending" data-dirsize="%s" aria-busy="true"><span class="spinner" aria-hidden="true"></span></td>`,
+				template.HTMLEscapeString(l.href(f)))
+		}
+		class := ""
+		if !f.isDir && strings.HasPrefix(mime.TypeByExtension(path.Ext(f.name)), "image/") {
+			class = ` class="image"`
+		}
+		if l.ShowPerms {
+			perms = fmt.Sprintf(`<td class="perms"><code>%s</code></td><td class="owner">%s</td>`,
+				f.mode, html.EscapeString(strings.Trim(f.owner+":"+f.group, ":")))
+		}
+		fmt.Fprintf(w, `<tr><td class="select"><input type="checkbox" form="selection" name="path" value="%[3]s" aria-label="%[9]s"></td><td class="name"><img src="%[1]sicons/%[2]s" alt=""><a href="%[10]s"%[4]s>%[5]s</a></td>%[6]s<td class="modified"><time datetime="%[7]s">%[7]s</time></td>%[8]s</tr>`,
+			l.endpoint(assetsPrefix), icon,
+			template.HTMLEscapeString(l.href(f)),
+			class,
+			template.HTMLEscapeString(f.name),
+			size,
+			f.modTime.Format(time.RFC3339),
+			perms,
+			html.EscapeString(l.t("select", f.name)),
+			template.HTMLEscapeString(escapePath(l.href(f))))
+	}
+	fmt.Fprint(w, "</tbody></table>")
+}
+
+// renderGallery writes the entries of an HTML listing as a grid, with
+// lazily loaded thumbnails for images and icons for everything else.
+func renderGallery(w io.Writer, l *listing) {
+	fmt.Fprint(w, `<ul class="gallery" aria-labelledby="title">`)
+	if l.Path != "/" {
+		fmt.Fprintf(w, `<li><a href="%s" title="%[2]s" aria-label="%[2]s"><img src="%sicons/up.svg" alt="" class="icon"><span>..</span></a></li>`,
+			template.HTMLEscapeString(l.viewParent()), html.EscapeString(l.t("parent")), l.endpoint(assetsPrefix))
+	}
+	for _, f := range l.Entries {
+		href := escapePath(l.href(f))
+		var img, class string
+		switch {
+		case f.isDir:
+			href += "?view=gallery"
+			img = fmt.Sprintf(`<img src="%sicons/dir.svg" alt="" class="icon">`, l.endpoint(assetsPrefix))
+		case strings.HasPrefix(mime.TypeByExtension(path.Ext(f.name)), "image/"):
+			class = ` class="image"`
+			thumb := url.Values{"path": {l.href(f)}, "size": {strconv.Itoa(thumbDefaultSize)}}
+			img = fmt.Sprintf(`<img src="%sthumb?%s" alt="" loading="lazy" width="%d" height="%[3]d">`,
+				l.endpoint(apiV1Prefix), template.HTMLEscapeString(thumb.Encode()), thumbDefaultSize)
+		default:
+			img = fmt.Sprintf(`<img src="%sicons/file.svg" alt="" class="icon">`, l.endpoint(assetsPrefix))
+		}
+		fmt.Fprintf(w, `<li><a href="%s"%s>%s<span>%s</span></a></li>`,
+			template.HTMLEscapeString(href), class, img, template.HTMLEscapeString(f.name))
+	}
+	fmt.Fprint(w, `</ul>`)
+}
+
+// viewParent returns the link to the parent directory in the same view.
+func (l *listing) viewParent() string {
+	parent := path.Dir(path.Clean(l.Path))
+	if parent != "/" {
+		parent += "/"
+	}
+	return escapePath(parent) + "?view=gallery"
+}
+
+// humanSize formats n bytes in binary units, such as "1.5 MiB".
+func humanSize(n int64) string {
+	if n < 1024 {
+		return fmt.Sprintf("%d B", n)
+	}
+	v, unit := float64(n), 0
+	for v >= 1024 && unit < len(sizeUnits) {
+		v /= 1024
+		unit++
+	}
+	return fmt.Sprintf("%.1f %s", v, sizeUnits[unit-1])
+}
+
+var sizeUnits = []string{"KiB", "MiB", "GiB", "TiB", "PiB", "EiB"}
+
+type jsonListing struct {
+	Path      string          `json:"path"`
+	Entries   []jsonListEntry `json:"entries"`
+	Truncated bool            `json:"truncated,omitempty"`
+}
+
+type jsonListEntry struct {
+	Name    string    `json:"name"`
+	Path    string    `json:"path"`
+	IsDir   bool      `json:"is_dir"`
+	Size    int64     `json:"size"`
+	ModTime time.Time `json:"mod_time"`
+	Mode    string    `json:"mode,omitempty"`
+	Owner   string    `json:"owner,omitempty"`
+	Group   string    `json:"group,omitempty"`
+}
+
+func renderListingJSON(w io.Writer, r *http.Request, l *listing) {
+	out := jsonListing{Path: l.Path, Entries: make([]jsonListEntry, len(l.Entries)), Truncated: l.Truncated > 0}
+	for i, e := range l.Entries {
+		out.Entries[i] = jsonListEntry{Name: e.name, Path: l.href(e), IsDir: e.isDir, Size: e.size, ModTime: e.modTime}
+		if l.ShowPerms {
+			out.Entries[i].Mode, out.Entries[i].Owner, out.Entries[i].Group = e.mode.String(), e.owner, e.group
+		}
+	}
+	json.NewEncoder(w).Encode(out)
+}
+
+// renderListingText writes one absolute URL per line, so the output can
+// be fed to wget -i or xargs curl.
+func renderListingText(w io.Writer, r *http.Request, l *listing) {
+	for _, e := range l.Entries {
+		io.WriteString(w, l.Origin+escapePath(l.href(e))+"\n")
+	}
+}
+
+type xmlListing struct {
+	XMLName   xml.Name       `xml:"listing"`
+	Path      string         `xml:"path,attr"`
+	Truncated bool           `xml:"truncated,attr,omitempty"`
+	Entries   []xmlListEntry `xml:"entry"`
+}
+
+type xmlListEntry struct {
+	Name    string    `xml:"name"`
+	Path    string    `xml:"path"`
+	Type    string    `xml:"type,attr"`
+	Size    int64     `xml:"size"`
+	ModTime time.Time `xml:"mod_time"`
+}
+
+func renderListingXML(w io.Writer, r *http.Request, l *listing) {
+	out := xmlListing{Path: l.Path, Truncated: l.Truncated > 0, Entries: make([]xmlListEntry, len(l.Entries))}
+	for i, e := range l.Entries {
+		typ := "file"
+		if e.isDir {
+			typ = "dir"
+		}
+		out.Entries[i] = xmlListEntry{Name: e.name, Path: l.href(e), Type: typ, Size: e.size, ModTime: e.modTime}
+	}
+	io.WriteString(w, xml.Header)
+	enc := xml.NewEncoder(w)
+	enc.Indent("", "  ")
+	enc.Encode(out)
+	io.WriteString(w, "\n")
+}
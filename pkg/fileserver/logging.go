@@ -0,0 +1,117 @@
+package fileserver
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"log/slog"
+	mrand "math/rand/v2"
+	"net/http"
+	"time"
+
+	"github.com/getsentry/sentry-go"
+	"go.opentelemetry.io/otel/trace"
+)
+
+type loggingResponseWriter struct {
+	http.ResponseWriter
+	status int
+	bytes  int64
+	// With traceErrors set, stack is where a 5xx status was written, for
+	// the Sentry report.
+	traceErrors bool
+	stack       *sentry.Stacktrace
+}
+
+func (lrw *loggingResponseWriter) WriteHeader(code int) {
+	lrw.status = code
+	if lrw.traceErrors && code >= 500 && lrw.stack == nil {
+		lrw.stack = sentry.NewStacktrace()
+	}
+	lrw.ResponseWriter.WriteHeader(code)
+}
+
+func (lrw *loggingResponseWriter) Write(b []byte) (int, error) {
+	n, err := lrw.ResponseWriter.Write(b)
+	lrw.bytes += int64(n)
+	return n, err
+}
+
+// Unwrap lets http.ResponseController reach the underlying writer.
+func (lrw *loggingResponseWriter) Unwrap() http.ResponseWriter {
+	return lrw.ResponseWriter
+}
+
+func newRequestID() string {
+	var b [8]byte
+	if _, err := rand.Read(b[:]); err != nil {
+		return fmt.Sprintf("%016x", time.Now().UnixNano())
+	}
+	return hex.EncodeToString(b[:])
+}
+
+// sampled decides whether a successful request is logged. Errors bypass
+// sampling entirely; slow requests are always kept when a threshold is set.
+func sampled(s *Settings, duration time.Duration) bool {
+	if s.LogSlow > 0 && duration >= s.LogSlow {
+		return true
+	}
+	return s.LogSampleRate >= 1 || mrand.Float64() < s.LogSampleRate
+}
+
+func (s *Server) logger(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		reqID := r.Header.Get("X-Request-ID")
+		if reqID == "" {
+			reqID = newRequestID()
+		}
+		w.Header().Set("X-Request-ID", reqID)
+
+		lrw := &loggingResponseWriter{ResponseWriter: w, status: http.StatusOK}
+		var sr *sentryRequest
+		if s.sentry != nil {
+			r, sr = s.sentryStart(r, reqID)
+			lrw.traceErrors = true
+		}
+		next.ServeHTTP(lrw, r)
+
+		if s.accessLog != nil {
+			s.accessLog.Log(r, lrw.status, lrw.bytes, start)
+		}
+
+		duration := time.Since(start)
+		s.stats.Observe(duration, lrw.status)
+		s.requestEvent(r, lrw.status, lrw.bytes, duration, reqID)
+		if s.statsd != nil {
+			s.statsd.observe(r, lrw.status, lrw.bytes, duration)
+		}
+		if sr != nil {
+			s.sentryFinish(sr, r, lrw)
+		}
+		level := slog.LevelInfo
+		if lrw.status >= 500 {
+			level = slog.LevelError
+		} else if lrw.status >= 400 {
+			level = slog.LevelWarn
+		} else if !sampled(s.settings.Load(), duration) {
+			return
+		}
+		attrs := []slog.Attr{
+			slog.String("method", r.Method),
+			slog.String("path", r.URL.Path),
+			slog.Int("status", lrw.status),
+			slog.Duration("duration", duration),
+			slog.Int64("bytes", lrw.bytes),
+			slog.String("remote_ip", RemoteIP(r)),
+			slog.String("request_id", reqID),
+		}
+		if s.geo != nil {
+			attrs = append(attrs, s.geoAttrs(RemoteIP(r))...)
+		}
+		if sc := trace.SpanContextFromContext(r.Context()); sc.IsValid() {
+			attrs = append(attrs, slog.String("trace_id", sc.TraceID().String()))
+		}
+		s.log.LogAttrs(r.Context(), level, "request", attrs...)
+	})
+}
@@ -0,0 +1,351 @@
+package fileserver
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	_ "modernc.org/sqlite" // registers the "sqlite" driver
+)
+
+// metaFlush is how often buffered download counts are written to the
+// metadata store; a crash loses at most this much of them.
+const metaFlush = 10 * time.Second
+
+// metaSchema holds the migrations of the metadata store, applied in order
+// and recorded in its user_version. Append to it; never edit an entry.
+var metaSchema = []string{
+	`CREATE TABLE short_links (
+		code    TEXT PRIMARY KEY,
+		path    TEXT NOT NULL UNIQUE,
+		created INTEGER NOT NULL
+	);
+	CREATE TABLE uploads (
+		id        INTEGER PRIMARY KEY,
+		time      INTEGER NOT NULL,
+		path      TEXT NOT NULL,
+		size      INTEGER NOT NULL,
+		user      TEXT NOT NULL,
+		remote_ip TEXT NOT NULL,
+		protocol  TEXT NOT NULL
+	);
+	CREATE INDEX uploads_path ON uploads (path);
+	CREATE TABLE downloads (
+		path  TEXT PRIMARY KEY,
+		count INTEGER NOT NULL,
+		last  INTEGER NOT NULL
+	);`,
+}
+
+// metaStore is an SQLite database of what the server keeps about files
+// besides the files themselves: short links, an audit trail of uploads
+// and download counts.
+type metaStore struct {
+	db *sql.DB
+
+	mu        sync.Mutex
+	downloads map[string]int64 // counts not yet written, by URL path
+	last      map[string]time.Time
+}
+
+func openMetaStore(file string) (*metaStore, error) {
+	dsn := "file:" + url.PathEscape(file) + "?_pragma=journal_mode(WAL)&_pragma=busy_timeout(5000)&_pragma=synchronous(NORMAL)"
+	db, err := sql.Open("sqlite", dsn)
+	if err != nil {
+		return nil, fmt.Errorf("metadata store %s: %w", file, err)
+	}
+	// One connection serializes writers, which SQLite would otherwise
+	// make wait for its lock.
+	db.SetMaxOpenConns(1)
+	if err := migrateMetaStore(db); err != nil {
+		db.Close()
+		return nil, fmt.Errorf("metadata store %s: %w", file, err)
+	}
+	return &metaStore{db: db, downloads: make(map[string]int64), last: make(map[string]time.Time)}, nil
+}
+
+func migrateMetaStore(db *sql.DB) error {
+	var version int
+	if err := db.QueryRow(`PRAGMA user_version`).Scan(&version); err != nil {
+		return err
+	}
+	if version > len(metaSchema) {
+		return fmt.Errorf("schema version %d is newer than this server knows", version)
+	}
+	for ; version < len(metaSchema); version++ {
+		tx, err := db.Begin()
+		if err != nil {
+			return err
+		}
+		if _, err := tx.Exec(metaSchema[version]); err != nil {
+			tx.Rollback()
+			return fmt.Errorf("migration %d: %w", version+1, err)
+		}
+		if _, err := tx.Exec(`PRAGMA user_version = ` + strconv.Itoa(version+1)); err != nil {
+			tx.Rollback()
+			return err
+		}
+		if err := tx.Commit(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// metaShortLinks keeps short links in the metadata store.
+type metaShortLinks struct {
+	db *sql.DB
+}
+
+func (sl metaShortLinks) get(code string) (string, bool, error) {
+	var p string
+	err := sl.db.QueryRow(`SELECT path FROM short_links WHERE code = ?`, code).Scan(&p)
+	if errors.Is(err, sql.ErrNoRows) {
+		return "", false, nil
+	}
+	return p, err == nil, err
+}
+
+func (sl metaShortLinks) shorten(p string) (code string, created bool, err error) {
+	for {
+		err := sl.db.QueryRow(`SELECT code FROM short_links WHERE path = ?`, p).Scan(&code)
+		if err == nil {
+			return code, false, nil
+		}
+		if !errors.Is(err, sql.ErrNoRows) {
+			return "", false, err
+		}
+		code = newShortCode()
+		res, err := sl.db.Exec(`INSERT INTO short_links (code, path, created) VALUES (?, ?, ?) ON CONFLICT DO NOTHING`,
+			code, p, time.Now().Unix())
+		if err != nil {
+			return "", false, err
+		}
+		// Nothing was inserted when the code was taken, or another request
+		// linked the path meanwhile; the next round tells which.
+		if n, _ := res.RowsAffected(); n == 1 {
+			return code, true, nil
+		}
+	}
+}
+
+// importShortLinks copies the links of a short links file into the store,
+// keeping those the store already has for a code or path.
+func (m *metaStore) importShortLinks(sl *shortLinks) (int, error) {
+	tx, err := m.db.Begin()
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+	now := time.Now().Unix()
+	imported := 0
+	for code, p := range sl.codes {
+		res, err := tx.Exec(`INSERT INTO short_links (code, path, created) VALUES (?, ?, ?) ON CONFLICT DO NOTHING`, code, p, now)
+		if err != nil {
+			return 0, err
+		}
+		n, _ := res.RowsAffected()
+		imported += int(n)
+	}
+	return imported, tx.Commit()
+}
+
+// auditUser names the user behind r for the upload audit trail, or "".
+func auditUser(r *http.Request) string {
+	if u, ok := kerberosUser(r); ok {
+		return u
+	}
+	u, _, _ := r.BasicAuth()
+	return u
+}
+
+// recordUpload appends an upload to the audit trail.
+func (m *metaStore) recordUpload(r *http.Request, urlPath string, size int64) error {
+	_, err := m.db.Exec(`INSERT INTO uploads (time, path, size, user, remote_ip, protocol) VALUES (?, ?, ?, ?, ?, ?)`,
+		time.Now().UnixMilli(), urlPath, size, auditUser(r), RemoteIP(r), r.Proto)
+	return err
+}
+
+// countDownload buffers a download of urlPath until the next flush.
+func (m *metaStore) countDownload(urlPath string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.downloads[urlPath]++
+	m.last[urlPath] = time.Now()
+}
+
+// flush writes the buffered download counts, keeping them for the next
+// flush when that fails.
+func (m *metaStore) flush() error {
+	m.mu.Lock()
+	downloads, last := m.downloads, m.last
+	m.downloads, m.last = make(map[string]int64), make(map[string]time.Time)
+	m.mu.Unlock()
+	if len(downloads) == 0 {
+		return nil
+	}
+	err := func() error {
+		tx, err := m.db.Begin()
+		if err != nil {
+			return err
+		}
+		defer tx.Rollback()
+		for p, n := range downloads {
+			if _, err := tx.Exec(`INSERT INTO downloads (path, count, last) VALUES (?, ?, ?)
+				ON CONFLICT (path) DO UPDATE SET count = count + excluded.count, last = max(last, excluded.last)`,
+				p, n, last[p].UnixMilli()); err != nil {
+				return err
+			}
+		}
+		return tx.Commit()
+	}()
+	if err != nil {
+		m.mu.Lock()
+		for p, n := range downloads {
+			m.downloads[p] += n
+			if last[p].After(m.last[p]) {
+				m.last[p] = last[p]
+			}
+		}
+		m.mu.Unlock()
+	}
+	return err
+}
+
+// flushMeta writes download counts every metaFlush until the server is
+// closed; Close writes the rest. The database stays open for the requests
+// still draining, whose uploads are recorded.
+func (s *Server) flushMeta() {
+	t := time.NewTicker(metaFlush)
+	defer t.Stop()
+	for {
+		select {
+		case <-t.C:
+			if err := s.meta.flush(); err != nil {
+				s.log.Error("Failed to save download counts", "error", err)
+			}
+		case <-s.stop:
+			return
+		}
+	}
+}
+
+// pathUnder returns an SQL LIKE pattern matching the URL paths at or
+// below dir, and "%" for the root.
+func pathUnder(dir string) string {
+	dir = strings.TrimSuffix(dir, "/")
+	if dir == "" {
+		return "%"
+	}
+	r := strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`)
+	return r.Replace(dir) + "/%"
+}
+
+type auditUpload struct {
+	Time     time.Time `json:"time"`
+	Path     string    `json:"path"`
+	Size     int64     `json:"size"`
+	User     string    `json:"user,omitempty"`
+	RemoteIP string    `json:"remote_ip"`
+	Protocol string    `json:"protocol"`
+}
+
+type downloadCount struct {
+	Path  string    `json:"path"`
+	Count int64     `json:"count"`
+	Last  time.Time `json:"last"`
+}
+
+// metaQuery reads the ?path= and ?limit= parameters of the admin
+// endpoints over the metadata store.
+func metaQuery(w http.ResponseWriter, r *http.Request) (dir string, limit int, ok bool) {
+	dir, limit = r.URL.Query().Get("path"), 100
+	if dir == "" {
+		dir = "/"
+	}
+	if v := r.URL.Query().Get("limit"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 1 || n > 10000 {
+			httpError(w, r, "limit must be between 1 and 10000", http.StatusBadRequest)
+			return "", 0, false
+		}
+		limit = n
+	}
+	return dir, limit, true
+}
+
+// adminUploads answers GET /admin/uploads with the newest uploads at or
+// below ?path=.
+func (s *Server) adminUploads(w http.ResponseWriter, r *http.Request) {
+	dir, limit, ok := metaQuery(w, r)
+	if !ok {
+		return
+	}
+	rows, err := s.meta.db.QueryContext(r.Context(), `SELECT time, path, size, user, remote_ip, protocol FROM uploads
+		WHERE path = ? OR path LIKE ? ESCAPE '\' ORDER BY id DESC LIMIT ?`, dir, pathUnder(dir), limit)
+	if err != nil {
+		s.log.Error("Failed to read upload audit trail", "error", err)
+		httpError(w, r, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+	defer rows.Close()
+	uploads := []auditUpload{}
+	for rows.Next() {
+		var u auditUpload
+		var ms int64
+		if err := rows.Scan(&ms, &u.Path, &u.Size, &u.User, &u.RemoteIP, &u.Protocol); err != nil {
+			s.log.Error("Failed to read upload audit trail", "error", err)
+			httpError(w, r, "Internal Server Error", http.StatusInternalServerError)
+			return
+		}
+		u.Time = time.UnixMilli(ms).UTC()
+		uploads = append(uploads, u)
+	}
+	if err := rows.Err(); err != nil {
+		s.log.Error("Failed to read upload audit trail", "error", err)
+		httpError(w, r, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]any{"uploads": uploads})
+}
+
+// adminDownloads answers GET /admin/downloads with the most downloaded
+// files at or below ?path=. Counts of the last metaFlush may be missing.
+func (s *Server) adminDownloads(w http.ResponseWriter, r *http.Request) {
+	dir, limit, ok := metaQuery(w, r)
+	if !ok {
+		return
+	}
+	rows, err := s.meta.db.QueryContext(r.Context(), `SELECT path, count, last FROM downloads
+		WHERE path = ? OR path LIKE ? ESCAPE '\' ORDER BY count DESC, path LIMIT ?`, dir, pathUnder(dir), limit)
+	if err != nil {
+		s.log.Error("Failed to read download counts", "error", err)
+		httpError(w, r, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+	defer rows.Close()
+	downloads := []downloadCount{}
+	for rows.Next() {
+		var d downloadCount
+		var ms int64
+		if err := rows.Scan(&d.Path, &d.Count, &ms); err != nil {
+			s.log.Error("Failed to read download counts", "error", err)
+			httpError(w, r, "Internal Server Error", http.StatusInternalServerError)
+			return
+		}
+		d.Last = time.UnixMilli(ms).UTC()
+		downloads = append(downloads, d)
+	}
+	if err := rows.Err(); err != nil {
+		s.log.Error("Failed to read download counts", "error", err)
+		httpError(w, r, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]any{"downloads": downloads})
+}
@@ -0,0 +1,38 @@
+package fileserver
+
+import (
+	"net/http"
+	"slices"
+	"strings"
+)
+
+// checkMethod lets a request through when its method is one of methods.
+// Otherwise it sets an Allow header listing them and OPTIONS, answers
+// OPTIONS with 204 and anything else with deny, and returns false.
+func checkMethod(w http.ResponseWriter, r *http.Request, methods []string, deny func()) bool {
+	if r.Method != http.MethodOptions && slices.Contains(methods, r.Method) {
+		return true
+	}
+	allow := append([]string{http.MethodOptions}, methods...)
+	slices.Sort(allow)
+	w.Header().Set("Allow", strings.Join(slices.Compact(allow), ", "))
+	if r.Method == http.MethodOptions {
+		w.WriteHeader(http.StatusNoContent)
+		return false
+	}
+	deny()
+	return false
+}
+
+// allowMethods is checkMethod for pages, answering 405 with an error page.
+func (s *Server) allowMethods(w http.ResponseWriter, r *http.Request, methods ...string) bool {
+	return checkMethod(w, r, methods, func() { s.renderError(w, r, http.StatusMethodNotAllowed) })
+}
+
+// allowAPIMethods is checkMethod for JSON endpoints, answering 405 with an
+// API error.
+func allowAPIMethods(w http.ResponseWriter, r *http.Request, methods ...string) bool {
+	return checkMethod(w, r, methods, func() {
+		writeAPIError(w, http.StatusMethodNotAllowed, "method "+r.Method+" not allowed", nil)
+	})
+}
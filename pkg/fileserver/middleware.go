@@ -0,0 +1,109 @@
+package fileserver
+
+import (
+	"fmt"
+	"net/http"
+	"runtime/debug"
+	"sort"
+	"sync"
+)
+
+// Middleware wraps an http.Handler.
+type Middleware func(http.Handler) http.Handler
+
+// middlewareRegistry maps names usable in Options.Middleware to
+// constructors. Constructors receive the Server so they can use its
+// settings and logger.
+var (
+	middlewareMu       sync.RWMutex
+	middlewareRegistry = map[string]func(*Server) (Middleware, error){
+		"realip":    func(s *Server) (Middleware, error) { return s.realIP, nil },
+		"tracing":   func(s *Server) (Middleware, error) { return s.tracing, nil },
+		"logging":   func(s *Server) (Middleware, error) { return s.logger, nil },
+		"recovery":  func(s *Server) (Middleware, error) { return s.recovery, nil },
+		"headers":   func(s *Server) (Middleware, error) { return s.secureHeaders, nil },
+		"normalize": func(s *Server) (Middleware, error) { return s.normalize, nil },
+		"geoip":     func(s *Server) (Middleware, error) { return s.geoRules, nil },
+		"auth":      func(s *Server) (Middleware, error) { return s.basicAuth, nil },
+		"ratelimit": (*Server).rateLimitMiddleware,
+		"compress":  func(s *Server) (Middleware, error) { return s.compress, nil },
+		"plugins":   func(s *Server) (Middleware, error) { return s.runPlugins, nil },
+	}
+)
+
+// DefaultMiddleware is the chain used when Options.Middleware is empty,
+// outermost first.
+var DefaultMiddleware = []string{"realip", "tracing", "logging", "recovery", "headers", "normalize", "geoip", "auth", "plugins"}
+
+// RegisterMiddleware makes a middleware available by name to
+// Options.Middleware. It panics if the name is already taken.
+func RegisterMiddleware(name string, constructor func(*Server) (Middleware, error)) {
+	middlewareMu.Lock()
+	defer middlewareMu.Unlock()
+	if _, dup := middlewareRegistry[name]; dup {
+		panic("fileserver: middleware " + name + " registered twice")
+	}
+	middlewareRegistry[name] = constructor
+}
+
+// MiddlewareNames lists the registered middleware.
+func MiddlewareNames() []string {
+	middlewareMu.RLock()
+	defer middlewareMu.RUnlock()
+	return middlewareNames()
+}
+
+// middlewareNames requires middlewareMu to be held.
+func middlewareNames() []string {
+	names := make([]string, 0, len(middlewareRegistry))
+	for name := range middlewareRegistry {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// buildChain wraps h in the named middleware; the first name is outermost.
+func (s *Server) buildChain(names []string, h http.Handler) (http.Handler, error) {
+	middlewareMu.RLock()
+	defer middlewareMu.RUnlock()
+	seen := make(map[string]bool)
+	chain := make([]Middleware, len(names))
+	for i, name := range names {
+		if seen[name] {
+			return nil, fmt.Errorf("middleware %q listed twice", name)
+		}
+		seen[name] = true
+		constructor, ok := middlewareRegistry[name]
+		if !ok {
+			return nil, fmt.Errorf("unknown middleware %q (have %v)", name, middlewareNames())
+		}
+		mw, err := constructor(s)
+		if err != nil {
+			return nil, fmt.Errorf("middleware %s: %w", name, err)
+		}
+		chain[i] = mw
+	}
+	for i := len(chain) - 1; i >= 0; i-- {
+		h = chain[i](h)
+	}
+	return h, nil
+}
+
+// recovery turns a handler panic into a logged 500 instead of a dropped
+// connection.
+func (s *Server) recovery(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		defer func() {
+			if v := recover(); v != nil {
+				if v == http.ErrAbortHandler {
+					panic(v)
+				}
+				s.log.Error("Handler panic", "path", r.URL.Path, "panic", fmt.Sprint(v), "stack", string(debug.Stack()))
+				s.reportPanic(r, v)
+				s.renderError(w, r, http.StatusInternalServerError)
+			}
+		}()
+		next.ServeHTTP(w, r)
+	})
+}
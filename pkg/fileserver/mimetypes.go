@@ -0,0 +1,100 @@
+package fileserver
+
+import (
+	"bufio"
+	"fmt"
+	"mime"
+	"os"
+	"path"
+	"strings"
+)
+
+// DefaultMIMETypes are served for their extensions whatever the host's
+// registry says: Windows installations often map .js to text/plain, and
+// older /etc/mime.types files lack .wasm, .mjs or .avif. Options.MIMETypes
+// and Options.MIMETypesFile add to and override them.
+var DefaultMIMETypes = map[string]string{
+	".html": "text/html; charset=utf-8",
+	".css":  "text/css; charset=utf-8",
+	".js":   "text/javascript; charset=utf-8",
+	".mjs":  "text/javascript; charset=utf-8",
+	".json": "application/json",
+	".wasm": "application/wasm",
+	".svg":  "image/svg+xml",
+	".avif": "image/avif",
+	".webp": "image/webp",
+}
+
+// compileMIMETypes merges the defaults, a mime.types file and explicit
+// types, later ones winning, into a table by lower-case extension.
+func compileMIMETypes(types map[string]string, file string) (map[string]string, error) {
+	out := make(map[string]string, len(DefaultMIMETypes)+len(types))
+	for ext, typ := range DefaultMIMETypes {
+		out[ext] = typ
+	}
+	if file != "" {
+		fromFile, err := readMIMETypes(file)
+		if err != nil {
+			return nil, err
+		}
+		for ext, typ := range fromFile {
+			out[ext] = typ
+		}
+	}
+	for ext, typ := range types {
+		if !strings.HasPrefix(ext, ".") {
+			return nil, fmt.Errorf("mime types: extension %q must start with a dot", ext)
+		}
+		if _, _, err := mime.ParseMediaType(typ); err != nil {
+			return nil, fmt.Errorf("mime type for %s: %q: %w", ext, typ, err)
+		}
+		out[strings.ToLower(ext)] = typ
+	}
+	return out, nil
+}
+
+// readMIMETypes reads a file in the mime.types format of Apache and nginx:
+// a media type followed by its extensions, without dots, per line, with #
+// starting comments.
+func readMIMETypes(name string) (map[string]string, error) {
+	f, err := os.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	types := make(map[string]string)
+	sc := bufio.NewScanner(f)
+	for n := 1; sc.Scan(); n++ {
+		line, _, _ := strings.Cut(sc.Text(), "#")
+		fields := strings.Fields(line)
+		if len(fields) == 0 {
+			continue
+		}
+		if _, _, err := mime.ParseMediaType(fields[0]); err != nil {
+			return nil, fmt.Errorf("%s:%d: %q: %w", name, n, fields[0], err)
+		}
+		for _, ext := range fields[1:] {
+			types["."+strings.ToLower(strings.TrimPrefix(ext, "."))] = fields[0]
+		}
+	}
+	return types, sc.Err()
+}
+
+// configuredType reports whether the type of a file name comes from
+// Options.MIMETypes or MIMETypesFile rather than a default.
+func (s *Server) configuredType(name string) bool {
+	ext := strings.ToLower(path.Ext(name))
+	typ, ok := s.mimeTypes[ext]
+	return ok && typ != DefaultMIMETypes[ext]
+}
+
+// mimeType returns the media type served for a file name: from the
+// server's table, else from the host's registry, else "" to leave it to
+// content sniffing.
+func (s *Server) mimeType(name string) string {
+	ext := strings.ToLower(path.Ext(name))
+	if typ, ok := s.mimeTypes[ext]; ok {
+		return typ
+	}
+	return mime.TypeByExtension(ext)
+}
@@ -0,0 +1,154 @@
+package fileserver
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"strings"
+	"time"
+)
+
+// S3Mirror copies every upload into a bucket of an S3-compatible object
+// store, such as AWS S3 or MinIO.
+type S3Mirror struct {
+	// Endpoint is the service URL, such as https://s3.eu-west-1.amazonaws.com
+	// or http://127.0.0.1:9000. Objects are addressed path-style below it.
+	Endpoint  string
+	Bucket    string
+	Region    string // defaults to us-east-1
+	AccessKey string
+	SecretKey string
+	// Prefix is put before the URL path of an upload, less its leading
+	// slash, to make its object key.
+	Prefix string
+}
+
+// Mirror statuses reported in upload responses.
+const (
+	mirrorOK     = "mirrored"
+	mirrorFailed = "failed"
+)
+
+const (
+	mirrorAttempts = 3
+	mirrorBackoff  = time.Second // doubled after each failed attempt
+	// mirrorTimeout bounds one attempt to copy a file, including its body.
+	mirrorTimeout = 10 * time.Minute
+)
+
+type s3Mirror struct {
+	S3Mirror
+	endpoint *url.URL
+	client   *http.Client
+}
+
+func newS3Mirror(m S3Mirror) (*s3Mirror, error) {
+	u, err := url.Parse(m.Endpoint)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return nil, fmt.Errorf("mirror endpoint %q: want an http or https URL", m.Endpoint)
+	}
+	if m.Bucket == "" || m.AccessKey == "" || m.SecretKey == "" {
+		return nil, fmt.Errorf("mirror %s: bucket, access key and secret key are required", m.Endpoint)
+	}
+	if m.Region == "" {
+		m.Region = "us-east-1"
+	}
+	m.Prefix = strings.Trim(m.Prefix, "/")
+	return &s3Mirror{S3Mirror: m, endpoint: u, client: &http.Client{Timeout: mirrorTimeout}}, nil
+}
+
+// mirrorUpload copies an uploaded file to the mirror bucket, retrying with
+// backoff after network errors, 429 and 5xx responses. It returns the
+// status for the upload response, or "" without a mirror.
+func (s *Server) mirrorUpload(ctx context.Context, fsPath string) string {
+	if s.mirror == nil {
+		return ""
+	}
+	urlPath := s.urlPath(fsPath)
+	key := path.Join(s.mirror.Prefix, strings.TrimPrefix(urlPath, "/"))
+	backoff := mirrorBackoff
+	for attempt := 1; ; attempt++ {
+		status, err := s.mirror.put(ctx, key, fsPath, s.mimeType(fsPath))
+		if err == nil && status < 300 {
+			s.log.Debug("Upload mirrored", "path", urlPath, "key", key)
+			return mirrorOK
+		}
+		retry := err != nil || status == http.StatusTooManyRequests || status >= 500
+		if !retry || attempt == mirrorAttempts {
+			s.log.Warn("Mirroring upload failed", "path", urlPath, "key", key, "attempts", attempt, "status", status, "error", err)
+			return mirrorFailed
+		}
+		select {
+		case <-time.After(backoff):
+			backoff *= 2
+		case <-ctx.Done():
+			s.log.Warn("Mirroring upload failed", "path", urlPath, "key", key, "attempts", attempt, "error", ctx.Err())
+			return mirrorFailed
+		case <-s.stop:
+			return mirrorFailed
+		}
+	}
+}
+
+// mirrorLater mirrors an upload in the background, for protocols whose
+// responses have no room for the outcome.
+func (s *Server) mirrorLater(fsPath string) {
+	if s.mirror != nil {
+		go s.mirrorUpload(context.Background(), fsPath)
+	}
+}
+
+// put uploads one file with a SigV4-signed PutObject request.
+func (m *s3Mirror) put(ctx context.Context, key, fsPath, contentType string) (int, error) {
+	f, err := os.Open(fsPath)
+	if err != nil {
+		return 0, err
+	}
+	defer f.Close()
+	h := sha256.New()
+	size, err := io.Copy(h, f)
+	if err != nil {
+		return 0, err
+	}
+	if _, err := f.Seek(0, io.SeekStart); err != nil {
+		return 0, err
+	}
+	payload := hex.EncodeToString(h.Sum(nil))
+
+	uri := awsURIEncode(path.Join("/", m.endpoint.Path, m.Bucket, key), false)
+	req, err := http.NewRequestWithContext(ctx, http.MethodPut, m.endpoint.Scheme+"://"+m.endpoint.Host+uri, io.LimitReader(f, size))
+	if err != nil {
+		return 0, err
+	}
+	req.ContentLength = size
+	if contentType != "" {
+		req.Header.Set("Content-Type", contentType)
+	}
+	now := time.Now().UTC()
+	sig := &sigV4{amzDate: now.Format(sigV4TimeFormat), scope: now.Format("20060102") + "/" + m.Region + "/s3/aws4_request"}
+	sig.key = []byte("AWS4" + m.SecretKey)
+	for _, part := range strings.Split(sig.scope, "/") {
+		sig.key = hmacSHA256(sig.key, part)
+	}
+	req.Header.Set("X-Amz-Date", sig.amzDate)
+	req.Header.Set("X-Amz-Content-Sha256", payload)
+	const signed = "host;x-amz-content-sha256;x-amz-date"
+	canon := fmt.Sprintf("PUT\n%s\n\nhost:%s\nx-amz-content-sha256:%s\nx-amz-date:%s\n\n%s\n%s",
+		uri, m.endpoint.Host, payload, sig.amzDate, signed, payload)
+	req.Header.Set("Authorization", fmt.Sprintf("%s Credential=%s/%s, SignedHeaders=%s, Signature=%s",
+		sigV4Algorithm, m.AccessKey, sig.scope, signed, sig.sign(sigV4Algorithm, sha256Hex([]byte(canon)))))
+
+	resp, err := m.client.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	io.Copy(io.Discard, io.LimitReader(resp.Body, 64<<10))
+	resp.Body.Close()
+	return resp.StatusCode, nil
+}
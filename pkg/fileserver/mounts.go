@@ -0,0 +1,159 @@
+package fileserver
+
+import (
+	"fmt"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// HiddenPolicy controls how dotfiles under a mount are treated.
+type HiddenPolicy string
+
+const (
+	HiddenHide HiddenPolicy = "hide" // omitted from listings but servable (the default)
+	HiddenShow HiddenPolicy = "show" // listed like any other file
+	HiddenDeny HiddenPolicy = "deny" // omitted from listings and answered with 404
+)
+
+// Mount exposes a directory under a URL prefix.
+type Mount struct {
+	Prefix string // URL path prefix, e.g. "/downloads"
+	// Dir is the served directory. Symbolic links below it are followed
+	// only as long as they stay inside it.
+	Dir string
+	// FS, when set, is served instead of the directory Dir, which then
+	// only names the mount in logs and plugin hooks. Such mounts are
+	// read-only; OpenArchive and OpenRemote make them from archives and
+	// from remote directories.
+	FS fs.FS
+	// ReadWrite allows write operations (uploads, edits, deletes) under
+	// the mount; mounts are read-only by default.
+	ReadWrite bool
+	Hidden    HiddenPolicy
+
+	fsys     fs.FS  // FS, or Dir opened with os.DirFS
+	trash    bool   // deletions go to its .trash; see trash.go
+	versions bool   // overwritten files are kept in .versions; see versions.go
+	realDir  string // Dir with symbolic links resolved, for containment checks
+}
+
+// newMounts validates ms, adds the root directory, or rootFS when set, at
+// "/" unless a mount already covers it, and sorts them longest prefix
+// first.
+func newMounts(root string, rootFS fs.FS, ms []Mount) ([]*Mount, error) {
+	var out []*Mount
+	seen := make(map[string]bool)
+	for _, m := range ms {
+		if !strings.HasPrefix(m.Prefix, "/") {
+			return nil, fmt.Errorf("mount prefix %q must start with /", m.Prefix)
+		}
+		m.Prefix = path.Clean(m.Prefix)
+		if seen[m.Prefix] {
+			return nil, fmt.Errorf("mount prefix %q used twice", m.Prefix)
+		}
+		seen[m.Prefix] = true
+		switch m.Hidden {
+		case "":
+			m.Hidden = HiddenHide
+		case HiddenHide, HiddenShow, HiddenDeny:
+		default:
+			return nil, fmt.Errorf("mount %s: invalid hidden policy %q (want hide, show or deny)", m.Prefix, m.Hidden)
+		}
+		if m.FS != nil && m.ReadWrite {
+			return nil, fmt.Errorf("mount %s: only directory mounts can be read-write", m.Prefix)
+		}
+		if m.FS != nil && m.Dir == "" {
+			m.Dir = "fs:" + m.Prefix
+		} else {
+			dir, err := filepath.Abs(m.Dir)
+			if err != nil {
+				return nil, fmt.Errorf("mount %s: %w", m.Prefix, err)
+			}
+			m.Dir = dir
+		}
+		out = append(out, newMount(m))
+	}
+	if !seen["/"] {
+		dir := root
+		if rootFS != nil && dir == "" {
+			dir = "fs:/"
+		}
+		out = append(out, newMount(Mount{Prefix: "/", Dir: dir, FS: rootFS, Hidden: HiddenHide}))
+	}
+	sort.Slice(out, func(i, j int) bool { return len(out[i].Prefix) > len(out[j].Prefix) })
+	return out, nil
+}
+
+func newMount(m Mount) *Mount {
+	m.fsys = m.FS
+	if m.fsys == nil {
+		m.fsys = os.DirFS(m.Dir)
+		m.realDir = realDir(m.Dir)
+	}
+	return &m
+}
+
+// resolve finds the mount serving urlPath (already cleaned) and the
+// corresponding filesystem path. ok is false when the path escapes the
+// mount's directory, also through a symbolic link, is in one of its
+// internal directories or is excluded by Options.Exclude.
+func (s *Server) resolve(urlPath string) (m *Mount, fsPath string, ok bool) {
+	for _, m := range s.mounts {
+		rest, found := strings.CutPrefix(urlPath, m.Prefix)
+		if !found || (m.Prefix != "/" && rest != "" && !strings.HasPrefix(rest, "/")) {
+			continue
+		}
+		fsPath := filepath.Join(m.Dir, filepath.FromSlash(rest))
+		if !within(m.Dir, fsPath) || (m.FS == nil && !contained(m.realDir, fsPath)) || m.internal(urlPath) || s.excluded(urlPath) {
+			return m, "", false
+		}
+		return m, fsPath, true
+	}
+	return nil, "", false
+}
+
+// hiddenPath reports whether any element of the URL path below the mount
+// prefix is a dotfile.
+func (m *Mount) hiddenPath(urlPath string) bool {
+	rest := strings.TrimPrefix(urlPath, m.Prefix)
+	for _, elem := range strings.Split(rest, "/") {
+		if strings.HasPrefix(elem, ".") && elem != "." && elem != ".." {
+			return true
+		}
+	}
+	return false
+}
+
+// internal reports whether urlPath is in a directory the server keeps for
+// itself at the root of the mount, the trash or the version store, which
+// are never served, listed or written like the rest of it.
+func (m *Mount) internal(urlPath string) bool {
+	rest := strings.TrimPrefix(strings.TrimPrefix(urlPath, m.Prefix), "/")
+	first, _, _ := strings.Cut(rest, "/")
+	return (m.trash && first == trashName) || (m.versions && first == versionsName)
+}
+
+// childMounts returns the names of mounts that appear directly inside the
+// directory listed at urlPath.
+func (s *Server) childMounts(urlPath string) []string {
+	var names []string
+	for _, m := range s.mounts {
+		if m.Prefix != "/" && path.Dir(m.Prefix) == urlPath {
+			names = append(names, path.Base(m.Prefix))
+		}
+	}
+	return names
+}
+
+// Mounts returns the mounts in effect, longest prefix first.
+func (s *Server) Mounts() []Mount {
+	out := make([]Mount, len(s.mounts))
+	for i, m := range s.mounts {
+		out[i] = *m
+	}
+	return out
+}
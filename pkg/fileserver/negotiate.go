@@ -0,0 +1,65 @@
+package fileserver
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strings"
+
+	"github.com/jcmturner/goidentity/v6"
+	"github.com/jcmturner/gokrb5/v8/keytab"
+	"github.com/jcmturner/gokrb5/v8/service"
+	"github.com/jcmturner/gokrb5/v8/spnego"
+)
+
+// kerberosUserKey is the request context key of the user authenticated
+// with Negotiate, as user@REALM.
+type kerberosUserKey struct{}
+
+func loadKeytab(path string) (*keytab.Keytab, error) {
+	kt, err := keytab.Load(path)
+	if err != nil {
+		return nil, fmt.Errorf("load keytab %s: %w", path, err)
+	}
+	if len(kt.Entries) == 0 {
+		return nil, fmt.Errorf("keytab %s has no entries", path)
+	}
+	return kt, nil
+}
+
+// negotiating reports whether r carries a Negotiate (SPNEGO) token to be
+// checked against the keytab.
+func (s *Server) negotiating(r *http.Request) bool {
+	scheme, _, _ := strings.Cut(r.Header.Get("Authorization"), " ")
+	return s.keytab != nil && strings.EqualFold(scheme, "Negotiate")
+}
+
+// negotiate returns a handler that authenticates requests carrying a
+// Kerberos ticket in a Negotiate header, as browsers on domain-joined
+// machines send after a "WWW-Authenticate: Negotiate" challenge, before
+// passing them on to next.
+func (s *Server) negotiate(next http.Handler) http.Handler {
+	// gokrb5 logs each success and the reason for each failure.
+	settings := []func(*service.Settings){
+		service.Logger(slog.NewLogLogger(s.log.Handler(), slog.LevelDebug)),
+	}
+	if s.keytabPrincipal != "" {
+		settings = append(settings, service.KeytabPrincipal(s.keytabPrincipal))
+	}
+	return spnego.SPNEGOKRB5Authenticate(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id := goidentity.FromHTTPRequestContext(r)
+		if id == nil || !id.Authenticated() {
+			s.renderError(w, r, http.StatusUnauthorized)
+			return
+		}
+		user := id.UserName() + "@" + id.Domain()
+		next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), kerberosUserKey{}, user)))
+	}), s.keytab, settings...)
+}
+
+// kerberosUser returns the user authenticated with Negotiate, if any.
+func kerberosUser(r *http.Request) (string, bool) {
+	user, ok := r.Context().Value(kerberosUserKey{}).(string)
+	return user, ok
+}
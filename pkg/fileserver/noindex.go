@@ -0,0 +1,60 @@
+package fileserver
+
+import (
+	"fmt"
+	"io/fs"
+	"net/http"
+	"path"
+)
+
+// noIndexName is the marker file that opts a directory out of listings.
+const noIndexName = ".noindex"
+
+// NoIndexPolicy controls how directories holding a .noindex file are
+// treated.
+type NoIndexPolicy string
+
+const (
+	NoIndexForbid NoIndexPolicy = "forbid" // listings answered with 403, files servable (the default)
+	NoIndexHide   NoIndexPolicy = "hide"   // listings answered with 404, files servable
+	NoIndexDeny   NoIndexPolicy = "deny"   // the directory and everything below it answered with 404
+	NoIndexOff    NoIndexPolicy = "off"    // the marker is ignored
+)
+
+// errNoIndex is returned for listings of a directory with a .noindex file.
+// It counts as a permission error for the gRPC, S3 and SFTP servers.
+var errNoIndex = fmt.Errorf("directory not listable: %w", fs.ErrPermission)
+
+// noIndexed reports whether the directory at name, a name in the mount's
+// fs.FS, opts out of listings.
+func (s *Server) noIndexed(m *Mount, name string) bool {
+	if s.noIndex == NoIndexOff {
+		return false
+	}
+	_, err := fs.Stat(m.fsys, path.Join(name, noIndexName))
+	return err == nil
+}
+
+// noIndexDenied reports whether, under NoIndexDeny, the file or directory
+// at fsPath is in or below a directory of its mount with a .noindex file.
+func (s *Server) noIndexDenied(m *Mount, fsPath string) bool {
+	if s.noIndex != NoIndexDeny {
+		return false
+	}
+	for name := m.name(fsPath); ; name = path.Dir(name) {
+		if s.noIndexed(m, name) {
+			return true
+		}
+		if name == "." {
+			return false
+		}
+	}
+}
+
+// noIndexStatus is the status of a listing refused for a .noindex file.
+func (s *Server) noIndexStatus() int {
+	if s.noIndex == NoIndexForbid {
+		return http.StatusForbidden
+	}
+	return http.StatusNotFound
+}
@@ -0,0 +1,74 @@
+package fileserver
+
+import (
+	"net/http"
+	"net/url"
+	"path"
+	"slices"
+	"strings"
+)
+
+// normalize puts request paths in canonical form before routing:
+// duplicate slashes collapse and "." and ".." segments resolve, keeping a
+// trailing slash. GET and HEAD requests for another form are redirected to
+// the canonical URL; other methods get 400, as a redirect would lose their
+// body. Paths with an encoded NUL, or a dot segment hidden by percent
+// encoding such as %2e%2e or ..%2f, are rejected outright.
+func (s *Server) normalize(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		p := r.URL.Path
+		if !strings.HasPrefix(p, "/") {
+			// Such as "*" in OPTIONS * or a CONNECT authority.
+			next.ServeHTTP(w, r)
+			return
+		}
+		if suspiciousPath(r.URL.EscapedPath()) {
+			s.log.Warn("Rejected suspicious path", "path", r.URL.EscapedPath(), "remote_ip", RemoteIP(r))
+			s.securityRequestEvent(securityPathTraversal, r, "http", "")
+			s.renderError(w, r, http.StatusBadRequest)
+			return
+		}
+		if slices.Contains(strings.Split(p, "/"), "..") {
+			// Browsers resolve these themselves; only tools and scanners
+			// send them.
+			s.securityRequestEvent(securityPathTraversal, r, "http", "")
+		}
+		canonical := path.Clean(p)
+		if strings.HasSuffix(p, "/") && canonical != "/" {
+			canonical += "/"
+		}
+		if canonical == p {
+			next.ServeHTTP(w, r)
+			return
+		}
+		if r.Method != http.MethodGet && r.Method != http.MethodHead {
+			s.renderError(w, r, http.StatusBadRequest)
+			return
+		}
+		target := escapePath(canonical)
+		if r.URL.RawQuery != "" {
+			target += "?" + r.URL.RawQuery
+		}
+		http.Redirect(w, r, target, http.StatusMovedPermanently)
+	})
+}
+
+// suspiciousPath reports whether an escaped URL path has a segment that
+// decodes to a NUL byte or hides "." or ".." behind percent encoding.
+func suspiciousPath(escaped string) bool {
+	for _, seg := range strings.Split(escaped, "/") {
+		if !strings.Contains(seg, "%") {
+			continue
+		}
+		dec, err := url.PathUnescape(seg)
+		if err != nil || strings.ContainsRune(dec, 0) {
+			return true
+		}
+		for _, part := range strings.FieldsFunc(dec, func(c rune) bool { return c == '/' || c == '\\' }) {
+			if part == "." || part == ".." {
+				return true
+			}
+		}
+	}
+	return false
+}
@@ -0,0 +1,10 @@
+//go:build !unix
+
+package fileserver
+
+import "io/fs"
+
+// fileOwner reports no owner where files lack Unix owners and groups.
+func fileOwner(info fs.FileInfo) (owner, group string) {
+	return "", ""
+}
@@ -0,0 +1,42 @@
+//go:build unix
+
+package fileserver
+
+import (
+	"io/fs"
+	"os/user"
+	"strconv"
+	"sync"
+	"syscall"
+)
+
+// ownerNames caches user and group names by "u<uid>" and "g<gid>", as
+// listings look up the same few IDs over and over.
+var ownerNames sync.Map
+
+// fileOwner returns the names of a file's owner and group, or their
+// numeric IDs when unknown, for example after a chroot without
+// /etc/passwd.
+func fileOwner(info fs.FileInfo) (owner, group string) {
+	st, ok := info.Sys().(*syscall.Stat_t)
+	if !ok {
+		return "", ""
+	}
+	return ownerName("u", strconv.FormatUint(uint64(st.Uid), 10)), ownerName("g", strconv.FormatUint(uint64(st.Gid), 10))
+}
+
+func ownerName(kind, id string) string {
+	if name, ok := ownerNames.Load(kind + id); ok {
+		return name.(string)
+	}
+	name := id
+	if kind == "u" {
+		if u, err := user.LookupId(id); err == nil {
+			name = u.Username
+		}
+	} else if g, err := user.LookupGroupId(id); err == nil {
+		name = g.Name
+	}
+	ownerNames.Store(kind+id, name)
+	return name
+}
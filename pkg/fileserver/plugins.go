@@ -0,0 +1,121 @@
+package fileserver
+
+import (
+	"io/fs"
+	"net/http"
+	"strings"
+	"sync"
+)
+
+// Plugin extends a Server. Besides Name, a plugin implements any of the
+// hook interfaces RequestHook, FileServedHook, UploadHook and ErrorHook;
+// hooks run in registration order. Plugins are added per server with
+// Options.Plugins, or for every server with RegisterPlugin, typically from
+// an init function of a compiled-in package.
+type Plugin interface {
+	Name() string
+}
+
+// RequestHook runs for every request that reaches the "plugins"
+// middleware. Returning false stops processing; the hook must then have
+// written a response, e.g. a 401 from a custom authentication scheme.
+type RequestHook interface {
+	OnRequest(w http.ResponseWriter, r *http.Request) bool
+}
+
+// FileServedHook runs after a file's content has been sent.
+type FileServedHook interface {
+	OnFileServed(r *http.Request, path string, info fs.FileInfo)
+}
+
+// UploadHook runs after an upload has been stored at path.
+type UploadHook interface {
+	OnUpload(r *http.Request, path string, size int64)
+}
+
+// ErrorHook runs whenever an error page is rendered.
+type ErrorHook interface {
+	OnError(r *http.Request, status int)
+}
+
+var (
+	pluginsMu     sync.Mutex
+	globalPlugins []Plugin
+)
+
+// RegisterPlugin adds p to every Server created afterwards.
+func RegisterPlugin(p Plugin) {
+	pluginsMu.Lock()
+	defer pluginsMu.Unlock()
+	globalPlugins = append(globalPlugins, p)
+}
+
+func registeredPlugins() []Plugin {
+	pluginsMu.Lock()
+	defer pluginsMu.Unlock()
+	return append([]Plugin(nil), globalPlugins...)
+}
+
+// Plugins returns the names of the plugins active on s.
+func (s *Server) Plugins() []string {
+	names := make([]string, len(s.plugins))
+	for i, p := range s.plugins {
+		names[i] = p.Name()
+	}
+	return names
+}
+
+// runPlugins is the "plugins" middleware, dispatching RequestHooks.
+func (s *Server) runPlugins(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		for _, p := range s.plugins {
+			if h, ok := p.(RequestHook); ok && !h.OnRequest(w, r) {
+				return
+			}
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+func (s *Server) fileServed(r *http.Request, path string, info fs.FileInfo) {
+	for _, p := range s.plugins {
+		if h, ok := p.(FileServedHook); ok {
+			h.OnFileServed(r, path, info)
+		}
+	}
+	// Of ranged requests, only those from the start count, so that a
+	// player seeking through a video is one download.
+	if s.meta != nil && r.Method == http.MethodGet {
+		if rng := r.Header.Get("Range"); rng == "" || strings.HasPrefix(rng, "bytes=0-") {
+			s.meta.countDownload(s.urlPath(path))
+		}
+	}
+}
+
+func (s *Server) uploaded(r *http.Request, path string, size int64) {
+	for _, p := range s.plugins {
+		if h, ok := p.(UploadHook); ok {
+			h.OnUpload(r, path, size)
+		}
+	}
+	if s.recent != nil {
+		s.recent.add(s.urlPath(path), size)
+	}
+	if s.dirSizes != nil {
+		s.dirSizes.invalidate(s.urlPath(path))
+	}
+	if s.meta != nil {
+		if err := s.meta.recordUpload(r, s.urlPath(path), size); err != nil {
+			s.log.Error("Failed to record upload", "path", s.urlPath(path), "error", err)
+		}
+	}
+	s.Publish(Event{Type: "upload", Path: s.urlPath(path), Size: size})
+}
+
+func (s *Server) errored(r *http.Request, status int) {
+	for _, p := range s.plugins {
+		if h, ok := p.(ErrorHook); ok {
+			h.OnError(r, status)
+		}
+	}
+}
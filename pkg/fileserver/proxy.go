@@ -0,0 +1,100 @@
+package fileserver
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/httputil"
+	"net/url"
+	"path"
+	"strings"
+	"time"
+)
+
+const defaultProxyTimeout = 30 * time.Second
+
+// ProxyRoute forwards requests under a URL prefix to an upstream HTTP
+// server.
+type ProxyRoute struct {
+	Prefix   string // e.g. "/api/"; a missing trailing slash is added
+	Upstream string // e.g. "http://127.0.0.1:9000"
+	// StripPrefix removes Prefix from the path sent upstream.
+	StripPrefix bool
+	// PreserveHost sends the client's Host header instead of the
+	// upstream's.
+	PreserveHost bool
+	// Timeout bounds connecting to the upstream and waiting for its
+	// response headers. It defaults to 30s.
+	Timeout time.Duration
+	// RequestHeaders and ResponseHeaders are set on the upstream request
+	// and on the response; an empty value removes the header.
+	RequestHeaders  map[string]string
+	ResponseHeaders map[string]string
+}
+
+// proxyHandler builds the reverse proxy for one route.
+func (s *Server) proxyHandler(pr ProxyRoute) (string, http.Handler, error) {
+	if !strings.HasPrefix(pr.Prefix, "/") {
+		return "", nil, fmt.Errorf("proxy prefix %q must start with /", pr.Prefix)
+	}
+	prefix := pr.Prefix
+	if !strings.HasSuffix(prefix, "/") {
+		prefix += "/"
+	}
+	if prefix == "/" || s.isReserved(path.Clean(prefix)) {
+		return "", nil, fmt.Errorf("proxy prefix %q is reserved", pr.Prefix)
+	}
+	target, err := url.Parse(pr.Upstream)
+	if err != nil || (target.Scheme != "http" && target.Scheme != "https") || target.Host == "" {
+		return "", nil, fmt.Errorf("proxy %s: invalid upstream %q", prefix, pr.Upstream)
+	}
+	timeout := pr.Timeout
+	if timeout == 0 {
+		timeout = defaultProxyTimeout
+	}
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.DialContext = (&net.Dialer{Timeout: timeout, KeepAlive: 30 * time.Second}).DialContext
+	transport.ResponseHeaderTimeout = timeout
+
+	rp := &httputil.ReverseProxy{
+		Transport: transport,
+		Rewrite: func(r *httputil.ProxyRequest) {
+			if pr.StripPrefix {
+				r.Out.URL.Path = "/" + strings.TrimPrefix(r.Out.URL.Path, prefix)
+				r.Out.URL.RawPath = ""
+			}
+			r.SetURL(target)
+			r.SetXForwarded()
+			if pr.PreserveHost {
+				r.Out.Host = r.In.Host
+			}
+			setHeaders(r.Out.Header, pr.RequestHeaders)
+		},
+		ModifyResponse: func(resp *http.Response) error {
+			setHeaders(resp.Header, pr.ResponseHeaders)
+			return nil
+		},
+		ErrorHandler: func(w http.ResponseWriter, r *http.Request, err error) {
+			status := http.StatusBadGateway
+			var ne net.Error
+			if errors.Is(err, context.DeadlineExceeded) || (errors.As(err, &ne) && ne.Timeout()) {
+				status = http.StatusGatewayTimeout
+			}
+			s.log.Warn("Proxy request failed", "upstream", target.Host, "path", r.URL.Path, "error", err)
+			s.renderError(w, r, status)
+		},
+	}
+	return prefix, rp, nil
+}
+
+func setHeaders(h http.Header, set map[string]string) {
+	for k, v := range set {
+		if v == "" {
+			h.Del(k)
+		} else {
+			h.Set(k, v)
+		}
+	}
+}
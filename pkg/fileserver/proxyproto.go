@@ -0,0 +1,183 @@
+package fileserver
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ProxyListener wraps ln so that every connection must begin with a
+// HAProxy PROXY protocol header (v1 text or v2 binary); the original client
+// address becomes the connection's RemoteAddr. When trusted proxies are
+// configured, connections from other peers are rejected.
+func (s *Server) ProxyListener(ln net.Listener) net.Listener {
+	return proxyListener{Listener: ln, srv: s}
+}
+
+type proxyListener struct {
+	net.Listener
+	srv *Server
+}
+
+func (l proxyListener) Accept() (net.Conn, error) {
+	c, err := l.Listener.Accept()
+	if err != nil {
+		return nil, err
+	}
+	return &proxyConn{Conn: c, r: bufio.NewReader(c), srv: l.srv}, nil
+}
+
+// proxyConn parses the header lazily, on the first Read or RemoteAddr call,
+// so a slow peer cannot stall the accept loop.
+type proxyConn struct {
+	net.Conn
+	r      *bufio.Reader
+	srv    *Server
+	once   sync.Once
+	remote net.Addr
+	err    error
+}
+
+const proxyHeaderTimeout = 5 * time.Second
+
+func (c *proxyConn) init() {
+	c.once.Do(func() {
+		if len(c.srv.trusted) > 0 {
+			host, _, _ := net.SplitHostPort(c.Conn.RemoteAddr().String())
+			if !c.srv.isTrustedProxy(host) {
+				c.err = fmt.Errorf("PROXY header from untrusted peer %s", host)
+				c.srv.log.Warn("Rejected connection", "error", c.err)
+				return
+			}
+		}
+		c.Conn.SetReadDeadline(time.Now().Add(proxyHeaderTimeout))
+		c.remote, c.err = readProxyHeader(c.r)
+		c.Conn.SetReadDeadline(time.Time{})
+		if c.err != nil {
+			c.srv.log.Warn("Invalid PROXY protocol header", "peer", c.Conn.RemoteAddr().String(), "error", c.err)
+		}
+	})
+}
+
+func (c *proxyConn) Read(b []byte) (int, error) {
+	c.init()
+	if c.err != nil {
+		return 0, c.err
+	}
+	return c.r.Read(b)
+}
+
+func (c *proxyConn) RemoteAddr() net.Addr {
+	c.init()
+	if c.remote != nil {
+		return c.remote
+	}
+	return c.Conn.RemoteAddr()
+}
+
+var proxyV2Signature = []byte("\r\n\r\n\x00\r\nQUIT\n")
+
+// readProxyHeader consumes a PROXY header and returns the source address,
+// or nil for LOCAL/UNKNOWN connections (health checks).
+func readProxyHeader(r *bufio.Reader) (net.Addr, error) {
+	sig, err := r.Peek(len(proxyV2Signature))
+	if err == nil && bytes.Equal(sig, proxyV2Signature) {
+		return readProxyV2(r)
+	}
+	prefix, err := r.Peek(6)
+	if err != nil {
+		return nil, err
+	}
+	if string(prefix) != "PROXY " {
+		return nil, errors.New("missing PROXY protocol header")
+	}
+	return readProxyV1(r)
+}
+
+func readProxyV1(r *bufio.Reader) (net.Addr, error) {
+	// A v1 header is at most 107 bytes including CRLF.
+	var line []byte
+	for len(line) < 107 {
+		b, err := r.ReadByte()
+		if err != nil {
+			return nil, err
+		}
+		line = append(line, b)
+		if b == '\n' {
+			break
+		}
+	}
+	s, ok := strings.CutSuffix(string(line), "\r\n")
+	if !ok {
+		return nil, errors.New("malformed PROXY v1 header")
+	}
+	fields := strings.Fields(s)
+	if len(fields) >= 2 && fields[1] == "UNKNOWN" {
+		return nil, nil
+	}
+	if len(fields) != 6 || (fields[1] != "TCP4" && fields[1] != "TCP6") {
+		return nil, errors.New("malformed PROXY v1 header")
+	}
+	src, srcOK := proxyV1Addr(fields[1], fields[2], fields[4])
+	_, dstOK := proxyV1Addr(fields[1], fields[3], fields[5])
+	if !srcOK || !dstOK {
+		return nil, errors.New("malformed PROXY v1 address")
+	}
+	return src, nil
+}
+
+// proxyV1Addr parses an address and port of a v1 header, which must be
+// of the header's protocol family.
+func proxyV1Addr(family, host, port string) (*net.TCPAddr, bool) {
+	ip := net.ParseIP(host)
+	if ip == nil || strings.Contains(host, ":") != (family == "TCP6") {
+		return nil, false
+	}
+	n, err := strconv.Atoi(port)
+	if err != nil || n < 0 || n > 65535 || strconv.Itoa(n) != port {
+		return nil, false
+	}
+	return &net.TCPAddr{IP: ip, Port: n}, true
+}
+
+func readProxyV2(r *bufio.Reader) (net.Addr, error) {
+	var hdr [16]byte
+	if _, err := io.ReadFull(r, hdr[:]); err != nil {
+		return nil, err
+	}
+	if hdr[12]>>4 != 2 {
+		return nil, errors.New("unsupported PROXY v2 version")
+	}
+	if hdr[12]&0x0f > 1 {
+		return nil, errors.New("unsupported PROXY v2 command")
+	}
+	body := make([]byte, binary.BigEndian.Uint16(hdr[14:16]))
+	if _, err := io.ReadFull(r, body); err != nil {
+		return nil, err
+	}
+	if hdr[12]&0x0f == 0 { // LOCAL command
+		return nil, nil
+	}
+	switch hdr[13] {
+	case 0x11: // TCP over IPv4
+		if len(body) < 12 {
+			return nil, errors.New("short PROXY v2 IPv4 address block")
+		}
+		return &net.TCPAddr{IP: net.IP(body[0:4]), Port: int(binary.BigEndian.Uint16(body[8:10]))}, nil
+	case 0x21: // TCP over IPv6
+		if len(body) < 36 {
+			return nil, errors.New("short PROXY v2 IPv6 address block")
+		}
+		return &net.TCPAddr{IP: net.IP(body[0:16]), Port: int(binary.BigEndian.Uint16(body[32:34]))}, nil
+	default:
+		return nil, nil
+	}
+}
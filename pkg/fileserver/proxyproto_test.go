@@ -0,0 +1,184 @@
+package fileserver
+
+import (
+	"bufio"
+	"encoding/binary"
+	"io"
+	"log/slog"
+	"net"
+	"net/netip"
+	"strings"
+	"testing"
+	"time"
+)
+
+// proxyV2 builds a v2 header with the given version and command byte,
+// family and protocol byte and address block.
+func proxyV2(verCmd, famProto byte, body []byte) string {
+	hdr := append([]byte{}, proxyV2Signature...)
+	hdr = append(hdr, verCmd, famProto)
+	hdr = binary.BigEndian.AppendUint16(hdr, uint16(len(body)))
+	return string(append(hdr, body...))
+}
+
+func proxyV2IPv4(src, dst string, srcPort, dstPort uint16) []byte {
+	s, d := netip.MustParseAddr(src).As4(), netip.MustParseAddr(dst).As4()
+	b := append(s[:], d[:]...)
+	b = binary.BigEndian.AppendUint16(b, srcPort)
+	return binary.BigEndian.AppendUint16(b, dstPort)
+}
+
+func proxyV2IPv6(src, dst string, srcPort, dstPort uint16) []byte {
+	s, d := netip.MustParseAddr(src).As16(), netip.MustParseAddr(dst).As16()
+	b := append(s[:], d[:]...)
+	b = binary.BigEndian.AppendUint16(b, srcPort)
+	return binary.BigEndian.AppendUint16(b, dstPort)
+}
+
+func TestReadProxyHeader(t *testing.T) {
+	ipv4 := proxyV2IPv4("192.0.2.1", "198.51.100.1", 56324, 443)
+	ipv6 := proxyV2IPv6("2001:db8::1", "2001:db8::2", 56324, 443)
+	// A PP2_TYPE_AUTHORITY TLV after the addresses.
+	tlv := append(append([]byte{}, ipv4...), 0x02, 0x00, 0x0b, 'e', 'x', 'a', 'm', 'p', 'l', 'e', '.', 'c', 'o', 'm')
+	huge := append(append([]byte{}, ipv4...), make([]byte, 0xffff-len(ipv4))...)
+
+	tests := []struct {
+		name  string
+		input string
+		want  string // the source address, "" for none
+		err   bool
+	}{
+		{"v1 TCP4", "PROXY TCP4 192.0.2.1 198.51.100.1 56324 443\r\n", "192.0.2.1:56324", false},
+		{"v1 TCP6", "PROXY TCP6 2001:db8::1 2001:db8::2 56324 443\r\n", "[2001:db8::1]:56324", false},
+		{"v1 TCP6 mapped IPv4", "PROXY TCP6 ::ffff:192.0.2.1 2001:db8::2 56324 443\r\n", "192.0.2.1:56324", false},
+		{"v1 UNKNOWN", "PROXY UNKNOWN\r\n", "", false},
+		{"v1 UNKNOWN with addresses", "PROXY UNKNOWN 2001:db8::1 2001:db8::2 56324 443\r\n", "", false},
+		{"v1 longest header", "PROXY TCP6 ffff:ffff:ffff:ffff:ffff:ffff:ffff:ffff ffff:ffff:ffff:ffff:ffff:ffff:ffff:ffff 65535 65535\r\n", "[ffff:ffff:ffff:ffff:ffff:ffff:ffff:ffff]:65535", false},
+		{"v1 oversized", "PROXY TCP4 192.0.2.1 198.51.100.1 56324 443" + strings.Repeat(" ", 100) + "\r\n", "", true},
+		{"v1 without CRLF", "PROXY TCP4 192.0.2.1 198.51.100.1 56324 443\n", "", true},
+		{"v1 truncated", "PROXY TCP4 192.0.2.1 198.51", "", true},
+		{"v1 too few fields", "PROXY TCP4 192.0.2.1 198.51.100.1 56324\r\n", "", true},
+		{"v1 unknown protocol", "PROXY UDP4 192.0.2.1 198.51.100.1 56324 443\r\n", "", true},
+		{"v1 bad address", "PROXY TCP4 192.0.2 198.51.100.1 56324 443\r\n", "", true},
+		{"v1 bad destination", "PROXY TCP4 192.0.2.1 example.com 56324 443\r\n", "", true},
+		{"v1 IPv6 as TCP4", "PROXY TCP4 2001:db8::1 2001:db8::2 56324 443\r\n", "", true},
+		{"v1 IPv4 as TCP6", "PROXY TCP6 192.0.2.1 198.51.100.1 56324 443\r\n", "", true},
+		{"v1 port out of range", "PROXY TCP4 192.0.2.1 198.51.100.1 65536 443\r\n", "", true},
+		{"v1 negative port", "PROXY TCP4 192.0.2.1 198.51.100.1 -1 443\r\n", "", true},
+		{"v1 port with leading zero", "PROXY TCP4 192.0.2.1 198.51.100.1 056324 443\r\n", "", true},
+		{"v1 lower case", "proxy TCP4 192.0.2.1 198.51.100.1 56324 443\r\n", "", true},
+		{"no header", "GET / HTTP/1.1\r\nHost: example.com\r\n\r\n", "", true},
+		{"empty", "", "", true},
+
+		{"v2 TCP4", proxyV2(0x21, 0x11, ipv4), "192.0.2.1:56324", false},
+		{"v2 TCP6", proxyV2(0x21, 0x21, ipv6), "[2001:db8::1]:56324", false},
+		{"v2 with TLVs", proxyV2(0x21, 0x11, tlv), "192.0.2.1:56324", false},
+		{"v2 longest body", proxyV2(0x21, 0x11, huge), "192.0.2.1:56324", false},
+		{"v2 LOCAL", proxyV2(0x20, 0x00, nil), "", false},
+		{"v2 LOCAL with addresses", proxyV2(0x20, 0x11, ipv4), "", false},
+		{"v2 UDP", proxyV2(0x21, 0x12, ipv4), "", false},
+		{"v2 unix socket", proxyV2(0x21, 0x31, make([]byte, 216)), "", false},
+		{"v2 short IPv4 block", proxyV2(0x21, 0x11, ipv4[:8]), "", true},
+		{"v2 short IPv6 block", proxyV2(0x21, 0x21, ipv6[:32]), "", true},
+		{"v2 IPv6 block for IPv4", proxyV2(0x21, 0x21, ipv4), "", true},
+		{"v2 truncated header", proxyV2(0x21, 0x11, ipv4)[:14], "", true},
+		{"v2 truncated body", proxyV2(0x21, 0x11, ipv4)[:20], "", true},
+		{"v2 version 1", proxyV2(0x11, 0x11, ipv4), "", true},
+		{"v2 unknown command", proxyV2(0x22, 0x11, ipv4), "", true},
+		{"v2 bad signature", strings.Replace(proxyV2(0x21, 0x11, ipv4), "QUIT", "QUIX", 1), "", true},
+		{"v2 signature only", string(proxyV2Signature), "", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// The request follows a complete header; a truncated one is
+			// all the peer sent.
+			rest := "GET / HTTP/1.1\r\n"
+			if tt.err {
+				rest = ""
+			}
+			r := bufio.NewReader(strings.NewReader(tt.input + rest))
+			addr, err := readProxyHeader(r)
+			if tt.err {
+				if err == nil {
+					t.Fatalf("got %v, want an error", addr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			got := ""
+			if addr != nil {
+				got = addr.String()
+			}
+			if got != tt.want {
+				t.Errorf("got address %q, want %q", got, tt.want)
+			}
+			if after, _ := io.ReadAll(r); string(after) != rest {
+				t.Errorf("header left %q unread, want %q", after, rest)
+			}
+		})
+	}
+}
+
+func TestProxyListenerTrust(t *testing.T) {
+	const header = "PROXY TCP4 192.0.2.1 198.51.100.1 56324 443\r\n"
+	tests := []struct {
+		name    string
+		trusted string // "" for none
+		want    string // the client address, "" if rejected
+	}{
+		{"no trusted proxies", "", "192.0.2.1:56324"},
+		{"trusted peer", "127.0.0.0/8", "192.0.2.1:56324"},
+		{"untrusted peer", "10.0.0.0/8", ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := &Server{log: slog.New(slog.DiscardHandler)}
+			if tt.trusted != "" {
+				s.trusted = []netip.Prefix{netip.MustParsePrefix(tt.trusted)}
+			}
+			inner, err := net.Listen("tcp", "127.0.0.1:0")
+			if err != nil {
+				t.Fatal(err)
+			}
+			ln := s.ProxyListener(inner)
+			defer ln.Close()
+			client, err := net.Dial("tcp", inner.Addr().String())
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer client.Close()
+			if _, err := io.WriteString(client, header+"hello"); err != nil {
+				t.Fatal(err)
+			}
+			c, err := ln.Accept()
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer c.Close()
+			c.SetDeadline(time.Now().Add(5 * time.Second))
+
+			buf := make([]byte, 5)
+			_, err = io.ReadFull(c, buf)
+			if tt.want == "" {
+				if err == nil {
+					t.Errorf("read %q from an untrusted peer", buf)
+				}
+				if got := c.RemoteAddr().String(); got != client.LocalAddr().String() {
+					t.Errorf("untrusted peer reported as %s, want its own address %s", got, client.LocalAddr())
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(buf) != "hello" {
+				t.Errorf("read %q after the header, want %q", buf, "hello")
+			}
+			if got := c.RemoteAddr().String(); got != tt.want {
+				t.Errorf("RemoteAddr %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
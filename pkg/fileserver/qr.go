@@ -0,0 +1,83 @@
+package fileserver
+
+import (
+	"bytes"
+	"fmt"
+	"html"
+	"net/http"
+	"strconv"
+
+	qrcode "github.com/skip2/go-qrcode"
+)
+
+// apiQR answers GET /api/v1/qr?path=/dir/file[&short=1] with an SVG QR
+// code of the absolute URL of a path on this server, or of its short link
+// when short links are enabled, for opening the page on a phone.
+func (s *Server) apiQR(w http.ResponseWriter, r *http.Request) {
+	p := r.URL.Query().Get("path")
+	if p == "" {
+		writeAPIError(w, http.StatusBadRequest, "missing path", map[string]string{"path": "required"})
+		return
+	}
+	relPath, m, fsPath, ok := s.lookup(p)
+	if !ok {
+		writeAPIError(w, http.StatusNotFound, http.StatusText(http.StatusNotFound), nil)
+		return
+	}
+	info, err := m.stat(fsPath)
+	if err != nil {
+		status := statusForError(err)
+		writeAPIError(w, status, http.StatusText(status), nil)
+		return
+	}
+	if info.IsDir() && relPath != "/" {
+		relPath += "/"
+	}
+	target := escapePath(relPath)
+	if v := r.URL.Query().Get("short"); s.shortLinks != nil && (v == "1" || v == "true") {
+		code, _, err := s.shortLinks.shorten(relPath)
+		if err != nil {
+			s.log.Error("Saving short links failed", "error", err)
+			writeAPIError(w, http.StatusInternalServerError, "could not save the short link", nil)
+			return
+		}
+		target = s.endpoint(shortPrefix + code)
+	}
+	svg, err := qrSVG(s.origin(r) + target)
+	if err != nil {
+		writeAPIError(w, http.StatusUnprocessableEntity, err.Error(), nil)
+		return
+	}
+	w.Header().Set("Content-Type", "image/svg+xml")
+	w.Header().Set("Content-Length", strconv.Itoa(len(svg)))
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Write(svg)
+}
+
+// qrSVG draws text as a QR code, one unit per module, with each row's
+// dark runs joined into a single path.
+func qrSVG(text string) ([]byte, error) {
+	code, err := qrcode.New(text, qrcode.Medium)
+	if err != nil {
+		return nil, err
+	}
+	bits := code.Bitmap() // includes the quiet zone
+	n := len(bits)
+	var b bytes.Buffer
+	fmt.Fprintf(&b, `<svg xmlns="http://www.w3.org/2000/svg" viewBox="0 0 %d %d" shape-rendering="crispEdges">`, n, n)
+	fmt.Fprintf(&b, `<title>%s</title><rect width="%d" height="%d" fill="#fff"/><path fill="#000" d="`, html.EscapeString(text), n, n)
+	for y, row := range bits {
+		for x := 0; x < len(row); x++ {
+			if !row[x] {
+				continue
+			}
+			start := x
+			for x < len(row) && row[x] {
+				x++
+			}
+			fmt.Fprintf(&b, "M%d %dh%dv1h-%dz", start, y, x-start, x-start)
+		}
+	}
+	b.WriteString(`"/></svg>`)
+	return b.Bytes(), nil
+}
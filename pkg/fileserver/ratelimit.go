@@ -0,0 +1,104 @@
+package fileserver
+
+import (
+	"errors"
+	"math"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// bucket is a token bucket for one client address.
+type bucket struct {
+	tokens float64
+	last   time.Time
+}
+
+// rateLimiter allows each client a number of requests per second with
+// bursts, both taken from the current Settings so that a reload changes
+// them for buckets already filling.
+type rateLimiter struct {
+	mu      sync.Mutex
+	buckets map[string]*bucket
+}
+
+// rateLimits returns the rate and burst of st, or zero when unlimited.
+func rateLimits(st *Settings) (rate, burst float64) {
+	if st.RateLimit <= 0 {
+		return 0, 0
+	}
+	burst = float64(st.RateBurst)
+	if burst < 1 {
+		burst = math.Max(1, math.Ceil(st.RateLimit))
+	}
+	return st.RateLimit, burst
+}
+
+// allow takes a token for key, or reports how long until one is available.
+func (l *rateLimiter) allow(key string, now time.Time, rate, burst float64) (bool, time.Duration) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	b, ok := l.buckets[key]
+	if !ok {
+		b = &bucket{tokens: burst, last: now}
+		l.buckets[key] = b
+	}
+	b.tokens = math.Min(burst, b.tokens+now.Sub(b.last).Seconds()*rate)
+	b.last = now
+	if b.tokens < 1 {
+		return false, time.Duration((1 - b.tokens) / rate * float64(time.Second))
+	}
+	b.tokens--
+	return true, 0
+}
+
+// prune drops buckets that have refilled completely; they are
+// indistinguishable from new ones.
+func (l *rateLimiter) prune(now time.Time, rate, burst float64) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	for key, b := range l.buckets {
+		if rate == 0 || b.tokens+now.Sub(b.last).Seconds()*rate >= burst {
+			delete(l.buckets, key)
+		}
+	}
+}
+
+func (s *Server) rateLimitMiddleware() (Middleware, error) {
+	if s.settings.Load().RateLimit <= 0 {
+		return nil, errors.New("requires a positive Settings.RateLimit")
+	}
+	l := &rateLimiter{buckets: make(map[string]*bucket)}
+	go func() {
+		ticker := time.NewTicker(time.Minute)
+		defer ticker.Stop()
+		for {
+			select {
+			case now := <-ticker.C:
+				rate, burst := rateLimits(s.settings.Load())
+				l.prune(now, rate, burst)
+			case <-s.stop:
+				return
+			}
+		}
+	}()
+
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			rate, burst := rateLimits(s.settings.Load())
+			if rate == 0 {
+				next.ServeHTTP(w, r)
+				return
+			}
+			ok, wait := l.allow(RemoteIP(r), time.Now(), rate, burst)
+			if !ok {
+				w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(wait.Seconds()))))
+				s.renderError(w, r, http.StatusTooManyRequests)
+				s.securityRequestEvent(securityRateLimited, r, "http", "")
+				return
+			}
+			next.ServeHTTP(w, r)
+		})
+	}, nil
+}
@@ -0,0 +1,77 @@
+package fileserver
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestRateLimitReload(t *testing.T) {
+	s, err := New(Options{
+		Root:       t.TempDir(),
+		Middleware: []string{"ratelimit"},
+		Settings:   Settings{RateLimit: 0.001, RateBurst: 1},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+	get := func() int {
+		w := httptest.NewRecorder()
+		s.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/", nil))
+		return w.Code
+	}
+	if code := get(); code != http.StatusOK {
+		t.Fatalf("first request: got %d, want 200", code)
+	}
+	if code := get(); code != http.StatusTooManyRequests {
+		t.Fatalf("second request: got %d, want 429", code)
+	}
+
+	// Lifting the limit lets everything through; restoring it applies to
+	// the bucket the client already drained.
+	st := s.Settings()
+	st.RateLimit = 0
+	if err := s.SetSettings(st); err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 3; i++ {
+		if code := get(); code != http.StatusOK {
+			t.Fatalf("request %d without a limit: got %d, want 200", i, code)
+		}
+	}
+	st.RateLimit, st.RateBurst = 0.001, 1
+	if err := s.SetSettings(st); err != nil {
+		t.Fatal(err)
+	}
+	if code := get(); code != http.StatusTooManyRequests {
+		t.Fatalf("request after restoring the limit: got %d, want 429", code)
+	}
+
+	st.RateLimit = -1
+	if err := s.SetSettings(st); err == nil {
+		t.Error("SetSettings accepted a negative rate limit")
+	}
+}
+
+func TestRateLimitNeedsRate(t *testing.T) {
+	if _, err := New(Options{Root: t.TempDir(), Middleware: []string{"ratelimit"}}); err == nil {
+		t.Error("New accepted the ratelimit middleware without a rate limit")
+	}
+}
+
+func TestRateLimitNeedsMiddleware(t *testing.T) {
+	if _, err := New(Options{Root: t.TempDir(), Settings: Settings{RateLimit: 10}}); err == nil {
+		t.Error("New accepted a rate limit without the ratelimit middleware")
+	}
+	s, err := New(Options{Root: t.TempDir()})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+	st := s.Settings()
+	st.RateLimit = 10
+	if err := s.SetSettings(st); err == nil {
+		t.Error("SetSettings accepted a rate limit without the ratelimit middleware")
+	}
+}
@@ -0,0 +1,81 @@
+package fileserver
+
+import (
+	"bytes"
+	"html"
+	"html/template"
+	"io"
+	"path/filepath"
+	"strings"
+
+	"github.com/microcosm-cc/bluemonday"
+	"github.com/yuin/goldmark"
+	"github.com/yuin/goldmark/extension"
+)
+
+// readmeNames are the files rendered below a directory listing, in order
+// of preference.
+var readmeNames = []string{"README.md", "README.txt"}
+
+// readmeMaxSize bounds the README read for a listing; longer files are
+// cut off.
+const readmeMaxSize = 1 << 20
+
+// markdown renders GitHub-flavoured Markdown. Raw HTML in the source is
+// left out, so a README cannot inject scripts into the listing page.
+var markdown = goldmark.New(goldmark.WithExtensions(extension.GFM))
+
+// annotationPolicy sanitizes HEADER.html and FOOTER.html, keeping the
+// formatting, links and images of user content but no scripts, styles or
+// forms.
+var annotationPolicy = bluemonday.UGCPolicy()
+
+// readListed reads the start of the directory entry named want, matched
+// case-insensitively, reporting whether there is one.
+func readListed(m *Mount, fsPath string, entries []listEntry, want string) ([]byte, bool) {
+	for _, e := range entries {
+		if e.isDir || !strings.EqualFold(e.name, want) {
+			continue
+		}
+		f, err := m.open(filepath.Join(fsPath, e.name))
+		if err != nil {
+			continue
+		}
+		src, err := io.ReadAll(io.LimitReader(f, readmeMaxSize))
+		f.Close()
+		if err == nil {
+			return src, true
+		}
+	}
+	return nil, false
+}
+
+// annotation returns the sanitized content of the directory's HEADER.html
+// or FOOTER.html, which mod_autoindex users know for annotating folders,
+// shown above or below the listing.
+func annotation(m *Mount, fsPath string, entries []listEntry, name string) template.HTML {
+	src, ok := readListed(m, fsPath, entries, name)
+	if !ok {
+		return ""
+	}
+	return template.HTML(annotationPolicy.SanitizeBytes(src))
+}
+
+// readme renders the directory's README, if it has one, as HTML for the
+// listing page.
+func (s *Server) readme(m *Mount, fsPath string, entries []listEntry) template.HTML {
+	for _, want := range readmeNames {
+		if src, ok := readListed(m, fsPath, entries, want); ok {
+			if strings.HasSuffix(want, ".md") {
+				var buf bytes.Buffer
+				if err := markdown.Convert(src, &buf); err != nil {
+					s.log.Warn("Rendering README failed", "path", fsPath, "error", err)
+					continue
+				}
+				return template.HTML(buf.String())
+			}
+			return template.HTML("<pre>" + html.EscapeString(string(src)) + "</pre>")
+		}
+	}
+	return ""
+}
@@ -0,0 +1,178 @@
+package fileserver
+
+import (
+	"io/fs"
+	"net/http"
+	"path"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	recentPath         = "/recent"
+	defaultRecentCount = 100
+)
+
+// recentIndex holds the newest files across all mounts for /recent. A
+// background walk rebuilds it every interval; uploads and deletions
+// through the server update it in between.
+type recentIndex struct {
+	count    int
+	interval time.Duration
+
+	mu      sync.RWMutex
+	entries []listEntry // newest first; names are URL paths without the leading "/"
+}
+
+func newRecentIndex(interval time.Duration, count int) *recentIndex {
+	if count <= 0 {
+		count = defaultRecentCount
+	}
+	return &recentIndex{count: count, interval: interval}
+}
+
+// newestFirst orders entries by modification time, newest first.
+func newestFirst(a, b listEntry) int {
+	if c := b.modTime.Compare(a.modTime); c != 0 {
+		return c
+	}
+	return strings.Compare(a.name, b.name)
+}
+
+func (ri *recentIndex) list() []listEntry {
+	ri.mu.RLock()
+	defer ri.mu.RUnlock()
+	return slices.Clone(ri.entries)
+}
+
+// add records a file just written at urlPath.
+func (ri *recentIndex) add(urlPath string, size int64) {
+	name := strings.TrimPrefix(urlPath, "/")
+	ri.mu.Lock()
+	defer ri.mu.Unlock()
+	ri.entries = slices.DeleteFunc(ri.entries, func(e listEntry) bool { return e.name == name })
+	ri.entries = slices.Insert(ri.entries, 0, listEntry{name: name, size: size, modTime: time.Now()})
+	if len(ri.entries) > ri.count {
+		ri.entries = ri.entries[:ri.count]
+	}
+}
+
+// remove drops the file at urlPath, or everything below it.
+func (ri *recentIndex) remove(urlPath string) {
+	name := strings.TrimPrefix(urlPath, "/")
+	ri.mu.Lock()
+	defer ri.mu.Unlock()
+	ri.entries = slices.DeleteFunc(ri.entries, func(e listEntry) bool {
+		return e.name == name || strings.HasPrefix(e.name, name+"/")
+	})
+}
+
+// indexRecent rebuilds the recent index now and then every interval until
+// the server is closed.
+func (s *Server) indexRecent() {
+	ticker := time.NewTicker(s.recent.interval)
+	defer ticker.Stop()
+	for {
+		start := time.Now()
+		entries := s.newestFiles(s.recent.count)
+		s.recent.mu.Lock()
+		s.recent.entries = entries
+		s.recent.mu.Unlock()
+		s.log.Debug("Recent index rebuilt", "files", len(entries), "duration", time.Since(start))
+		select {
+		case <-ticker.C:
+		case <-s.stop:
+			return
+		}
+	}
+}
+
+// newestFiles walks every mount for its newest count regular files.
+func (s *Server) newestFiles(count int) []listEntry {
+	var top []listEntry
+	keep := func() {
+		slices.SortFunc(top, newestFirst)
+		top = top[:min(len(top), count)]
+	}
+	s.walkFiles("/", func(_ *Mount, _, urlPath string, info fs.FileInfo) error {
+		top = append(top, listEntry{name: strings.TrimPrefix(urlPath, "/"), size: info.Size(), modTime: info.ModTime()})
+		if len(top) >= 2*count {
+			keep()
+		}
+		return nil
+	})
+	keep()
+	return top
+}
+
+// walkFiles calls fn for the regular files at or below the URL path dir
+// in every mount, with the file's name in its mount, leaving out what
+// listings hide and the parts of a mount covered by another one. It
+// stops when the server is closed or fn returns fs.SkipAll.
+func (s *Server) walkFiles(dir string, fn func(m *Mount, name, urlPath string, info fs.FileInfo) error) {
+	for _, m := range s.mounts {
+		start := "."
+		switch {
+		case m.Prefix == "/" || dir == m.Prefix || strings.HasPrefix(dir, m.Prefix+"/"):
+			if rest := strings.Trim(strings.TrimPrefix(dir, m.Prefix), "/"); rest != "" {
+				start = rest
+			}
+		case dir != "/" && !strings.HasPrefix(m.Prefix, dir+"/"):
+			continue
+		}
+		stopped := false
+		fs.WalkDir(m.fsys, start, func(name string, d fs.DirEntry, err error) error {
+			select {
+			case <-s.stop:
+				stopped = true
+				return fs.SkipAll
+			default:
+			}
+			if err != nil {
+				return nil // unreadable directories are skipped
+			}
+			urlPath := path.Join(m.Prefix, name)
+			if name != "." && ((m.Hidden != HiddenShow && strings.HasPrefix(d.Name(), ".")) || m.internal(urlPath) || s.excluded(urlPath)) {
+				if d.IsDir() {
+					return fs.SkipDir
+				}
+				return nil
+			}
+			if d.IsDir() {
+				if owner, _, _ := s.resolve(urlPath); owner != m || s.noIndexed(m, name) {
+					return fs.SkipDir
+				}
+				return nil
+			}
+			info, err := d.Info()
+			if err != nil || !info.Mode().IsRegular() {
+				return nil
+			}
+			if err := fn(m, name, urlPath, info); err != nil {
+				stopped = err == fs.SkipAll
+				return err
+			}
+			return nil
+		})
+		if stopped {
+			return
+		}
+	}
+}
+
+// recentHandler serves the newest files of the tree as a listing in any of
+// the listing formats, newest first unless ?sort= says otherwise.
+func (s *Server) recentHandler(w http.ResponseWriter, r *http.Request) {
+	if !s.allowMethods(w, r, http.MethodGet, http.MethodHead) {
+		return
+	}
+	l, format, ok := parseListingQuery(w, r, "modified", true)
+	if !ok {
+		return
+	}
+	l.Path, l.Entries, l.Recent = "/", s.recent.list(), true
+	s.prepareListing(w, r, l, format)
+	s.writeListing(w, r, l, format)
+}
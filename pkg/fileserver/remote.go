@@ -0,0 +1,608 @@
+package fileserver
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"html"
+	"io"
+	"io/fs"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Remote is a directory on another server served through a mount: a WebDAV
+// share, or a plain HTTP directory index such as the autoindex pages of
+// Apache or nginx. See OpenRemote.
+type Remote struct {
+	URL string // http or https URL of the directory
+	// WebDAV lists directories with PROPFIND; otherwise the links of
+	// their index pages are followed and files are sized with HEAD.
+	WebDAV bool
+	// Username and Password authenticate with HTTP basic auth. They
+	// default to those in URL.
+	Username string
+	Password string
+	// CacheTTL is how long listings and file metadata are reused before
+	// asking the remote again. It defaults to 30s; negative disables it.
+	CacheTTL time.Duration
+	// CacheDir, when set, keeps fetched files on disk and serves them from
+	// there while the remote reports them unchanged. Otherwise every read
+	// goes to the remote, with Range requests for seeks.
+	CacheDir string
+	// Timeout bounds connecting to the remote and waiting for its response
+	// headers. It defaults to 30s.
+	Timeout time.Duration
+}
+
+const defaultRemoteTTL = 30 * time.Second
+
+// remoteListWorkers is how many HEAD requests size the files of an HTTP
+// index page at once.
+const remoteListWorkers = 8
+
+// OpenRemote returns a read-only file tree for use as Options.FS or
+// Mount.FS that reads a remote directory over HTTP. The remote is only
+// contacted once files are requested, so it may be down at startup.
+func OpenRemote(r Remote) (fs.FS, error) {
+	base, err := url.Parse(r.URL)
+	if err != nil || (base.Scheme != "http" && base.Scheme != "https") || base.Host == "" {
+		return nil, fmt.Errorf("remote %q: want an http or https URL", redactURL(r.URL))
+	}
+	if base.User != nil {
+		if r.Username == "" {
+			r.Username = base.User.Username()
+			r.Password, _ = base.User.Password()
+		}
+		base.User = nil
+	}
+	if !strings.HasSuffix(base.Path, "/") {
+		base.Path += "/"
+		base.RawPath = ""
+	}
+	if r.CacheTTL == 0 {
+		r.CacheTTL = defaultRemoteTTL
+	}
+	if r.CacheDir != "" {
+		if err := os.MkdirAll(r.CacheDir, 0o700); err != nil {
+			return nil, fmt.Errorf("remote %s: %w", base, err)
+		}
+	}
+	timeout := r.Timeout
+	if timeout == 0 {
+		timeout = defaultProxyTimeout
+	}
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.DialContext = (&net.Dialer{Timeout: timeout, KeepAlive: 30 * time.Second}).DialContext
+	transport.ResponseHeaderTimeout = timeout
+	return &remoteFS{
+		Remote: r,
+		base:   base,
+		client: &http.Client{Transport: transport},
+		dirs:   make(map[string]*remoteDirCache),
+	}, nil
+}
+
+// remoteFS implements fs.FS, fs.StatFS and fs.ReadDirFS over HTTP.
+type remoteFS struct {
+	Remote
+	base   *url.URL // with a trailing slash and no user info
+	client *http.Client
+
+	mu   sync.Mutex
+	dirs map[string]*remoteDirCache // listings by name
+}
+
+type remoteDirCache struct {
+	entries []*remoteInfo // sorted by name
+	expires time.Time
+}
+
+// remoteInfo describes a remote file or directory.
+type remoteInfo struct {
+	name    string
+	size    int64
+	modTime time.Time
+	dir     bool
+	etag    string
+}
+
+func (i *remoteInfo) Name() string       { return i.name }
+func (i *remoteInfo) Size() int64        { return i.size }
+func (i *remoteInfo) ModTime() time.Time { return i.modTime }
+func (i *remoteInfo) IsDir() bool        { return i.dir }
+func (i *remoteInfo) Sys() any           { return nil }
+
+func (i *remoteInfo) Mode() fs.FileMode {
+	if i.dir {
+		return fs.ModeDir | 0o555
+	}
+	return 0o444
+}
+
+func (i *remoteInfo) Type() fs.FileMode          { return i.Mode().Type() }
+func (i *remoteInfo) Info() (fs.FileInfo, error) { return i, nil }
+
+// String names the remote in logs, without credentials.
+func (f *remoteFS) String() string { return f.base.String() }
+
+// url returns the URL of a name in the tree, with a trailing slash for
+// directories.
+func (f *remoteFS) url(name string, dir bool) string {
+	u := *f.base
+	if name != "." {
+		u.Path += name
+		if dir {
+			u.Path += "/"
+		}
+	}
+	return u.String()
+}
+
+func (f *remoteFS) do(method, rawURL string, header http.Header, body io.Reader) (*http.Response, error) {
+	req, err := http.NewRequest(method, rawURL, body)
+	if err != nil {
+		return nil, err
+	}
+	for k, v := range header {
+		req.Header[k] = v
+	}
+	req.Header.Set("User-Agent", "go-server")
+	if f.Username != "" || f.Password != "" {
+		req.SetBasicAuth(f.Username, f.Password)
+	}
+	return f.client.Do(req)
+}
+
+// remoteError maps a failed response to the fs error handlers expect.
+func remoteError(op, name string, resp *http.Response) error {
+	err := fmt.Errorf("remote answered %s", resp.Status)
+	switch resp.StatusCode {
+	case http.StatusNotFound, http.StatusGone:
+		err = fs.ErrNotExist
+	case http.StatusUnauthorized, http.StatusForbidden:
+		err = fs.ErrPermission
+	}
+	return &fs.PathError{Op: op, Path: name, Err: err}
+}
+
+func (f *remoteFS) Stat(name string) (fs.FileInfo, error) {
+	if !fs.ValidPath(name) {
+		return nil, &fs.PathError{Op: "stat", Path: name, Err: fs.ErrInvalid}
+	}
+	if name == "." {
+		// Listing the root checks that it exists and is reachable.
+		if _, err := f.list("."); err != nil {
+			return nil, err
+		}
+		return &remoteInfo{name: ".", dir: true}, nil
+	}
+	entries, err := f.list(path.Dir(name))
+	if err != nil {
+		var pe *fs.PathError
+		if errors.As(err, &pe) && errors.Is(err, fs.ErrNotExist) {
+			pe.Path = name
+		}
+		return nil, err
+	}
+	base := path.Base(name)
+	i, found := slices.BinarySearchFunc(entries, base, func(e *remoteInfo, name string) int { return strings.Compare(e.name, name) })
+	if !found {
+		return nil, &fs.PathError{Op: "stat", Path: name, Err: fs.ErrNotExist}
+	}
+	return entries[i], nil
+}
+
+func (f *remoteFS) ReadDir(name string) ([]fs.DirEntry, error) {
+	entries, err := f.list(name)
+	if err != nil {
+		return nil, err
+	}
+	out := make([]fs.DirEntry, len(entries))
+	for i, e := range entries {
+		out[i] = e
+	}
+	return out, nil
+}
+
+func (f *remoteFS) Open(name string) (fs.File, error) {
+	info, err := f.Stat(name)
+	if err != nil {
+		return nil, err
+	}
+	ri := info.(*remoteInfo)
+	if ri.dir {
+		entries, err := f.ReadDir(name)
+		if err != nil {
+			return nil, err
+		}
+		return &remoteDir{info: ri, entries: entries}, nil
+	}
+	if f.CacheDir != "" {
+		return f.openCached(name, ri)
+	}
+	return &remoteFile{fs: f, name: name, info: ri}, nil
+}
+
+// list returns a directory's entries, from the cache while it is fresh.
+func (f *remoteFS) list(name string) ([]*remoteInfo, error) {
+	if !fs.ValidPath(name) {
+		return nil, &fs.PathError{Op: "readdir", Path: name, Err: fs.ErrInvalid}
+	}
+	f.mu.Lock()
+	c, ok := f.dirs[name]
+	f.mu.Unlock()
+	if ok && time.Now().Before(c.expires) {
+		return c.entries, nil
+	}
+	var entries []*remoteInfo
+	var err error
+	if f.WebDAV {
+		entries, err = f.propfind(name)
+	} else {
+		entries, err = f.index(name)
+	}
+	if err != nil {
+		return nil, err
+	}
+	slices.SortFunc(entries, func(a, b *remoteInfo) int { return strings.Compare(a.name, b.name) })
+	entries = slices.CompactFunc(entries, func(a, b *remoteInfo) bool { return a.name == b.name })
+	if f.CacheTTL > 0 {
+		f.mu.Lock()
+		now := time.Now()
+		for k, c := range f.dirs {
+			if now.After(c.expires) {
+				delete(f.dirs, k)
+			}
+		}
+		f.dirs[name] = &remoteDirCache{entries: entries, expires: now.Add(f.CacheTTL)}
+		f.mu.Unlock()
+	}
+	return entries, nil
+}
+
+const propfindBody = `<?xml version="1.0" encoding="utf-8"?>
+<propfind xmlns="DAV:"><prop><resourcetype/><getcontentlength/><getlastmodified/><getetag/></prop></propfind>`
+
+type davMultistatus struct {
+	Responses []struct {
+		Href     string `xml:"DAV: href"`
+		Propstat []struct {
+			Status string `xml:"DAV: status"`
+			Prop   struct {
+				ResourceType struct {
+					Collection *struct{} `xml:"DAV: collection"`
+				} `xml:"DAV: resourcetype"`
+				ContentLength string `xml:"DAV: getcontentlength"`
+				LastModified  string `xml:"DAV: getlastmodified"`
+				ETag          string `xml:"DAV: getetag"`
+			} `xml:"DAV: prop"`
+		} `xml:"DAV: propstat"`
+	} `xml:"DAV: response"`
+}
+
+// propfind lists a WebDAV collection.
+func (f *remoteFS) propfind(name string) ([]*remoteInfo, error) {
+	dirURL := f.url(name, true)
+	resp, err := f.do("PROPFIND", dirURL, http.Header{
+		"Depth":        {"1"},
+		"Content-Type": {"application/xml; charset=utf-8"},
+	}, strings.NewReader(propfindBody))
+	if err != nil {
+		return nil, &fs.PathError{Op: "readdir", Path: name, Err: err}
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusMultiStatus {
+		return nil, remoteError("readdir", name, resp)
+	}
+	var ms davMultistatus
+	if err := xml.NewDecoder(resp.Body).Decode(&ms); err != nil {
+		return nil, &fs.PathError{Op: "readdir", Path: name, Err: fmt.Errorf("invalid PROPFIND response: %w", err)}
+	}
+	dir, _ := url.Parse(dirURL)
+	var entries []*remoteInfo
+	for _, r := range ms.Responses {
+		child, ok := childName(dir, r.Href)
+		if !ok {
+			continue
+		}
+		for _, ps := range r.Propstat {
+			if !strings.Contains(ps.Status, " 200") {
+				continue
+			}
+			e := &remoteInfo{name: child, dir: ps.Prop.ResourceType.Collection != nil, etag: ps.Prop.ETag}
+			e.size, _ = strconv.ParseInt(ps.Prop.ContentLength, 10, 64)
+			e.modTime, _ = http.ParseTime(ps.Prop.LastModified)
+			entries = append(entries, e)
+			break
+		}
+	}
+	return entries, nil
+}
+
+// indexLink matches the link targets of an HTML index page.
+var indexLink = regexp.MustCompile(`(?i)href\s*=\s*["']([^"'?#]+)["']`)
+
+// index lists a directory from the links of its HTML index page that lead
+// directly below it, sizing files with HEAD requests.
+func (f *remoteFS) index(name string) ([]*remoteInfo, error) {
+	dirURL := f.url(name, true)
+	resp, err := f.do(http.MethodGet, dirURL, nil, nil)
+	if err != nil {
+		return nil, &fs.PathError{Op: "readdir", Path: name, Err: err}
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, remoteError("readdir", name, resp)
+	}
+	page, err := io.ReadAll(io.LimitReader(resp.Body, 16<<20))
+	if err != nil {
+		return nil, &fs.PathError{Op: "readdir", Path: name, Err: err}
+	}
+	dir := resp.Request.URL // after redirects, such as to add the slash
+	var entries []*remoteInfo
+	for _, m := range indexLink.FindAllSubmatch(page, -1) {
+		child, ok := childName(dir, html.UnescapeString(string(m[1])))
+		if !ok {
+			continue
+		}
+		isDir := bytes.HasSuffix(m[1], []byte("/"))
+		entries = append(entries, &remoteInfo{name: child, dir: isDir})
+	}
+
+	// Size the files, a few at a time; those that fail are left out.
+	var wg sync.WaitGroup
+	sem := make(chan struct{}, remoteListWorkers)
+	for _, e := range entries {
+		if e.dir {
+			continue
+		}
+		wg.Add(1)
+		sem <- struct{}{}
+		go func() {
+			defer func() { <-sem; wg.Done() }()
+			resp, err := f.do(http.MethodHead, f.url(path.Join(name, e.name), false), nil, nil)
+			if err != nil {
+				e.name = ""
+				return
+			}
+			resp.Body.Close()
+			if resp.StatusCode != http.StatusOK {
+				e.name = ""
+				return
+			}
+			e.size = resp.ContentLength
+			e.modTime, _ = http.ParseTime(resp.Header.Get("Last-Modified"))
+			e.etag = resp.Header.Get("ETag")
+		}()
+	}
+	wg.Wait()
+	return slices.DeleteFunc(entries, func(e *remoteInfo) bool { return e.name == "" }), nil
+}
+
+// childName returns the name of the entry href links to when that is
+// directly inside dir.
+func childName(dir *url.URL, href string) (string, bool) {
+	u, err := dir.Parse(href)
+	if err != nil || u.Host != dir.Host {
+		return "", false
+	}
+	rest, ok := strings.CutPrefix(u.Path, dir.Path)
+	rest = strings.TrimSuffix(rest, "/")
+	if !ok || rest == "" || strings.Contains(rest, "/") || rest == "." || rest == ".." {
+		return "", false
+	}
+	return rest, true
+}
+
+// remoteDir is an open remote directory.
+type remoteDir struct {
+	info    *remoteInfo
+	entries []fs.DirEntry
+	pos     int
+}
+
+func (d *remoteDir) Stat() (fs.FileInfo, error) { return d.info, nil }
+func (d *remoteDir) Close() error               { return nil }
+
+func (d *remoteDir) Read([]byte) (int, error) {
+	return 0, &fs.PathError{Op: "read", Path: d.info.name, Err: errIsDir}
+}
+
+func (d *remoteDir) ReadDir(n int) ([]fs.DirEntry, error) {
+	rest := d.entries[d.pos:]
+	if n > 0 && len(rest) == 0 {
+		return nil, io.EOF
+	}
+	if n > 0 && n < len(rest) {
+		rest = rest[:n]
+	}
+	d.pos += len(rest)
+	return rest, nil
+}
+
+// remoteFile reads a remote file with GET requests, starting a new one
+// with a Range header after each seek, so that range requests from
+// clients become range requests to the remote.
+type remoteFile struct {
+	fs   *remoteFS
+	name string
+	info *remoteInfo
+
+	mu   sync.Mutex
+	pos  int64
+	body io.ReadCloser // positioned at pos; nil until read
+}
+
+func (rf *remoteFile) Stat() (fs.FileInfo, error) { return rf.info, nil }
+
+// get requests the file from off, reading up to n bytes when n > 0.
+func (rf *remoteFile) get(off, n int64) (io.ReadCloser, error) {
+	header := http.Header{}
+	switch {
+	case n > 0:
+		header.Set("Range", fmt.Sprintf("bytes=%d-%d", off, off+n-1))
+	case off > 0:
+		header.Set("Range", fmt.Sprintf("bytes=%d-", off))
+	}
+	resp, err := rf.fs.do(http.MethodGet, rf.fs.url(rf.name, false), header, nil)
+	if err != nil {
+		return nil, &fs.PathError{Op: "read", Path: rf.name, Err: err}
+	}
+	switch resp.StatusCode {
+	case http.StatusPartialContent:
+		return resp.Body, nil
+	case http.StatusOK:
+		// The remote ignored the range: skip to it.
+		if _, err := io.CopyN(io.Discard, resp.Body, off); err != nil {
+			resp.Body.Close()
+			return nil, &fs.PathError{Op: "read", Path: rf.name, Err: err}
+		}
+		return resp.Body, nil
+	case http.StatusRequestedRangeNotSatisfiable:
+		resp.Body.Close()
+		return io.NopCloser(strings.NewReader("")), nil
+	}
+	resp.Body.Close()
+	return nil, remoteError("read", rf.name, resp)
+}
+
+func (rf *remoteFile) Read(p []byte) (int, error) {
+	rf.mu.Lock()
+	defer rf.mu.Unlock()
+	if rf.body == nil {
+		body, err := rf.get(rf.pos, 0)
+		if err != nil {
+			return 0, err
+		}
+		rf.body = body
+	}
+	n, err := rf.body.Read(p)
+	rf.pos += int64(n)
+	return n, err
+}
+
+func (rf *remoteFile) Seek(offset int64, whence int) (int64, error) {
+	rf.mu.Lock()
+	defer rf.mu.Unlock()
+	switch whence {
+	case io.SeekCurrent:
+		offset += rf.pos
+	case io.SeekEnd:
+		offset += rf.info.size
+	case io.SeekStart:
+	default:
+		return 0, errWhence
+	}
+	if offset < 0 {
+		return 0, errWhence
+	}
+	if offset != rf.pos && rf.body != nil {
+		rf.body.Close()
+		rf.body = nil
+	}
+	rf.pos = offset
+	return offset, nil
+}
+
+func (rf *remoteFile) ReadAt(p []byte, off int64) (int, error) {
+	if len(p) == 0 {
+		return 0, nil
+	}
+	body, err := rf.get(off, int64(len(p)))
+	if err != nil {
+		return 0, err
+	}
+	defer body.Close()
+	n, err := io.ReadFull(body, p)
+	if errors.Is(err, io.ErrUnexpectedEOF) {
+		err = io.EOF
+	}
+	return n, err
+}
+
+func (rf *remoteFile) Close() error {
+	rf.mu.Lock()
+	defer rf.mu.Unlock()
+	if rf.body != nil {
+		rf.body.Close()
+		rf.body = nil
+	}
+	return nil
+}
+
+// cachedFile is a remote file served from its copy in the cache
+// directory.
+type cachedFile struct {
+	*os.File
+	info *remoteInfo
+}
+
+func (cf *cachedFile) Stat() (fs.FileInfo, error) { return cf.info, nil }
+
+// openCached opens the cached copy of a file, fetching it first when
+// there is none of the version the remote reports. Copies are named by
+// the file's URL and that version, so a changed file is fetched anew.
+func (f *remoteFS) openCached(name string, info *remoteInfo) (fs.File, error) {
+	fileURL := f.url(name, false)
+	key := sha256.Sum256([]byte(fileURL))
+	version := info.etag
+	if version == "" {
+		version = strconv.FormatInt(info.size, 10) + "@" + strconv.FormatInt(info.modTime.UnixNano(), 10)
+	}
+	ver := sha256.Sum256([]byte(version))
+	prefix := filepath.Join(f.CacheDir, hex.EncodeToString(key[:16]))
+	cached := prefix + "-" + hex.EncodeToString(ver[:8])
+
+	if file, err := os.Open(cached); err == nil {
+		return &cachedFile{File: file, info: info}, nil
+	}
+	resp, err := f.do(http.MethodGet, fileURL, nil, nil)
+	if err != nil {
+		return nil, &fs.PathError{Op: "open", Path: name, Err: err}
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, remoteError("open", name, resp)
+	}
+	tmp, err := os.CreateTemp(f.CacheDir, ".fetch-*")
+	if err != nil {
+		return nil, err
+	}
+	if _, err := io.Copy(tmp, resp.Body); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return nil, &fs.PathError{Op: "open", Path: name, Err: err}
+	}
+	if err := tmp.Close(); err != nil {
+		os.Remove(tmp.Name())
+		return nil, err
+	}
+	// Older versions of the file go.
+	old, _ := filepath.Glob(prefix + "-*")
+	for _, o := range old {
+		os.Remove(o)
+	}
+	if err := os.Rename(tmp.Name(), cached); err != nil {
+		os.Remove(tmp.Name())
+		return nil, err
+	}
+	file, err := os.Open(cached)
+	if err != nil {
+		return nil, err
+	}
+	return &cachedFile{File: file, info: info}, nil
+}
@@ -0,0 +1,54 @@
+package fileserver
+
+import (
+	"net/http"
+	"strings"
+)
+
+// legacyEndpoints are the paths the endpoints had before they moved below
+// the reserved prefix. Those ending in "/" match everything below them.
+var legacyEndpoints = []string{
+	"/api", "/post", "/ws", "/graphql", "/trash", "/recent", "/version",
+	"/api/v1/", "/_assets/", "/files/", "/drop/", "/s/", "/admin/",
+}
+
+// isReserved reports whether a URL path is the reserved prefix or below it.
+func (s *Server) isReserved(p string) bool {
+	return p == s.reserved || strings.HasPrefix(p, s.reserved+"/")
+}
+
+// endpoint returns the URL of an endpoint, given by its path below the
+// reserved prefix.
+func (s *Server) endpoint(p string) string {
+	return s.reserved + p
+}
+
+// legacyRedirect answers a request for an endpoint's former path with a
+// 308 to its place below the reserved prefix, which keeps the method and
+// body, and reports whether it did. A file at that path is served instead,
+// as it could not be before.
+func (s *Server) legacyRedirect(w http.ResponseWriter, r *http.Request, relPath string) bool {
+	target := ""
+	for _, p := range legacyEndpoints {
+		if relPath == p || relPath == strings.TrimSuffix(p, "/") || (strings.HasSuffix(p, "/") && strings.HasPrefix(r.URL.Path, p)) {
+			target = r.URL.EscapedPath()
+			break
+		}
+	}
+	if target == "" {
+		return false
+	}
+	if m, fsPath, ok := s.resolve(relPath); ok {
+		if _, err := m.stat(fsPath); err == nil {
+			return false
+		}
+	}
+	if strings.HasPrefix(target, "/_assets/") {
+		target = assetsPrefix + strings.TrimPrefix(target, "/_assets/")
+	}
+	if r.URL.RawQuery != "" {
+		target += "?" + r.URL.RawQuery
+	}
+	http.Redirect(w, r, s.endpoint(target), http.StatusPermanentRedirect)
+	return true
+}
@@ -0,0 +1,143 @@
+package fileserver
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"path"
+	"regexp"
+	"strings"
+)
+
+// Rule rewrites or redirects request paths matching a regular expression or
+// a glob. Rules are tried in order before routing; the first match wins.
+type Rule struct {
+	// Regex is matched against the request path. Alternatively Glob takes
+	// a path pattern where * matches within one path element, ** across
+	// elements and ? a single character.
+	Regex string
+	Glob  string
+	// To is the new path, optionally with a query string. $1, $2, ...
+	// refer to the regex groups, or to the glob wildcards in order; write
+	// ${1} when a letter, digit or underscore follows.
+	To string
+	// Status is 301, 302, 303, 307 or 308 for an external redirect; 0
+	// rewrites the request internally.
+	Status int
+	// IfExists applies an internal rewrite only when the new path names
+	// an existing file, as for extension-less clean URLs.
+	IfExists bool
+}
+
+type rule struct {
+	Rule
+	re *regexp.Regexp
+}
+
+func compileRules(rules []Rule) ([]rule, error) {
+	out := make([]rule, len(rules))
+	for i, r := range rules {
+		expr := r.Regex
+		switch {
+		case (r.Regex == "") == (r.Glob == ""):
+			return nil, fmt.Errorf("rule %d: exactly one of regex and glob is required", i+1)
+		case r.Glob != "":
+			expr = globToRegex(r.Glob)
+		}
+		re, err := regexp.Compile(expr)
+		if err != nil {
+			return nil, fmt.Errorf("rule %d: %w", i+1, err)
+		}
+		switch r.Status {
+		case 0, http.StatusMovedPermanently, http.StatusFound, http.StatusSeeOther,
+			http.StatusTemporaryRedirect, http.StatusPermanentRedirect:
+		default:
+			return nil, fmt.Errorf("rule %d: invalid redirect status %d", i+1, r.Status)
+		}
+		if r.To == "" {
+			return nil, fmt.Errorf("rule %d: missing target", i+1)
+		}
+		out[i] = rule{Rule: r, re: re}
+	}
+	return out, nil
+}
+
+// globToRegex turns a glob into an anchored regex capturing each wildcard.
+func globToRegex(glob string) string {
+	var b strings.Builder
+	b.WriteString("^")
+	for i := 0; i < len(glob); i++ {
+		switch c := glob[i]; c {
+		case '*':
+			if i+1 < len(glob) && glob[i+1] == '*' {
+				b.WriteString("(.*)")
+				i++
+			} else {
+				b.WriteString("([^/]*)")
+			}
+		case '?':
+			b.WriteString("([^/])")
+		default:
+			b.WriteString(regexp.QuoteMeta(string(c)))
+		}
+	}
+	b.WriteString("$")
+	return b.String()
+}
+
+// exists reports whether a URL path names an existing file or directory.
+func (s *Server) exists(urlPath string) bool {
+	m, fsPath, ok := s.resolve(path.Clean(urlPath))
+	if !ok {
+		return false
+	}
+	_, err := m.stat(fsPath)
+	return err == nil
+}
+
+// rewrite applies the rules ahead of the mux.
+func (s *Server) rewrite(next http.Handler) http.Handler {
+	if len(s.rules) == 0 {
+		return next
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		for _, rl := range s.rules {
+			m := rl.re.FindStringSubmatchIndex(r.URL.Path)
+			if m == nil {
+				continue
+			}
+			target := string(rl.re.ExpandString(nil, rl.To, r.URL.Path, m))
+			if rl.Status != 0 {
+				if !strings.Contains(target, "?") && r.URL.RawQuery != "" {
+					target += "?" + r.URL.RawQuery
+				}
+				http.Redirect(w, r, target, rl.Status)
+				return
+			}
+			u, err := url.Parse(target)
+			if err != nil {
+				s.log.Warn("Invalid rewrite target", "rule", rl.re.String(), "target", target, "error", err)
+				continue
+			}
+			p := path.Clean("/" + u.Path)
+			if strings.HasSuffix(u.Path, "/") && p != "/" {
+				p += "/"
+			}
+			if rl.IfExists && !s.exists(p) {
+				continue
+			}
+			s.log.Debug("Rewrote request", "from", r.URL.Path, "to", p)
+			r2 := new(http.Request)
+			*r2 = *r
+			r2.URL = new(url.URL)
+			*r2.URL = *r.URL
+			r2.URL.Path, r2.URL.RawPath = p, ""
+			if u.RawQuery != "" {
+				r2.URL.RawQuery = u.RawQuery
+			}
+			next.ServeHTTP(w, r2)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
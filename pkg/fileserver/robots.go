@@ -0,0 +1,20 @@
+package fileserver
+
+import "net/http"
+
+// robotsPath is where crawlers look for robots.txt, outside the reserved
+// prefix by necessity.
+const robotsPath = "/robots.txt"
+
+// robotsHandler serves a robots.txt that keeps every crawler out, in place
+// of any robots.txt file under the root.
+func (s *Server) robotsHandler(w http.ResponseWriter, r *http.Request) {
+	if !s.allowMethods(w, r, http.MethodGet, http.MethodHead) {
+		return
+	}
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	w.Header().Set("Cache-Control", "public, max-age=86400")
+	if r.Method == http.MethodGet {
+		w.Write([]byte("User-agent: *\nDisallow: /\n"))
+	}
+}
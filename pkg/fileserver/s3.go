@@ -0,0 +1,563 @@
+package fileserver
+
+import (
+	"bytes"
+	"context"
+	"crypto/md5"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"io/fs"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	defaultS3Bucket = "go-server"
+	s3MaxKeys       = 1000
+	s3TimeFormat    = "2006-01-02T15:04:05.000Z"
+	s3Namespace     = "http://s3.amazonaws.com/doc/2006-03-01/"
+)
+
+// S3Options configure Server.S3Handler.
+type S3Options struct {
+	// Bucket is the name under which the served tree appears. It
+	// defaults to "go-server".
+	Bucket string
+	// Credentials map access key IDs to secret keys. Requests must then
+	// be signed with one of them using AWS Signature Version 4; without
+	// credentials every request is accepted.
+	Credentials map[string]string
+}
+
+// S3Handler returns a handler speaking a minimal path-style S3 API over
+// the served tree: ListBuckets, HeadBucket, GetBucketLocation,
+// ListObjects (V1 and V2), GetObject, HeadObject and PutObject. Object
+// keys are URL paths without the leading slash, so mounts and hidden-file
+// policies apply as over HTTP, and PutObject needs a read-write mount.
+// Multipart uploads, copies and deletes are not implemented. The handler
+// expects to be served at the root of its own listener.
+func (s *Server) S3Handler(opts S3Options) http.Handler {
+	a := &s3API{s: s, bucket: opts.Bucket, creds: opts.Credentials, now: time.Now}
+	if a.bucket == "" {
+		a.bucket = defaultS3Bucket
+	}
+	return http.HandlerFunc(a.serveHTTP)
+}
+
+type s3API struct {
+	s      *Server
+	bucket string
+	creds  map[string]string
+	now    func() time.Time // the clock request dates are checked against
+}
+
+// s3Error is an S3 error response.
+type s3Error struct {
+	status int
+	code   string
+	msg    string
+}
+
+func (e *s3Error) Error() string { return e.msg }
+
+var (
+	errS3NotImplemented = &s3Error{http.StatusNotImplemented, "NotImplemented", "A header or query parameter you provided implies functionality that is not implemented."}
+	errS3NoSuchKey      = &s3Error{http.StatusNotFound, "NoSuchKey", "The specified key does not exist."}
+)
+
+// objectSubresources are query parameters selecting object operations
+// other than plain GET, HEAD and PUT.
+var objectSubresources = []string{"acl", "attributes", "legal-hold", "partNumber", "restore", "retention", "select", "tagging", "torrent", "uploadId", "uploads"}
+
+// listParams are the query parameters understood by ListObjects V1.
+var listParams = []string{"delimiter", "encoding-type", "marker", "max-keys", "prefix"}
+
+func (a *s3API) serveHTTP(w http.ResponseWriter, r *http.Request) {
+	sig, err := a.authenticate(r)
+	if err != nil {
+		a.writeError(w, r, err)
+		return
+	}
+	bucket, key, _ := strings.Cut(strings.TrimPrefix(r.URL.Path, "/"), "/")
+	q := r.URL.Query()
+	switch {
+	case bucket == "":
+		if r.Method != http.MethodGet {
+			a.writeError(w, r, errS3MethodNotAllowed)
+			return
+		}
+		a.listBuckets(w)
+	case bucket != a.bucket:
+		a.writeError(w, r, &s3Error{http.StatusNotFound, "NoSuchBucket", "The specified bucket does not exist."})
+	case key == "":
+		switch {
+		case r.Method == http.MethodHead:
+			w.WriteHeader(http.StatusOK)
+		case r.Method != http.MethodGet:
+			a.writeError(w, r, errS3MethodNotAllowed)
+		case q.Get("list-type") == "2":
+			a.listObjects(w, r, true)
+		case q.Has("location"):
+			writeXML(w, http.StatusOK, struct {
+				XMLName xml.Name `xml:"LocationConstraint"`
+				NS      string   `xml:"xmlns,attr"`
+			}{NS: s3Namespace})
+		case onlyParams(q, listParams):
+			a.listObjects(w, r, false)
+		default:
+			a.writeError(w, r, errS3NotImplemented)
+		}
+	default:
+		if slices.ContainsFunc(objectSubresources, q.Has) || r.Header.Get("X-Amz-Copy-Source") != "" {
+			a.writeError(w, r, errS3NotImplemented)
+			return
+		}
+		switch r.Method {
+		case http.MethodGet, http.MethodHead:
+			a.getObject(w, r, key)
+		case http.MethodPut:
+			a.putObject(w, r, key, sig)
+		case http.MethodPost, http.MethodDelete:
+			a.writeError(w, r, errS3NotImplemented)
+		default:
+			a.writeError(w, r, errS3MethodNotAllowed)
+		}
+	}
+}
+
+var errS3MethodNotAllowed = &s3Error{http.StatusMethodNotAllowed, "MethodNotAllowed", "The specified method is not allowed against this resource."}
+
+func onlyParams(q map[string][]string, allowed []string) bool {
+	for k := range q {
+		if !slices.Contains(allowed, k) {
+			return false
+		}
+	}
+	return true
+}
+
+func writeXML(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/xml")
+	w.WriteHeader(status)
+	io.WriteString(w, xml.Header)
+	xml.NewEncoder(w).Encode(v)
+}
+
+// writeError answers with the S3 error matching err.
+func (a *s3API) writeError(w http.ResponseWriter, r *http.Request, err error) {
+	var e *s3Error
+	var oe *opError
+	switch {
+	case errors.As(err, &e):
+	case errors.As(err, &oe) && oe.code == http.StatusNotFound:
+		e = errS3NoSuchKey
+	case errors.As(err, &oe) && oe.code == http.StatusForbidden:
+		e = &s3Error{http.StatusForbidden, "AccessDenied", oe.msg}
+	case errors.As(err, &oe):
+		e = &s3Error{oe.code, "InvalidRequest", oe.msg}
+	case errors.Is(err, fs.ErrNotExist):
+		e = errS3NoSuchKey
+	case errors.Is(err, fs.ErrPermission):
+		e = &s3Error{http.StatusForbidden, "AccessDenied", "Access Denied"}
+	default:
+		a.s.log.Error("S3 request failed", "path", r.URL.Path, "error", err)
+		e = &s3Error{http.StatusInternalServerError, "InternalError", "We encountered an internal error. Please try again."}
+	}
+	reqID := w.Header().Get("X-Request-ID")
+	w.Header().Set("X-Amz-Request-Id", reqID)
+	if r.Method == http.MethodHead {
+		w.WriteHeader(e.status)
+		return
+	}
+	writeXML(w, e.status, struct {
+		XMLName   xml.Name `xml:"Error"`
+		Code      string
+		Message   string
+		Resource  string
+		RequestID string `xml:"RequestId"`
+	}{Code: e.code, Message: e.msg, Resource: r.URL.Path, RequestID: reqID})
+}
+
+// s3ETag derives an entity tag from a file's size and modification time.
+// Real S3 ETags are MD5 digests, which would mean reading every file to
+// list it; the "-1" suffix marks this one as opaque, as S3 does for
+// multipart uploads, so clients do not compare it with a digest.
+func s3ETag(size int64, modTime time.Time) string {
+	sum := sha256.Sum256(fmt.Appendf(nil, "%d/%d", size, modTime.UnixNano()))
+	return `"` + hex.EncodeToString(sum[:16]) + `-1"`
+}
+
+type s3Owner struct {
+	ID          string
+	DisplayName string
+}
+
+func (a *s3API) listBuckets(w http.ResponseWriter) {
+	type bucket struct {
+		Name         string
+		CreationDate string
+	}
+	writeXML(w, http.StatusOK, struct {
+		XMLName xml.Name `xml:"ListAllMyBucketsResult"`
+		NS      string   `xml:"xmlns,attr"`
+		Owner   s3Owner
+		Buckets []bucket `xml:"Buckets>Bucket"`
+	}{
+		NS:      s3Namespace,
+		Owner:   s3Owner{defaultS3Bucket, defaultS3Bucket},
+		Buckets: []bucket{{a.bucket, a.s.started.UTC().Format(s3TimeFormat)}},
+	})
+}
+
+type s3Object struct {
+	Key          string
+	LastModified string
+	ETag         string
+	Size         int64
+	StorageClass string
+}
+
+type s3Prefix struct {
+	Prefix string
+}
+
+type listBucketResult struct {
+	XMLName               xml.Name `xml:"ListBucketResult"`
+	NS                    string   `xml:"xmlns,attr"`
+	Name                  string
+	Prefix                string
+	Delimiter             string `xml:",omitempty"`
+	Marker                *string
+	NextMarker            string `xml:",omitempty"`
+	ContinuationToken     string `xml:",omitempty"`
+	NextContinuationToken string `xml:",omitempty"`
+	StartAfter            string `xml:",omitempty"`
+	KeyCount              *int
+	MaxKeys               int
+	EncodingType          string `xml:",omitempty"`
+	IsTruncated           bool
+	Contents              []s3Object
+	CommonPrefixes        []s3Prefix
+}
+
+// listObjects answers ListObjects, or ListObjectsV2 when v2 is set.
+func (a *s3API) listObjects(w http.ResponseWriter, r *http.Request, v2 bool) {
+	q := r.URL.Query()
+	res := listBucketResult{
+		NS:        s3Namespace,
+		Name:      a.bucket,
+		Prefix:    q.Get("prefix"),
+		Delimiter: q.Get("delimiter"),
+		MaxKeys:   s3MaxKeys,
+	}
+	if mk := q.Get("max-keys"); mk != "" {
+		n, err := strconv.Atoi(mk)
+		if err != nil || n < 0 {
+			a.writeError(w, r, &s3Error{http.StatusBadRequest, "InvalidArgument", "Invalid max-keys"})
+			return
+		}
+		res.MaxKeys = min(n, s3MaxKeys)
+	}
+	var after string
+	if v2 {
+		res.StartAfter = q.Get("start-after")
+		after = res.StartAfter
+		if tok := q.Get("continuation-token"); tok != "" {
+			key, err := base64.RawURLEncoding.DecodeString(tok)
+			if err != nil {
+				a.writeError(w, r, &s3Error{http.StatusBadRequest, "InvalidArgument", "The continuation token provided is incorrect"})
+				return
+			}
+			res.ContinuationToken = tok
+			after = max(after, string(key))
+		}
+	} else {
+		marker := q.Get("marker")
+		res.Marker = &marker
+		after = marker
+	}
+
+	objects, prefixes, err := a.list(r.Context(), res.Prefix, res.Delimiter)
+	if a.s.canceled(r, err) {
+		return
+	}
+	if err != nil {
+		a.writeError(w, r, err)
+		return
+	}
+	keys := make([]string, 0, len(objects)+len(prefixes))
+	for k := range objects {
+		keys = append(keys, k)
+	}
+	for k := range prefixes {
+		keys = append(keys, k)
+	}
+	slices.Sort(keys)
+	i, _ := slices.BinarySearch(keys, after)
+	if i < len(keys) && keys[i] == after {
+		i++
+	}
+	var last string
+	for _, k := range keys[i:] {
+		if len(res.Contents)+len(res.CommonPrefixes) == res.MaxKeys {
+			res.IsTruncated = true
+			break
+		}
+		if o, ok := objects[k]; ok {
+			res.Contents = append(res.Contents, o)
+		} else {
+			res.CommonPrefixes = append(res.CommonPrefixes, s3Prefix{k})
+		}
+		last = k
+	}
+	if res.IsTruncated {
+		if v2 {
+			res.NextContinuationToken = base64.RawURLEncoding.EncodeToString([]byte(last))
+		} else {
+			res.NextMarker = last
+		}
+	}
+	if v2 {
+		n := len(res.Contents) + len(res.CommonPrefixes)
+		res.KeyCount = &n
+	}
+	if q.Get("encoding-type") == "url" {
+		res.EncodingType = "url"
+		res.Prefix = awsURIEncode(res.Prefix, false)
+		res.Delimiter = awsURIEncode(res.Delimiter, true)
+		res.StartAfter = awsURIEncode(res.StartAfter, false)
+		res.NextMarker = awsURIEncode(res.NextMarker, false)
+		for i := range res.Contents {
+			res.Contents[i].Key = awsURIEncode(res.Contents[i].Key, false)
+		}
+		for i := range res.CommonPrefixes {
+			res.CommonPrefixes[i].Prefix = awsURIEncode(res.CommonPrefixes[i].Prefix, false)
+		}
+	}
+	writeXML(w, http.StatusOK, res)
+}
+
+// list walks the directories that may hold keys starting with prefix. As
+// in S3, keys containing delimiter after the prefix are rolled up into
+// common prefixes; with the usual "/" the walk stops at them.
+func (a *s3API) list(ctx context.Context, prefix, delimiter string) (map[string]s3Object, map[string]bool, error) {
+	objects := make(map[string]s3Object)
+	prefixes := make(map[string]bool)
+	var walk func(dir string) error
+	walk = func(dir string) error {
+		relPath, m, fsPath, ok := a.s.lookup(dir)
+		if !ok {
+			return nil
+		}
+		entries, _, err := a.s.readListing(ctx, m, fsPath, relPath)
+		if err != nil {
+			return err
+		}
+		for _, e := range entries {
+			key := strings.TrimPrefix(path.Join(relPath, e.name), "/")
+			if e.isDir {
+				key += "/"
+				switch {
+				case delimiter == "/" && strings.HasPrefix(key, prefix):
+					prefixes[key] = true
+				case strings.HasPrefix(key, prefix) || strings.HasPrefix(prefix, key):
+					if err := walk("/" + key); err != nil {
+						return err
+					}
+				}
+				continue
+			}
+			if !strings.HasPrefix(key, prefix) {
+				continue
+			}
+			if delimiter != "" {
+				if i := strings.Index(key[len(prefix):], delimiter); i >= 0 {
+					prefixes[key[:len(prefix)+i+len(delimiter)]] = true
+					continue
+				}
+			}
+			objects[key] = s3Object{
+				Key:          key,
+				LastModified: e.modTime.UTC().Format(s3TimeFormat),
+				ETag:         s3ETag(e.size, e.modTime),
+				Size:         e.size,
+				StorageClass: "STANDARD",
+			}
+		}
+		return nil
+	}
+	if err := walk("/"); err != nil {
+		return nil, nil, err
+	}
+	return objects, prefixes, nil
+}
+
+// getObject answers GetObject and HeadObject. Directories are not
+// objects.
+func (a *s3API) getObject(w http.ResponseWriter, r *http.Request, key string) {
+	_, m, fsPath, ok := a.s.lookup(key)
+	if !ok || strings.HasSuffix(key, "/") {
+		a.writeError(w, r, errS3NoSuchKey)
+		return
+	}
+	f, info, err := m.openContent(fsPath)
+	if err != nil {
+		a.writeError(w, r, err)
+		return
+	}
+	defer f.Close()
+	if info.IsDir() {
+		a.writeError(w, r, errS3NoSuchKey)
+		return
+	}
+	w.Header().Set("ETag", s3ETag(info.Size(), info.ModTime()))
+	w.Header().Set("Accept-Ranges", "bytes")
+	if typ := a.s.mimeType(info.Name()); typ != "" {
+		w.Header().Set("Content-Type", typ)
+	}
+	if ct := r.URL.Query().Get("response-content-type"); ct != "" {
+		w.Header().Set("Content-Type", ct)
+	}
+	if cd := r.URL.Query().Get("response-content-disposition"); cd != "" {
+		w.Header().Set("Content-Disposition", cd)
+	}
+	http.ServeContent(w, r, info.Name(), info.ModTime(), newContextSeeker(r.Context(), f))
+	if r.Method == http.MethodGet {
+		a.s.fileServed(r, fsPath, info)
+	}
+}
+
+// putObject answers PutObject, replacing any existing file. Missing
+// parent directories are created, and an empty object whose key ends in
+// "/" creates a directory, as clients do to make folders.
+func (a *s3API) putObject(w http.ResponseWriter, r *http.Request, key string, sig *sigV4) {
+	relPath, fsPath, err := a.s.writable(key)
+	if err != nil {
+		a.writeError(w, r, err)
+		return
+	}
+	body, err := payloadReader(r, sig)
+	if err != nil {
+		a.writeError(w, r, err)
+		return
+	}
+	if strings.HasSuffix(key, "/") {
+		if n, _ := io.Copy(io.Discard, io.LimitReader(body, 1)); n > 0 {
+			a.writeError(w, r, &s3Error{http.StatusBadRequest, "InvalidRequest", "Folder objects must be empty"})
+			return
+		}
+		if err := os.MkdirAll(fsPath, 0o755); err != nil {
+			a.writeError(w, r, err)
+			return
+		}
+		a.s.invalidate(fsPath)
+		w.Header().Set("ETag", `"d41d8cd98f00b204e9800998ecf8427e"`)
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+	if info, err := os.Stat(fsPath); err == nil && info.IsDir() {
+		a.writeError(w, r, &opError{http.StatusConflict, relPath + " is a directory"})
+		return
+	}
+	if err := os.MkdirAll(filepath.Dir(fsPath), 0o755); err != nil {
+		a.writeError(w, r, err)
+		return
+	}
+
+	// Write to a temporary file beside the target so a failed upload
+	// never leaves a partial file in place.
+	tmp, err := os.CreateTemp(filepath.Dir(fsPath), ".upload-*")
+	if err != nil {
+		a.writeError(w, r, err)
+		return
+	}
+	defer os.Remove(tmp.Name())
+	defer tmp.Close()
+	sum := md5.New()
+	size, err := io.Copy(io.MultiWriter(tmp, sum), body)
+	if err != nil {
+		a.writeError(w, r, err)
+		return
+	}
+	if want := r.Header.Get("Content-MD5"); want != "" {
+		if got := base64.StdEncoding.EncodeToString(sum.Sum(nil)); got != want {
+			a.writeError(w, r, &s3Error{http.StatusBadRequest, "BadDigest", "The Content-MD5 you specified did not match what we received."})
+			return
+		}
+	}
+	if err := tmp.Chmod(0o644); err != nil {
+		a.writeError(w, r, err)
+		return
+	}
+	if err := tmp.Close(); err != nil {
+		a.writeError(w, r, err)
+		return
+	}
+	if err := a.s.keepVersion(fsPath); err != nil {
+		a.writeError(w, r, err)
+		return
+	}
+	if err := os.Rename(tmp.Name(), fsPath); err != nil {
+		a.writeError(w, r, err)
+		return
+	}
+	a.s.invalidate(fsPath)
+	a.s.log.Info("File uploaded", "path", relPath, "size", size, "via", "s3", "remote_ip", RemoteIP(r))
+	a.s.uploaded(r, fsPath, size)
+	a.s.mirrorLater(fsPath)
+	if info, err := os.Stat(fsPath); err == nil {
+		w.Header().Set("ETag", s3ETag(info.Size(), info.ModTime()))
+	}
+	w.WriteHeader(http.StatusOK)
+}
+
+// payloadReader returns the request body, decoding aws-chunked uploads
+// and checking the payload hash the client declared.
+func payloadReader(r *http.Request, sig *sigV4) (io.Reader, error) {
+	declared := r.Header.Get("X-Amz-Content-Sha256")
+	switch {
+	case strings.HasPrefix(declared, "STREAMING-"):
+		if !strings.HasPrefix(declared, "STREAMING-AWS4-HMAC-SHA256-PAYLOAD") {
+			sig = nil // unsigned chunks
+		}
+		return newChunkedReader(r.Body, sig), nil
+	case declared == "" || declared == unsignedPayload:
+		return r.Body, nil
+	}
+	want, err := hex.DecodeString(declared)
+	if err != nil || len(want) != sha256.Size {
+		return nil, &s3Error{http.StatusBadRequest, "InvalidArgument", "x-amz-content-sha256 must be UNSIGNED-PAYLOAD, STREAMING-* or a SHA-256 hex digest"}
+	}
+	return &hashCheckReader{r: r.Body, h: sha256.New(), want: want}, nil
+}
+
+// hashCheckReader fails at EOF unless the data read has the expected
+// SHA-256 digest.
+type hashCheckReader struct {
+	r    io.Reader
+	h    hash.Hash
+	want []byte
+}
+
+var errS3ContentSHA256 = &s3Error{http.StatusBadRequest, "XAmzContentSHA256Mismatch", "The provided 'x-amz-content-sha256' header does not match what was computed."}
+
+func (hr *hashCheckReader) Read(p []byte) (int, error) {
+	n, err := hr.r.Read(p)
+	hr.h.Write(p[:n])
+	if err == io.EOF && !bytes.Equal(hr.h.Sum(nil), hr.want) {
+		return n, errS3ContentSHA256
+	}
+	return n, err
+}
@@ -0,0 +1,255 @@
+package fileserver
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	sigV4Algorithm  = "AWS4-HMAC-SHA256"
+	sigV4TimeFormat = "20060102T150405Z"
+	sigV4MaxSkew    = 15 * time.Minute
+	unsignedPayload = "UNSIGNED-PAYLOAD"
+	emptySHA256     = "e3b0c44298fc1c149afbf4c8996fb92427ae41e4649b934ca495991b7852b855"
+
+	// s3MaxChunk bounds one aws-chunked chunk, which is held in memory
+	// until its signature is checked. Clients send 64 KiB to 16 MiB.
+	s3MaxChunk = 64 << 20
+)
+
+// sigV4 is a verified AWS Signature Version 4 request signature, kept to
+// check the signatures of aws-chunked payload chunks.
+type sigV4 struct {
+	key       []byte // derived signing key
+	amzDate   string
+	scope     string
+	signature string
+}
+
+var (
+	errS3AccessDenied = &s3Error{http.StatusForbidden, "AccessDenied", "Access Denied"}
+	errS3Signature    = &s3Error{http.StatusForbidden, "SignatureDoesNotMatch", "The request signature we calculated does not match the signature you provided."}
+)
+
+// authenticate verifies the request's Authorization header. It returns a
+// nil signature when no credentials are configured.
+func (a *s3API) authenticate(r *http.Request) (*sigV4, error) {
+	if len(a.creds) == 0 {
+		return nil, nil
+	}
+	auth, ok := strings.CutPrefix(r.Header.Get("Authorization"), sigV4Algorithm+" ")
+	if !ok {
+		return nil, errS3AccessDenied
+	}
+	fields := make(map[string]string)
+	for _, kv := range strings.Split(auth, ",") {
+		k, v, _ := strings.Cut(strings.TrimSpace(kv), "=")
+		fields[k] = v
+	}
+	keyID, scope, _ := strings.Cut(fields["Credential"], "/")
+	secret, ok := a.creds[keyID]
+	if !ok {
+		a.s.securityRequestEvent(securityAuthFailure, r, "s3", keyID)
+		return nil, &s3Error{http.StatusForbidden, "InvalidAccessKeyId", "The AWS Access Key Id you provided does not exist in our records."}
+	}
+	scopeParts := strings.Split(scope, "/")
+	amzDate := r.Header.Get("X-Amz-Date")
+	t, err := time.Parse(sigV4TimeFormat, amzDate)
+	if err != nil || len(scopeParts) != 4 || scopeParts[0] != amzDate[:8] || scopeParts[3] != "aws4_request" {
+		return nil, &s3Error{http.StatusBadRequest, "AuthorizationHeaderMalformed", "The authorization header is malformed."}
+	}
+	if d := a.now().Sub(t); d > sigV4MaxSkew || d < -sigV4MaxSkew {
+		return nil, &s3Error{http.StatusForbidden, "RequestTimeTooSkewed", "The difference between the request time and the current time is too large."}
+	}
+
+	payload := r.Header.Get("X-Amz-Content-Sha256")
+	if payload == "" {
+		payload = unsignedPayload
+	}
+	signed := strings.Split(fields["SignedHeaders"], ";")
+	var canon strings.Builder
+	fmt.Fprintf(&canon, "%s\n%s\n%s\n", r.Method, awsURIEncode(r.URL.Path, false), canonicalQuery(r.URL.RawQuery))
+	for _, name := range signed {
+		var v string
+		switch name {
+		case "host":
+			v = r.Host
+		case "content-length":
+			v = strconv.FormatInt(r.ContentLength, 10)
+		default:
+			vals := r.Header.Values(name)
+			for i, val := range vals {
+				vals[i] = strings.Join(strings.Fields(val), " ")
+			}
+			v = strings.Join(vals, ",")
+		}
+		fmt.Fprintf(&canon, "%s:%s\n", name, v)
+	}
+	fmt.Fprintf(&canon, "\n%s\n%s", fields["SignedHeaders"], payload)
+
+	sig := &sigV4{key: []byte("AWS4" + secret), amzDate: amzDate, scope: scope}
+	for _, part := range scopeParts {
+		sig.key = hmacSHA256(sig.key, part)
+	}
+	want := sig.sign(sigV4Algorithm, sha256Hex([]byte(canon.String())))
+	if !hmac.Equal([]byte(want), []byte(fields["Signature"])) {
+		a.s.securityRequestEvent(securityAuthFailure, r, "s3", keyID)
+		return nil, errS3Signature
+	}
+	sig.signature = want
+	return sig, nil
+}
+
+// sign returns the hex signature of a string to sign made of the given
+// algorithm, the request date and scope, and the remaining lines.
+func (sig *sigV4) sign(algorithm string, lines ...string) string {
+	s := algorithm + "\n" + sig.amzDate + "\n" + sig.scope + "\n" + strings.Join(lines, "\n")
+	return hex.EncodeToString(hmacSHA256(sig.key, s))
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	h := hmac.New(sha256.New, key)
+	io.WriteString(h, data)
+	return h.Sum(nil)
+}
+
+func sha256Hex(data []byte) string {
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+// canonicalQuery sorts and re-encodes a query string as SigV4 requires.
+func canonicalQuery(raw string) string {
+	q, _ := url.ParseQuery(raw)
+	pairs := make([]string, 0, len(q))
+	for k, vs := range q {
+		for _, v := range vs {
+			pairs = append(pairs, awsURIEncode(k, true)+"="+awsURIEncode(v, true))
+		}
+	}
+	slices.Sort(pairs)
+	return strings.Join(pairs, "&")
+}
+
+// awsURIEncode percent-encodes everything but unreserved characters and,
+// unless encodeSlash is set, slashes.
+func awsURIEncode(s string, encodeSlash bool) string {
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		switch {
+		case 'A' <= c && c <= 'Z', 'a' <= c && c <= 'z', '0' <= c && c <= '9',
+			c == '-', c == '.', c == '_', c == '~', c == '/' && !encodeSlash:
+			b.WriteByte(c)
+		default:
+			fmt.Fprintf(&b, "%%%02X", c)
+		}
+	}
+	return b.String()
+}
+
+// chunkedReader decodes an aws-chunked body: chunks of
+// "<hex size>[;chunk-signature=<sig>]\r\n<data>\r\n" ending with an empty
+// chunk and optional trailer lines. When sig is set, each chunk's
+// signature is checked against the previous one before its data is
+// returned. Trailing checksums are not checked.
+type chunkedReader struct {
+	r    *bufio.Reader
+	sig  *sigV4
+	prev string
+	buf  []byte
+	done bool
+}
+
+func newChunkedReader(r io.Reader, sig *sigV4) *chunkedReader {
+	cr := &chunkedReader{r: bufio.NewReader(r), sig: sig}
+	if sig != nil {
+		cr.prev = sig.signature
+	}
+	return cr
+}
+
+var errS3Chunk = &s3Error{http.StatusBadRequest, "IncompleteBody", "The aws-chunked request body is malformed."}
+
+func (cr *chunkedReader) Read(p []byte) (int, error) {
+	for len(cr.buf) == 0 {
+		if cr.done {
+			return 0, io.EOF
+		}
+		if err := cr.next(); err != nil {
+			return 0, err
+		}
+	}
+	n := copy(p, cr.buf)
+	cr.buf = cr.buf[n:]
+	return n, nil
+}
+
+// next reads and verifies the following chunk into buf.
+func (cr *chunkedReader) next() error {
+	line, err := cr.line()
+	if err != nil {
+		return err
+	}
+	sizeHex, ext, _ := strings.Cut(line, ";")
+	size, err := strconv.ParseInt(sizeHex, 16, 64)
+	if err != nil || size < 0 || size > s3MaxChunk {
+		return errS3Chunk
+	}
+	data := make([]byte, size)
+	if _, err := io.ReadFull(cr.r, data); err != nil {
+		return errS3Chunk
+	}
+	if cr.sig != nil {
+		got, _ := strings.CutPrefix(ext, "chunk-signature=")
+		want := cr.sig.sign("AWS4-HMAC-SHA256-PAYLOAD", cr.prev, emptySHA256, sha256Hex(data))
+		if !hmac.Equal([]byte(got), []byte(want)) {
+			return errS3Signature
+		}
+		cr.prev = want
+	}
+	if size == 0 {
+		// Skip the trailers up to the closing empty line.
+		for {
+			line, err := cr.line()
+			if err == io.EOF || (err == nil && line == "") {
+				break
+			}
+			if err != nil {
+				return err
+			}
+		}
+		cr.done = true
+		return nil
+	}
+	if crlf, err := cr.line(); err != nil || crlf != "" {
+		return errS3Chunk
+	}
+	cr.buf = data
+	return nil
+}
+
+func (cr *chunkedReader) line() (string, error) {
+	line, err := cr.r.ReadSlice('\n')
+	if err == bufio.ErrBufferFull {
+		return "", errS3Chunk
+	}
+	if err == io.EOF && len(line) > 0 {
+		err = io.ErrUnexpectedEOF
+	}
+	if err != nil {
+		return "", err
+	}
+	return string(bytes.TrimSuffix(bytes.TrimSuffix(line, []byte("\n")), []byte("\r"))), nil
+}
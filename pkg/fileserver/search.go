@@ -0,0 +1,396 @@
+package fileserver
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"html/template"
+	"io/fs"
+	"mime"
+	"net/http"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/blevesearch/bleve/v2"
+	"github.com/blevesearch/bleve/v2/mapping"
+	"github.com/blevesearch/bleve/v2/search/query"
+)
+
+const (
+	searchPath = "/search"
+	// searchMaxFile bounds the text files whose contents are indexed;
+	// larger ones are found by name only.
+	searchMaxFile = 1 << 20
+	// searchBatch is how many documents are written to the index at once.
+	searchBatch           = 200
+	defaultSearchInterval = 10 * time.Minute
+	defaultSearchResults  = 50
+	maxSearchResults      = 500
+)
+
+// searchIndex is a full-text index of the files in the tree, with the
+// contents of text files, for /search?content=1. One goroutine keeps it
+// current: it rescans the tree every interval, re-reading only the files
+// whose size or modification time changed, and updates the paths of
+// upload, copy, move and delete events in between.
+type searchIndex struct {
+	idx      bleve.Index
+	interval time.Duration
+	// versions maps the URL path of every indexed file to the version
+	// indexed; only the indexing goroutine uses it.
+	versions map[string]string
+}
+
+// searchDoc is an indexed file. Its ID is its URL path.
+type searchDoc struct {
+	Path    string `json:"path"`
+	Name    string `json:"name"`
+	Content string `json:"content"`
+	Version string `json:"version"` // size and modification time
+}
+
+func searchMapping() *mapping.IndexMappingImpl {
+	keyword := bleve.NewKeywordFieldMapping()
+	keyword.IncludeInAll = false
+	version := bleve.NewKeywordFieldMapping()
+	version.Index, version.IncludeInAll = false, false
+	content := bleve.NewTextFieldMapping()
+	content.IncludeTermVectors = true // for highlighting
+
+	doc := bleve.NewDocumentStaticMapping()
+	doc.AddFieldMappingsAt("path", keyword)
+	doc.AddFieldMappingsAt("name", bleve.NewTextFieldMapping())
+	doc.AddFieldMappingsAt("content", content)
+	doc.AddFieldMappingsAt("version", version)
+	m := bleve.NewIndexMapping()
+	m.DefaultMapping = doc
+	return m
+}
+
+// openSearchIndex opens the index in dir, creating it if needed.
+func openSearchIndex(dir string, interval time.Duration) (*searchIndex, error) {
+	if interval <= 0 {
+		interval = defaultSearchInterval
+	}
+	idx, err := bleve.Open(dir)
+	if errors.Is(err, bleve.ErrorIndexPathDoesNotExist) {
+		idx, err = bleve.New(dir, searchMapping())
+	}
+	if err != nil {
+		return nil, fmt.Errorf("search index %s: %w", dir, err)
+	}
+	return &searchIndex{idx: idx, interval: interval}, nil
+}
+
+// loadVersions reads the version of every indexed file, so that a restart
+// does not re-read the whole tree.
+func (si *searchIndex) loadVersions() error {
+	si.versions = make(map[string]string)
+	var after []string
+	for {
+		req := bleve.NewSearchRequestOptions(bleve.NewMatchAllQuery(), 10000, 0, false)
+		req.Fields = []string{"version"}
+		req.SortBy([]string{"_id"})
+		if after != nil {
+			req.SetSearchAfter(after)
+		}
+		res, err := si.idx.Search(req)
+		if err != nil {
+			return err
+		}
+		if len(res.Hits) == 0 {
+			return nil
+		}
+		for _, hit := range res.Hits {
+			v, _ := hit.Fields["version"].(string)
+			si.versions[hit.ID] = v
+		}
+		after = []string{res.Hits[len(res.Hits)-1].ID}
+	}
+}
+
+// runSearchIndex keeps the index current until the server is closed, then
+// closes it.
+func (s *Server) runSearchIndex() {
+	defer s.search.idx.Close()
+	events, unsubscribe := s.Subscribe()
+	defer unsubscribe()
+	if err := s.search.loadVersions(); err != nil {
+		s.log.Error("Failed to read search index", "error", err)
+		return
+	}
+	ticker := time.NewTicker(s.search.interval)
+	defer ticker.Stop()
+	for rescan := true; ; rescan = false {
+		if rescan {
+			start := time.Now()
+			indexed, deleted := s.syncSearchIndex("/")
+			s.log.Debug("Search index updated", "files", len(s.search.versions), "indexed", indexed, "deleted", deleted, "duration", time.Since(start))
+		}
+		select {
+		case <-ticker.C:
+			rescan = true
+		case ev := <-events:
+			switch ev.Type {
+			case "upload", "copy", "move", "delete":
+				for _, p := range []string{ev.From, ev.Path} {
+					if p != "" {
+						s.syncSearchIndex(p)
+					}
+				}
+			}
+		case <-s.stop:
+			return
+		}
+	}
+}
+
+// syncSearchIndex brings the index up to date for the files at or below
+// the URL path dir, reporting how many it indexed and deleted.
+func (s *Server) syncSearchIndex(dir string) (indexed, deleted int) {
+	si := s.search
+	batch := si.idx.NewBatch()
+	flush := func() {
+		if batch.Size() == 0 {
+			return
+		}
+		if err := si.idx.Batch(batch); err != nil {
+			s.log.Error("Failed to update search index", "error", err)
+		}
+		batch.Reset()
+	}
+	seen := make(map[string]bool)
+	s.walkFiles(dir, func(m *Mount, name, urlPath string, info fs.FileInfo) error {
+		seen[urlPath] = true
+		version := strconv.FormatInt(info.Size(), 10) + "-" + strconv.FormatInt(info.ModTime().UnixNano(), 10)
+		if si.versions[urlPath] == version {
+			return nil
+		}
+		doc := searchDoc{Path: urlPath, Name: path.Base(urlPath), Version: version}
+		doc.Content, _ = readText(m, name, info.Size())
+		if err := batch.Index(urlPath, doc); err != nil {
+			return nil
+		}
+		si.versions[urlPath] = version
+		indexed++
+		if batch.Size() >= searchBatch {
+			flush()
+		}
+		return nil
+	})
+	for p := range si.versions {
+		if !seen[p] && (dir == "/" || p == dir || strings.HasPrefix(p, dir+"/")) {
+			batch.Delete(p)
+			delete(si.versions, p)
+			deleted++
+		}
+	}
+	flush()
+	return indexed, deleted
+}
+
+// readText returns the contents of a file worth indexing: a UTF-8 text
+// file no larger than searchMaxFile, judged by its extension, if it has
+// a known one, and by its bytes.
+func readText(m *Mount, name string, size int64) (string, bool) {
+	if size == 0 || size > searchMaxFile {
+		return "", false
+	}
+	if t, _, _ := strings.Cut(mime.TypeByExtension(path.Ext(name)), ";"); t != "" && !strings.HasPrefix(t, "text/") &&
+		!strings.Contains(t, "json") && !strings.Contains(t, "xml") && !strings.Contains(t, "javascript") {
+		return "", false
+	}
+	data, err := fs.ReadFile(m.fsys, name)
+	if err != nil || bytes.IndexByte(data, 0) >= 0 || !utf8.Valid(data) {
+		return "", false
+	}
+	return string(data), true
+}
+
+type searchResponse struct {
+	Query   string         `json:"query"`
+	Path    string         `json:"path"`
+	Content bool           `json:"content"`
+	Total   uint64         `json:"total"` // matches, of which at most limit are listed
+	Results []searchResult `json:"results"`
+}
+
+type searchResult struct {
+	Path    string    `json:"path"`
+	URL     string    `json:"url"`
+	Size    int64     `json:"size"`
+	ModTime time.Time `json:"mod_time"`
+	// Snippets are passages of a content match as HTML, the matched
+	// words in <mark> elements.
+	Snippets []template.HTML `json:"snippets,omitempty"`
+}
+
+// searchNames walks the tree below dir for files whose name contains q,
+// ignoring case.
+func (s *Server) searchNames(ctx context.Context, q, dir string, limit int) searchResponse {
+	res := searchResponse{Results: []searchResult{}}
+	q = strings.ToLower(q)
+	s.walkFiles(dir, func(_ *Mount, _, urlPath string, info fs.FileInfo) error {
+		if ctx.Err() != nil {
+			return fs.SkipAll
+		}
+		if !strings.Contains(strings.ToLower(path.Base(urlPath)), q) {
+			return nil
+		}
+		res.Total++
+		if len(res.Results) < limit {
+			res.Results = append(res.Results, searchResult{Path: urlPath, URL: escapePath(urlPath), Size: info.Size(), ModTime: info.ModTime()})
+		}
+		return nil
+	})
+	return res
+}
+
+// searchContents queries the index for files below dir. q is in the
+// bleve query string syntax: words, "phrases", +required, -excluded and
+// name:word.
+func (s *Server) searchContents(ctx context.Context, q, dir string, limit int) (searchResponse, error) {
+	res := searchResponse{Results: []searchResult{}}
+	var qq query.Query = bleve.NewQueryStringQuery(q)
+	if dir != "/" {
+		prefix := bleve.NewPrefixQuery(dir + "/")
+		prefix.SetField("path")
+		qq = bleve.NewConjunctionQuery(qq, prefix)
+	}
+	req := bleve.NewSearchRequestOptions(qq, limit, 0, false)
+	req.Highlight = bleve.NewHighlightWithStyle("html")
+	req.Highlight.AddField("content")
+	found, err := s.search.idx.SearchInContext(ctx, req)
+	if err != nil {
+		return res, err
+	}
+	res.Total = found.Total
+	for _, hit := range found.Hits {
+		// The index may lag behind deletions, and settings such as the
+		// excludes may have changed since a file was indexed.
+		relPath, m, fsPath, ok := s.lookup(hit.ID)
+		if !ok {
+			continue
+		}
+		info, err := m.stat(fsPath)
+		if err != nil {
+			continue
+		}
+		r := searchResult{Path: relPath, URL: escapePath(relPath), Size: info.Size(), ModTime: info.ModTime()}
+		for _, f := range hit.Fragments["content"] {
+			r.Snippets = append(r.Snippets, template.HTML(f)) // escaped by the highlighter
+		}
+		res.Results = append(res.Results, r)
+	}
+	return res, nil
+}
+
+type searchPage struct {
+	Lang, Title, Placeholder, Search, ContentLabel, Summary string
+	Assets, Action, DirURL                                  string
+	searchResponse
+	Indexed bool // content search is available
+}
+
+var searchTemplate = template.Must(template.New("search").Parse(`<!DOCTYPE html>
+<html lang="{{.Lang}}"><head><meta charset="utf-8"><meta name="viewport" content="width=device-width, initial-scale=1">
+<title>{{.Title}}</title><link rel="stylesheet" href="{{.Assets}}style.css"></head>
+<body>
+<header class="title"><h1>{{.Title}}</h1><a class="view" href="{{.DirURL}}">{{.Path}}</a></header>
+<form class="search" action="{{.Action}}" role="search">
+<input type="hidden" name="path" value="{{.Path}}">
+<input type="search" name="q" value="{{.Query}}" placeholder="{{.Placeholder}}" aria-label="{{.Placeholder}}" autofocus>
+{{if .Indexed}}<label><input type="checkbox" name="content" value="1"{{if .Content}} checked{{end}}> {{.ContentLabel}}</label>{{end}}
+<button type="submit">{{.Search}}</button>
+</form>
+<main id="main">
+{{if .Query}}<p class="summary" role="status">{{.Summary}}</p>{{end}}
+{{if .Results}}<ol class="search-results">
+{{range .Results}}<li><a href="{{.URL}}">{{.Path}}</a>{{range .Snippets}}<p class="snippet">{{.}}</p>{{end}}</li>
+{{end}}</ol>{{end}}
+</main>
+</body></html>
+`))
+
+// searchHandler serves /search: files below ?path= whose name contains ?q=,
+// or with ?content=1 and a search index, whose contents match it. It
+// answers with an HTML page, or JSON for ?format=json and clients that
+// prefer it.
+func (s *Server) searchHandler(w http.ResponseWriter, r *http.Request) {
+	if !s.allowMethods(w, r, http.MethodGet, http.MethodHead) {
+		return
+	}
+	params := r.URL.Query()
+	relPath, m, fsPath, ok := s.lookup(params.Get("path"))
+	if !ok {
+		s.renderError(w, r, http.StatusNotFound)
+		return
+	}
+	if info, err := m.stat(fsPath); err != nil || !info.IsDir() {
+		s.renderError(w, r, http.StatusNotFound)
+		return
+	}
+	limit := defaultSearchResults
+	if v := params.Get("limit"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 1 || n > maxSearchResults {
+			httpError(w, r, fmt.Sprintf("limit must be between 1 and %d", maxSearchResults), http.StatusBadRequest)
+			return
+		}
+		limit = n
+	}
+	q := strings.TrimSpace(params.Get("q"))
+	content := params.Get("content") == "1"
+	if content && s.search == nil {
+		httpError(w, r, "content search is not enabled", http.StatusBadRequest)
+		return
+	}
+	res := searchResponse{Results: []searchResult{}}
+	switch {
+	case q == "":
+	case content:
+		var err error
+		if res, err = s.searchContents(r.Context(), q, relPath, limit); err != nil {
+			httpError(w, r, "invalid query: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+	default:
+		res = s.searchNames(r.Context(), q, relPath, limit)
+	}
+	res.Query, res.Path, res.Content = q, relPath, content
+
+	w.Header().Add("Vary", "Accept")
+	w.Header().Set("Cache-Control", "no-store")
+	if params.Get("format") == "json" || prefersJSON(r) {
+		writeJSON(w, http.StatusOK, res)
+		return
+	}
+	lang := s.language(w, r)
+	page := searchPage{
+		Lang:           lang,
+		Title:          msg(lang, "search_in", relPath),
+		Placeholder:    msg(lang, "search"),
+		Search:         msg(lang, "search"),
+		ContentLabel:   msg(lang, "search_content"),
+		Summary:        msg(lang, "matches", res.Total),
+		Assets:         s.endpoint(assetsPrefix),
+		Action:         s.endpoint(searchPath),
+		DirURL:         escapePath(strings.TrimSuffix(relPath, "/") + "/"),
+		searchResponse: res,
+		Indexed:        s.search != nil,
+	}
+	var buf bytes.Buffer
+	if err := searchTemplate.Execute(&buf, page); err != nil {
+		s.renderError(w, r, http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Header().Set("Content-Length", strconv.Itoa(buf.Len()))
+	if r.Method != http.MethodHead {
+		buf.WriteTo(w)
+	}
+}
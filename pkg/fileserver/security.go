@@ -0,0 +1,58 @@
+package fileserver
+
+import (
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Events of the security log, whose format Options.SecurityLog documents
+// and the fail2ban filter in cmd/go-server/fail2ban matches. Changing it
+// breaks that filter and any other a user wrote.
+const (
+	// securityAuthFailure is a login with a wrong password, an unknown
+	// user or key, a bad admin token or a rejected Kerberos ticket.
+	securityAuthFailure = "auth_failure"
+	// securityPathTraversal is a request path that tries to climb out of
+	// the served tree with ".." segments, encoded dot segments or NUL.
+	securityPathTraversal = "path_traversal"
+	// securityRateLimited is a request refused by the rate limiter.
+	securityRateLimited = "rate_limited"
+)
+
+// securityEvent writes an event to the security log, if there is one.
+// client is an IP address, or a host:port whose port is dropped.
+func (s *Server) securityEvent(event, client, service, user, urlPath string) {
+	if s.securityLog == nil {
+		return
+	}
+	if host, _, err := net.SplitHostPort(client); err == nil {
+		client = host
+	}
+	var b strings.Builder
+	b.WriteString(time.Now().UTC().Format("2006-01-02T15:04:05.000Z"))
+	b.WriteString(" " + event + " client=" + client + " service=" + service)
+	// Quoting keeps what clients send from ending the line or faking a
+	// field.
+	if user != "" {
+		b.WriteString(" user=" + strconv.Quote(user))
+	}
+	if urlPath != "" {
+		b.WriteString(" path=" + strconv.Quote(urlPath))
+	}
+	b.WriteByte('\n')
+	s.securityLog.Write([]byte(b.String()))
+}
+
+// securityRequestEvent writes an event about an HTTP request to the
+// security log, with the path as the client sent it rather than as the
+// endpoints below the reserved prefix see it.
+func (s *Server) securityRequestEvent(event string, r *http.Request, service, user string) {
+	p, _, _ := strings.Cut(r.RequestURI, "?")
+	if p == "" {
+		p = r.URL.EscapedPath()
+	}
+	s.securityEvent(event, RemoteIP(r), service, user, p)
+}
@@ -0,0 +1,102 @@
+package fileserver
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/getsentry/sentry-go"
+)
+
+// Sentry reports handler panics and 5xx responses to Sentry, with the
+// request and a stack trace: where the panic happened, or where the
+// error status was written.
+type Sentry struct {
+	DSN string // the project's client key URL
+	// Environment, such as "production", and Release, such as the server
+	// version, tell deployments apart in Sentry. Both are optional.
+	Environment string
+	Release     string
+}
+
+// sentryFlush bounds how long Close waits for queued events to be sent.
+const sentryFlush = 2 * time.Second
+
+// sentryRequestKey is the request context key of the *sentryRequest the
+// logging middleware keeps for each request.
+type sentryRequestKey struct{}
+
+type sentryRequest struct {
+	hub      *sentry.Hub
+	reported bool // a panic was already sent for this request
+}
+
+func (s *Server) startSentry(opts *Sentry) error {
+	if opts == nil {
+		return nil
+	}
+	client, err := sentry.NewClient(sentry.ClientOptions{
+		Dsn:              opts.DSN,
+		Environment:      opts.Environment,
+		Release:          opts.Release,
+		AttachStacktrace: true,
+	})
+	if err != nil {
+		return fmt.Errorf("sentry: %w", err)
+	}
+	s.sentry = sentry.NewHub(client, sentry.NewScope())
+	return nil
+}
+
+// sentryHub returns a hub whose events carry r. The request is attached
+// without its body, which may hold uploaded files or passwords; headers
+// such as Authorization and Cookie are left out by Sentry itself.
+func (s *Server) sentryHub(r *http.Request, reqID string) *sentry.Hub {
+	hub := s.sentry.Clone()
+	bodyless := r.WithContext(r.Context())
+	bodyless.Body = http.NoBody
+	hub.Scope().SetRequest(bodyless)
+	if reqID != "" {
+		hub.Scope().SetTag("request_id", reqID)
+	}
+	return hub
+}
+
+// sentryStart returns r carrying the request's hub, for recovery to report
+// panics with.
+func (s *Server) sentryStart(r *http.Request, reqID string) (*http.Request, *sentryRequest) {
+	sr := &sentryRequest{hub: s.sentryHub(r, reqID)}
+	return r.WithContext(context.WithValue(r.Context(), sentryRequestKey{}, sr)), sr
+}
+
+// sentryFinish reports a 5xx response that no panic report covers.
+func (s *Server) sentryFinish(sr *sentryRequest, r *http.Request, lrw *loggingResponseWriter) {
+	if lrw.status < 500 || sr.reported {
+		return
+	}
+	event := sentry.NewEvent()
+	event.Level = sentry.LevelError
+	event.Message = strconv.Itoa(lrw.status) + " " + http.StatusText(lrw.status)
+	event.Transaction = r.Method + " " + r.URL.Path
+	event.Tags["status"] = strconv.Itoa(lrw.status)
+	if lrw.stack != nil {
+		event.Threads = []sentry.Thread{{Stacktrace: lrw.stack, Current: true}}
+	}
+	sr.hub.CaptureEvent(event)
+}
+
+// reportPanic sends a recovered panic to Sentry, if configured.
+func (s *Server) reportPanic(r *http.Request, v any) {
+	if s.sentry == nil {
+		return
+	}
+	sr, ok := r.Context().Value(sentryRequestKey{}).(*sentryRequest)
+	if !ok {
+		// The logging middleware is not in front of recovery.
+		sr = &sentryRequest{hub: s.sentryHub(r, "")}
+	}
+	sr.reported = true
+	sr.hub.RecoverWithContext(r.Context(), v)
+}
@@ -0,0 +1,373 @@
+package fileserver
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/pkg/sftp"
+	"golang.org/x/crypto/ssh"
+)
+
+// sftpHandshakeTimeout bounds the SSH handshake, including authentication.
+const sftpHandshakeTimeout = 30 * time.Second
+
+// SFTPOptions configure Server.ServeSFTP.
+type SFTPOptions struct {
+	// HostKey identifies the server to clients. It is required.
+	HostKey ssh.Signer
+	// AuthorizedKeys may log in as any user without a password. Passwords
+	// are checked against Settings.AuthUsers and Options.Htpasswd. With
+	// neither users nor keys, clients need no credentials, as over HTTP.
+	AuthorizedKeys []ssh.PublicKey
+}
+
+// ServeSFTP accepts SSH connections on ln and offers the "sftp" subsystem
+// over the same mounts, hidden-file policies and plugin hooks as HTTP.
+// Writes are allowed only inside read-write mounts. It returns when ln
+// fails, typically because it was closed.
+func (s *Server) ServeSFTP(ln net.Listener, opts SFTPOptions) error {
+	if opts.HostKey == nil {
+		return errors.New("sftp: no host key")
+	}
+	authorized := make(map[string]bool, len(opts.AuthorizedKeys))
+	for _, k := range opts.AuthorizedKeys {
+		authorized[string(k.Marshal())] = true
+	}
+	for {
+		conn, err := ln.Accept()
+		if err != nil {
+			return err
+		}
+		go s.serveSSH(conn, opts.HostKey, authorized)
+	}
+}
+
+// sshConfig is built per connection so that changes to the users made by
+// SetSettings apply to new sessions.
+func (s *Server) sshConfig(hostKey ssh.Signer, authorized map[string]bool) *ssh.ServerConfig {
+	st := s.settings.Load()
+	cfg := &ssh.ServerConfig{
+		NoClientAuth: !s.authRequired(st) && len(authorized) == 0,
+		PasswordCallback: func(c ssh.ConnMetadata, pass []byte) (*ssh.Permissions, error) {
+			if s.checkUser(st, c.User(), string(pass)) {
+				return nil, nil
+			}
+			s.securityEvent(securityAuthFailure, c.RemoteAddr().String(), "sftp", c.User(), "")
+			return nil, fmt.Errorf("invalid password for %s", c.User())
+		},
+		PublicKeyCallback: func(c ssh.ConnMetadata, key ssh.PublicKey) (*ssh.Permissions, error) {
+			if authorized[string(key.Marshal())] {
+				return nil, nil
+			}
+			return nil, fmt.Errorf("unknown public key for %s", c.User())
+		},
+	}
+	cfg.AddHostKey(hostKey)
+	return cfg
+}
+
+func (s *Server) serveSSH(conn net.Conn, hostKey ssh.Signer, authorized map[string]bool) {
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(sftpHandshakeTimeout))
+	sc, chans, reqs, err := ssh.NewServerConn(conn, s.sshConfig(hostKey, authorized))
+	if err != nil {
+		s.log.Warn("SFTP handshake failed", "remote_addr", conn.RemoteAddr().String(), "error", err)
+		return
+	}
+	defer sc.Close()
+	conn.SetDeadline(time.Time{})
+	s.log.Info("SFTP session started", "user", sc.User(), "remote_addr", sc.RemoteAddr().String())
+	go ssh.DiscardRequests(reqs)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	for nc := range chans {
+		if nc.ChannelType() != "session" {
+			nc.Reject(ssh.UnknownChannelType, "only session channels are supported")
+			continue
+		}
+		ch, chReqs, err := nc.Accept()
+		if err != nil {
+			continue
+		}
+		go s.serveSFTPChannel(ctx, sc, ch, chReqs)
+	}
+	s.log.Info("SFTP session ended", "user", sc.User(), "remote_addr", sc.RemoteAddr().String())
+}
+
+// serveSFTPChannel waits for the session to ask for the sftp subsystem and
+// then serves it. Shells and commands are refused.
+func (s *Server) serveSFTPChannel(ctx context.Context, sc *ssh.ServerConn, ch ssh.Channel, reqs <-chan *ssh.Request) {
+	defer ch.Close()
+	for req := range reqs {
+		ok := req.Type == "subsystem" && len(req.Payload) > 4 && string(req.Payload[4:]) == "sftp"
+		req.Reply(ok, nil)
+		if !ok {
+			continue
+		}
+		go ssh.DiscardRequests(reqs)
+		h := &sftpHandler{s: s, ctx: ctx, user: sc.User(), remoteAddr: sc.RemoteAddr().String()}
+		rs := sftp.NewRequestServer(ch, sftp.Handlers{FileGet: h, FilePut: h, FileCmd: h, FileList: h})
+		// Clients usually just close the connection when done.
+		if err := rs.Serve(); err != nil && !errors.Is(err, io.EOF) && !errors.Is(err, io.ErrUnexpectedEOF) {
+			s.log.Warn("SFTP session failed", "user", h.user, "error", err)
+		}
+		rs.Close()
+		return
+	}
+}
+
+// sftpHandler implements the sftp request handlers for one session.
+type sftpHandler struct {
+	s          *Server
+	ctx        context.Context
+	user       string
+	remoteAddr string
+}
+
+// request builds the request handed to plugin hooks for an SFTP operation.
+func (h *sftpHandler) request(method, urlPath string) *http.Request {
+	r := hookRequest(h.ctx, method, urlPath)
+	r.Proto = "SFTP"
+	r.RemoteAddr = h.remoteAddr
+	return r
+}
+
+// sftpError maps the errors of the shared write operations to SFTP
+// status codes; other errors are translated by the sftp package.
+func sftpError(err error) error {
+	var oe *opError
+	if !errors.As(err, &oe) {
+		return err
+	}
+	switch oe.code {
+	case http.StatusNotFound:
+		return sftp.ErrSSHFxNoSuchFile
+	case http.StatusForbidden:
+		return sftp.ErrSSHFxPermissionDenied
+	}
+	return err
+}
+
+func (h *sftpHandler) Fileread(r *sftp.Request) (io.ReaderAt, error) {
+	relPath, m, fsPath, ok := h.s.lookup(r.Filepath)
+	if !ok {
+		return nil, sftp.ErrSSHFxNoSuchFile
+	}
+	f, info, err := m.openContent(fsPath)
+	if err != nil {
+		return nil, err
+	}
+	if info.IsDir() {
+		f.Close()
+		return nil, fmt.Errorf("%s is a directory", relPath)
+	}
+	h.s.fileServed(h.request(http.MethodGet, relPath), fsPath, info)
+	return f, nil
+}
+
+// Filewrite writes to a temporary file beside the target, which replaces
+// the target when the client closes it, so that readers never see a
+// partial upload. Opening without truncation starts from the existing
+// contents, letting clients resume.
+func (h *sftpHandler) Filewrite(r *sftp.Request) (io.WriterAt, error) {
+	relPath, fsPath, err := h.s.writable(r.Filepath)
+	if err != nil {
+		return nil, sftpError(err)
+	}
+	flags := r.Pflags()
+	existing, err := os.Open(fsPath)
+	switch {
+	case err == nil:
+		defer existing.Close()
+		if flags.Excl {
+			return nil, os.ErrExist
+		}
+		if info, err := existing.Stat(); err != nil || info.IsDir() {
+			return nil, fmt.Errorf("%s is a directory", relPath)
+		}
+	case !errors.Is(err, os.ErrNotExist):
+		return nil, err
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(fsPath), ".upload-*")
+	if err != nil {
+		return nil, err
+	}
+	if existing != nil && !flags.Trunc {
+		if _, err := io.Copy(tmp, existing); err != nil {
+			tmp.Close()
+			os.Remove(tmp.Name())
+			return nil, err
+		}
+	}
+	return &sftpUpload{File: tmp, h: h, relPath: relPath, fsPath: fsPath}, nil
+}
+
+// sftpUpload is a file being written over SFTP.
+type sftpUpload struct {
+	*os.File
+	h       *sftpHandler
+	relPath string
+	fsPath  string
+	failed  bool
+}
+
+// TransferError is called by the sftp package when the session ends with
+// the file still open; the upload is then discarded.
+func (u *sftpUpload) TransferError(error) {
+	u.failed = true
+}
+
+func (u *sftpUpload) Close() error {
+	defer os.Remove(u.Name())
+	if u.failed {
+		return u.File.Close()
+	}
+	info, err := u.Stat()
+	if err != nil {
+		u.File.Close()
+		return err
+	}
+	if err := u.Chmod(0o644); err != nil {
+		u.File.Close()
+		return err
+	}
+	if err := u.File.Close(); err != nil {
+		return err
+	}
+	if err := u.h.s.keepVersion(u.fsPath); err != nil {
+		return err
+	}
+	if err := os.Rename(u.Name(), u.fsPath); err != nil {
+		return err
+	}
+	s := u.h.s
+	s.invalidate(u.fsPath)
+	s.log.Info("File uploaded", "path", u.relPath, "size", info.Size(), "via", "sftp", "user", u.h.user)
+	s.uploaded(u.h.request(http.MethodPut, u.relPath), u.fsPath, info.Size())
+	s.mirrorLater(u.fsPath)
+	return nil
+}
+
+func (h *sftpHandler) Filecmd(r *sftp.Request) error {
+	switch r.Method {
+	case "Setstat":
+		return h.setstat(r)
+	case "Rename":
+		return sftpError(h.s.batchMove(batchOp{Op: "move", From: r.Filepath, To: r.Target}))
+	case "Remove", "Rmdir":
+		_, fsPath, err := h.s.writable(r.Filepath)
+		if err != nil {
+			return sftpError(err)
+		}
+		info, err := os.Lstat(fsPath)
+		if err != nil {
+			return err
+		}
+		if info.IsDir() != (r.Method == "Rmdir") {
+			return sftp.ErrSSHFxFailure
+		}
+		return sftpError(h.s.batchDelete(h.request(http.MethodDelete, r.Filepath), batchOp{Op: "delete", Path: r.Filepath}))
+	case "Mkdir":
+		_, fsPath, err := h.s.writable(r.Filepath)
+		if err != nil {
+			return sftpError(err)
+		}
+		if err := os.Mkdir(fsPath, 0o755); err != nil {
+			return err
+		}
+		h.s.invalidate(fsPath)
+		return nil
+	}
+	// Links could point outside the mounts.
+	return sftp.ErrSSHFxOpUnsupported
+}
+
+// setstat applies the size, permissions and times a client sets. Owners
+// are left alone.
+func (h *sftpHandler) setstat(r *sftp.Request) error {
+	_, fsPath, err := h.s.writable(r.Filepath)
+	if err != nil {
+		return sftpError(err)
+	}
+	flags, attrs := r.AttrFlags(), r.Attributes()
+	if flags.Size {
+		if err := os.Truncate(fsPath, int64(attrs.Size)); err != nil {
+			return err
+		}
+	}
+	if flags.Permissions {
+		if err := os.Chmod(fsPath, attrs.FileMode().Perm()); err != nil {
+			return err
+		}
+	}
+	if flags.Acmodtime {
+		if err := os.Chtimes(fsPath, attrs.AccessTime(), attrs.ModTime()); err != nil {
+			return err
+		}
+	}
+	h.s.invalidate(fsPath)
+	return nil
+}
+
+func (h *sftpHandler) Filelist(r *sftp.Request) (sftp.ListerAt, error) {
+	relPath, m, fsPath, ok := h.s.lookup(r.Filepath)
+	if !ok {
+		return nil, sftp.ErrSSHFxNoSuchFile
+	}
+	switch r.Method {
+	case "List":
+		entries, _, err := h.s.readListing(r.Context(), m, fsPath, relPath)
+		if err != nil {
+			return nil, err
+		}
+		infos := make(listerAt, len(entries))
+		for i, e := range entries {
+			infos[i] = entryInfo(e)
+		}
+		return infos, nil
+	case "Stat", "Lstat":
+		// Symlinks are followed, as over HTTP.
+		info, err := m.stat(fsPath)
+		if err != nil {
+			return nil, err
+		}
+		return listerAt{info}, nil
+	}
+	return nil, sftp.ErrSSHFxOpUnsupported
+}
+
+type listerAt []os.FileInfo
+
+func (l listerAt) ListAt(dst []os.FileInfo, offset int64) (int, error) {
+	if offset >= int64(len(l)) {
+		return 0, io.EOF
+	}
+	n := copy(dst, l[offset:])
+	if n < len(dst) {
+		return n, io.EOF
+	}
+	return n, nil
+}
+
+// entryInfo presents a listing entry as an os.FileInfo.
+type entryInfo listEntry
+
+func (e entryInfo) Name() string       { return e.name }
+func (e entryInfo) Size() int64        { return e.size }
+func (e entryInfo) ModTime() time.Time { return e.modTime }
+func (e entryInfo) IsDir() bool        { return e.isDir }
+func (e entryInfo) Sys() any           { return nil }
+func (e entryInfo) Mode() os.FileMode {
+	if e.isDir {
+		return os.ModeDir | 0o755
+	}
+	return 0o644
+}
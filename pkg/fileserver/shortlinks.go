@@ -0,0 +1,200 @@
+package fileserver
+
+import (
+	"crypto/rand"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+)
+
+// Short links under /s/ redirect to paths in the served tree. They are
+// kept in the metadata store if there is one, and otherwise in a JSON
+// file mapping codes to paths, rewritten on every change.
+const (
+	shortPrefix     = "/s/"
+	shortCodeLength = 6
+	shortCodeChars  = "abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ0123456789"
+)
+
+// shortLinkStore is where short links are kept.
+type shortLinkStore interface {
+	get(code string) (p string, ok bool, err error)
+	// shorten returns the code for p, creating and saving a new one unless
+	// p already has one.
+	shorten(p string) (code string, created bool, err error)
+}
+
+type shortLinks struct {
+	file string
+
+	mu     sync.Mutex
+	codes  map[string]string // code -> path
+	byPath map[string]string // path -> code
+}
+
+func loadShortLinks(file string) (*shortLinks, error) {
+	sl := &shortLinks{file: file, codes: make(map[string]string), byPath: make(map[string]string)}
+	data, err := os.ReadFile(file)
+	if errors.Is(err, os.ErrNotExist) {
+		return sl, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(data, &sl.codes); err != nil {
+		return nil, fmt.Errorf("short links %s: %w", file, err)
+	}
+	for code, p := range sl.codes {
+		sl.byPath[p] = code
+	}
+	return sl, nil
+}
+
+func (sl *shortLinks) get(code string) (string, bool, error) {
+	sl.mu.Lock()
+	defer sl.mu.Unlock()
+	p, ok := sl.codes[code]
+	return p, ok, nil
+}
+
+func (sl *shortLinks) shorten(p string) (code string, created bool, err error) {
+	sl.mu.Lock()
+	defer sl.mu.Unlock()
+	if code, ok := sl.byPath[p]; ok {
+		return code, false, nil
+	}
+	for code == "" || sl.codes[code] != "" {
+		code = newShortCode()
+	}
+	sl.codes[code] = p
+	if err := sl.save(); err != nil {
+		delete(sl.codes, code)
+		return "", false, err
+	}
+	sl.byPath[p] = code
+	return code, true, nil
+}
+
+// save writes the links to a temporary file and renames it over the old
+// one, so a crash never leaves a truncated file.
+func (sl *shortLinks) save() error {
+	data, err := json.MarshalIndent(sl.codes, "", "  ")
+	if err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(sl.file), ".shortlinks-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	_, err = tmp.Write(data)
+	if cerr := tmp.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), sl.file)
+}
+
+func newShortCode() string {
+	b := make([]byte, shortCodeLength)
+	rand.Read(b)
+	for i := range b {
+		b[i] = shortCodeChars[int(b[i])%len(shortCodeChars)]
+	}
+	return string(b)
+}
+
+func validShortCode(code string) bool {
+	if len(code) != shortCodeLength {
+		return false
+	}
+	for i := 0; i < len(code); i++ {
+		if !strings.ContainsRune(shortCodeChars, rune(code[i])) {
+			return false
+		}
+	}
+	return true
+}
+
+// shortHandler redirects GET /s/<code> to the linked path.
+func (s *Server) shortHandler(w http.ResponseWriter, r *http.Request) {
+	if !s.allowMethods(w, r, http.MethodGet, http.MethodHead) {
+		return
+	}
+	code := strings.TrimPrefix(r.URL.Path, shortPrefix)
+	p, ok := "", validShortCode(code)
+	if ok {
+		var err error
+		if p, ok, err = s.shortLinks.get(code); err != nil {
+			s.log.Error("Reading short links failed", "error", err)
+			s.renderError(w, r, http.StatusInternalServerError)
+			return
+		}
+	}
+	if !ok {
+		s.renderError(w, r, http.StatusNotFound)
+		return
+	}
+	http.Redirect(w, r, escapePath(p), http.StatusFound)
+}
+
+type shortenRequest struct {
+	Path string `json:"path"`
+}
+
+func (sr *shortenRequest) validate() map[string]string {
+	if sr.Path == "" {
+		return map[string]string{"path": "required"}
+	}
+	return nil
+}
+
+type shortenResponse struct {
+	Code string `json:"code"`
+	Path string `json:"path"`
+	URL  string `json:"url"`
+}
+
+// apiShorten answers POST /api/v1/shorten {"path": "/deep/path"} with a
+// short link to an existing file or directory.
+func (s *Server) apiShorten(w http.ResponseWriter, r *http.Request) {
+	var req shortenRequest
+	if !s.decodeAPIRequest(w, r, &req) {
+		return
+	}
+	relPath, m, fsPath, ok := s.lookup(req.Path)
+	if !ok {
+		writeAPIError(w, http.StatusNotFound, http.StatusText(http.StatusNotFound), nil)
+		return
+	}
+	info, err := m.stat(fsPath)
+	if err != nil {
+		status := statusForError(err)
+		writeAPIError(w, status, http.StatusText(status), nil)
+		return
+	}
+	if info.IsDir() && relPath != "/" {
+		relPath += "/"
+	}
+	code, created, err := s.shortLinks.shorten(relPath)
+	if err != nil {
+		s.log.Error("Saving short links failed", "error", err)
+		writeAPIError(w, http.StatusInternalServerError, "could not save the short link", nil)
+		return
+	}
+	status := http.StatusOK
+	if created {
+		status = http.StatusCreated
+		s.log.Info("Short link created", "code", code, "path", relPath, "remote_ip", RemoteIP(r))
+	}
+	link := s.endpoint(shortPrefix + code)
+	w.Header().Set("Location", link)
+	writeJSON(w, status, shortenResponse{Code: code, Path: relPath, URL: link})
+}
@@ -0,0 +1,107 @@
+package fileserver
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"io"
+	"net/http"
+	"path"
+	"time"
+)
+
+// statHashes are the digests GET /api/v1/stat computes on request.
+var statHashes = []string{"sha256"}
+
+type statResponse struct {
+	Path     string    `json:"path"`
+	Name     string    `json:"name"`
+	Size     int64     `json:"size"`
+	ModTime  time.Time `json:"mod_time"`
+	Mode     string    `json:"mode"`
+	IsDir    bool      `json:"is_dir"`
+	MIMEType string    `json:"mime_type"`
+	// Hashes lists the digests available with ?hash=; the requested one
+	// is returned in SHA256.
+	Hashes []string `json:"hashes"`
+	SHA256 string   `json:"sha256,omitempty"`
+}
+
+// apiStat answers GET /api/v1/stat?path=/sub/file[&hash=sha256].
+func (s *Server) apiStat(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	if q.Get("path") == "" {
+		writeAPIError(w, http.StatusBadRequest, "missing path", map[string]string{"path": "required"})
+		return
+	}
+	hash := q.Get("hash")
+	if hash != "" && hash != "sha256" {
+		writeAPIError(w, http.StatusBadRequest, "unsupported hash", map[string]string{"hash": "must be sha256"})
+		return
+	}
+	relPath, m, fsPath, ok := s.lookup(q.Get("path"))
+	if !ok {
+		writeAPIError(w, http.StatusNotFound, http.StatusText(http.StatusNotFound), nil)
+		return
+	}
+	info, err := m.stat(fsPath)
+	if err != nil {
+		status := statusForError(err)
+		writeAPIError(w, status, http.StatusText(status), nil)
+		return
+	}
+	resp := statResponse{
+		Path:    relPath,
+		Name:    path.Base(relPath),
+		Size:    info.Size(),
+		ModTime: info.ModTime(),
+		Mode:    info.Mode().String(),
+		IsDir:   info.IsDir(),
+		Hashes:  []string{},
+	}
+	if info.IsDir() {
+		resp.MIMEType = "inode/directory"
+		writeJSON(w, http.StatusOK, resp)
+		return
+	}
+	resp.Hashes = statHashes
+	if resp.MIMEType, resp.SHA256, err = s.fileTypeAndHash(m, fsPath, hash != ""); err != nil {
+		status := statusForError(err)
+		writeAPIError(w, status, http.StatusText(status), nil)
+		return
+	}
+	writeJSON(w, http.StatusOK, resp)
+}
+
+// fileTypeAndHash determines a file's MIME type from its extension or,
+// failing that, its first 512 bytes, and optionally its SHA-256 digest.
+func (s *Server) fileTypeAndHash(m *Mount, fsPath string, withHash bool) (mimeType, digest string, err error) {
+	mimeType = s.mimeType(fsPath)
+	if mimeType != "" && !withHash {
+		return mimeType, "", nil
+	}
+	f, err := m.open(fsPath)
+	if err != nil {
+		return "", "", err
+	}
+	defer f.Close()
+	var r io.Reader = f
+	if mimeType == "" {
+		head := make([]byte, 512)
+		n, err := io.ReadFull(f, head)
+		if err != nil && !errors.Is(err, io.ErrUnexpectedEOF) && !errors.Is(err, io.EOF) {
+			return "", "", err
+		}
+		mimeType = http.DetectContentType(head[:n])
+		r = io.MultiReader(bytes.NewReader(head[:n]), f)
+	}
+	if withHash {
+		h := sha256.New()
+		if _, err := io.Copy(h, r); err != nil {
+			return "", "", err
+		}
+		digest = hex.EncodeToString(h.Sum(nil))
+	}
+	return mimeType, digest, nil
+}
@@ -0,0 +1,59 @@
+package fileserver
+
+import (
+	"fmt"
+	"mime"
+	"net/http"
+	"path"
+	"strconv"
+	"strings"
+)
+
+// StaticRoute answers GET and HEAD requests for a path with a fixed
+// response. As with http.ServeMux, a path ending in a slash matches
+// everything below it, so a route for "/" with status 503 puts the whole
+// server into maintenance.
+type StaticRoute struct {
+	Path        string
+	Status      int // defaults to 200
+	Body        string
+	ContentType string // defaults to the type for the path's extension
+	Headers     map[string]string
+}
+
+func (s *Server) staticHandler(sr StaticRoute) (string, http.Handler, error) {
+	if !strings.HasPrefix(sr.Path, "/") || strings.ContainsAny(sr.Path, "{} ") {
+		return "", nil, fmt.Errorf("invalid static route path %q", sr.Path)
+	}
+	if s.isReserved(path.Clean(sr.Path)) {
+		return "", nil, fmt.Errorf("static route path %q is reserved", sr.Path)
+	}
+	status := sr.Status
+	if status == 0 {
+		status = http.StatusOK
+	}
+	if status < 100 || status > 999 {
+		return "", nil, fmt.Errorf("static route %s: invalid status %d", sr.Path, sr.Status)
+	}
+	ctype := sr.ContentType
+	if ctype == "" {
+		ctype = mime.TypeByExtension(path.Ext(sr.Path))
+	}
+	if ctype == "" {
+		ctype = http.DetectContentType([]byte(sr.Body))
+	}
+	body := []byte(sr.Body)
+	h := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		hdr := w.Header()
+		for k, v := range sr.Headers {
+			hdr.Set(k, v)
+		}
+		hdr.Set("Content-Type", ctype)
+		hdr.Set("Content-Length", strconv.Itoa(len(body)))
+		w.WriteHeader(status)
+		if r.Method != http.MethodHead {
+			w.Write(body)
+		}
+	})
+	return "GET " + sr.Path, h, nil
+}
@@ -0,0 +1,124 @@
+package fileserver
+
+import (
+	"slices"
+	"sort"
+	"sync"
+	"time"
+)
+
+// latencyBounds are the upper bounds of the histogram buckets: roughly 25%
+// apart from 50µs to a minute, so percentiles are accurate to that ratio.
+var latencyBounds = func() []time.Duration {
+	var b []time.Duration
+	for d := 50 * time.Microsecond; d < time.Minute; d = d * 5 / 4 {
+		b = append(b, d)
+	}
+	return append(b, time.Minute)
+}()
+
+// latencyStats is a request latency histogram covering one summary interval.
+type latencyStats struct {
+	mu           sync.Mutex
+	buckets      []uint64 // len(latencyBounds)+1; the last bucket is overflow
+	count        uint64
+	clientErrors uint64
+	serverErrors uint64
+	max          time.Duration
+}
+
+func newLatencyStats() *latencyStats {
+	return &latencyStats{buckets: make([]uint64, len(latencyBounds)+1)}
+}
+
+// Observe records one completed request.
+func (s *latencyStats) Observe(d time.Duration, status int) {
+	i := sort.Search(len(latencyBounds), func(i int) bool { return latencyBounds[i] >= d })
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.buckets[i]++
+	s.count++
+	if status >= 500 {
+		s.serverErrors++
+	} else if status >= 400 {
+		s.clientErrors++
+	}
+	if d > s.max {
+		s.max = d
+	}
+}
+
+// snapshot returns a copy of the current interval's data.
+func (s *latencyStats) snapshot() *latencyStats {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return &latencyStats{
+		buckets:      slices.Clone(s.buckets),
+		count:        s.count,
+		clientErrors: s.clientErrors,
+		serverErrors: s.serverErrors,
+		max:          s.max,
+	}
+}
+
+// reset returns a copy of the current interval's data and starts a new one.
+func (s *latencyStats) reset() *latencyStats {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	snap := &latencyStats{
+		buckets:      s.buckets,
+		count:        s.count,
+		clientErrors: s.clientErrors,
+		serverErrors: s.serverErrors,
+		max:          s.max,
+	}
+	s.buckets = make([]uint64, len(latencyBounds)+1)
+	s.count, s.clientErrors, s.serverErrors, s.max = 0, 0, 0, 0
+	return snap
+}
+
+// percentile returns the upper bound of the bucket holding quantile q.
+func (s *latencyStats) percentile(q float64) time.Duration {
+	if s.count == 0 {
+		return 0
+	}
+	rank := uint64(q*float64(s.count) + 0.5)
+	if rank == 0 {
+		rank = 1
+	}
+	var seen uint64
+	for i, n := range s.buckets {
+		seen += n
+		if seen >= rank {
+			if i == len(latencyBounds) || latencyBounds[i] > s.max {
+				return s.max
+			}
+			return latencyBounds[i]
+		}
+	}
+	return s.max
+}
+
+// logStats emits a summary line every interval until the Server is closed.
+func (s *Server) logStats(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			snap := s.stats.reset()
+			s.log.Info("request summary",
+				"interval", interval.String(),
+				"requests", snap.count,
+				"client_errors", snap.clientErrors,
+				"server_errors", snap.serverErrors,
+				"p50", snap.percentile(0.50).String(),
+				"p95", snap.percentile(0.95).String(),
+				"p99", snap.percentile(0.99).String(),
+				"max", snap.max.String(),
+			)
+		case <-s.stop:
+			return
+		}
+	}
+}
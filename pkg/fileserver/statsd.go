@@ -0,0 +1,143 @@
+package fileserver
+
+import (
+	"bytes"
+	"fmt"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// StatsD pushes request metrics to a StatsD or DogStatsD agent over UDP:
+// a requests counter by status class, a request_duration timing in
+// milliseconds and a response_bytes counter.
+type StatsD struct {
+	Addr string // host:port of the agent, e.g. 127.0.0.1:8125
+	// Prefix is put before every metric name. It defaults to "go_server.".
+	Prefix string
+	// DogStatsD tags the metrics with the method and status class in the
+	// DogStatsD format instead of putting the status class in the name of
+	// the requests counter.
+	DogStatsD bool
+	// Tags are added to every metric, such as "env:prod"; DogStatsD only.
+	Tags []string
+}
+
+const (
+	defaultStatsDPrefix = "go_server."
+	// statsdPacket is the largest datagram sent, which fits an Ethernet
+	// frame with room to spare.
+	statsdPacket = 1432
+	// statsdFlush is how often buffered metrics are sent when no packet
+	// fills up.
+	statsdFlush = time.Second
+)
+
+// statsdSink batches metric lines into datagrams.
+type statsdSink struct {
+	StatsD
+	conn net.Conn
+	tags string // ",a:b,c:d" or ""
+
+	mu  sync.Mutex
+	buf bytes.Buffer
+}
+
+// startStatsD connects to the agent and flushes metrics to it until the
+// server is closed. UDP needs no handshake, so an agent that is down only
+// loses the metrics sent meanwhile.
+func (s *Server) startStatsD(opts *StatsD) error {
+	if opts == nil {
+		return nil
+	}
+	if len(opts.Tags) > 0 && !opts.DogStatsD {
+		return fmt.Errorf("statsd %s: tags need DogStatsD", opts.Addr)
+	}
+	conn, err := net.Dial("udp", opts.Addr)
+	if err != nil {
+		return fmt.Errorf("statsd %s: %w", opts.Addr, err)
+	}
+	sink := &statsdSink{StatsD: *opts, conn: conn}
+	if sink.Prefix == "" {
+		sink.Prefix = defaultStatsDPrefix
+	}
+	if len(opts.Tags) > 0 {
+		sink.tags = "," + strings.Join(opts.Tags, ",")
+	}
+	s.statsd = sink
+	go func() {
+		t := time.NewTicker(statsdFlush)
+		defer t.Stop()
+		for {
+			select {
+			case <-t.C:
+				sink.flush()
+			case <-s.stop:
+				sink.flush()
+				conn.Close()
+				return
+			}
+		}
+	}()
+	return nil
+}
+
+// observe records one served request.
+func (m *statsdSink) observe(r *http.Request, status int, bytes int64, d time.Duration) {
+	class := strconv.Itoa(status/100) + "xx"
+	ms := strconv.FormatFloat(float64(d.Microseconds())/1000, 'f', 3, 64)
+	if m.DogStatsD {
+		tags := "|#method:" + statsdMethod(r.Method) + ",status_class:" + class + m.tags
+		m.add(m.Prefix + "requests:1|c" + tags)
+		m.add(m.Prefix + "request_duration:" + ms + "|ms" + tags)
+		m.add(m.Prefix + "response_bytes:" + strconv.FormatInt(bytes, 10) + "|c" + tags)
+		return
+	}
+	m.add(m.Prefix + "requests." + class + ":1|c")
+	m.add(m.Prefix + "request_duration:" + ms + "|ms")
+	m.add(m.Prefix + "response_bytes:" + strconv.FormatInt(bytes, 10) + "|c")
+}
+
+// statsdMethod names a method for a tag, lumping unusual ones together so
+// that clients cannot make up new tag values.
+func statsdMethod(method string) string {
+	switch method {
+	case http.MethodGet, http.MethodHead, http.MethodPost, http.MethodPut, http.MethodPatch,
+		http.MethodDelete, http.MethodOptions:
+		return strings.ToLower(method)
+	}
+	return "other"
+}
+
+// add buffers a metric line, first sending the buffer if the line would
+// not fit in the packet.
+func (m *statsdSink) add(line string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.buf.Len() > 0 && m.buf.Len()+1+len(line) > statsdPacket {
+		m.send()
+	}
+	if m.buf.Len() > 0 {
+		m.buf.WriteByte('\n')
+	}
+	m.buf.WriteString(line)
+}
+
+func (m *statsdSink) flush() {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.send()
+}
+
+// send writes out the buffer; m.mu must be held. Errors, such as when the
+// agent is not listening, are dropped like the metrics.
+func (m *statsdSink) send() {
+	if m.buf.Len() == 0 {
+		return
+	}
+	m.conn.Write(m.buf.Bytes())
+	m.buf.Reset()
+}
@@ -0,0 +1,187 @@
+package fileserver
+
+import (
+	"context"
+	"errors"
+	"io"
+	"io/fs"
+	"path/filepath"
+	"sync"
+)
+
+// Every mount reads through an fs.FS: os.DirFS(Dir) unless Mount.FS
+// supplies another, such as an embed.FS, a zip.Reader or a remote
+// backend. Handlers resolve a URL path to a mount and a filesystem path as
+// before and use the helpers below to read it; writes still use the os
+// package, as only directory mounts may be read-write.
+
+// name converts a filesystem path under the mount to a name in its fs.FS.
+func (m *Mount) name(fsPath string) string {
+	rel, err := filepath.Rel(m.Dir, fsPath)
+	if err != nil {
+		return "."
+	}
+	return filepath.ToSlash(rel)
+}
+
+func (m *Mount) stat(fsPath string) (fs.FileInfo, error) {
+	return fs.Stat(m.fsys, m.name(fsPath))
+}
+
+func (m *Mount) open(fsPath string) (fs.File, error) {
+	return m.fsys.Open(m.name(fsPath))
+}
+
+// readDirBatch is how many entries readDir reads between checks of its
+// context.
+const readDirBatch = 256
+
+// readDir reads a directory like fs.ReadDir, unsorted and a batch at a
+// time where the filesystem allows, giving up with the context's error
+// once it is done, such as when the client has gone away. With a positive
+// limit it stops after that many entries and reports whether there were
+// more.
+func (m *Mount) readDir(ctx context.Context, fsPath string, limit int) (entries []fs.DirEntry, truncated bool, err error) {
+	f, err := m.open(fsPath)
+	if err != nil {
+		return nil, false, err
+	}
+	defer f.Close()
+	d, ok := f.(fs.ReadDirFile)
+	if !ok {
+		entries, err = fs.ReadDir(m.fsys, m.name(fsPath))
+		if limit > 0 && len(entries) > limit {
+			return entries[:limit], true, err
+		}
+		return entries, false, err
+	}
+	for {
+		if err := ctx.Err(); err != nil {
+			return nil, false, err
+		}
+		n := readDirBatch
+		if limit > 0 {
+			// One more than the limit tells whether there are more.
+			n = min(n, limit+1-len(entries))
+		}
+		batch, err := d.ReadDir(n)
+		entries = append(entries, batch...)
+		if limit > 0 && len(entries) > limit {
+			return entries[:limit], true, nil
+		}
+		if err == io.EOF {
+			return entries, false, nil
+		}
+		if err != nil {
+			return nil, false, err
+		}
+	}
+}
+
+// content is an open file that can seek and read at offsets, as
+// http.ServeContent and the SFTP server need.
+type content interface {
+	io.ReadSeekCloser
+	io.ReaderAt
+}
+
+// openContent opens a regular file for serving. Files from fs.FS
+// implementations that cannot seek, such as zip entries, are wrapped to
+// seek by reopening and skipping ahead.
+func (m *Mount) openContent(fsPath string) (content, fs.FileInfo, error) {
+	f, err := m.open(fsPath)
+	if err != nil {
+		return nil, nil, err
+	}
+	info, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return nil, nil, err
+	}
+	if c, ok := f.(content); ok {
+		return c, info, nil
+	}
+	return &rewindFile{m: m, fsPath: fsPath, f: f, size: info.Size()}, info, nil
+}
+
+// rewindFile makes a sequential fs.File seekable. Seeking backwards
+// reopens the file, so it suits the few seeks of range requests rather
+// than random access.
+type rewindFile struct {
+	m      *Mount
+	fsPath string
+	size   int64
+
+	mu  sync.Mutex
+	f   fs.File
+	pos int64
+}
+
+var errWhence = errors.New("seek: invalid whence or offset")
+
+func (rf *rewindFile) Read(p []byte) (int, error) {
+	rf.mu.Lock()
+	defer rf.mu.Unlock()
+	n, err := rf.f.Read(p)
+	rf.pos += int64(n)
+	return n, err
+}
+
+func (rf *rewindFile) Seek(offset int64, whence int) (int64, error) {
+	rf.mu.Lock()
+	defer rf.mu.Unlock()
+	return rf.seek(offset, whence)
+}
+
+func (rf *rewindFile) seek(offset int64, whence int) (int64, error) {
+	switch whence {
+	case io.SeekCurrent:
+		offset += rf.pos
+	case io.SeekEnd:
+		offset += rf.size
+	case io.SeekStart:
+	default:
+		return 0, errWhence
+	}
+	if offset < 0 {
+		return 0, errWhence
+	}
+	if offset < rf.pos {
+		f, err := rf.m.open(rf.fsPath)
+		if err != nil {
+			return 0, err
+		}
+		rf.f.Close()
+		rf.f, rf.pos = f, 0
+	}
+	if offset > rf.pos {
+		n, err := io.CopyN(io.Discard, rf.f, min(offset, rf.size)-rf.pos)
+		rf.pos += n
+		if err != nil && err != io.EOF {
+			return 0, err
+		}
+	}
+	// Seeking past the end is allowed; reads there return EOF.
+	rf.pos = offset
+	return offset, nil
+}
+
+func (rf *rewindFile) ReadAt(p []byte, off int64) (int, error) {
+	rf.mu.Lock()
+	defer rf.mu.Unlock()
+	if _, err := rf.seek(off, io.SeekStart); err != nil {
+		return 0, err
+	}
+	n, err := io.ReadFull(rf.f, p)
+	rf.pos += int64(n)
+	if err == io.ErrUnexpectedEOF {
+		err = io.EOF
+	}
+	return n, err
+}
+
+func (rf *rewindFile) Close() error {
+	rf.mu.Lock()
+	defer rf.mu.Unlock()
+	return rf.f.Close()
+}
@@ -0,0 +1,391 @@
+package fileserver
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"net/http"
+	"path"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// syncMaxFiles bounds both the manifest of a sync request and the
+	// files below the directory synced.
+	syncMaxFiles = 100000
+	// syncMaxBody bounds a sync request, which carries a whole manifest.
+	syncMaxBody = 64 << 20
+
+	syncDefaultBlock = 1 << 20
+	syncMinBlock     = 4 << 10
+	syncMaxBlock     = 64 << 20
+
+	// syncHashCacheMax bounds the digests kept between syncs.
+	syncHashCacheMax = 16384
+)
+
+// syncRequest is the body of POST /api/v1/sync: the files a client holds
+// below a directory, by path relative to it.
+type syncRequest struct {
+	Path      string           `json:"path"`
+	BlockSize int64            `json:"block_size"`
+	Files     []syncClientFile `json:"files"`
+}
+
+type syncClientFile struct {
+	Path   string `json:"path"`
+	Size   int64  `json:"size"`
+	SHA256 string `json:"sha256"`
+	// Blocks are the SHA-256 digests of each BlockSize bytes of the file,
+	// the last block being shorter. Without them a changed file is
+	// fetched whole.
+	Blocks []string `json:"blocks"`
+}
+
+func (q *syncRequest) validate() map[string]string {
+	fields := make(map[string]string)
+	if q.Path == "" {
+		fields["path"] = "required"
+	}
+	if q.BlockSize != 0 && (q.BlockSize < syncMinBlock || q.BlockSize > syncMaxBlock) {
+		fields["block_size"] = fmt.Sprintf("must be between %d and %d", syncMinBlock, syncMaxBlock)
+	}
+	if len(q.Files) > syncMaxFiles {
+		fields["files"] = fmt.Sprintf("more than %d entries", syncMaxFiles)
+		return fields
+	}
+	blockSize := q.blockSize()
+	seen := make(map[string]bool, len(q.Files))
+	// Only the first bad entry is reported, as a manifest can be long.
+	for i, f := range q.Files {
+		key := fmt.Sprintf("files[%d]", i)
+		switch {
+		case f.Path == "" || path.IsAbs(f.Path) || path.Clean(f.Path) != f.Path || f.Path == ".." || strings.HasPrefix(f.Path, "../"):
+			fields[key+".path"] = "must be a clean relative path"
+		case seen[f.Path]:
+			fields[key+".path"] = "duplicate"
+		case f.Size < 0:
+			fields[key+".size"] = "must not be negative"
+		case !sha256Digest(f.SHA256):
+			fields[key+".sha256"] = "must be a hex SHA-256 digest"
+		case f.Blocks != nil && int64(len(f.Blocks)) != (f.Size+blockSize-1)/blockSize:
+			fields[key+".blocks"] = fmt.Sprintf("want %d digests of %d-byte blocks", (f.Size+blockSize-1)/blockSize, blockSize)
+		case slices.ContainsFunc(f.Blocks, func(b string) bool { return !sha256Digest(b) }):
+			fields[key+".blocks"] = "must be hex SHA-256 digests"
+		default:
+			seen[f.Path] = true
+			continue
+		}
+		break
+	}
+	return fields
+}
+
+func (q *syncRequest) blockSize() int64 {
+	if q.BlockSize == 0 {
+		return syncDefaultBlock
+	}
+	return q.BlockSize
+}
+
+func sha256Digest(s string) bool {
+	b, err := hex.DecodeString(s)
+	return err == nil && len(b) == sha256.Size
+}
+
+type syncResponse struct {
+	Path      string `json:"path"`
+	BlockSize int64  `json:"block_size"`
+	// Added and Changed are the files to fetch; Deleted are the client's
+	// paths that are gone, to remove.
+	Added     []syncFile `json:"added"`
+	Changed   []syncFile `json:"changed"`
+	Deleted   []string   `json:"deleted"`
+	Unchanged int        `json:"unchanged"`
+	Bytes     int64      `json:"bytes"` // total to fetch
+}
+
+type syncFile struct {
+	Path    string    `json:"path"`
+	URL     string    `json:"url"`
+	Size    int64     `json:"size"`
+	ModTime time.Time `json:"mod_time"`
+	SHA256  string    `json:"sha256,omitempty"` // set when it was computed
+	// Ranges are the bytes to fetch with Range requests to URL: the whole
+	// file, or the blocks that differ. The client resizes its copy to Size
+	// and writes each range at its offset.
+	Ranges []syncRange `json:"ranges"`
+}
+
+type syncRange struct {
+	Offset int64 `json:"offset"`
+	Length int64 `json:"length"`
+}
+
+// apiSync answers POST /api/v1/sync, comparing a client's manifest of a
+// directory with the files below it, so that a mirror can fetch only what
+// changed: new files, and of changed files the blocks that differ.
+func (s *Server) apiSync(w http.ResponseWriter, r *http.Request) {
+	var req syncRequest
+	if !decodeAPIBody(w, r, &req, syncMaxBody) {
+		return
+	}
+	relPath, m, fsPath, ok := s.lookup(req.Path)
+	if !ok {
+		writeAPIError(w, http.StatusNotFound, http.StatusText(http.StatusNotFound), nil)
+		return
+	}
+	info, err := m.stat(fsPath)
+	if err != nil {
+		status := statusForError(err)
+		writeAPIError(w, status, http.StatusText(status), nil)
+		return
+	}
+	if !info.IsDir() {
+		writeAPIError(w, http.StatusUnprocessableEntity, "not a directory", nil)
+		return
+	}
+	if s.noIndexed(m, m.name(fsPath)) {
+		status := s.noIndexStatus()
+		writeAPIError(w, status, http.StatusText(status), nil)
+		return
+	}
+	files, err := s.syncFiles(r.Context(), m, m.name(fsPath))
+	if errors.Is(err, errSyncTooMany) {
+		writeAPIError(w, http.StatusUnprocessableEntity, err.Error(), map[string]string{"path": "sync a subdirectory"})
+		return
+	} else if err != nil {
+		if !s.canceled(r, err) {
+			status := statusForError(err)
+			writeAPIError(w, status, http.StatusText(status), nil)
+		}
+		return
+	}
+
+	blockSize := req.blockSize()
+	resp := syncResponse{Path: relPath, BlockSize: blockSize, Added: []syncFile{}, Changed: []syncFile{}, Deleted: []string{}}
+	client := make(map[string]syncClientFile, len(req.Files))
+	for _, f := range req.Files {
+		client[f.Path] = f
+		if _, ok := files[f.Path]; !ok {
+			resp.Deleted = append(resp.Deleted, f.Path)
+		}
+	}
+	for rel, fi := range files {
+		f := syncFile{Path: rel, URL: escapePath(path.Join(relPath, rel)), Size: fi.info.Size(), ModTime: fi.info.ModTime()}
+		f.Ranges = diffBlocks(nil, nil, 0, f.Size)
+		have, ok := client[rel]
+		if !ok {
+			resp.Added = append(resp.Added, f)
+			resp.Bytes += f.Size
+			continue
+		}
+		var bs int64
+		if have.Blocks != nil {
+			bs = blockSize
+		}
+		if have.Size != f.Size && bs == 0 {
+			// Changed for sure, and fetched whole: no need to hash it.
+			resp.Changed = append(resp.Changed, f)
+			resp.Bytes += f.Size
+			continue
+		}
+		d, err := s.syncHashes.digest(r.Context(), m, fi.name, fi.info, bs)
+		if err != nil {
+			if !s.canceled(r, err) {
+				status := statusForError(err)
+				writeAPIError(w, status, http.StatusText(status), map[string]string{"path": rel})
+			}
+			return
+		}
+		if have.Size == f.Size && have.SHA256 == d.sum {
+			resp.Unchanged++
+			continue
+		}
+		f.SHA256 = d.sum
+		f.Ranges = diffBlocks(d.blocks, have.Blocks, bs, f.Size)
+		for _, rg := range f.Ranges {
+			resp.Bytes += rg.Length
+		}
+		resp.Changed = append(resp.Changed, f)
+	}
+	slices.Sort(resp.Deleted)
+	for _, l := range [][]syncFile{resp.Added, resp.Changed} {
+		slices.SortFunc(l, func(a, b syncFile) int { return strings.Compare(a.Path, b.Path) })
+	}
+	w.Header().Set("Cache-Control", "no-store")
+	writeJSON(w, http.StatusOK, resp)
+}
+
+// diffBlocks returns the merged byte ranges of the server's blocks that
+// differ from the client's, or the whole file without client blocks.
+func diffBlocks(server []string, client []string, blockSize, size int64) []syncRange {
+	ranges := []syncRange{}
+	if client == nil {
+		if size > 0 {
+			ranges = append(ranges, syncRange{Offset: 0, Length: size})
+		}
+		return ranges
+	}
+	for i, sum := range server {
+		if i < len(client) && client[i] == sum {
+			continue
+		}
+		off := int64(i) * blockSize
+		n := min(blockSize, size-off)
+		if k := len(ranges) - 1; k >= 0 && ranges[k].Offset+ranges[k].Length == off {
+			ranges[k].Length += n
+		} else {
+			ranges = append(ranges, syncRange{Offset: off, Length: n})
+		}
+	}
+	return ranges
+}
+
+var errSyncTooMany = fmt.Errorf("more than %d files to sync", syncMaxFiles)
+
+type syncEntry struct {
+	name string // in the mount's file system
+	info fs.FileInfo
+}
+
+// syncFiles lists the regular files below root by their path relative to
+// it, skipping what listings hide as zipItem does.
+func (s *Server) syncFiles(ctx context.Context, m *Mount, root string) (map[string]syncEntry, error) {
+	files := make(map[string]syncEntry)
+	err := fs.WalkDir(m.fsys, root, func(name string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		if name == root {
+			return nil
+		}
+		if (m.Hidden != HiddenShow && strings.HasPrefix(d.Name(), ".")) || m.internal(path.Join(m.Prefix, name)) || s.excluded(path.Join(m.Prefix, name)) {
+			if d.IsDir() {
+				return fs.SkipDir
+			}
+			return nil
+		}
+		if d.IsDir() {
+			if s.noIndexed(m, name) {
+				return fs.SkipDir
+			}
+			return nil
+		}
+		if !d.Type().IsRegular() {
+			return nil
+		}
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+		if len(files) == syncMaxFiles {
+			return errSyncTooMany
+		}
+		rel := name
+		if root != "." {
+			rel = strings.TrimPrefix(name, root+"/")
+		}
+		files[rel] = syncEntry{name: name, info: info}
+		return nil
+	})
+	return files, err
+}
+
+// syncHashCache keeps file digests between syncs while the files keep
+// their size and modification time, so that periodic syncs of a large
+// directory only hash what changed.
+type syncHashCache struct {
+	mu      sync.Mutex
+	entries map[string]syncDigest // by mount prefix and name
+}
+
+type syncDigest struct {
+	size      int64
+	modTime   time.Time
+	blockSize int64 // 0 without block digests
+	sum       string
+	blocks    []string
+}
+
+func newSyncHashCache() *syncHashCache {
+	return &syncHashCache{entries: make(map[string]syncDigest)}
+}
+
+// digest returns the SHA-256 digest of a file and, with a block size, of
+// each of its blocks.
+func (c *syncHashCache) digest(ctx context.Context, m *Mount, name string, info fs.FileInfo, blockSize int64) (syncDigest, error) {
+	key := m.Prefix + "\x00" + name
+	c.mu.Lock()
+	d, ok := c.entries[key]
+	c.mu.Unlock()
+	if ok && d.size == info.Size() && d.modTime.Equal(info.ModTime()) && (blockSize == 0 || d.blockSize == blockSize) {
+		return d, nil
+	}
+
+	f, err := m.fsys.Open(name)
+	if err != nil {
+		return syncDigest{}, err
+	}
+	defer f.Close()
+	d = syncDigest{size: info.Size(), modTime: info.ModTime(), blockSize: blockSize}
+	whole := sha256.New()
+	if blockSize == 0 {
+		if _, err := io.Copy(whole, ctxReader{ctx, f}); err != nil {
+			return syncDigest{}, err
+		}
+	} else {
+		buf := make([]byte, blockSize)
+		for {
+			n, err := io.ReadFull(f, buf)
+			if n > 0 {
+				whole.Write(buf[:n])
+				sum := sha256.Sum256(buf[:n])
+				d.blocks = append(d.blocks, hex.EncodeToString(sum[:]))
+			}
+			if errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) {
+				break
+			} else if err != nil {
+				return syncDigest{}, err
+			}
+			if err := ctx.Err(); err != nil {
+				return syncDigest{}, err
+			}
+		}
+	}
+	d.sum = hex.EncodeToString(whole.Sum(nil))
+
+	c.mu.Lock()
+	if len(c.entries) >= syncHashCacheMax {
+		for k := range c.entries {
+			delete(c.entries, k)
+			break
+		}
+	}
+	c.entries[key] = d
+	c.mu.Unlock()
+	return d, nil
+}
+
+// ctxReader stops reading once its context is done.
+type ctxReader struct {
+	ctx context.Context
+	r   io.Reader
+}
+
+func (r ctxReader) Read(p []byte) (int, error) {
+	if err := r.ctx.Err(); err != nil {
+		return 0, err
+	}
+	return r.r.Read(p)
+}
@@ -0,0 +1,238 @@
+package fileserver
+
+import (
+	"bytes"
+	"container/list"
+	"errors"
+	"fmt"
+	"image"
+	"image/color"
+	_ "image/gif" // registered for image.Decode
+	"image/jpeg"
+	"image/png"
+	"net/http"
+	"runtime"
+	"strconv"
+	"sync"
+)
+
+const (
+	thumbDefaultSize = 256
+	thumbMinSize     = 16
+	thumbMaxSize     = 1024
+	// thumbMaxPixels refuses larger sources, bounding decode memory.
+	thumbMaxPixels = 40_000_000
+	thumbCacheSize = 64 << 20 // bytes of encoded thumbnails kept
+)
+
+var (
+	errNotImage   = errors.New("not a supported image")
+	errImageLarge = errors.New("image too large for a thumbnail")
+)
+
+type thumb struct {
+	key         string
+	data        []byte
+	contentType string
+}
+
+// thumbCache keeps recently served thumbnails up to a total size, evicting
+// the least recently used. Keys include the source's size and modification
+// time, so edited images get new thumbnails.
+type thumbCache struct {
+	mu    sync.Mutex
+	size  int
+	order *list.List // of *thumb, most recent first
+	items map[string]*list.Element
+	// sem bounds concurrent decodes, each of which may hold a large image.
+	sem chan struct{}
+}
+
+func newThumbCache() *thumbCache {
+	return &thumbCache{
+		order: list.New(),
+		items: make(map[string]*list.Element),
+		sem:   make(chan struct{}, min(runtime.GOMAXPROCS(0), 4)),
+	}
+}
+
+func (c *thumbCache) get(key string) (*thumb, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	el, ok := c.items[key]
+	if !ok {
+		return nil, false
+	}
+	c.order.MoveToFront(el)
+	return el.Value.(*thumb), true
+}
+
+func (c *thumbCache) put(t *thumb) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if _, ok := c.items[t.key]; ok {
+		return
+	}
+	c.items[t.key] = c.order.PushFront(t)
+	c.size += len(t.data)
+	for c.size > thumbCacheSize && c.order.Len() > 1 {
+		old := c.order.Remove(c.order.Back()).(*thumb)
+		delete(c.items, old.key)
+		c.size -= len(old.data)
+	}
+}
+
+// apiThumb answers GET /api/v1/thumb?path=/dir/photo.jpg[&size=256] with
+// the image scaled to fit a size×size box, as JPEG, or PNG for PNG and GIF
+// sources, which may be transparent. Smaller images are not enlarged.
+func (s *Server) apiThumb(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	p := q.Get("path")
+	if p == "" {
+		writeAPIError(w, http.StatusBadRequest, "missing path", map[string]string{"path": "required"})
+		return
+	}
+	size := thumbDefaultSize
+	if v := q.Get("size"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < thumbMinSize || n > thumbMaxSize {
+			writeAPIError(w, http.StatusBadRequest, "invalid size",
+				map[string]string{"size": fmt.Sprintf("between %d and %d", thumbMinSize, thumbMaxSize)})
+			return
+		}
+		size = n
+	}
+	relPath, m, fsPath, ok := s.lookup(p)
+	if !ok {
+		writeAPIError(w, http.StatusNotFound, http.StatusText(http.StatusNotFound), nil)
+		return
+	}
+	info, err := m.stat(fsPath)
+	if err != nil {
+		status := statusForError(err)
+		writeAPIError(w, status, http.StatusText(status), nil)
+		return
+	}
+	if info.IsDir() {
+		writeAPIError(w, http.StatusUnprocessableEntity, errNotImage.Error(), nil)
+		return
+	}
+
+	key := fmt.Sprintf("%s\x00%d\x00%d\x00%d", relPath, size, info.Size(), info.ModTime().UnixNano())
+	t, ok := s.thumbs.get(key)
+	if !ok {
+		select {
+		case s.thumbs.sem <- struct{}{}:
+		case <-r.Context().Done():
+			return
+		}
+		t, err = makeThumb(m, fsPath, size)
+		<-s.thumbs.sem
+		switch {
+		case errors.Is(err, errNotImage), errors.Is(err, errImageLarge):
+			writeAPIError(w, http.StatusUnprocessableEntity, err.Error(), nil)
+			return
+		case err != nil:
+			s.log.Error("Thumbnail failed", "path", relPath, "error", err)
+			status := statusForError(err)
+			writeAPIError(w, status, http.StatusText(status), nil)
+			return
+		}
+		t.key = key
+		s.thumbs.put(t)
+	}
+	w.Header().Set("Content-Type", t.contentType)
+	w.Header().Set("Cache-Control", "max-age=3600")
+	http.ServeContent(w, r, "", info.ModTime(), bytes.NewReader(t.data))
+}
+
+// makeThumb decodes an image and encodes it scaled down to fit size.
+func makeThumb(m *Mount, fsPath string, size int) (*thumb, error) {
+	f, err := m.open(fsPath)
+	if err != nil {
+		return nil, err
+	}
+	cfg, format, err := image.DecodeConfig(f)
+	f.Close()
+	if err != nil {
+		return nil, errNotImage
+	}
+	if cfg.Width*cfg.Height > thumbMaxPixels {
+		return nil, errImageLarge
+	}
+	// Reopen rather than seek, as not every fs.FS file can.
+	if f, err = m.open(fsPath); err != nil {
+		return nil, err
+	}
+	src, _, err := image.Decode(f)
+	f.Close()
+	if err != nil {
+		return nil, errNotImage
+	}
+
+	w, h := cfg.Width, cfg.Height
+	if w > size || h > size {
+		if w >= h {
+			w, h = size, max(1, h*size/w)
+		} else {
+			w, h = max(1, w*size/h), size
+		}
+	}
+	var buf bytes.Buffer
+	t := &thumb{contentType: "image/jpeg"}
+	dst := scaleDown(src, w, h)
+	if format == "png" || format == "gif" {
+		t.contentType = "image/png"
+		err = png.Encode(&buf, dst)
+	} else {
+		err = jpeg.Encode(&buf, dst, &jpeg.Options{Quality: 80})
+	}
+	if err != nil {
+		return nil, err
+	}
+	t.data = buf.Bytes()
+	return t, nil
+}
+
+// scaleDown resizes src to w×h by averaging the source pixels that fall
+// into each destination pixel, which is sharp enough for thumbnails and
+// free of the aliasing of nearest-neighbour sampling.
+func scaleDown(src image.Image, w, h int) *image.RGBA {
+	b := src.Bounds()
+	at := rgbaAt(src)
+	dst := image.NewRGBA(image.Rect(0, 0, w, h))
+	for y := 0; y < h; y++ {
+		y0, y1 := b.Min.Y+y*b.Dy()/h, b.Min.Y+(y+1)*b.Dy()/h
+		y1 = max(y1, y0+1)
+		for x := 0; x < w; x++ {
+			x0, x1 := b.Min.X+x*b.Dx()/w, b.Min.X+(x+1)*b.Dx()/w
+			x1 = max(x1, x0+1)
+			var sr, sg, sb, sa, n uint32
+			for sy := y0; sy < y1; sy++ {
+				for sx := x0; sx < x1; sx++ {
+					r, g, b, a := at(sx, sy)
+					sr, sg, sb, sa, n = sr+r, sg+g, sb+b, sa+a, n+1
+				}
+			}
+			i := dst.PixOffset(x, y)
+			dst.Pix[i], dst.Pix[i+1], dst.Pix[i+2], dst.Pix[i+3] = uint8(sr/n), uint8(sg/n), uint8(sb/n), uint8(sa/n)
+		}
+	}
+	return dst
+}
+
+// rgbaAt returns a reader of 8-bit premultiplied pixels, avoiding the
+// per-pixel allocation of image.Image.At for decoded JPEGs.
+func rgbaAt(src image.Image) func(x, y int) (r, g, b, a uint32) {
+	if img, ok := src.(*image.YCbCr); ok {
+		return func(x, y int) (uint32, uint32, uint32, uint32) {
+			yi, ci := img.YOffset(x, y), img.COffset(x, y)
+			r, g, b := color.YCbCrToRGB(img.Y[yi], img.Cb[ci], img.Cr[ci])
+			return uint32(r), uint32(g), uint32(b), 0xff
+		}
+	}
+	return func(x, y int) (uint32, uint32, uint32, uint32) {
+		r, g, b, a := src.At(x, y).RGBA()
+		return r >> 8, g >> 8, b >> 8, a >> 8
+	}
+}
@@ -0,0 +1,43 @@
+package fileserver
+
+import (
+	"net/http"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// tracer delegates to the global TracerProvider, so spans are exported once
+// the program installs one.
+var tracer = otel.Tracer("github.com/AScotM/go-server/pkg/fileserver")
+
+// tracing starts a server span per request, continuing any trace context
+// carried in the incoming headers.
+func (s *Server) tracing(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ctx := otel.GetTextMapPropagator().Extract(r.Context(), propagation.HeaderCarrier(r.Header))
+		ctx, span := tracer.Start(ctx, r.Method+" "+r.URL.Path,
+			trace.WithSpanKind(trace.SpanKindServer),
+			trace.WithAttributes(
+				attribute.String("http.request.method", r.Method),
+				attribute.String("url.path", r.URL.Path),
+				attribute.String("client.address", RemoteIP(r)),
+				attribute.String("user_agent.original", r.UserAgent()),
+			))
+		defer span.End()
+
+		lrw := &loggingResponseWriter{ResponseWriter: w, status: http.StatusOK}
+		next.ServeHTTP(lrw, r.WithContext(ctx))
+
+		span.SetAttributes(
+			attribute.Int("http.response.status_code", lrw.status),
+			attribute.Int64("http.response.body.size", lrw.bytes),
+		)
+		if lrw.status >= 500 {
+			span.SetStatus(codes.Error, http.StatusText(lrw.status))
+		}
+	})
+}
@@ -0,0 +1,319 @@
+package fileserver
+
+import (
+	"bytes"
+	"encoding/json"
+	"html/template"
+	"io"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// With a trash retention set, deletions move files into a .trash directory
+// at the root of their read-write mount, where rename is cheap, instead of
+// removing them. Each deleted file or directory is kept as .trash/<id>
+// beside .trash/<id>.json, which records where it came from, until it is
+// restored, purged, or older than the retention. The trash is never served,
+// listed or writable like the rest of the mount.
+const (
+	trashName = ".trash"
+	trashPath = "/trash"
+)
+
+type trashItem struct {
+	ID      string    `json:"id"`
+	Path    string    `json:"path"` // URL path it was deleted from
+	Dir     bool      `json:"dir"`
+	Size    int64     `json:"size"`
+	Deleted time.Time `json:"deleted"`
+	Expires time.Time `json:"expires"` // when it is purged
+
+	m *Mount // whose trash holds it
+}
+
+func (m *Mount) trashFile(id string) string {
+	return filepath.Join(m.Dir, trashName, id)
+}
+
+// moveToTrash deletes the file or directory at fsPath by moving it into
+// its mount's trash. Non-empty directories are only moved when recursive.
+func (s *Server) moveToTrash(relPath, fsPath string, recursive bool) error {
+	m, _, _ := s.resolve(relPath)
+	info, err := os.Lstat(fsPath)
+	if err != nil {
+		return err
+	}
+	if info.IsDir() && !recursive {
+		d, err := os.Open(fsPath)
+		if err != nil {
+			return err
+		}
+		_, err = d.Readdirnames(1)
+		d.Close()
+		if err != io.EOF {
+			return &opError{http.StatusConflict, relPath + " is a non-empty directory"}
+		}
+	}
+	if err := os.MkdirAll(filepath.Join(m.Dir, trashName), 0o700); err != nil {
+		return err
+	}
+	// IDs are random like upload IDs, so validUploadID checks them too.
+	item := trashItem{ID: newUploadID(), Path: relPath, Dir: info.IsDir(), Deleted: time.Now().UTC()}
+	if !item.Dir {
+		item.Size = info.Size()
+	}
+	meta, err := json.Marshal(item)
+	if err != nil {
+		return err
+	}
+	if err := os.WriteFile(m.trashFile(item.ID)+".json", meta, 0o600); err != nil {
+		return err
+	}
+	if err := os.Rename(fsPath, m.trashFile(item.ID)); err != nil {
+		os.Remove(m.trashFile(item.ID) + ".json")
+		return err
+	}
+	s.log.Info("Moved to trash", "path", relPath, "id", item.ID)
+	return nil
+}
+
+// trashItems returns what is in the trash of every mount, most recently
+// deleted first.
+func (s *Server) trashItems() []trashItem {
+	var items []trashItem
+	for _, m := range s.mounts {
+		if !m.trash {
+			continue
+		}
+		metas, _ := filepath.Glob(filepath.Join(m.Dir, trashName, "*.json"))
+		for _, meta := range metas {
+			if item, err := s.readTrashItem(m, strings.TrimSuffix(filepath.Base(meta), ".json")); err == nil {
+				items = append(items, item)
+			}
+		}
+	}
+	slices.SortFunc(items, func(a, b trashItem) int { return b.Deleted.Compare(a.Deleted) })
+	return items
+}
+
+func (s *Server) readTrashItem(m *Mount, id string) (trashItem, error) {
+	var item trashItem
+	data, err := os.ReadFile(m.trashFile(id) + ".json")
+	if err != nil {
+		return item, err
+	}
+	if err := json.Unmarshal(data, &item); err != nil {
+		return item, err
+	}
+	item.ID, item.Expires, item.m = id, item.Deleted.Add(s.trashRetention), m
+	return item, nil
+}
+
+// findTrash looks up an item in the trash of any mount.
+func (s *Server) findTrash(id string) (trashItem, error) {
+	if validUploadID(id) {
+		for _, m := range s.mounts {
+			if !m.trash {
+				continue
+			}
+			if item, err := s.readTrashItem(m, id); err == nil {
+				return item, nil
+			}
+		}
+	}
+	return trashItem{}, &opError{http.StatusNotFound, "no such item in the trash"}
+}
+
+// restoreTrash moves an item back to where it was deleted from, which
+// must still be free, recreating missing parent directories.
+func (s *Server) restoreTrash(r *http.Request, item trashItem) error {
+	relPath, fsPath, err := s.writable(item.Path)
+	if err != nil {
+		return err
+	}
+	if _, err := os.Lstat(fsPath); err == nil {
+		return &opError{http.StatusConflict, relPath + " already exists"}
+	}
+	if err := os.MkdirAll(filepath.Dir(fsPath), 0o755); err != nil {
+		return err
+	}
+	if err := os.Rename(item.m.trashFile(item.ID), fsPath); err != nil {
+		return err
+	}
+	os.Remove(item.m.trashFile(item.ID) + ".json")
+	s.invalidate(fsPath)
+	if s.dirSizes != nil {
+		s.dirSizes.invalidate(relPath)
+	}
+	s.log.Info("Restored from trash", "path", relPath, "id", item.ID, "remote_ip", RemoteIP(r))
+	s.Publish(Event{Type: "restore", Path: relPath, Size: item.Size})
+	return nil
+}
+
+// purgeTrash removes an item for good.
+func purgeTrash(item trashItem) error {
+	if err := os.RemoveAll(item.m.trashFile(item.ID)); err != nil {
+		return err
+	}
+	return os.Remove(item.m.trashFile(item.ID) + ".json")
+}
+
+// cleanTrash purges items older than the retention until the server is
+// closed.
+func (s *Server) cleanTrash() {
+	ticker := time.NewTicker(min(s.trashRetention, time.Hour))
+	defer ticker.Stop()
+	for {
+		now := time.Now()
+		for _, item := range s.trashItems() {
+			if now.Before(item.Expires) {
+				continue
+			}
+			if err := purgeTrash(item); err != nil {
+				s.log.Error("Purging trash failed", "path", item.Path, "id", item.ID, "error", err)
+			} else {
+				s.log.Debug("Trash item expired", "path", item.Path, "id", item.ID)
+			}
+		}
+		select {
+		case <-ticker.C:
+		case <-s.stop:
+			return
+		}
+	}
+}
+
+type trashResponse struct {
+	Items []trashItem `json:"items"`
+}
+
+// apiTrashList answers GET /api/v1/trash with the items in the trash.
+func (s *Server) apiTrashList(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Cache-Control", "no-store")
+	writeJSON(w, http.StatusOK, trashResponse{Items: append([]trashItem{}, s.trashItems()...)})
+}
+
+// apiTrashEmpty answers DELETE /api/v1/trash by purging every item.
+func (s *Server) apiTrashEmpty(w http.ResponseWriter, r *http.Request) {
+	n := 0
+	for _, item := range s.trashItems() {
+		if err := purgeTrash(item); err != nil {
+			s.log.Error("Purging trash failed", "path", item.Path, "id", item.ID, "error", err)
+			writeOpError(w, err)
+			return
+		}
+		n++
+	}
+	s.log.Info("Trash emptied", "items", n, "remote_ip", RemoteIP(r))
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// apiTrashPurge answers DELETE /api/v1/trash/{id} by purging one item.
+func (s *Server) apiTrashPurge(w http.ResponseWriter, r *http.Request) {
+	item, err := s.findTrash(endpointSegment(r, 1))
+	if err == nil {
+		err = purgeTrash(item)
+	}
+	if err != nil {
+		writeOpError(w, err)
+		return
+	}
+	s.log.Info("Purged from trash", "path", item.Path, "id", item.ID, "remote_ip", RemoteIP(r))
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// apiTrashRestore answers POST /api/v1/trash/{id}/restore by moving an
+// item back, replying with the item. A file now at its path gets 409.
+func (s *Server) apiTrashRestore(w http.ResponseWriter, r *http.Request) {
+	item, err := s.findTrash(endpointSegment(r, 1))
+	if err == nil {
+		err = s.restoreTrash(r, item)
+	}
+	if err != nil {
+		writeOpError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, item)
+}
+
+type trashPage struct {
+	Lang, Title, Empty, Restore, Purge string
+	Path, Size, Deleted, Expires       string
+	Assets, API                        string // URLs ending in "/"
+	Items                              []trashRow
+}
+
+type trashRow struct {
+	ID, Path, Parent, Size string
+	Deleted, Expires       time.Time
+}
+
+var trashTemplate = template.Must(template.New("trash").Parse(`<!DOCTYPE html>
+<html lang="{{.Lang}}"><head><meta charset="utf-8"><meta name="viewport" content="width=device-width, initial-scale=1">
+<title>{{.Title}}</title><link rel="stylesheet" href="{{.Assets}}style.css"><script src="{{.Assets}}trash.js" defer></script></head>
+<body data-api="{{.API}}">
+<header class="title"><h1>{{.Title}}</h1><a class="view" href="/">/</a></header>
+{{if .Items}}<table class="listing trash">
+<thead><tr><th>{{.Path}}</th><th class="size">{{.Size}}</th><th>{{.Deleted}}</th><th>{{.Expires}}</th><th></th></tr></thead>
+<tbody>
+{{range .Items}}<tr data-id="{{.ID}}"><td class="name"><a href="{{.Parent}}">{{.Path}}</a></td><td class="size">{{.Size}}</td>
+<td class="modified">{{.Deleted.Format "2006-01-02 15:04"}}</td><td class="modified">{{.Expires.Format "2006-01-02 15:04"}}</td>
+<td><button type="button" class="restore">{{$.Restore}}</button> <button type="button" class="purge">{{$.Purge}}</button></td></tr>
+{{end}}</tbody></table>
+{{else}}<p>{{.Empty}}</p>
+{{end}}</body></html>
+`))
+
+// trashHandler serves /trash, a page listing the trash with buttons that
+// restore or purge items through the API.
+func (s *Server) trashHandler(w http.ResponseWriter, r *http.Request) {
+	if !s.allowMethods(w, r, http.MethodGet, http.MethodHead) {
+		return
+	}
+	lang := s.language(w, r)
+	page := trashPage{
+		Lang:    lang,
+		Assets:  s.endpoint(assetsPrefix),
+		API:     s.endpoint(apiV1Prefix),
+		Title:   msg(lang, "trash"),
+		Empty:   msg(lang, "trash_empty"),
+		Restore: msg(lang, "restore"),
+		Purge:   msg(lang, "purge"),
+		Path:    msg(lang, "original_path"),
+		Size:    msg(lang, "size"),
+		Deleted: msg(lang, "deleted"),
+		Expires: msg(lang, "expires"),
+	}
+	for _, item := range s.trashItems() {
+		row := trashRow{ID: item.ID, Path: item.Path, Parent: path.Dir(item.Path), Deleted: item.Deleted.Local(), Expires: item.Expires.Local()}
+		if row.Parent != "/" {
+			row.Parent += "/"
+		}
+		row.Parent = escapePath(row.Parent)
+		if item.Dir {
+			row.Path += "/"
+		} else if s.exactSizes {
+			row.Size = strconv.FormatInt(item.Size, 10)
+		} else {
+			row.Size = humanSize(item.Size)
+		}
+		page.Items = append(page.Items, row)
+	}
+	var buf bytes.Buffer
+	if err := trashTemplate.Execute(&buf, page); err != nil {
+		s.renderError(w, r, http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Header().Set("Cache-Control", "no-store")
+	w.Header().Set("Content-Length", strconv.Itoa(buf.Len()))
+	if r.Method != http.MethodHead {
+		buf.WriteTo(w)
+	}
+}
@@ -0,0 +1,246 @@
+package fileserver
+
+import (
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"net/http"
+	"os"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// tus implements the tus 1.0.0 resumable upload protocol (https://tus.io)
+// with the creation, termination and expiration extensions.
+const (
+	tusPrefix     = "/files/"
+	tusVersion    = "1.0.0"
+	tusExtensions = "creation,termination,expiration"
+	// defaultTusMaxSize is the default of Options.TusMaxSize.
+	defaultTusMaxSize = 10 << 30
+)
+
+// tusInfo is stored beside each upload's data in staging.
+type tusInfo struct {
+	Length   int64     `json:"length"`
+	Dest     string    `json:"dest"`     // URL path of the finished file
+	Metadata string    `json:"metadata"` // Upload-Metadata as sent
+	Expires  time.Time `json:"expires"`
+}
+
+// parseTusMetadata decodes "key base64value,key2 base64value2".
+func parseTusMetadata(h string) (map[string]string, bool) {
+	md := make(map[string]string)
+	for _, pair := range strings.Split(h, ",") {
+		pair = strings.TrimSpace(pair)
+		if pair == "" {
+			continue
+		}
+		key, enc, _ := strings.Cut(pair, " ")
+		v, err := base64.StdEncoding.DecodeString(enc)
+		if err != nil || key == "" {
+			return nil, false
+		}
+		md[key] = string(v)
+	}
+	return md, true
+}
+
+func (s *Server) tusHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Tus-Resumable", tusVersion)
+	if r.Method == http.MethodOptions {
+		w.Header().Set("Tus-Version", tusVersion)
+		w.Header().Set("Tus-Extension", tusExtensions)
+		w.Header().Set("Tus-Max-Size", strconv.FormatInt(s.tusMaxSize, 10))
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+	if r.Header.Get("Tus-Resumable") != tusVersion {
+		w.Header().Set("Tus-Version", tusVersion)
+		httpError(w, r, "Unsupported tus version", http.StatusPreconditionFailed)
+		return
+	}
+	id := strings.TrimPrefix(r.URL.Path, tusPrefix)
+	switch {
+	case id == "" && r.Method == http.MethodPost:
+		s.tusCreate(w, r)
+	case id == "":
+		w.Header().Set("Allow", "OPTIONS, POST")
+		httpError(w, r, "Method not allowed", http.StatusMethodNotAllowed)
+	case !validUploadID(id):
+		httpError(w, r, "Upload not found", http.StatusNotFound)
+	case r.Method == http.MethodHead:
+		s.tusHead(w, r, id)
+	case r.Method == http.MethodPatch:
+		s.tusPatch(w, r, id)
+	case r.Method == http.MethodDelete:
+		s.tusDelete(w, r, id)
+	default:
+		w.Header().Set("Allow", "OPTIONS, HEAD, PATCH, DELETE")
+		httpError(w, r, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+// tusCreate starts an upload. The metadata must include "filename" and may
+// include "dir", the URL path of the directory to upload into (default /).
+func (s *Server) tusCreate(w http.ResponseWriter, r *http.Request) {
+	length, err := strconv.ParseInt(r.Header.Get("Upload-Length"), 10, 64)
+	if err != nil || length < 0 {
+		httpError(w, r, "Missing or invalid Upload-Length", http.StatusBadRequest)
+		return
+	}
+	if length > s.tusMaxSize {
+		w.Header().Set("Tus-Max-Size", strconv.FormatInt(s.tusMaxSize, 10))
+		httpError(w, r, fmt.Sprintf("Upload-Length exceeds %d bytes", s.tusMaxSize), http.StatusRequestEntityTooLarge)
+		return
+	}
+	md, ok := parseTusMetadata(r.Header.Get("Upload-Metadata"))
+	name := md["filename"]
+	if !ok || name == "" || strings.ContainsAny(name, `/\`) || name == "." || name == ".." {
+		httpError(w, r, "Upload-Metadata must include a plain filename", http.StatusBadRequest)
+		return
+	}
+	dir := md["dir"]
+	if dir == "" {
+		dir = "/"
+	}
+	dest := path.Join("/", dir, name)
+	if _, err := s.checkUploadTarget(dest); err != nil {
+		s.tusError(w, r, err)
+		return
+	}
+
+	id := newUploadID()
+	info := tusInfo{Length: length, Dest: dest, Metadata: r.Header.Get("Upload-Metadata"), Expires: time.Now().Add(uploadExpiry)}
+	if err := os.WriteFile(s.staging.path("tus", id, ".bin"), nil, 0o600); err != nil {
+		s.tusError(w, r, err)
+		return
+	}
+	if err := s.staging.writeInfo("tus", id, info); err != nil {
+		s.staging.remove("tus", id)
+		s.tusError(w, r, err)
+		return
+	}
+	s.log.Debug("Upload created", "id", id, "dest", dest, "length", length)
+	w.Header().Set("Location", s.endpoint(tusPrefix+id))
+	w.Header().Set("Upload-Expires", info.Expires.UTC().Format(http.TimeFormat))
+	w.WriteHeader(http.StatusCreated)
+}
+
+// tusOffset loads an upload's info and current offset.
+func (s *Server) tusOffset(id string) (tusInfo, int64, error) {
+	var info tusInfo
+	if err := s.staging.readInfo("tus", id, &info); err != nil {
+		return info, 0, err
+	}
+	st, err := os.Stat(s.staging.path("tus", id, ".bin"))
+	if err != nil {
+		return info, 0, err
+	}
+	return info, st.Size(), nil
+}
+
+func (s *Server) tusHead(w http.ResponseWriter, r *http.Request, id string) {
+	info, offset, err := s.tusOffset(id)
+	if err != nil {
+		s.tusError(w, r, err)
+		return
+	}
+	w.Header().Set("Cache-Control", "no-store")
+	w.Header().Set("Upload-Offset", strconv.FormatInt(offset, 10))
+	w.Header().Set("Upload-Length", strconv.FormatInt(info.Length, 10))
+	w.Header().Set("Upload-Expires", info.Expires.UTC().Format(http.TimeFormat))
+	if info.Metadata != "" {
+		w.Header().Set("Upload-Metadata", info.Metadata)
+	}
+	w.WriteHeader(http.StatusOK)
+}
+
+func (s *Server) tusPatch(w http.ResponseWriter, r *http.Request, id string) {
+	if r.Header.Get("Content-Type") != "application/offset+octet-stream" {
+		httpError(w, r, "Content-Type must be application/offset+octet-stream", http.StatusUnsupportedMediaType)
+		return
+	}
+	if !s.staging.acquire(id) {
+		httpError(w, r, "Upload is locked by another request", http.StatusLocked)
+		return
+	}
+	defer s.staging.release(id)
+	info, offset, err := s.tusOffset(id)
+	if err != nil {
+		s.tusError(w, r, err)
+		return
+	}
+	if want, err := strconv.ParseInt(r.Header.Get("Upload-Offset"), 10, 64); err != nil || want != offset {
+		w.Header().Set("Upload-Offset", strconv.FormatInt(offset, 10))
+		httpError(w, r, "Upload-Offset does not match", http.StatusConflict)
+		return
+	}
+
+	data := s.staging.path("tus", id, ".bin")
+	f, err := os.OpenFile(data, os.O_WRONLY|os.O_APPEND, 0)
+	if err != nil {
+		s.tusError(w, r, err)
+		return
+	}
+	// Keep whatever arrived before a dropped connection; the client
+	// resumes from the offset HEAD reports.
+	n, copyErr := io.Copy(f, io.LimitReader(r.Body, info.Length-offset))
+	if err := f.Close(); err != nil && copyErr == nil {
+		copyErr = err
+	}
+	offset += n
+	info.Expires = time.Now().Add(uploadExpiry)
+	s.staging.writeInfo("tus", id, info)
+	if copyErr != nil {
+		s.log.Warn("Upload interrupted", "id", id, "offset", offset, "error", copyErr)
+		s.tusError(w, r, copyErr)
+		return
+	}
+
+	if offset == info.Length {
+		mirror, err := s.commitUpload(r, data, info.Dest, info.Length)
+		if err != nil {
+			s.tusError(w, r, err)
+			return
+		}
+		s.staging.remove("tus", id)
+		if mirror != "" {
+			w.Header().Set("Upload-Mirror", mirror)
+		}
+	}
+	w.Header().Set("Upload-Offset", strconv.FormatInt(offset, 10))
+	w.Header().Set("Upload-Expires", info.Expires.UTC().Format(http.TimeFormat))
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (s *Server) tusDelete(w http.ResponseWriter, r *http.Request, id string) {
+	if !s.staging.acquire(id) {
+		httpError(w, r, "Upload is locked by another request", http.StatusLocked)
+		return
+	}
+	defer s.staging.release(id)
+	if _, err := os.Stat(s.staging.path("tus", id, ".json")); err != nil {
+		s.tusError(w, r, err)
+		return
+	}
+	s.staging.remove("tus", id)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (s *Server) tusError(w http.ResponseWriter, r *http.Request, err error) {
+	var oe *opError
+	switch {
+	case errors.As(err, &oe):
+		httpError(w, r, oe.msg, oe.code)
+	case errors.Is(err, fs.ErrNotExist):
+		httpError(w, r, "Upload not found", http.StatusNotFound)
+	default:
+		s.log.Error("Upload failed", "error", err)
+		httpError(w, r, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+	}
+}
@@ -0,0 +1,213 @@
+package fileserver
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+// uploadExpiry is how long an unfinished upload is kept in staging.
+const uploadExpiry = 24 * time.Hour
+
+// staging is the directory holding partial uploads until they are
+// complete. Each upload has an ID naming its files there.
+type staging struct {
+	dir  string
+	mu   sync.Mutex
+	busy map[string]bool
+}
+
+func newStaging(dir string) (*staging, error) {
+	if dir == "" {
+		dir = filepath.Join(os.TempDir(), "go-server-uploads")
+	}
+	if err := os.MkdirAll(dir, 0o700); err != nil {
+		return nil, fmt.Errorf("create upload staging directory: %w", err)
+	}
+	return &staging{dir: dir, busy: make(map[string]bool)}, nil
+}
+
+func newUploadID() string {
+	var b [16]byte
+	rand.Read(b[:])
+	return hex.EncodeToString(b[:])
+}
+
+// validUploadID rejects IDs that could name files outside staging.
+func validUploadID(id string) bool {
+	if len(id) != 32 {
+		return false
+	}
+	_, err := hex.DecodeString(id)
+	return err == nil
+}
+
+// acquire marks an upload as being written, failing if it already is.
+func (st *staging) acquire(id string) bool {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	if st.busy[id] {
+		return false
+	}
+	st.busy[id] = true
+	return true
+}
+
+func (st *staging) release(id string) {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	delete(st.busy, id)
+}
+
+func (st *staging) path(kind, id, ext string) string {
+	return filepath.Join(st.dir, kind+"-"+id+ext)
+}
+
+func (st *staging) readInfo(kind, id string, v any) error {
+	data, err := os.ReadFile(st.path(kind, id, ".json"))
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(data, v)
+}
+
+func (st *staging) writeInfo(kind, id string, v any) error {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(st.path(kind, id, ".json"), data, 0o600)
+}
+
+// remove deletes every staging file of an upload.
+func (st *staging) remove(kind, id string) {
+	matches, _ := filepath.Glob(filepath.Join(st.dir, kind+"-"+id+"*"))
+	for _, m := range matches {
+		os.RemoveAll(m)
+	}
+}
+
+// cleanStaging periodically removes uploads idle for longer than
+// uploadExpiry.
+func (s *Server) cleanStaging() {
+	ticker := time.NewTicker(time.Hour)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			entries, err := os.ReadDir(s.staging.dir)
+			if err != nil {
+				continue
+			}
+			for _, e := range entries {
+				info, err := e.Info()
+				if err != nil || time.Since(info.ModTime()) < uploadExpiry {
+					continue
+				}
+				kind, rest, _ := strings.Cut(e.Name(), "-")
+				id := strings.SplitN(rest, ".", 2)[0]
+				if !s.staging.acquire(id) {
+					continue
+				}
+				s.staging.remove(kind, id)
+				s.staging.release(id)
+				s.log.Info("Removed expired upload", "id", id)
+			}
+		case <-s.stop:
+			return
+		}
+	}
+}
+
+// checkUploadTarget verifies that a finished upload could be written to
+// the URL path dest, returning the filesystem path.
+func (s *Server) checkUploadTarget(dest string) (string, error) {
+	_, fsPath, err := s.writable(dest)
+	if err != nil {
+		return "", err
+	}
+	if info, err := os.Stat(filepath.Dir(fsPath)); err != nil || !info.IsDir() {
+		return "", &opError{http.StatusConflict, "parent directory of " + dest + " does not exist"}
+	}
+	if _, err := os.Lstat(fsPath); err == nil {
+		return "", &opError{http.StatusConflict, dest + " already exists"}
+	}
+	return fsPath, nil
+}
+
+// hardLink is os.Link, replaced in tests by one failing like a filesystem
+// without hard links.
+var hardLink = os.Link
+
+// publishUpload moves tmp to dst unless dst exists, failing with an error
+// matching fs.ErrExist then. A file may have appeared at dst since
+// checkUploadTarget looked, which a rename would silently replace, so tmp
+// is hard linked, which never replaces, and then removed. Where that
+// fails, as across filesystems or on those without hard links such as
+// FAT and many FUSE and SMB mounts, dst is created exclusively and tmp
+// copied into it.
+func publishUpload(tmp, dst string) error {
+	err := hardLink(tmp, dst)
+	if err != nil && !errors.Is(err, fs.ErrExist) {
+		err = copyExclusive(tmp, dst)
+	}
+	if err != nil {
+		return err
+	}
+	os.Remove(tmp) // the staging cleanup catches it otherwise
+	return nil
+}
+
+// copyExclusive copies the file src to dst, which must not exist, and
+// removes dst again when that fails.
+func copyExclusive(src, dst string) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	out, err := os.OpenFile(dst, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0o644)
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(out, in)
+	if cerr := out.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		os.Remove(dst)
+	}
+	return err
+}
+
+// commitUpload moves a completed staging file to dest, runs the upload
+// hooks and mirrors it, returning the mirror status.
+func (s *Server) commitUpload(r *http.Request, tmp, dest string, size int64) (string, error) {
+	fsPath, err := s.checkUploadTarget(dest)
+	if err != nil {
+		return "", err
+	}
+	if err := os.Chmod(tmp, 0o644); err != nil {
+		return "", err
+	}
+	if err := publishUpload(tmp, fsPath); err != nil {
+		if errors.Is(err, fs.ErrExist) {
+			return "", &opError{http.StatusConflict, dest + " already exists"}
+		}
+		return "", err
+	}
+	s.invalidate(fsPath)
+	s.log.Info("File uploaded", "path", dest, "size", size, "remote_ip", RemoteIP(r))
+	s.uploaded(r, fsPath, size)
+	return s.mirrorUpload(r.Context(), fsPath), nil
+}
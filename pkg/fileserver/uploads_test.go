@@ -0,0 +1,71 @@
+package fileserver
+
+import (
+	"errors"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"syscall"
+	"testing"
+)
+
+func TestPublishUploadNoReplace(t *testing.T) {
+	dir := t.TempDir()
+	tmp, dst := filepath.Join(dir, "staged"), filepath.Join(dir, "a.txt")
+	if err := os.WriteFile(tmp, []byte("upload"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	// Another request created the target after it was checked.
+	if err := os.WriteFile(dst, []byte("existing"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if err := publishUpload(tmp, dst); !errors.Is(err, fs.ErrExist) {
+		t.Fatalf("publishing over an existing file: got %v, want an fs.ErrExist error", err)
+	}
+	if data, _ := os.ReadFile(dst); string(data) != "existing" {
+		t.Errorf("existing file was replaced by %q", data)
+	}
+
+	os.Remove(dst)
+	if err := publishUpload(tmp, dst); err != nil {
+		t.Fatal(err)
+	}
+	if data, _ := os.ReadFile(dst); string(data) != "upload" {
+		t.Errorf("published file holds %q, want %q", data, "upload")
+	}
+	if _, err := os.Stat(tmp); !errors.Is(err, fs.ErrNotExist) {
+		t.Errorf("staging file left behind: %v", err)
+	}
+}
+
+func TestPublishUploadWithoutHardLinks(t *testing.T) {
+	defer func(l func(string, string) error) { hardLink = l }(hardLink)
+	hardLink = func(oldname, newname string) error {
+		return &os.LinkError{Op: "link", Old: oldname, New: newname, Err: syscall.EPERM}
+	}
+	dir := t.TempDir()
+	tmp, dst := filepath.Join(dir, "staged"), filepath.Join(dir, "a.txt")
+	if err := os.WriteFile(tmp, []byte("upload"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(dst, []byte("existing"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if err := publishUpload(tmp, dst); !errors.Is(err, fs.ErrExist) {
+		t.Fatalf("publishing over an existing file: got %v, want an fs.ErrExist error", err)
+	}
+	if data, _ := os.ReadFile(dst); string(data) != "existing" {
+		t.Errorf("existing file was replaced by %q", data)
+	}
+
+	os.Remove(dst)
+	if err := publishUpload(tmp, dst); err != nil {
+		t.Fatal(err)
+	}
+	if data, _ := os.ReadFile(dst); string(data) != "upload" {
+		t.Errorf("published file holds %q, want %q", data, "upload")
+	}
+	if _, err := os.Stat(tmp); !errors.Is(err, fs.ErrNotExist) {
+		t.Errorf("staging file left behind: %v", err)
+	}
+}
@@ -0,0 +1,230 @@
+package fileserver
+
+import (
+	"errors"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"slices"
+	"time"
+)
+
+// With versioning on, a file about to be overwritten through the server
+// is first kept in a .versions directory at the root of its read-write
+// mount, as .versions/<path>/<time>, where <time> is when it was replaced.
+// Only the newest versions of each file are kept. Versions are hard links
+// where the filesystem allows, so keeping one costs no copy: the overwrite
+// renames a new file into place and leaves the old one untouched.
+const (
+	versionsName  = ".versions"
+	versionLayout = "20060102T150405.000000000Z"
+)
+
+// versionDir is where the versions of the file at fsPath are kept.
+func (m *Mount) versionDir(fsPath string) string {
+	return filepath.Join(m.Dir, versionsName, filepath.FromSlash(m.name(fsPath)))
+}
+
+// keepVersion keeps the regular file at fsPath, if there is one, as a
+// version before it is overwritten, and prunes its oldest versions. An
+// error means the file could not be kept and must not be overwritten.
+func (s *Server) keepVersion(fsPath string) error {
+	if s.versions <= 0 {
+		return nil
+	}
+	m, _, ok := s.resolve(s.urlPath(fsPath))
+	if !ok || !m.versions {
+		return nil
+	}
+	info, err := os.Lstat(fsPath)
+	if err != nil || !info.Mode().IsRegular() {
+		return nil
+	}
+	dir := m.versionDir(fsPath)
+	if err := os.MkdirAll(dir, 0o700); err != nil {
+		return err
+	}
+	kept := filepath.Join(dir, time.Now().UTC().Format(versionLayout))
+	if err := os.Link(fsPath, kept); err != nil {
+		if err := copyPath(os.DirFS(filepath.Dir(fsPath)), filepath.Base(fsPath), kept, info, nil); err != nil {
+			return err
+		}
+	}
+	ids := versionIDs(dir)
+	for _, id := range ids[min(len(ids), s.versions):] {
+		os.Remove(filepath.Join(dir, id))
+	}
+	return nil
+}
+
+// versionIDs lists the versions in dir, newest first.
+func versionIDs(dir string) []string {
+	entries, _ := os.ReadDir(dir)
+	var ids []string
+	for _, e := range entries {
+		if _, err := time.Parse(versionLayout, e.Name()); err == nil && e.Type().IsRegular() {
+			ids = append(ids, e.Name())
+		}
+	}
+	slices.Sort(ids)
+	slices.Reverse(ids)
+	return ids
+}
+
+// stageVersion copies the version id in dir to a temporary file beside it,
+// returning its path. Inside .versions it is never served or listed, and
+// versionIDs skips it.
+func stageVersion(dir, id string, info os.FileInfo) (string, error) {
+	in, err := os.Open(filepath.Join(dir, id))
+	if err != nil {
+		return "", err
+	}
+	defer in.Close()
+	out, err := os.CreateTemp(dir, ".restore-*")
+	if err != nil {
+		return "", err
+	}
+	_, err = io.Copy(out, in)
+	if err == nil {
+		err = out.Chmod(info.Mode().Perm())
+	}
+	if cerr := out.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		os.Remove(out.Name())
+		return "", err
+	}
+	return out.Name(), nil
+}
+
+type fileVersion struct {
+	ID       string    `json:"id"`
+	Replaced time.Time `json:"replaced"`
+	Modified time.Time `json:"modified"`
+	Size     int64     `json:"size"`
+}
+
+type versionsResponse struct {
+	Path     string        `json:"path"`
+	Versions []fileVersion `json:"versions"`
+}
+
+// versionTarget resolves the file whose versions a request is about.
+func (s *Server) versionTarget(p string) (*Mount, string, string, error) {
+	relPath, fsPath, err := s.writable(p)
+	if err != nil {
+		return nil, "", "", err
+	}
+	m, _, _ := s.resolve(relPath)
+	if !m.versions {
+		return nil, "", "", &opError{http.StatusForbidden, m.Prefix + " keeps no versions"}
+	}
+	return m, relPath, fsPath, nil
+}
+
+// apiVersions answers GET /api/v1/versions?path=/dir/file with the kept
+// versions of a file, newest first. The file itself need not exist any
+// more.
+func (s *Server) apiVersions(w http.ResponseWriter, r *http.Request) {
+	p := r.URL.Query().Get("path")
+	if p == "" {
+		writeAPIError(w, http.StatusBadRequest, "missing path", map[string]string{"path": "required"})
+		return
+	}
+	m, relPath, fsPath, err := s.versionTarget(p)
+	if err != nil {
+		writeOpError(w, err)
+		return
+	}
+	resp := versionsResponse{Path: relPath, Versions: []fileVersion{}}
+	dir := m.versionDir(fsPath)
+	for _, id := range versionIDs(dir) {
+		info, err := os.Stat(filepath.Join(dir, id))
+		if err != nil {
+			continue
+		}
+		replaced, _ := time.Parse(versionLayout, id)
+		resp.Versions = append(resp.Versions, fileVersion{ID: id, Replaced: replaced, Modified: info.ModTime(), Size: info.Size()})
+	}
+	w.Header().Set("Cache-Control", "no-store")
+	writeJSON(w, http.StatusOK, resp)
+}
+
+type versionRestoreRequest struct {
+	Path    string `json:"path"`
+	Version string `json:"version"`
+}
+
+func (v *versionRestoreRequest) validate() map[string]string {
+	fields := make(map[string]string)
+	if v.Path == "" {
+		fields["path"] = "required"
+	}
+	if _, err := time.Parse(versionLayout, v.Version); err != nil {
+		fields["version"] = "must be the id of a version"
+	}
+	return fields
+}
+
+// apiVersionRestore answers POST /api/v1/versions {"path", "version"} by
+// putting a version back in place of the file, which is itself kept as a
+// version first, so a restore can be undone.
+func (s *Server) apiVersionRestore(w http.ResponseWriter, r *http.Request) {
+	var req versionRestoreRequest
+	if !s.decodeAPIRequest(w, r, &req) {
+		return
+	}
+	m, relPath, fsPath, err := s.versionTarget(req.Path)
+	if err != nil {
+		writeOpError(w, err)
+		return
+	}
+	dir := m.versionDir(fsPath)
+	info, err := os.Stat(filepath.Join(dir, req.Version))
+	if errors.Is(err, os.ErrNotExist) {
+		err = &opError{http.StatusNotFound, "no version " + req.Version + " of " + relPath}
+	}
+	if err != nil {
+		writeOpError(w, err)
+		return
+	}
+	if existing, err := os.Lstat(fsPath); err == nil && existing.IsDir() {
+		writeAPIError(w, http.StatusConflict, relPath+" is a directory", nil)
+		return
+	}
+	if err := os.MkdirAll(filepath.Dir(fsPath), 0o755); err != nil {
+		writeOpError(w, err)
+		return
+	}
+	// Copy the version out before keeping the current file, which may
+	// prune it.
+	restored, err := stageVersion(dir, req.Version, info)
+	if err != nil {
+		writeOpError(w, err)
+		return
+	}
+	defer os.Remove(restored) // fails harmlessly once renamed
+	// Saves from the editor check the ETag under the same lock.
+	s.editMu.Lock()
+	defer s.editMu.Unlock()
+	if err := s.keepVersion(fsPath); err != nil {
+		s.log.Error("Keeping version failed", "path", relPath, "error", err)
+		writeOpError(w, err)
+		return
+	}
+	if err := os.Rename(restored, fsPath); err != nil {
+		writeOpError(w, err)
+		return
+	}
+	if info, err = os.Stat(fsPath); err != nil {
+		writeOpError(w, err)
+		return
+	}
+	s.invalidate(fsPath)
+	s.log.Info("Version restored", "path", relPath, "version", req.Version, "remote_ip", RemoteIP(r))
+	s.uploaded(r, fsPath, info.Size())
+	mirror := s.mirrorUpload(r.Context(), fsPath)
+	writeJSON(w, http.StatusOK, fileWriteResponse{Path: relPath, Size: info.Size(), ETag: fileETag(info), Modified: info.ModTime(), Mirror: mirror})
+}
@@ -0,0 +1,135 @@
+package fileserver
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"slices"
+	"time"
+)
+
+// Webhook posts file events to a URL as they happen.
+type Webhook struct {
+	URL string
+	// Secret, when set, signs each body with HMAC-SHA256 in the
+	// X-Signature-256 header as "sha256=<hex>", as GitHub does.
+	Secret string
+	// Events are the event types delivered. They default to
+	// DefaultWebhookEvents.
+	Events []string
+}
+
+// DefaultWebhookEvents are delivered to webhooks that name none.
+var DefaultWebhookEvents = []string{"upload", "delete", "move"}
+
+const (
+	// webhookQueue is how many events wait for one webhook while earlier
+	// deliveries are retried; later ones are dropped.
+	webhookQueue    = 1024
+	webhookAttempts = 5
+	webhookTimeout  = 10 * time.Second
+	webhookBackoff  = time.Second // doubled after each failed attempt
+)
+
+// startWebhooks validates the webhooks and delivers published events to
+// each of them in order until the server is closed.
+func (s *Server) startWebhooks(hooks []Webhook) error {
+	hooks = slices.Clone(hooks)
+	for i := range hooks {
+		u, err := url.Parse(hooks[i].URL)
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			return fmt.Errorf("webhook %q: want an http or https URL", hooks[i].URL)
+		}
+		if len(hooks[i].Events) == 0 {
+			hooks[i].Events = DefaultWebhookEvents
+		}
+	}
+	client := &http.Client{Timeout: webhookTimeout}
+	for _, h := range hooks {
+		events, unsubscribe := s.Subscribe()
+		queue := make(chan Event, webhookQueue)
+		go func() {
+			defer close(queue)
+			for {
+				select {
+				case ev := <-events:
+					if !slices.Contains(h.Events, ev.Type) {
+						continue
+					}
+					select {
+					case queue <- ev:
+					default:
+						s.log.Warn("Webhook queue full, event dropped", "url", h.URL, "type", ev.Type, "path", ev.Path)
+					}
+				case <-s.stop:
+					unsubscribe()
+					return
+				}
+			}
+		}()
+		go func() {
+			for ev := range queue {
+				s.deliverWebhook(client, h, ev)
+			}
+		}()
+	}
+	return nil
+}
+
+// deliverWebhook posts ev to h, retrying with exponential backoff after
+// network errors, 429 and 5xx responses.
+func (s *Server) deliverWebhook(client *http.Client, h Webhook, ev Event) {
+	body, err := json.Marshal(ev)
+	if err != nil {
+		return
+	}
+	delivery := newRequestID()
+	backoff := webhookBackoff
+	for attempt := 1; ; attempt++ {
+		status, err := s.postWebhook(client, h, ev.Type, delivery, body)
+		if err == nil && status < 300 {
+			s.log.Debug("Webhook delivered", "url", h.URL, "type", ev.Type, "path", ev.Path, "delivery", delivery)
+			return
+		}
+		retry := err != nil || status == http.StatusTooManyRequests || status >= 500
+		if !retry || attempt == webhookAttempts {
+			s.log.Warn("Webhook delivery failed", "url", h.URL, "type", ev.Type, "path", ev.Path,
+				"delivery", delivery, "attempts", attempt, "status", status, "error", err)
+			return
+		}
+		select {
+		case <-time.After(backoff):
+			backoff *= 2
+		case <-s.stop:
+			return
+		}
+	}
+}
+
+// postWebhook makes one delivery attempt, returning the response status.
+func (s *Server) postWebhook(client *http.Client, h Webhook, typ, delivery string, body []byte) (int, error) {
+	req, err := http.NewRequest(http.MethodPost, h.URL, bytes.NewReader(body))
+	if err != nil {
+		return 0, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "go-server-webhook")
+	req.Header.Set("X-Webhook-Event", typ)
+	req.Header.Set("X-Webhook-Delivery", delivery)
+	if h.Secret != "" {
+		mac := hmac.New(sha256.New, []byte(h.Secret))
+		mac.Write(body)
+		req.Header.Set("X-Signature-256", "sha256="+hex.EncodeToString(mac.Sum(nil)))
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	resp.Body.Close()
+	return resp.StatusCode, nil
+}
@@ -0,0 +1,168 @@
+package fileserver
+
+import (
+	"archive/zip"
+	"fmt"
+	"io"
+	"io/fs"
+	"mime"
+	"net/http"
+	"path"
+	"strings"
+)
+
+// archiveMaxPaths bounds the number of entries selected for one archive.
+const archiveMaxPaths = 1000
+
+type archiveRequest struct {
+	Paths []string `json:"paths"`
+	Name  string   `json:"name"` // archive file name, without .zip
+}
+
+func (a *archiveRequest) validate() map[string]string {
+	switch {
+	case len(a.Paths) == 0:
+		return map[string]string{"paths": "required"}
+	case len(a.Paths) > archiveMaxPaths:
+		return map[string]string{"paths": fmt.Sprintf("more than %d entries", archiveMaxPaths)}
+	}
+	return nil
+}
+
+// archiveItem is a selected file or directory, resolved to its mount.
+type archiveItem struct {
+	m      *Mount
+	fsPath string
+	name   string // path in the archive
+}
+
+// apiArchive answers POST /api/v1/archive with a zip of the selected files
+// and directories, streamed as it is written. The body is JSON {"paths":
+// [...], "name": "..."} or, as submitted by the listing page, a form with
+// repeated path fields and a name.
+func (s *Server) apiArchive(w http.ResponseWriter, r *http.Request) {
+	var req archiveRequest
+	if ct, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type")); ct == "application/x-www-form-urlencoded" {
+		s.limitBody(w, r)
+		if err := r.ParseForm(); bodyTooLarge(w, err) {
+			return
+		} else if err != nil {
+			writeAPIError(w, http.StatusBadRequest, "invalid form: "+err.Error(), nil)
+			return
+		}
+		req.Paths, req.Name = r.PostForm["path"], r.PostForm.Get("name")
+		if fields := req.validate(); len(fields) > 0 {
+			writeAPIError(w, http.StatusUnprocessableEntity, "validation failed", fields)
+			return
+		}
+	} else if !s.decodeAPIRequest(w, r, &req) {
+		return
+	}
+
+	// Check every path before the first byte goes out, so a bad selection
+	// still gets an error response instead of a truncated zip.
+	items := make([]archiveItem, 0, len(req.Paths))
+	for _, p := range req.Paths {
+		relPath, m, fsPath, ok := s.lookup(p)
+		if !ok || relPath == "/" {
+			writeAPIError(w, http.StatusNotFound, http.StatusText(http.StatusNotFound), map[string]string{"paths": p})
+			return
+		}
+		info, err := m.stat(fsPath)
+		if err != nil {
+			status := statusForError(err)
+			writeAPIError(w, status, http.StatusText(status), map[string]string{"paths": p})
+			return
+		}
+		if info.IsDir() && s.noIndexed(m, m.name(fsPath)) {
+			status := s.noIndexStatus()
+			writeAPIError(w, status, http.StatusText(status), map[string]string{"paths": p})
+			return
+		}
+		items = append(items, archiveItem{m: m, fsPath: fsPath, name: path.Base(relPath)})
+	}
+
+	name := strings.TrimSuffix(path.Base("/"+req.Name), ".zip")
+	if name == "/" || name == "." {
+		name = "download"
+	}
+	w.Header().Set("Content-Type", "application/zip")
+	w.Header().Set("Content-Disposition", contentDisposition("attachment", name+".zip"))
+	w.Header().Set("Cache-Control", "no-store")
+	zw := zip.NewWriter(w)
+	for _, it := range items {
+		if err := s.zipItem(r, zw, it); err != nil {
+			if s.canceled(r, err) {
+				panic(http.ErrAbortHandler)
+			}
+			// The status line is gone; dropping the connection at least
+			// leaves the client with an incomplete download, not a
+			// plausible but short archive.
+			s.log.Warn("Archive aborted", "path", it.name, "error", err)
+			panic(http.ErrAbortHandler)
+		}
+	}
+	if err := zw.Close(); err != nil {
+		panic(http.ErrAbortHandler)
+	}
+}
+
+// zipItem adds a file, or a directory with everything below it, skipping
+// dotfiles as listings do unless the mount shows them, and directories
+// with a .noindex file.
+func (s *Server) zipItem(r *http.Request, zw *zip.Writer, it archiveItem) error {
+	root := it.m.name(it.fsPath)
+	return fs.WalkDir(it.m.fsys, root, func(name string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if err := r.Context().Err(); err != nil {
+			return err
+		}
+		if name != root && ((it.m.Hidden != HiddenShow && strings.HasPrefix(d.Name(), ".")) || it.m.internal(path.Join(it.m.Prefix, name)) || s.excluded(path.Join(it.m.Prefix, name))) {
+			if d.IsDir() {
+				return fs.SkipDir
+			}
+			return nil
+		}
+		if name != root && d.IsDir() && s.noIndexed(it.m, name) {
+			return fs.SkipDir
+		}
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+		if !d.IsDir() && !info.Mode().IsRegular() {
+			return nil
+		}
+		hdr, err := zip.FileInfoHeader(info)
+		if err != nil {
+			return err
+		}
+		rel := ""
+		switch {
+		case name == root:
+		case root == ".":
+			rel = name
+		default:
+			rel = strings.TrimPrefix(name, root+"/")
+		}
+		hdr.Name = path.Join(it.name, rel)
+		if d.IsDir() {
+			hdr.Name += "/"
+		} else {
+			hdr.Method = zip.Deflate
+		}
+		fw, err := zw.CreateHeader(hdr)
+		if err != nil || d.IsDir() {
+			return err
+		}
+		f, err := it.m.fsys.Open(name)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		_, err = io.Copy(fw, contextReader{r.Context(), f})
+		return err
+	})
+}